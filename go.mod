@@ -3,7 +3,17 @@ module github.com/haozi4263/admission-registry
 go 1.14
 
 require (
+	github.com/docker/distribution v2.7.1+incompatible
+	github.com/evanphx/json-patch v4.9.0+incompatible
+	github.com/opencontainers/go-digest v1.0.0
+	github.com/prometheus/client_golang v1.7.1
+	go.opentelemetry.io/otel v0.20.0
+	go.opentelemetry.io/otel/exporters/otlp v0.20.0
+	go.opentelemetry.io/otel/sdk v0.20.0
+	go.opentelemetry.io/otel/trace v0.20.0
 	k8s.io/api v0.20.2
 	k8s.io/apimachinery v0.20.2
+	k8s.io/client-go v0.20.2
 	k8s.io/klog v1.0.0
+	sigs.k8s.io/yaml v1.2.0
 )
@@ -6,62 +6,364 @@ import (
 	"flag"
 	"fmt"
 	"github.com/haozi4263/admission-registry/pkg"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"io/ioutil"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/klog"
 	"net/http"
 	"os"
 	"os/signal"
 	"strings"
 	"syscall"
+	"time"
 )
 
-func main()  {
+func main() {
 	// webhook http server 需要和api-server交互需要是一个支持tls的webhook
 	// 通过命令行参数传递证书
 	var param pkg.WhSvrParam
-	flag.IntVar(&param.Port, "port",443, "Webhook Server Port.")
-	flag.StringVar(&param.CertFile, "tlsCertFile", "/etc/webhook/cert/tls.crt","x509 certification file")
-	flag.StringVar(&param.KeyFile, "keyFile", "/etc/webhook/cert/tls.key","x509 private key file")
+	flag.IntVar(&param.Port, "port", 443, "Webhook Server Port.")
+	flag.StringVar(&param.CertFile, "tlsCertFile", "/etc/webhook/cert/tls.crt", "x509 certification file")
+	flag.StringVar(&param.KeyFile, "keyFile", "/etc/webhook/cert/tls.key", "x509 private key file")
+	flag.StringVar(&param.MutateCertFile, "mutateTLSCertFile", "", "x509 certification file for /mutate, served on mutatePort; /mutate shares tlsCertFile on port if empty")
+	flag.StringVar(&param.MutateKeyFile, "mutateKeyFile", "", "x509 private key file for /mutate")
+	flag.IntVar(&param.MutatePort, "mutatePort", 8443, "port /mutate is served on when it has its own certificate")
+	flag.IntVar(&param.CertRetries, "certRetries", 0, "times to retry loading the key pairs on startup, e.g. while cert-manager mounts them")
+	flag.DurationVar(&param.CertWarmupDelay, "certWarmupDelay", 2*time.Second, "delay between attempts to load the key pairs on startup")
+	flag.StringVar(&param.MinTLSVersion, "minTLSVersion", "1.2", "minimum TLS version, 1.2 or 1.3")
+	flag.StringVar(&param.TLSCipherSuites, "tlsCipherSuites", "", "comma separated TLS 1.2 cipher suites, Go defaults if empty")
+	flag.BoolVar(&param.KeepAlive, "keepAlive", true, "reuse connections from the api-server")
+	flag.DurationVar(&param.IdleTimeout, "idleTimeout", 120*time.Second, "how long idle keep-alive connections are kept open")
+	flag.DurationVar(&param.ReadHeaderTimeout, "readHeaderTimeout", 10*time.Second, "timeout for reading request headers")
+	flag.DurationVar(&param.MaxRequestTimeout, "maxRequestTimeout", 30*time.Second, "maximum time spent on an admission request, shortened by the api-server's timeout parameter")
+	flag.StringVar(&param.ConfigFile, "config", "", "policy config file")
+	flag.StringVar(&param.AllowlistURL, "allowlistURL", "", "URL of the registry governance service providing the allowlist")
+	flag.DurationVar(&param.AllowlistInterval, "allowlistInterval", 5*time.Minute, "allowlist refresh interval")
+	flag.Float64Var(&param.DebugSampleRate, "debugSampleRate", 0, "fraction of requests logged verbosely, between 0 and 1")
+	flag.BoolVar(&param.DebugSampleByUID, "debugSampleByUID", false, "sample requests deterministically by UID")
+	flag.BoolVar(&param.TracingEnabled, "tracing", false, "enable OpenTelemetry tracing")
+	flag.StringVar(&param.TracingEndpoint, "tracingEndpoint", "localhost:4318", "OTLP/HTTP endpoint spans are exported to")
+	flag.StringVar(&param.DecisionSinkURL, "decisionSinkURL", "", "URL admission decisions are POSTed to")
+	flag.StringVar(&param.ScannerURL, "scannerURL", "", "URL of the vulnerability scanner queried by image digest")
+	flag.IntVar(&param.BreakerThreshold, "breakerThreshold", 5, "consecutive failures before calls to the image registry or scanner are short-circuited, 0 disables it")
+	flag.DurationVar(&param.BreakerCooldown, "breakerCooldown", 30*time.Second, "how long an open circuit breaker waits before probing again")
+	flag.BoolVar(&param.SelfRegister, "selfRegister", false, "create the webhook configurations on startup")
+	flag.StringVar(&param.RegistrationName, "registrationName", "admission-registry", "name of the webhook configurations")
+	flag.StringVar(&param.ServiceNamespace, "serviceNamespace", "default", "namespace of the webhook service")
+	flag.StringVar(&param.ServiceName, "serviceName", "admission-registry", "name of the webhook service")
+	flag.StringVar(&param.CABundleFile, "caBundleFile", "", "CA bundle file put into the webhook configurations")
+	flag.StringVar(&param.FailurePolicy, "failurePolicy", "Fail", "failure policy of the webhook configurations, Fail or Ignore")
+	flag.StringVar(&param.NamespaceSelector, "namespaceSelector", "", "label selector of namespaces the webhook applies to")
+	flag.BoolVar(&param.CleanupOnShutdown, "cleanupOnShutdown", false, "remove the webhook configurations on shutdown")
+	flag.StringVar(&param.LogFormat, "logFormat", pkg.LogFormatText, "log output format, text or json")
+	flag.BoolVar(&param.ReadyWhenDegraded, "readyWhenDegraded", true, "report ready (degraded) on /readyz when external dependencies are unreachable, set to false to take replicas out of the Service instead")
+	flag.StringVar(&param.ConfigTokenFile, "configTokenFile", "", "file containing the bearer token required by /config and /recent, both are disabled if empty")
+	flag.StringVar(&param.BreakGlassKeyFile, "breakGlassKeyFile", "", "file containing the HMAC key break-glass tokens are signed with, break-glass is disabled if empty")
+	flag.DurationVar(&param.BreakGlassMaxTTL, "breakGlassMaxTTL", time.Hour, "maximum validity of a break-glass token")
+	flag.IntVar(&param.RecentDecisions, "recentDecisions", 0, "number of recent admission decisions kept in memory and served on /recent, 0 disables it")
+	flag.DurationVar(&param.BootstrapWindow, "bootstrapWindow", 0, "allow all images with warnings for this long after startup, for cluster bring-up")
+	flag.Int64Var(&param.MaxBodySize, "maxBodySize", 3*1024*1024, "maximum size of an AdmissionReview request body in bytes")
+	flag.IntVar(&param.LargeObjectSize, "largeObjectSize", 256*1024, "workloads larger than this many bytes are decoded without status and managedFields to reduce memory, 0 always decodes the full object")
+	flag.BoolVar(&param.ImpactReportOnReload, "impactReportOnReload", false, "after a config reload, evaluate running pods against the new policy and report how many would be denied")
+	flag.StringVar(&param.ReplayDir, "replay", "", "replay the AdmissionReview files in this directory, report the decisions and exit")
 	flag.Parse()
 
-	cert, err :=tls.LoadX509KeyPair(param.CertFile, param.KeyFile)
+	if err := pkg.SetLogFormat(param.LogFormat); err != nil {
+		klog.Errorf("Invalid log format: %v", err)
+		return
+	}
+
+	if param.TracingEnabled {
+		shutdown, err := pkg.InitTracing(context.Background(), param.TracingEndpoint)
+		if err != nil {
+			klog.Errorf("Failed to init tracing: %v", err)
+			return
+		}
+		defer shutdown(context.Background())
+	}
+
+	config, err := loadConfig(param.ConfigFile)
+	if err != nil {
+		klog.Errorf("Failed to load config: %v", err)
+		return
+	}
+	if err := pkg.SetLogVerbosity(config.LogVerbosity); err != nil {
+		klog.Errorf("Invalid log verbosity: %v", err)
+		return
+	}
+
+	// 回放模式只需要策略配置, 不访问集群和镜像仓库, 结果只取决于请求本身
+	if param.ReplayDir != "" {
+		replay(&pkg.WebhookServer{Config: config, Inspector: pkg.NewCachedInspector(pkg.OfflineInspector{})}, param.ReplayDir)
+		return
+	}
+
+	cert, err := pkg.LoadKeyPair(param.CertFile, param.KeyFile, param.CertRetries, param.CertWarmupDelay)
 	if err != nil {
 		klog.Errorf("Failed to load key pair: %v", err)
 		return
 	}
 
+	minVersion, err := pkg.TLSVersion(param.MinTLSVersion)
+	if err != nil {
+		klog.Errorf("Invalid minimum TLS version: %v", err)
+		return
+	}
+	cipherSuites, err := pkg.CipherSuites(strings.Split(param.TLSCipherSuites, ","))
+	if err != nil {
+		klog.Errorf("Invalid TLS cipher suites: %v", err)
+		return
+	}
+
+	// 部分检查需要查询集群中的资源, 不在集群中运行时这些检查会被跳过
+	client, err := pkg.NewInClusterClient()
+	if err != nil {
+		klog.Warningf("Failed to create kubernetes client, checks relying on cluster state are disabled: %v", err)
+	}
+
 	// 实例化一个Webhook Server
 	whsrv := pkg.WebhookServer{
 		Server: &http.Server{
-			Addr: fmt.Sprintf(":%d", param.Port),
+			Addr:              fmt.Sprintf(":%d", param.Port),
+			IdleTimeout:       param.IdleTimeout,
+			ReadHeaderTimeout: param.ReadHeaderTimeout,
 			TLSConfig: &tls.Config{
 				Certificates: []tls.Certificate{cert},
+				MinVersion:   minVersion,
+				CipherSuites: cipherSuites,
 			},
 		},
-		WhiteListRegistries: strings.Split(os.Getenv("WHITELIST_REGISTRIES"),","),
+		Config:    config,
+		Inspector: pkg.NewCachedInspector(&pkg.RegistryInspector{}),
+		Client:    client,
+
+		DebugSampleRate:  param.DebugSampleRate,
+		DebugSampleByUID: param.DebugSampleByUID,
+
+		ReadyWhenDegraded: param.ReadyWhenDegraded,
+		MaxRequestTimeout: param.MaxRequestTimeout,
+
+		MaxBodySize:     param.MaxBodySize,
+		LargeObjectSize: param.LargeObjectSize,
+	}
+	// 配置了的外部依赖都加入就绪检查
+	for _, dep := range []pkg.Dependency{
+		{Name: "allowlist", URL: param.AllowlistURL},
+		{Name: "scanner", URL: param.ScannerURL},
+		{Name: "decision-sink", URL: param.DecisionSinkURL},
+	} {
+		if dep.URL != "" {
+			whsrv.Dependencies = append(whsrv.Dependencies, dep)
+		}
+	}
+
+	stopCh := make(chan struct{})
+	if param.AllowlistURL != "" {
+		whsrv.Allowlist = &pkg.RemoteAllowlist{URL: param.AllowlistURL, Interval: param.AllowlistInterval}
+		go whsrv.Allowlist.Run(stopCh)
+	}
+	if param.DecisionSinkURL != "" {
+		sink := pkg.NewHTTPSink(param.DecisionSinkURL, 1000)
+		whsrv.Sink = sink
+		go sink.Run(stopCh)
+	}
+
+	if param.ConfigTokenFile != "" {
+		token, err := ioutil.ReadFile(param.ConfigTokenFile)
+		if err != nil {
+			klog.Errorf("Failed to read config token: %v", err)
+			return
+		}
+		whsrv.ConfigToken = strings.TrimSpace(string(token))
+	}
+
+	if param.BreakGlassKeyFile != "" {
+		key, err := ioutil.ReadFile(param.BreakGlassKeyFile)
+		if err != nil {
+			klog.Errorf("Failed to read break-glass key: %v", err)
+			return
+		}
+		whsrv.BreakGlassKey = []byte(strings.TrimSpace(string(key)))
+		whsrv.BreakGlassMaxTTL = param.BreakGlassMaxTTL
+	}
+
+	if param.RecentDecisions > 0 {
+		whsrv.Recent = pkg.NewRecentDecisions(param.RecentDecisions)
+	}
+
+	if param.BootstrapWindow > 0 {
+		whsrv.BootstrapUntil = time.Now().Add(param.BootstrapWindow)
+		klog.Warningf("Bootstrap mode: all images are allowed until %s", whsrv.BootstrapUntil.Format(time.RFC3339))
+	}
+
+	whsrv.Inspector.Breaker = &pkg.CircuitBreakers{Name: "registry", Threshold: param.BreakerThreshold, Cooldown: param.BreakerCooldown}
+	whsrv.Attestations = pkg.NewCachedVerifier(&pkg.RegistryInspector{})
+	whsrv.Attestations.Breaker = &pkg.CircuitBreakers{Name: "attestation", Threshold: param.BreakerThreshold, Cooldown: param.BreakerCooldown}
+	if param.ScannerURL != "" {
+		whsrv.Scanner = pkg.NewCachedScanner(&pkg.HTTPScanner{URL: param.ScannerURL})
+		whsrv.Scanner.Breaker = &pkg.CircuitBreaker{Name: "scanner", Threshold: param.BreakerThreshold, Cooldown: param.BreakerCooldown}
+	}
+
+	var registration *pkg.Registration
+	if param.SelfRegister {
+		registration, err = newRegistration(param)
+		if err != nil {
+			klog.Errorf("Invalid webhook registration: %v", err)
+			return
+		}
+	}
+
+	// mutate 配置了单独的证书时使用单独的端口, 原来的端口只处理 validate, 这样两个 webhook 各自只能看到自己的证书
+	if param.MutateCertFile != "" {
+		mutateCert, err := pkg.LoadKeyPair(param.MutateCertFile, param.MutateKeyFile, param.CertRetries, param.CertWarmupDelay)
+		if err != nil {
+			klog.Errorf("Failed to load mutate key pair: %v", err)
+			return
+		}
+		whsrv.MutateServer = whsrv.NewMutateServer(mutateCert, param)
 	}
 
 	// 定义http server handler
 	mux := http.NewServeMux()
-	mux.HandleFunc("/validate", whsrv.Handler)
-	mux.HandleFunc("/mutate", whsrv.Handler) // todo
+	mux.HandleFunc(pkg.ValidatePath, whsrv.Handler)
+	if whsrv.MutateServer == nil {
+		mux.HandleFunc(pkg.MutatePath, whsrv.Handler)
+	}
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.HandleFunc("/version", pkg.VersionHandler)
+	mux.HandleFunc("/config", whsrv.ConfigHandler)
+	mux.HandleFunc("/recent", whsrv.RecentHandler)
+	mux.HandleFunc("/readyz", whsrv.ReadyHandler)
 	whsrv.Server.Handler = mux
+	// 关闭 keep-alive 之后 net/http 会在每个响应上设置 Connection: close 并在响应之后关闭连接
+	whsrv.Server.SetKeepAlivesEnabled(param.KeepAlive)
 
 	// 在一个新的goroutine里面启动 webhook server
 	go func() {
-		if err := whsrv.Server.ListenAndServeTLS("",""); err != nil {
+		if err := whsrv.Server.ListenAndServeTLS("", ""); err != nil {
 			klog.Errorf("Failed to listen adn server webhook: %v", err)
 		}
 	}()
-	klog.Info("Server started")
+	if whsrv.MutateServer != nil {
+		go func() {
+			if err := whsrv.MutateServer.ListenAndServeTLS("", ""); err != nil {
+				klog.Errorf("Failed to listen and serve mutate webhook: %v", err)
+			}
+		}()
+	}
+	if registration != nil && client != nil {
+		if err := registration.Register(context.Background(), client); err != nil {
+			klog.Errorf("Failed to register webhook configurations: %v", err)
+		}
+	}
+	info := pkg.GetBuildInfo()
+	klog.Infof("Server started, version=%s, gitCommit=%s, goVersion=%s", info.Version, info.GitCommit, info.GoVersion)
 	// 监听OS的关闭新信号
+	// 收到 SIGHUP 时重新加载配置, 加载失败时继续使用原来的配置
 	signalChan := make(chan os.Signal, 1)
-	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM )
-	<- signalChan
+	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	for sig := <-signalChan; sig == syscall.SIGHUP; sig = <-signalChan {
+		config, err := loadConfig(param.ConfigFile)
+		if err != nil {
+			klog.Errorf("Failed to reload config, keep serving the current one: %v", err)
+			continue
+		}
+		if err := pkg.SetLogVerbosity(config.LogVerbosity); err != nil {
+			klog.Errorf("Failed to set log verbosity: %v", err)
+		}
+		whsrv.SetConfig(config)
+		klog.Infof("Reloaded config, policyVersion=%s", config.PolicyVersion)
+		if param.ImpactReportOnReload {
+			go whsrv.ReportPolicyImpact()
+		}
+	}
 
 	klog.Info("Got Os shutdown signal, gracefully shutting down...")
+	close(stopCh)
+	if registration != nil && client != nil {
+		if err := registration.Unregister(context.Background(), client); err != nil {
+			klog.Errorf("Failed to remove webhook configurations: %v", err)
+		}
+	}
 	if err := whsrv.Server.Shutdown(context.Background()); err != nil {
 		klog.Errorf("HTTP Server Shutdown error: %v", err)
 	}
+	if whsrv.MutateServer != nil {
+		if err := whsrv.MutateServer.Shutdown(context.Background()); err != nil {
+			klog.Errorf("Mutate HTTP Server Shutdown error: %v", err)
+		}
+	}
 
+}
+
+// newRegistration 按照启动参数构造 webhook 配置
+func newRegistration(param pkg.WhSvrParam) (*pkg.Registration, error) {
+	registration := &pkg.Registration{
+		Name:             param.RegistrationName,
+		ServiceNamespace: param.ServiceNamespace,
+		ServiceName:      param.ServiceName,
+		FailurePolicy:    admissionregistrationv1.FailurePolicyType(param.FailurePolicy),
+		Cleanup:          param.CleanupOnShutdown,
+	}
+	if param.MutateCertFile != "" {
+		registration.MutatePort = int32(param.MutatePort)
+	}
+	if param.CABundleFile != "" {
+		caBundle, err := ioutil.ReadFile(param.CABundleFile)
+		if err != nil {
+			return nil, err
+		}
+		registration.CABundle = caBundle
+	}
+	if param.NamespaceSelector != "" {
+		selector, err := metav1.ParseToLabelSelector(param.NamespaceSelector)
+		if err != nil {
+			return nil, err
+		}
+		registration.NamespaceSelector = selector
+	}
+	return registration, nil
+}
+
+// replay 回放抓取的请求并打印每个请求的决定, 和期望结果不一致时以非 0 状态退出
+func replay(whsrv *pkg.WebhookServer, dir string) {
+	results, err := whsrv.ReplayFromDir(dir)
+	if err != nil {
+		klog.Errorf("Failed to replay %s: %v", dir, err)
+		os.Exit(1)
+	}
+	failed := false
+	for _, result := range results {
+		status := "ok"
+		if result.Mismatch != "" {
+			status = "MISMATCH " + result.Mismatch
+			failed = true
+		}
+		fmt.Printf("%s: allowed=%v %s\n", result.File, result.Response.Allowed, status)
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
 
+// loadConfig 加载策略配置, path 为空时使用空的配置
+func loadConfig(path string) (pkg.Config, error) {
+	var config pkg.Config
+	if path != "" {
+		c, err := pkg.LoadConfig(path)
+		if err != nil {
+			return config, err
+		}
+		config = *c
+	}
+	// 兼容之前通过环境变量配置的白名单, 追加到基础策略里
+	var registries []string
+	for _, reg := range strings.Split(os.Getenv("WHITELIST_REGISTRIES"), ",") {
+		if reg = strings.TrimSpace(reg); reg != "" {
+			registries = append(registries, reg)
+		}
+	}
+	config.AddWhiteListRegistries(registries)
+	return config, nil
 }
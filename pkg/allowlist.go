@@ -0,0 +1,90 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// 默认的白名单刷新间隔
+const defaultAllowlistInterval = 5 * time.Minute
+
+// RemoteAllowlist 定期从外部的仓库治理服务拉取白名单并缓存
+// 服务需要返回 {"registries": ["docker.io", ...]} 格式的 json
+// 拉取失败时继续使用上一次成功拉取的白名单
+type RemoteAllowlist struct {
+	URL      string
+	Interval time.Duration // 刷新间隔, 为 0 时使用默认值
+	Client   *http.Client
+
+	mu         sync.RWMutex
+	registries []string
+}
+
+// Registries 返回缓存的白名单
+func (r *RemoteAllowlist) Registries() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.registries
+}
+
+// Refresh 拉取一次白名单, 成功后替换缓存
+func (r *RemoteAllowlist) Refresh(ctx context.Context) error {
+	req, err := http.NewRequest(http.MethodGet, r.URL, nil)
+	if err != nil {
+		return err
+	}
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("allowlist service returned %s", resp.Status)
+	}
+	var body struct {
+		Registries []string `json:"registries"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	r.registries = normalizeRegistryPrefixes(body.Registries)
+	r.mu.Unlock()
+	return nil
+}
+
+// Run 立即拉取一次, 之后按间隔定期刷新, 直到 stopCh 关闭
+func (r *RemoteAllowlist) Run(stopCh <-chan struct{}) {
+	interval := r.Interval
+	if interval == 0 {
+		interval = defaultAllowlistInterval
+	}
+	refresh := func() {
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		defer cancel()
+		if err := r.Refresh(ctx); err != nil {
+			klog.Warningf("Failed to refresh allowlist from %s, keep serving the last good list: %v", r.URL, err)
+		}
+	}
+	refresh()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			refresh()
+		case <-stopCh:
+			return
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package pkg
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/haozi4263/admission-registry/pkg/testutil"
+	admissionV1 "k8s.io/api/admission/v1"
+)
+
+// allowlistService 假的仓库治理服务, 按顺序返回 responses 中的响应, 用完之后重复最后一个
+type allowlistService struct {
+	mu        sync.Mutex
+	responses []struct {
+		code int
+		body string
+	}
+	requests int32
+}
+
+func (a *allowlistService) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	i := int(atomic.AddInt32(&a.requests, 1)) - 1
+	if i >= len(a.responses) {
+		i = len(a.responses) - 1
+	}
+	w.WriteHeader(a.responses[i].code)
+	w.Write([]byte(a.responses[i].body))
+}
+
+func (a *allowlistService) respond(code int, body string) {
+	a.responses = append(a.responses, struct {
+		code int
+		body string
+	}{code, body})
+}
+
+func TestRemoteAllowlistRefresh(t *testing.T) {
+	service := &allowlistService{}
+	service.respond(http.StatusOK, `{"registries": ["registry.corp.com", "library/"]}`)
+	service.respond(http.StatusInternalServerError, `unavailable`)
+	service.respond(http.StatusOK, `{"registries": ["registry.corp.com"`)
+	service.respond(http.StatusOK, `{"registries": ["quay.io"]}`)
+	server := httptest.NewServer(service)
+	defer server.Close()
+
+	allowlist := &RemoteAllowlist{URL: server.URL}
+	tests := []struct {
+		name    string
+		wantErr bool
+		want    []string
+	}{
+		{name: "first fetch", want: []string{"registry.corp.com", "docker.io/library"}},
+		{name: "5xx keeps the last good list", wantErr: true, want: []string{"registry.corp.com", "docker.io/library"}},
+		{name: "malformed json keeps the last good list", wantErr: true, want: []string{"registry.corp.com", "docker.io/library"}},
+		{name: "update", want: []string{"quay.io"}},
+	}
+	for _, tt := range tests {
+		err := allowlist.Refresh(context.Background())
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: got error %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		if got := allowlist.Registries(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRemoteAllowlistRun(t *testing.T) {
+	service := &allowlistService{}
+	service.respond(http.StatusOK, `{"registries": ["registry.corp.com"]}`)
+	service.respond(http.StatusServiceUnavailable, ``)
+	server := httptest.NewServer(service)
+	defer server.Close()
+
+	allowlist := &RemoteAllowlist{URL: server.URL, Interval: 10 * time.Millisecond}
+	stopCh := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		allowlist.Run(stopCh)
+		close(done)
+	}()
+	deadline := time.Now().Add(time.Second)
+	for atomic.LoadInt32(&service.requests) < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("allowlist was fetched %d times, want periodic refreshes", atomic.LoadInt32(&service.requests))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	close(stopCh)
+	<-done
+	if got := allowlist.Registries(); !reflect.DeepEqual(got, []string{"registry.corp.com"}) {
+		t.Errorf("got %v after failed refreshes, want the last good list", got)
+	}
+}
+
+func TestValidateUsesRemoteAllowlist(t *testing.T) {
+	service := &allowlistService{}
+	service.respond(http.StatusOK, `{"registries": ["registry.corp.com"]}`)
+	server := httptest.NewServer(service)
+	defer server.Close()
+	allowlist := &RemoteAllowlist{URL: server.URL}
+	if err := allowlist.Refresh(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &WebhookServer{Allowlist: allowlist}
+	s.SetConfig(Config{Base: Policy{WhiteListRegistries: []string{"docker.io"}}})
+	for image, want := range map[string]bool{
+		"nginx:1.21":                   true,
+		"registry.corp.com/team/app:1": true,
+		"quay.io/app:1.0":              false,
+	} {
+		if resp := s.Review(testutil.NewPodAdmissionReview(testPod("web", image), admissionV1.Create)); resp.Allowed != want {
+			t.Errorf("%s: allowed = %v, want %v", image, resp.Allowed, want)
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// checkRequiredAnnotations pod 必须带有 RequiredAnnotations 中的注解, 并且值完整匹配对应的正则, 正则为空时只要求注解存在
+// 比如要求 git-commit 注解记录镜像构建时的提交: git-commit: "[0-9a-f]{40}"
+func checkRequiredAnnotations(_ context.Context, policy *Policy, pod *corev1.Pod) *denial {
+	if len(policy.RequiredAnnotations) == 0 {
+		return nil
+	}
+	// 按 key 排序, 同时缺少多个注解时每次给出的提示一致
+	keys := make([]string, 0, len(policy.RequiredAnnotations))
+	for key := range policy.RequiredAnnotations {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		pattern := policy.RequiredAnnotations[key]
+		value, ok := pod.Annotations[key]
+		if !ok {
+			return &denial{
+				code:    http.StatusForbidden,
+				message: fmt.Sprintf("pod %s is missing required annotation %s.", pod.Name, key),
+			}
+		}
+		if pattern != "" && !matchPattern(pattern, value) {
+			return &denial{
+				code: http.StatusForbidden,
+				message: fmt.Sprintf("annotation %s of pod %s has value %q, which doesn't match %q.",
+					key, pod.Name, value, pattern),
+			}
+		}
+	}
+	return nil
+}
+
+// checkRequiredLabels pod 必须带有 RequiredLabels 中的标签, 并且值完整匹配对应的正则, 正则为空时只要求标签存在
+// 比如 team: "[a-z]+", version: 'v?[0-9]+\.[0-9]+\.[0-9]+'
+func checkRequiredLabels(_ context.Context, policy *Policy, pod *corev1.Pod) *denial {
+	if len(policy.RequiredLabels) == 0 {
+		return nil
+	}
+	keys := make([]string, 0, len(policy.RequiredLabels))
+	for key := range policy.RequiredLabels {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		pattern := policy.RequiredLabels[key]
+		value, ok := pod.Labels[key]
+		if !ok {
+			return &denial{
+				code:    http.StatusForbidden,
+				message: fmt.Sprintf("pod %s is missing required label %s.", pod.Name, key),
+			}
+		}
+		if pattern != "" && !matchPattern(pattern, value) {
+			return &denial{
+				code: http.StatusForbidden,
+				message: fmt.Sprintf("label %s of pod %s has value %q, which doesn't match %q.",
+					key, pod.Name, value, pattern),
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package pkg
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCheckRequiredAnnotations(t *testing.T) {
+	required := map[string]string{"git-commit": "[0-9a-f]{40}", "owner": ""}
+	commit := strings.Repeat("a", 40)
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		required    map[string]string
+		wantCode    int
+		wantMessage string
+	}{
+		{name: "all present", annotations: map[string]string{"git-commit": commit, "owner": "team-a"}, required: required},
+		{name: "empty pattern only requires presence", annotations: map[string]string{"git-commit": commit, "owner": ""}, required: required},
+		{name: "missing", annotations: map[string]string{"owner": "team-a"}, required: required, wantCode: http.StatusForbidden, wantMessage: "missing required annotation git-commit"},
+		{name: "partial match", annotations: map[string]string{"git-commit": commit + "z", "owner": "team-a"}, required: required, wantCode: http.StatusForbidden, wantMessage: "doesn't match"},
+		{name: "first missing key in order", required: required, wantCode: http.StatusForbidden, wantMessage: "missing required annotation git-commit"},
+		{name: "not configured"},
+	}
+	for _, tt := range tests {
+		pod := imagePod("nginx")
+		pod.Name = "web"
+		pod.Annotations = tt.annotations
+		d := checkRequiredAnnotations(context.Background(), &Policy{RequiredAnnotations: tt.required}, pod)
+		if code := denialCode(d); code != tt.wantCode {
+			t.Errorf("%s: got code %d, want %d", tt.name, code, tt.wantCode)
+			continue
+		}
+		if d != nil && !strings.Contains(d.message, tt.wantMessage) {
+			t.Errorf("%s: got message %q, want it to contain %q", tt.name, d.message, tt.wantMessage)
+		}
+	}
+}
+
+func TestCheckRequiredLabels(t *testing.T) {
+	required := map[string]string{"team": "[a-z]+", "version": `v?[0-9]+\.[0-9]+\.[0-9]+`, "tier": ""}
+	tests := []struct {
+		name        string
+		labels      map[string]string
+		wantCode    int
+		wantMessage string
+	}{
+		{name: "all present", labels: map[string]string{"team": "payments", "version": "v1.2.3", "tier": "web"}},
+		{name: "version without prefix", labels: map[string]string{"team": "payments", "version": "1.2.3", "tier": ""}},
+		{name: "missing", labels: map[string]string{"team": "payments", "version": "v1.2.3"}, wantCode: http.StatusForbidden, wantMessage: "missing required label tier"},
+		{name: "value doesn't match", labels: map[string]string{"team": "Payments", "version": "v1.2.3", "tier": "web"}, wantCode: http.StatusForbidden, wantMessage: "label team of pod web"},
+		{name: "partial match", labels: map[string]string{"team": "payments", "version": "v1.2.3-rc1", "tier": "web"}, wantCode: http.StatusForbidden, wantMessage: "label version of pod web"},
+	}
+	for _, tt := range tests {
+		pod := imagePod("nginx")
+		pod.Name = "web"
+		pod.Labels = tt.labels
+		d := checkRequiredLabels(context.Background(), &Policy{RequiredLabels: required}, pod)
+		if code := denialCode(d); code != tt.wantCode {
+			t.Errorf("%s: got code %d, want %d", tt.name, code, tt.wantCode)
+			continue
+		}
+		if d != nil && !strings.Contains(d.message, tt.wantMessage) {
+			t.Errorf("%s: got message %q, want it to contain %q", tt.name, d.message, tt.wantMessage)
+		}
+	}
+	if d := checkRequiredLabels(context.Background(), &Policy{}, imagePod("nginx")); d != nil {
+		t.Errorf("not configured: got denial %q", d.message)
+	}
+}
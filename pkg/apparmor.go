@@ -0,0 +1,43 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// 每个容器的 AppArmor profile 注解, 后面拼接容器名
+	appArmorAnnotationPrefix = "container.apparmor.security.beta.kubernetes.io/"
+	appArmorRuntimeDefault   = "runtime/default"
+	appArmorLocalhostPrefix  = "localhost/"
+)
+
+// checkAppArmorProfile 每个容器都必须通过注解指定 runtime/default 或者 localhost/<profile>, 没有注解或者 unconfined 时拒绝
+func checkAppArmorProfile(_ context.Context, policy *Policy, pod *corev1.Pod) *denial {
+	if !policy.RequireAppArmorProfile {
+		return nil
+	}
+	for _, container := range podContainers(pod) {
+		key := appArmorAnnotationPrefix + container.Name
+		profile, ok := pod.Annotations[key]
+		if !ok {
+			return &denial{
+				code: http.StatusForbidden,
+				message: fmt.Sprintf("container %s has no AppArmor profile! Please set the annotation %s to %s or %s<profile>.",
+					container.Name, key, appArmorRuntimeDefault, appArmorLocalhostPrefix),
+			}
+		}
+		if profile != appArmorRuntimeDefault && !strings.HasPrefix(profile, appArmorLocalhostPrefix) {
+			return &denial{
+				code: http.StatusForbidden,
+				message: fmt.Sprintf("container %s uses AppArmor profile %s, only %s and %s<profile> are allowed.",
+					container.Name, profile, appArmorRuntimeDefault, appArmorLocalhostPrefix),
+			}
+		}
+	}
+	return nil
+}
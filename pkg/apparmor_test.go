@@ -0,0 +1,30 @@
+package pkg
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestCheckAppArmorProfile(t *testing.T) {
+	tests := []struct {
+		name        string
+		annotations map[string]string
+		require     bool
+		wantCode    int
+	}{
+		{name: "runtime default", annotations: map[string]string{appArmorAnnotationPrefix + "setup": "runtime/default", appArmorAnnotationPrefix + "app": "runtime/default"}, require: true},
+		{name: "localhost profile", annotations: map[string]string{appArmorAnnotationPrefix + "setup": "runtime/default", appArmorAnnotationPrefix + "app": "localhost/k8s-nginx"}, require: true},
+		{name: "unconfined", annotations: map[string]string{appArmorAnnotationPrefix + "setup": "runtime/default", appArmorAnnotationPrefix + "app": "unconfined"}, require: true, wantCode: http.StatusForbidden},
+		{name: "init container without a profile", annotations: map[string]string{appArmorAnnotationPrefix + "app": "runtime/default"}, require: true, wantCode: http.StatusForbidden},
+		{name: "profile for another container", annotations: map[string]string{appArmorAnnotationPrefix + "setup": "runtime/default", appArmorAnnotationPrefix + "sidecar": "runtime/default"}, require: true, wantCode: http.StatusForbidden},
+		{name: "not required"},
+	}
+	for _, tt := range tests {
+		pod := initPod("setup")
+		pod.Annotations = tt.annotations
+		if code := denialCode(checkAppArmorProfile(context.Background(), &Policy{RequireAppArmorProfile: tt.require}, pod)); code != tt.wantCode {
+			t.Errorf("%s: got code %d, want %d", tt.name, code, tt.wantCode)
+		}
+	}
+}
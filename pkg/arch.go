@@ -0,0 +1,68 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// 节点架构的标签, 后者是旧版本 kubelet 使用的
+var archLabels = []string{"kubernetes.io/arch", "beta.kubernetes.io/arch"}
+
+// checkArchTags pod 通过 nodeSelector 或者 node affinity 固定了节点架构时, 镜像 tag 必须匹配该架构的正则
+// 没有固定架构, 或者没有配置该架构的正则时不检查
+// 只有 digest 没有 tag 的镜像没有可以匹配的 tag, digest 可能指向多架构的 manifest list, 同样不检查
+func checkArchTags(_ context.Context, policy *Policy, pod *corev1.Pod) *denial {
+	if len(policy.ArchTagPatterns) == 0 {
+		return nil
+	}
+	arch := podArch(pod)
+	pattern, ok := policy.ArchTagPatterns[arch]
+	if !ok {
+		return nil
+	}
+	for _, container := range podContainers(pod) {
+		ref := parseImage(container.Image)
+		if ref.Tag == "" && ref.Digest != "" {
+			continue
+		}
+		if !matchPattern(pattern, ref.Tag) {
+			return &denial{
+				code: http.StatusForbidden,
+				message: fmt.Sprintf("%s image of container %s has tag %q, but pod %s targets %s nodes! Please use an image tag matching %q.",
+					container.Image, container.Name, ref.Tag, pod.Name, arch, pattern),
+			}
+		}
+	}
+	return nil
+}
+
+// podArch 返回 pod 固定的节点架构, 优先使用 nodeSelector
+// required node affinity 中每个 term 都要求同一个架构时才认为固定了架构, 允许多个架构时返回空
+func podArch(pod *corev1.Pod) string {
+	for _, label := range archLabels {
+		if arch := pod.Spec.NodeSelector[label]; arch != "" {
+			return arch
+		}
+	}
+	affinity := pod.Spec.Affinity
+	if affinity == nil || affinity.NodeAffinity == nil || affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution == nil {
+		return ""
+	}
+	arch := ""
+	for _, term := range affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms {
+		termArch := ""
+		for _, expr := range term.MatchExpressions {
+			if containsString(archLabels, expr.Key) && expr.Operator == corev1.NodeSelectorOpIn && len(expr.Values) == 1 {
+				termArch = expr.Values[0]
+			}
+		}
+		if termArch == "" || (arch != "" && termArch != arch) {
+			return ""
+		}
+		arch = termArch
+	}
+	return arch
+}
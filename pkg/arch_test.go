@@ -0,0 +1,68 @@
+package pkg
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestCheckArchTags(t *testing.T) {
+	config, err := loadTestConfig(t, "base:\n  archTagPatterns:\n    arm64: \".*-arm64\"\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := config.Base
+	tests := []struct {
+		name, image, arch string
+		wantCode          int
+	}{
+		{name: "matching tag", image: "registry.corp.com/app:1.2-arm64", arch: "arm64"},
+		{name: "other tag", image: "registry.corp.com/app:1.2", arch: "arm64", wantCode: http.StatusForbidden},
+		{name: "no tag", image: "registry.corp.com/app", arch: "arm64", wantCode: http.StatusForbidden},
+		{name: "tag and digest", image: "registry.corp.com/app:1.2@" + testDigest("app"), arch: "arm64", wantCode: http.StatusForbidden},
+		{name: "digest only", image: "registry.corp.com/app@" + testDigest("app"), arch: "arm64"},
+		{name: "arch without pattern", image: "registry.corp.com/app:1.2", arch: "amd64"},
+		{name: "no arch", image: "registry.corp.com/app:1.2"},
+	}
+	for _, tt := range tests {
+		pod := imagePod(tt.image)
+		if tt.arch != "" {
+			pod.Spec.NodeSelector = map[string]string{"kubernetes.io/arch": tt.arch}
+		}
+		d := checkArchTags(context.Background(), &policy, pod)
+		if code := denialCode(d); code != tt.wantCode {
+			t.Errorf("%s: got code %d (%v), want %d", tt.name, code, d, tt.wantCode)
+		}
+	}
+}
+
+func TestPodArch(t *testing.T) {
+	term := func(values ...string) corev1.NodeSelectorTerm {
+		return corev1.NodeSelectorTerm{MatchExpressions: []corev1.NodeSelectorRequirement{
+			{Key: "kubernetes.io/arch", Operator: corev1.NodeSelectorOpIn, Values: values},
+		}}
+	}
+	affinityPod := func(terms ...corev1.NodeSelectorTerm) *corev1.Pod {
+		return &corev1.Pod{Spec: corev1.PodSpec{Affinity: &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{NodeSelectorTerms: terms},
+		}}}}
+	}
+	tests := []struct {
+		name string
+		pod  *corev1.Pod
+		want string
+	}{
+		{name: "beta nodeSelector", pod: &corev1.Pod{Spec: corev1.PodSpec{NodeSelector: map[string]string{"beta.kubernetes.io/arch": "arm64"}}}, want: "arm64"},
+		{name: "affinity", pod: affinityPod(term("arm64"), term("arm64")), want: "arm64"},
+		{name: "affinity with several arches", pod: affinityPod(term("arm64", "amd64"))},
+		{name: "terms with different arches", pod: affinityPod(term("arm64"), term("amd64"))},
+		{name: "none", pod: &corev1.Pod{}},
+	}
+	for _, tt := range tests {
+		if got := podArch(tt.pod); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package pkg
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog"
+)
+
+// 查询镜像 attestation 的默认超时时间
+const defaultAttestationTimeout = 5 * time.Second
+
+// 认可的 SBOM attestation 的 predicate 类型, SPDX 和 CycloneDX
+var sbomPredicateTypes = []string{
+	"https://spdx.dev/Document",
+	"https://cyclonedx.org/bom",
+}
+
+// AttestationVerifier 判断镜像是否带有 SBOM attestation, 测试时可以替换成假的实现
+type AttestationVerifier interface {
+	HasSBOM(ctx context.Context, image, digest string) (bool, error)
+}
+
+// CachedVerifier 按 digest 缓存查询结果
+// 只缓存已经找到 attestation 的结果, 没有找到的镜像之后可能会补上 attestation, 每次都重新查询
+type CachedVerifier struct {
+	Verifier AttestationVerifier
+	Timeout  time.Duration    // 单次查询的超时时间, 为 0 时使用默认值
+	Breaker  *CircuitBreakers // 按镜像仓库熔断, 为 nil 时不熔断
+
+	mu    sync.RWMutex
+	cache map[string]bool
+}
+
+// NewCachedVerifier 创建带缓存的 AttestationVerifier
+func NewCachedVerifier(verifier AttestationVerifier) *CachedVerifier {
+	return &CachedVerifier{
+		Verifier: verifier,
+		cache:    make(map[string]bool),
+	}
+}
+
+func (c *CachedVerifier) hasSBOM(ctx context.Context, image, digest string) (bool, error) {
+	c.mu.RLock()
+	ok := c.cache[digest]
+	c.mu.RUnlock()
+	if ok {
+		return true, nil
+	}
+
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = defaultAttestationTimeout
+	}
+	ctx, span := startSpan(ctx, "verify attestation")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	var found bool
+	err := c.Breaker.For(imageRegistry(image)).Do(func() (err error) {
+		found, err = c.Verifier.HasSBOM(ctx, image, digest)
+		return err
+	})
+	if err != nil || !found {
+		return false, err
+	}
+	c.mu.Lock()
+	c.cache[digest] = true
+	c.mu.Unlock()
+	return true, nil
+}
+
+// HasSBOM 按照 cosign 的约定查找 attestation: 和镜像同一个仓库, tag 为 sha256-<hex>.att
+// 每一层是一个 DSSE envelope, payload 是 in-toto statement, predicateType 为 SPDX 或 CycloneDX 时认为带有 SBOM
+// 这里只检查 attestation 是否存在, 不验证签名
+func (r *RegistryInspector) HasSBOM(ctx context.Context, image, digest string) (bool, error) {
+	ref := parseImage(image)
+	tag := strings.Replace(digest, ":", "-", 1) + ".att"
+	var manifest struct {
+		Layers []struct {
+			Digest string `json:"digest"`
+		} `json:"layers"`
+	}
+	err := r.getJSON(ctx, ref, "manifests/"+tag, manifestMediaTypes, &manifest)
+	if errors.Is(err, errRegistryNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	for _, layer := range manifest.Layers {
+		var envelope struct {
+			Payload string `json:"payload"`
+		}
+		if err := r.getJSON(ctx, ref, "blobs/"+layer.Digest, nil, &envelope); err != nil {
+			return false, err
+		}
+		payload, err := base64.StdEncoding.DecodeString(envelope.Payload)
+		if err != nil {
+			klog.Warningf("Invalid attestation payload in %s of %s: %v", layer.Digest, image, err)
+			continue
+		}
+		var statement struct {
+			PredicateType string `json:"predicateType"`
+		}
+		if err := json.Unmarshal(payload, &statement); err != nil {
+			klog.Warningf("Invalid attestation statement in %s of %s: %v", layer.Digest, image, err)
+			continue
+		}
+		for _, predicateType := range sbomPredicateTypes {
+			if strings.HasPrefix(statement.PredicateType, predicateType) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// checkSBOM 镜像必须带有 SBOM attestation
+// 这是合规要求, 查询失败时总是拒绝, 不受 InspectFailClosed 影响
+func (s *WebhookServer) checkSBOM(ctx context.Context, policy *Policy, pod *corev1.Pod) *denial {
+	if !policy.RequireSBOM {
+		return nil
+	}
+	if s.Attestations == nil || s.Inspector == nil {
+		return &denial{
+			code:    http.StatusInternalServerError,
+			message: "SBOM attestations are required, but the webhook has no attestation verifier configured.",
+		}
+	}
+	for _, container := range podContainers(pod) {
+		digest, err := s.Inspector.digest(ctx, container.Image)
+		if err == nil {
+			var found bool
+			if found, err = s.Attestations.hasSBOM(ctx, container.Image, digest); err == nil && !found {
+				return &denial{
+					code:    http.StatusForbidden,
+					message: fmt.Sprintf("%s image has no SBOM attestation! Please attest the image with an SPDX or CycloneDX SBOM.", container.Image),
+				}
+			}
+		}
+		if err != nil {
+			klog.Errorf("Can't verify SBOM attestation of %s: %v", container.Image, err)
+			return &denial{
+				code:    http.StatusInternalServerError,
+				message: fmt.Sprintf("can't verify SBOM attestation of %s: %v", container.Image, err),
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package pkg
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeVerifier 按 digest 返回是否带有 SBOM, 记录查询的次数
+type fakeVerifier struct {
+	sboms map[string]bool
+	err   error
+	calls int
+}
+
+func (f *fakeVerifier) HasSBOM(_ context.Context, _, digest string) (bool, error) {
+	f.calls++
+	return f.sboms[digest], f.err
+}
+
+func TestCheckSBOM(t *testing.T) {
+	attested, unattested := "registry.corp.com/app:1", "registry.corp.com/legacy:1"
+	verifier := &fakeVerifier{sboms: map[string]bool{testDigest(attested): true}}
+	tests := []struct {
+		name              string
+		image             string
+		policy            Policy
+		noVerifier        bool
+		err, inspectorErr error
+		wantCode          int
+	}{
+		{name: "attested", image: attested, policy: Policy{RequireSBOM: true}},
+		{name: "no attestation", image: unattested, policy: Policy{RequireSBOM: true}, wantCode: http.StatusForbidden},
+		{name: "verify error fails closed", image: unattested, policy: Policy{RequireSBOM: true}, err: errors.New("registry unavailable"), wantCode: http.StatusInternalServerError},
+		{name: "digest error fails closed", image: unattested, policy: Policy{RequireSBOM: true}, inspectorErr: errors.New("registry unavailable"), wantCode: http.StatusInternalServerError},
+		{name: "no verifier configured", image: attested, policy: Policy{RequireSBOM: true}, noVerifier: true, wantCode: http.StatusInternalServerError},
+		{name: "not required", image: unattested},
+	}
+	discardLogs(t)
+	for _, tt := range tests {
+		verifier.err = tt.err
+		s := &WebhookServer{Inspector: NewCachedInspector(&fakeInspector{err: tt.inspectorErr}), Attestations: NewCachedVerifier(verifier)}
+		if tt.noVerifier {
+			s.Attestations = nil
+		}
+		if code := denialCode(s.checkSBOM(context.Background(), &tt.policy, imagePod(tt.image))); code != tt.wantCode {
+			t.Errorf("%s: got code %d, want %d", tt.name, code, tt.wantCode)
+		}
+	}
+}
+
+func TestCachedVerifierCachesOnlyAttestedImages(t *testing.T) {
+	attested, unattested := "registry.corp.com/app:1", "registry.corp.com/legacy:1"
+	verifier := &fakeVerifier{sboms: map[string]bool{testDigest(attested): true}}
+	cached := NewCachedVerifier(verifier)
+	for i := 0; i < 3; i++ {
+		cached.hasSBOM(context.Background(), attested, testDigest(attested))
+		cached.hasSBOM(context.Background(), unattested, testDigest(unattested))
+	}
+	// 找到 attestation 的镜像只查询一次, 没有找到的每次都重新查询
+	if verifier.calls != 4 {
+		t.Errorf("queried the verifier %d times, want 4", verifier.calls)
+	}
+}
+
+// attestationLayer 返回 payload 为 predicateType 的 in-toto statement 的 DSSE envelope
+func attestationLayer(predicateType string) string {
+	payload := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf(`{"_type": "https://in-toto.io/Statement/v0.1", "predicateType": %q}`, predicateType)))
+	return fmt.Sprintf(`{"payloadType": "application/vnd.in-toto+json", "payload": %q}`, payload)
+}
+
+func TestRegistryInspectorHasSBOM(t *testing.T) {
+	tests := []struct {
+		name   string
+		layers []string // 为 nil 时仓库中没有 attestation
+		want   bool
+	}{
+		{name: "SPDX", layers: []string{attestationLayer("https://spdx.dev/Document")}, want: true},
+		{name: "CycloneDX", layers: []string{attestationLayer("https://cyclonedx.org/bom/v1.4")}, want: true},
+		{name: "provenance only", layers: []string{attestationLayer("https://slsa.dev/provenance/v0.2")}},
+		{name: "SBOM after an invalid layer", layers: []string{`{"payload": "not base64!"}`, attestationLayer("https://spdx.dev/Document")}, want: true},
+		{name: "no attestation"},
+	}
+	digest := testDigest("app")
+	discardLogs(t)
+	for _, tt := range tests {
+		server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if tt.layers == nil {
+				http.NotFound(w, r)
+				return
+			}
+			if r.URL.Path == "/v2/app/manifests/"+strings.Replace(digest, ":", "-", 1)+".att" {
+				var manifest struct {
+					Layers []map[string]string `json:"layers"`
+				}
+				for i := range tt.layers {
+					manifest.Layers = append(manifest.Layers, map[string]string{"digest": fmt.Sprintf("sha256:%d", i)})
+				}
+				json.NewEncoder(w).Encode(manifest)
+				return
+			}
+			var i int
+			if _, err := fmt.Sscanf(r.URL.Path, "/v2/app/blobs/sha256:%d", &i); err != nil || i >= len(tt.layers) {
+				http.NotFound(w, r)
+				return
+			}
+			w.Write([]byte(tt.layers[i]))
+		}))
+		inspector := &RegistryInspector{Client: server.Client()}
+		image := strings.TrimPrefix(server.URL, "https://") + "/app:1"
+		got, err := inspector.HasSBOM(context.Background(), image, digest)
+		server.Close()
+		if err != nil || got != tt.want {
+			t.Errorf("%s: got %v, %v, want %v", tt.name, got, err, tt.want)
+		}
+	}
+}
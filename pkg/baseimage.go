@@ -0,0 +1,52 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog"
+)
+
+// 默认记录基础镜像的 label
+const defaultBaseImageLabel = "base-image"
+
+// checkBaseImages 镜像必须基于允许的基础镜像构建, 基础镜像通过镜像的 label 来判断
+func (s *WebhookServer) checkBaseImages(ctx context.Context, policy *Policy, pod *corev1.Pod) *denial {
+	if len(policy.AllowedBaseImages) == 0 || s.Inspector == nil {
+		return nil
+	}
+	label := policy.BaseImageLabel
+	if label == "" {
+		label = defaultBaseImageLabel
+	}
+	for i, container := range podContainers(pod) {
+		info, err := s.Inspector.inspectImage(ctx, container.Image)
+		if err != nil {
+			klog.Errorf("Can't inspect image %s: %v", container.Image, err)
+			return &denial{
+				code:    http.StatusForbidden,
+				message: fmt.Sprintf("can't verify base image of %s: %v", container.Image, err),
+			}
+		}
+		base := info.Labels[label]
+		approved := false
+		for _, allowed := range policy.AllowedBaseImages {
+			if base != "" && strings.HasPrefix(base, allowed) {
+				approved = true
+				break
+			}
+		}
+		if !approved {
+			return &denial{
+				code: http.StatusForbidden,
+				message: fmt.Sprintf("%s image is not built from an approved base image (label %s=%q)! Only base images %v are allowed.",
+					container.Image, label, base, policy.AllowedBaseImages),
+				field: containerField(pod, i) + ".image",
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// countingInspector 记录查询元数据的次数, 用于测试按 digest 缓存
+type countingInspector struct {
+	*fakeInspector
+	inspects int
+}
+
+func (c *countingInspector) Inspect(ctx context.Context, image, digest string) (*ImageInfo, error) {
+	c.inspects++
+	return c.fakeInspector.Inspect(ctx, image, digest)
+}
+
+func TestCheckBaseImages(t *testing.T) {
+	approved, other, unlabelled := "registry.corp.com/app:1", "registry.corp.com/tools:1", "registry.corp.com/raw:1"
+	inspector := &fakeInspector{infos: map[string]*ImageInfo{
+		testDigest(approved): {Labels: map[string]string{"base-image": "registry.corp.com/base/alpine:3.13", "org.corp.base": "ubuntu:20.04"}},
+		testDigest(other):    {Labels: map[string]string{"base-image": "ubuntu:20.04", "org.corp.base": "registry.corp.com/base/debian:10"}},
+	}}
+	tests := []struct {
+		name      string
+		init, app string
+		policy    Policy
+		err       error
+		wantCode  int
+		wantField string
+	}{
+		{name: "approved", init: approved, app: approved},
+		{name: "app container", init: approved, app: other, wantCode: http.StatusForbidden, wantField: "containers[0].image"},
+		{name: "init container", init: other, app: approved, wantCode: http.StatusForbidden, wantField: "initContainers[0].image"},
+		{name: "missing label", init: approved, app: unlabelled, wantCode: http.StatusForbidden, wantField: "containers[0].image"},
+		{name: "custom label", init: other, app: other, policy: Policy{BaseImageLabel: "org.corp.base"}},
+		{name: "custom label ignores the default", init: approved, app: approved, policy: Policy{BaseImageLabel: "org.corp.base"}, wantCode: http.StatusForbidden, wantField: "initContainers[0].image"},
+		{name: "inspect error", init: approved, app: approved, err: errors.New("registry unavailable"), wantCode: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inspector.err = tt.err
+			s := &WebhookServer{Inspector: NewCachedInspector(inspector)}
+			policy := tt.policy
+			policy.AllowedBaseImages = []string{"registry.corp.com/base/"}
+			pod := &corev1.Pod{Spec: corev1.PodSpec{
+				InitContainers: []corev1.Container{{Name: "setup", Image: tt.init}},
+				Containers:     []corev1.Container{{Name: "app", Image: tt.app}},
+			}}
+			d := s.checkBaseImages(context.Background(), &policy, pod)
+			assertDenial(t, d, tt.wantCode, false)
+			if d != nil && d.field != tt.wantField {
+				t.Errorf("denied field %q, want %q", d.field, tt.wantField)
+			}
+		})
+	}
+}
+
+func TestCheckBaseImagesCachesByDigest(t *testing.T) {
+	image := "registry.corp.com/app:1"
+	inspector := &countingInspector{fakeInspector: &fakeInspector{infos: map[string]*ImageInfo{
+		testDigest(image): {Labels: map[string]string{"base-image": "registry.corp.com/base/alpine:3.13"}},
+	}}}
+	s := &WebhookServer{Inspector: NewCachedInspector(inspector)}
+	policy := &Policy{AllowedBaseImages: []string{"registry.corp.com/base/"}}
+	for i := 0; i < 3; i++ {
+		if d := s.checkBaseImages(context.Background(), policy, imagePod(image, image)); d != nil {
+			t.Fatalf("request %d: %s", i, d.message)
+		}
+	}
+	if inspector.inspects != 1 {
+		t.Errorf("inspected the image %d times, want 1", inspector.inspects)
+	}
+}
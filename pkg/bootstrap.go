@@ -0,0 +1,49 @@
+package pkg
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	admissionV1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+)
+
+var bootstrapMode = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "admission_bootstrap_mode",
+	Help: "Whether the webhook is in the bootstrap window allowing all images (1) or enforcing (0).",
+})
+
+func init() {
+	prometheus.MustRegister(bootstrapMode)
+}
+
+// bootstrapping 是否还在启动后的 bootstrap 窗口内, 同时更新 bootstrap 模式的指标
+// 窗口结束之后自动切换到正常的检查, 不需要重启
+func (s *WebhookServer) bootstrapping() bool {
+	if s.BootstrapUntil.IsZero() || !s.now().Before(s.BootstrapUntil) {
+		bootstrapMode.Set(0)
+		return false
+	}
+	bootstrapMode.Set(1)
+	return true
+}
+
+// bootstrapResponse 把 bootstrap 窗口内被拒绝的请求改为允许, 拒绝的原因作为警告返回
+// 集群刚开始搭建时核心组件的仓库可能还没有加入白名单, 避免 webhook 自己和这些组件互相依赖
+func (s *WebhookServer) bootstrapResponse(uid string, resp *admissionV1.AdmissionResponse) *admissionV1.AdmissionResponse {
+	if resp.Allowed {
+		return resp
+	}
+	klog.Warningf("Bootstrap mode allows UID=%s which would be denied: %s", uid, resp.Result.Message)
+	return &admissionV1.AdmissionResponse{
+		Allowed: true,
+		Warnings: append(resp.Warnings, fmt.Sprintf("%s (allowed during bootstrap until %s)",
+			resp.Result.Message, s.BootstrapUntil.Format(time.RFC3339))),
+		Result: &metav1.Status{
+			Code: http.StatusOK,
+		},
+	}
+}
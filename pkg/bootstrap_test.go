@@ -0,0 +1,45 @@
+package pkg
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/haozi4263/admission-registry/pkg/testutil"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	admissionV1 "k8s.io/api/admission/v1"
+)
+
+func TestBootstrapWindow(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name           string
+		image          string
+		bootstrapUntil time.Time
+		wantCode       int32
+		wantWarning    bool
+		wantMode       float64
+	}{
+		{name: "denied image during bootstrap", image: "quay.io/app:1", bootstrapUntil: now.Add(time.Minute), wantCode: http.StatusOK, wantWarning: true, wantMode: 1},
+		{name: "allowed image during bootstrap", image: "nginx:1.21", bootstrapUntil: now.Add(time.Minute), wantCode: http.StatusOK, wantMode: 1},
+		{name: "window ended", image: "quay.io/app:1", bootstrapUntil: now, wantCode: http.StatusForbidden},
+		{name: "not configured", image: "quay.io/app:1", wantCode: http.StatusForbidden},
+	}
+	discardLogs(t)
+	defer bootstrapMode.Set(0)
+	for _, tt := range tests {
+		s := &WebhookServer{BootstrapUntil: tt.bootstrapUntil, Clock: func() time.Time { return now }}
+		s.SetConfig(Config{Base: Policy{WhiteListRegistries: []string{"docker.io"}}})
+		resp := s.Review(testutil.NewPodAdmissionReview(testPod("web", tt.image), admissionV1.Create))
+		if resp.Result == nil || resp.Result.Code != tt.wantCode {
+			t.Errorf("%s: got result %+v, want code %d", tt.name, resp.Result, tt.wantCode)
+		}
+		if warned := len(resp.Warnings) == 1 && strings.Contains(resp.Warnings[0], "allowed during bootstrap until 2026-01-01T12:01:00Z"); warned != tt.wantWarning {
+			t.Errorf("%s: got warnings %q, want bootstrap warning %v", tt.name, resp.Warnings, tt.wantWarning)
+		}
+		if mode := promtestutil.ToFloat64(bootstrapMode); mode != tt.wantMode {
+			t.Errorf("%s: bootstrap mode gauge = %v, want %v", tt.name, mode, tt.wantMode)
+		}
+	}
+}
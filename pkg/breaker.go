@@ -0,0 +1,151 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// ErrCircuitOpen 熔断打开时直接返回的错误, 调用方按照查询失败处理 (默认放行, 或者按配置拒绝)
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// 熔断打开后默认等待多久再尝试恢复
+const defaultBreakerCooldown = 30 * time.Second
+
+// CircuitBreakers 最多保存多少个 key 的熔断器, 超过时淘汰最久没有使用的
+const defaultBreakerKeys = 1000
+
+var breakerOpen = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "admission_circuit_breaker_open",
+	Help: "Whether the circuit breaker of an external dependency is open (1) or closed (0).",
+}, []string{"dependency"})
+
+func init() {
+	prometheus.MustRegister(breakerOpen)
+}
+
+// CircuitBreaker 外部依赖的熔断器
+// 连续失败 Threshold 次之后打开, 打开期间不再调用依赖, 直接返回 ErrCircuitOpen, 避免每个请求都要等到超时
+// 打开 Cooldown 之后放行一次调用作为探测, 成功则关闭, 失败则继续打开
+type CircuitBreaker struct {
+	Name      string        // 依赖的名字, 作为指标的 label
+	Threshold int           // 连续失败多少次之后打开, 为 0 时不熔断
+	Cooldown  time.Duration // 打开之后多久开始探测, 为 0 时使用默认值
+
+	mu       sync.Mutex
+	failures int
+	open     bool
+	openedAt time.Time
+	probing  bool
+}
+
+// Do 通过熔断器调用 fn, b 为 nil 时直接调用
+// 只有网络错误, 超时和 5xx 算作失败, 4xx 说明依赖本身是正常的, 和成功一样处理
+func (b *CircuitBreaker) Do(fn func() error) error {
+	if b == nil || b.Threshold <= 0 {
+		return fn()
+	}
+	allowed, probe := b.allow()
+	if !allowed {
+		return ErrCircuitOpen
+	}
+	err := fn()
+	b.record(breakerFailure(err), probe)
+	return err
+}
+
+// allow 判断是否放行这次调用, probe 为 true 表示这次调用是打开之后的探测
+func (b *CircuitBreaker) allow() (allowed, probe bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.open {
+		return true, false
+	}
+	cooldown := b.Cooldown
+	if cooldown == 0 {
+		cooldown = defaultBreakerCooldown
+	}
+	// 同一时间只放行一个探测请求
+	if b.probing || time.Since(b.openedAt) < cooldown {
+		return false, false
+	}
+	b.probing = true
+	return true, true
+}
+
+func (b *CircuitBreaker) record(failed, probe bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if probe {
+		b.probing = false
+	} else if b.open {
+		// 打开之前就已经发出的调用, 结果不影响熔断状态, 由探测决定是否关闭
+		return
+	}
+	if !failed {
+		b.failures = 0
+		b.open = false
+		breakerOpen.WithLabelValues(b.Name).Set(0)
+		return
+	}
+	b.failures++
+	if b.open || b.failures >= b.Threshold {
+		b.open = true
+		b.openedAt = time.Now()
+		breakerOpen.WithLabelValues(b.Name).Set(1)
+	}
+}
+
+// statusError 依赖返回了非预期的 HTTP 状态码
+type statusError struct {
+	Code    int
+	Message string
+}
+
+func (e *statusError) Error() string { return e.Message }
+
+// breakerFailure 判断 err 是否说明依赖不可用: 网络错误, 超时和 5xx
+// 调用方取消请求不算依赖的问题
+func breakerFailure(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var status *statusError
+	if errors.As(err, &status) {
+		return status.Code >= 500
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// CircuitBreakers 按 key 分别熔断, 比如每个镜像仓库一个熔断器, 一个仓库不可用不会影响其他仓库
+type CircuitBreakers struct {
+	Name      string        // 依赖的名字, 指标的 label 为 Name/key
+	Threshold int           // 同 CircuitBreaker
+	Cooldown  time.Duration // 同 CircuitBreaker
+
+	mu       sync.Mutex
+	breakers *lruCache
+}
+
+// For 返回 key 对应的熔断器, 没有时创建, s 为 nil 时返回 nil
+func (s *CircuitBreakers) For(key string) *CircuitBreaker {
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.breakers == nil {
+		s.breakers = newLRUCache(defaultBreakerKeys)
+	}
+	if b, ok := s.breakers.Get(key); ok {
+		return b.(*CircuitBreaker)
+	}
+	b := &CircuitBreaker{Name: s.Name + "/" + key, Threshold: s.Threshold, Cooldown: s.Cooldown}
+	s.breakers.Add(key, b)
+	return b
+}
@@ -0,0 +1,177 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+var errUnavailable = &statusError{Code: 503, Message: "service unavailable"}
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	b := &CircuitBreaker{Name: "test", Threshold: 2, Cooldown: 20 * time.Millisecond}
+	calls := 0
+	call := func(err error) error {
+		return b.Do(func() error {
+			calls++
+			return err
+		})
+	}
+
+	// closed: 失败次数没有达到阈值
+	call(errUnavailable)
+	if call(nil) != nil || b.open {
+		t.Fatal("breaker should stay closed below the threshold")
+	}
+	call(errUnavailable)
+	call(errUnavailable)
+	if !b.open {
+		t.Fatal("breaker should open after Threshold consecutive failures")
+	}
+
+	// open: 不再调用依赖
+	calls = 0
+	if err := call(nil); err != ErrCircuitOpen || calls != 0 {
+		t.Fatalf("open breaker: err=%v calls=%d, want ErrCircuitOpen without calling", err, calls)
+	}
+
+	// half-open: 探测失败之后继续打开
+	time.Sleep(30 * time.Millisecond)
+	if err := call(errUnavailable); err != errUnavailable || calls != 1 {
+		t.Fatalf("probe: err=%v calls=%d", err, calls)
+	}
+	if err := call(nil); err != ErrCircuitOpen {
+		t.Fatalf("failed probe should reopen the breaker, got %v", err)
+	}
+
+	// half-open: 探测成功之后关闭
+	time.Sleep(30 * time.Millisecond)
+	if err := call(nil); err != nil {
+		t.Fatalf("probe: %v", err)
+	}
+	if b.open || b.failures != 0 {
+		t.Fatal("successful probe should close the breaker")
+	}
+}
+
+func TestCircuitBreakerSingleProbe(t *testing.T) {
+	b := &CircuitBreaker{Name: "test", Threshold: 1, Cooldown: 20 * time.Millisecond}
+
+	// 打开之前发出的调用, 在探测期间才返回
+	staleRelease, staleDone := make(chan struct{}), make(chan error)
+	go func() {
+		staleDone <- b.Do(func() error {
+			<-staleRelease
+			return nil
+		})
+	}()
+	time.Sleep(5 * time.Millisecond)
+	b.Do(func() error { return errUnavailable })
+	time.Sleep(30 * time.Millisecond)
+
+	probeStarted, probeRelease, probeDone := make(chan struct{}), make(chan struct{}), make(chan error)
+	go func() {
+		probeDone <- b.Do(func() error {
+			close(probeStarted)
+			<-probeRelease
+			return nil
+		})
+	}()
+	<-probeStarted
+
+	close(staleRelease)
+	if err := <-staleDone; err != nil {
+		t.Fatal(err)
+	}
+	// 旧调用的结果不能释放探测的名额, 也不能关闭熔断
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := b.Do(func() error { return nil }); err != ErrCircuitOpen {
+				t.Errorf("call during probe: got %v, want ErrCircuitOpen", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	close(probeRelease)
+	if err := <-probeDone; err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Do(func() error { return nil }); err != nil {
+		t.Fatalf("breaker should be closed after the probe succeeded, got %v", err)
+	}
+}
+
+func TestBreakerFailure(t *testing.T) {
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{nil, false},
+		{&statusError{Code: 404}, false},
+		{fmt.Errorf("registry returned 404: %w", errRegistryNotFound), false},
+		{&statusError{Code: 401}, false},
+		{&statusError{Code: 429}, false},
+		{&statusError{Code: 500}, true},
+		{fmt.Errorf("inspect: %w", &statusError{Code: 502}), true},
+		{&net.OpError{Op: "dial", Err: errors.New("connection refused")}, true},
+		{context.DeadlineExceeded, true},
+		{context.Canceled, false},
+	}
+	for _, tt := range tests {
+		if got := breakerFailure(tt.err); got != tt.want {
+			t.Errorf("breakerFailure(%v) = %v, want %v", tt.err, got, tt.want)
+		}
+	}
+}
+
+func TestCircuitBreakerIgnoresClientErrors(t *testing.T) {
+	b := &CircuitBreaker{Name: "test", Threshold: 2}
+	for i := 0; i < 5; i++ {
+		b.Do(func() error { return &statusError{Code: 404, Message: "not found"} })
+	}
+	if b.open {
+		t.Fatal("4xx responses should not open the breaker")
+	}
+}
+
+func TestCircuitBreakersPerKey(t *testing.T) {
+	s := &CircuitBreakers{Name: "registry", Threshold: 1, Cooldown: time.Minute}
+	s.For("broken.example.com").Do(func() error { return errUnavailable })
+	if err := s.For("broken.example.com").Do(func() error { return nil }); err != ErrCircuitOpen {
+		t.Fatalf("broken registry: got %v, want ErrCircuitOpen", err)
+	}
+	if err := s.For("docker.io").Do(func() error { return nil }); err != nil {
+		t.Fatalf("other registries should not be affected, got %v", err)
+	}
+	var nilSet *CircuitBreakers
+	if err := nilSet.For("docker.io").Do(func() error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCircuitBreakerConcurrent(t *testing.T) {
+	s := &CircuitBreakers{Name: "registry", Threshold: 3, Cooldown: time.Millisecond}
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				var err error
+				if (i+j)%3 == 0 {
+					err = errUnavailable
+				}
+				s.For(fmt.Sprintf("registry%d.example.com", i%4)).Do(func() error { return err })
+			}
+		}(i)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,181 @@
+package pkg
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	admissionV1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+)
+
+// breakGlassAnnotation pod 上携带 break-glass token 的注解, 工作负载写在 pod 模板上
+const breakGlassAnnotation = "admission-registry/break-glass"
+
+// break-glass token 默认最长的有效期
+const defaultBreakGlassMaxTTL = time.Hour
+
+var breakGlassTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Name: "admission_break_glass_total",
+	Help: "Number of denied requests carrying a break-glass token, by result (accepted or rejected).",
+}, []string{"result"})
+
+func init() {
+	prometheus.MustRegister(breakGlassTotal)
+}
+
+// BreakGlassClaims break-glass token 的内容, 只在一个命名空间内有效, 过期之后失效
+type BreakGlassClaims struct {
+	Namespace string    `json:"namespace"`        // token 生效的命名空间
+	Approver  string    `json:"approver"`         // 批准的人, 记录在审计信息中
+	Reason    string    `json:"reason,omitempty"` // 原因, 比如故障单号
+	IssuedAt  time.Time `json:"issuedAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+}
+
+// SignBreakGlassToken 用 key 签发 break-glass token, 格式为 base64(claims).base64(HMAC-SHA256)
+// 值写在 pod 的 admission-registry/break-glass 注解上
+func SignBreakGlassToken(key []byte, claims BreakGlassClaims) (string, error) {
+	if claims.Namespace == "" || claims.Approver == "" {
+		return "", errors.New("break-glass token needs a namespace and an approver")
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(breakGlassMAC(key, encoded)), nil
+}
+
+func breakGlassMAC(key []byte, payload string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// verifyBreakGlassToken 校验签名, 命名空间和有效期, 有效期超过 maxTTL 的 token 同样拒绝
+func verifyBreakGlassToken(key []byte, token, namespace string, now time.Time, maxTTL time.Duration) (*BreakGlassClaims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 2 {
+		return nil, errors.New("malformed token")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(signature, breakGlassMAC(key, parts[0])) {
+		return nil, errors.New("invalid signature")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, errors.New("malformed token")
+	}
+	var claims BreakGlassClaims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, errors.New("malformed token")
+	}
+	if claims.Namespace != namespace {
+		return nil, fmt.Errorf("token is for namespace %q", claims.Namespace)
+	}
+	if claims.Approver == "" {
+		return nil, errors.New("token has no approver")
+	}
+	if !now.Before(claims.ExpiresAt) {
+		return nil, fmt.Errorf("token expired at %s", claims.ExpiresAt.Format(time.RFC3339))
+	}
+	if claims.ExpiresAt.Sub(claims.IssuedAt) > maxTTL || claims.IssuedAt.After(now) {
+		return nil, fmt.Errorf("token must be issued in the past and valid for at most %s", maxTTL)
+	}
+	return &claims, nil
+}
+
+// breakGlass 故障处理时允许带有有效 break-glass token 的 pod 绕过被拒绝的检查
+// 绕过时打印警告日志, 记录事件, 批准人写进审计信息和发送给 DecisionSink 的决定中
+// token 无效时保持拒绝, 原因作为警告返回
+func (s *WebhookServer) breakGlass(req *admissionV1.AdmissionRequest, pod *corev1.Pod, resp *admissionV1.AdmissionResponse) *admissionV1.AdmissionResponse {
+	if resp.Allowed || pod == nil || len(s.BreakGlassKey) == 0 {
+		return resp
+	}
+	token := pod.Annotations[breakGlassAnnotation]
+	if token == "" {
+		return resp
+	}
+	maxTTL := s.BreakGlassMaxTTL
+	if maxTTL == 0 {
+		maxTTL = defaultBreakGlassMaxTTL
+	}
+	claims, err := verifyBreakGlassToken(s.BreakGlassKey, token, req.Namespace, s.now(), maxTTL)
+	if err != nil {
+		klog.Warningf("Rejected break-glass token on %s %s/%s UID=%s: %v", req.Kind.Kind, req.Namespace, req.Name, req.UID, err)
+		breakGlassTotal.WithLabelValues("rejected").Inc()
+		resp.Warnings = append(resp.Warnings, fmt.Sprintf("break-glass token rejected: %v", err))
+		return resp
+	}
+	klog.Warningf("BREAK-GLASS: allowing %s %s/%s UID=%s approved by %s (reason: %q, expires %s), bypassed: %s",
+		req.Kind.Kind, req.Namespace, req.Name, req.UID, claims.Approver, claims.Reason,
+		claims.ExpiresAt.Format(time.RFC3339), resp.Result.Message)
+	breakGlassTotal.WithLabelValues("accepted").Inc()
+	s.recordBreakGlassEvent(req, claims, resp.Result.Message)
+
+	annotations := make(map[string]string, len(resp.AuditAnnotations)+3)
+	for k, v := range resp.AuditAnnotations {
+		annotations[k] = v
+	}
+	annotations["break-glass-approver"] = claims.Approver
+	annotations["break-glass-reason"] = claims.Reason
+	annotations["break-glass-bypassed"] = resp.Result.Message
+	return &admissionV1.AdmissionResponse{
+		Allowed: true,
+		Warnings: append(resp.Warnings, fmt.Sprintf("%s (bypassed by break-glass token approved by %s until %s)",
+			resp.Result.Message, claims.Approver, claims.ExpiresAt.Format(time.RFC3339))),
+		AuditAnnotations: annotations,
+		Result: &metav1.Status{
+			Code: http.StatusOK,
+		},
+	}
+}
+
+// recordBreakGlassEvent 在对象所在的命名空间中记录一个 Warning 事件, 异步创建, 不阻塞准入请求
+func (s *WebhookServer) recordBreakGlassEvent(req *admissionV1.AdmissionRequest, claims *BreakGlassClaims, bypassed string) {
+	if s.Client == nil {
+		return
+	}
+	now := metav1.NewTime(s.now())
+	event := &corev1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "admission-registry-break-glass-",
+			Namespace:    req.Namespace,
+		},
+		InvolvedObject: corev1.ObjectReference{
+			APIVersion: req.Kind.Version,
+			Kind:       req.Kind.Kind,
+			Namespace:  req.Namespace,
+			Name:       req.Name,
+		},
+		Reason: "BreakGlass",
+		Message: fmt.Sprintf("admission policy bypassed with break-glass token approved by %s (reason: %q): %s",
+			claims.Approver, claims.Reason, bypassed),
+		Type:           corev1.EventTypeWarning,
+		Source:         corev1.EventSource{Component: "admission-registry"},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}
+	if req.Kind.Group != "" {
+		event.InvolvedObject.APIVersion = req.Kind.Group + "/" + req.Kind.Version
+	}
+	go func() {
+		ctx, cancel := s.lookupContext(context.Background())
+		defer cancel()
+		if _, err := s.Client.CoreV1().Events(req.Namespace).Create(ctx, event, metav1.CreateOptions{}); err != nil {
+			klog.Errorf("Failed to record break-glass event for %s/%s: %v", req.Namespace, req.Name, err)
+		}
+	}()
+}
@@ -0,0 +1,136 @@
+package pkg
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/haozi4263/admission-registry/pkg/testutil"
+	admissionV1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// captureSink 保存收到的准入决定
+type captureSink struct {
+	mu        sync.Mutex
+	decisions []Decision
+}
+
+func (c *captureSink) Send(d Decision) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.decisions = append(c.decisions, d)
+}
+
+func TestBreakGlass(t *testing.T) {
+	key := []byte("break-glass-key")
+	now := time.Date(2021, 6, 1, 12, 0, 0, 0, time.UTC)
+	sign := func(key []byte, claims BreakGlassClaims) string {
+		token, err := SignBreakGlassToken(key, claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+	// tamper 把 token 的内容换成 other 的内容, 保留原来的签名
+	tamper := func(token, other string) string {
+		return strings.Split(other, ".")[0] + "." + strings.Split(token, ".")[1]
+	}
+	valid := BreakGlassClaims{Namespace: "team", Approver: "sre-oncall", Reason: "INC-42", IssuedAt: now.Add(-time.Minute), ExpiresAt: now.Add(30 * time.Minute)}
+	expired := valid
+	expired.IssuedAt, expired.ExpiresAt = now.Add(-2*time.Hour), now.Add(-time.Hour)
+	otherNamespace := valid
+	otherNamespace.Namespace = "prod"
+	tooLong := valid
+	tooLong.ExpiresAt = now.Add(24 * time.Hour)
+	tests := []struct {
+		name        string
+		token       string
+		wantAllowed bool
+		wantWarning string
+	}{
+		{name: "valid", token: sign(key, valid), wantAllowed: true, wantWarning: "bypassed by break-glass token approved by sre-oncall"},
+		{name: "expired", token: sign(key, expired), wantWarning: "token expired"},
+		{name: "forged", token: sign([]byte("attacker-key"), valid), wantWarning: "invalid signature"},
+		{name: "tampered", token: tamper(sign(key, otherNamespace), sign(key, valid)), wantWarning: "invalid signature"},
+		{name: "other namespace", token: sign(key, otherNamespace), wantWarning: `token is for namespace "prod"`},
+		{name: "valid for too long", token: sign(key, tooLong), wantWarning: "valid for at most 1h0m0s"},
+		{name: "malformed", token: "not-a-token", wantWarning: "malformed token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset()
+			sink := &captureSink{}
+			s := &WebhookServer{Client: client, Sink: sink, BreakGlassKey: key, Clock: func() time.Time { return now }}
+			s.SetConfig(Config{Base: Policy{WhiteListRegistries: []string{"docker.io"}}})
+			pod := testPod("web", "quay.io/app:1.0")
+			pod.Annotations = map[string]string{breakGlassAnnotation: tt.token}
+
+			resp, err := testutil.ServeReview(http.HandlerFunc(s.Handler), ValidatePath, testutil.NewPodAdmissionReview(pod, admissionV1.Create))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.Allowed != tt.wantAllowed {
+				t.Fatalf("allowed = %v, want %v (result %+v)", resp.Allowed, tt.wantAllowed, resp.Result)
+			}
+			if !strings.Contains(strings.Join(resp.Warnings, "\n"), tt.wantWarning) {
+				t.Errorf("warnings %q do not mention %q", resp.Warnings, tt.wantWarning)
+			}
+			if len(sink.decisions) != 1 {
+				t.Fatalf("sink received %d decisions, want 1", len(sink.decisions))
+			}
+			decision := sink.decisions[0]
+			if !tt.wantAllowed {
+				if decision.Allowed || decision.BreakGlassApprover != "" || resp.AuditAnnotations["break-glass-approver"] != "" {
+					t.Errorf("rejected token was recorded as a bypass: %+v", decision)
+				}
+				return
+			}
+
+			// 绕过时批准人写进审计信息和发送给 sink 的决定, 并且记录事件
+			if resp.AuditAnnotations["break-glass-approver"] != "sre-oncall" || resp.AuditAnnotations["break-glass-reason"] != "INC-42" ||
+				!strings.Contains(resp.AuditAnnotations["break-glass-bypassed"], "quay.io/app:1.0") {
+				t.Errorf("audit annotations = %v", resp.AuditAnnotations)
+			}
+			if !decision.Allowed || decision.BreakGlassApprover != "sre-oncall" || !strings.Contains(decision.Message, "quay.io/app:1.0") {
+				t.Errorf("decision = %+v", decision)
+			}
+			deadline := time.Now().Add(time.Second)
+			for {
+				events, err := client.CoreV1().Events("team").List(context.Background(), metav1.ListOptions{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if len(events.Items) == 1 {
+					if event := events.Items[0]; event.Reason != "BreakGlass" || event.InvolvedObject.Name != "web" || !strings.Contains(event.Message, "sre-oncall") {
+						t.Errorf("event = %+v", event)
+					}
+					break
+				}
+				if time.Now().After(deadline) {
+					t.Fatal("no break-glass event was recorded")
+				}
+				time.Sleep(10 * time.Millisecond)
+			}
+		})
+	}
+}
+
+func TestBreakGlassDisabledWithoutKey(t *testing.T) {
+	now := time.Now()
+	token, err := SignBreakGlassToken(nil, BreakGlassClaims{Namespace: "team", Approver: "sre-oncall", IssuedAt: now.Add(-time.Minute), ExpiresAt: now.Add(time.Minute)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &WebhookServer{}
+	s.SetConfig(Config{Base: Policy{WhiteListRegistries: []string{"docker.io"}}})
+	pod := testPod("web", "quay.io/app:1.0")
+	pod.Annotations = map[string]string{breakGlassAnnotation: token}
+	if resp := s.Review(testutil.NewPodAdmissionReview(pod, admissionV1.Create)); resp.Allowed {
+		t.Error("break-glass token was accepted without a configured key")
+	}
+}
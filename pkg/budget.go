@@ -0,0 +1,155 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	admissionV1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/klog"
+)
+
+// 命名空间资源用量的缓存时间, 短时间内连续创建的 pod 共用一次查询
+// 通过检查的 pod 会立即加到缓存的用量中, 所以缓存期间连续创建的 pod 不能一起超出预算
+const namespaceUsageTTL = 10 * time.Second
+
+type namespaceUsage struct {
+	requests corev1.ResourceList
+	expires  time.Time
+}
+
+// checkNamespaceBudget 创建 pod 时, 新 pod 的 CPU/内存 request 加上命名空间中已有 pod 的 request 不能超过配置的预算
+func (s *WebhookServer) checkNamespaceBudget(ctx context.Context, policy *Policy, req *admissionV1.AdmissionRequest, obj runtime.Object) *denial {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok || req.Operation != admissionV1.Create || s.Client == nil {
+		return nil
+	}
+	budget := policy.namespaceBudget()
+	if len(budget) == 0 {
+		return nil
+	}
+	used, err := s.namespaceUsage(ctx, pod.Namespace)
+	if err != nil {
+		return lookupFailed(policy, "pods in namespace "+pod.Namespace, err)
+	}
+	requested := podRequests(pod)
+	if name, current, ok := s.reserveUsage(pod.Namespace, used, requested, budget); !ok {
+		limit, add := budget[name], requested[name]
+		return &denial{
+			code: http.StatusForbidden,
+			message: fmt.Sprintf("pod %s requests %s %s, namespace %s already uses %s of its %s budget.",
+				pod.Name, add.String(), name, pod.Namespace, current.String(), limit.String()),
+		}
+	}
+	return nil
+}
+
+// reserveUsage 在预算之内时把 pod 的 request 加到缓存的用量中, 缓存过期之后以重新查询的结果为准
+// 比较和预留在同一把锁中完成, 同时创建的 pod (比如 ReplicaSet 扩容) 不能都基于同一份用量通过检查
+// 超出预算时返回超出的资源和当前的用量; 缓存不存在时以 used 为准
+// 之后的检查拒绝了这个 pod 时, 缓存期间会多算它的 request, 宁可多拒绝也不超出预算
+func (s *WebhookServer) reserveUsage(namespace string, used, requested, budget corev1.ResourceList) (corev1.ResourceName, resource.Quantity, bool) {
+	s.usageMu.Lock()
+	defer s.usageMu.Unlock()
+	cached, ok := s.usage[namespace]
+	if !ok {
+		cached = namespaceUsage{requests: used, expires: time.Now().Add(namespaceUsageTTL)}
+	}
+	for name, limit := range budget {
+		total := cached.requests[name].DeepCopy()
+		total.Add(requested[name])
+		if total.Cmp(limit) > 0 {
+			return name, cached.requests[name].DeepCopy(), false
+		}
+	}
+	// 缓存的用量可能正在被其它请求读取, 复制之后再修改
+	reserved := cached.requests.DeepCopy()
+	addResources(reserved, requested)
+	if s.usage == nil {
+		s.usage = make(map[string]namespaceUsage)
+	}
+	s.usage[namespace] = namespaceUsage{requests: reserved, expires: cached.expires}
+	return "", resource.Quantity{}, true
+}
+
+// namespaceBudget 解析策略中的预算, 非法的配置会被忽略
+func (p *Policy) namespaceBudget() corev1.ResourceList {
+	budget := corev1.ResourceList{}
+	for name, value := range map[corev1.ResourceName]string{
+		corev1.ResourceCPU:    p.NamespaceCPUBudget,
+		corev1.ResourceMemory: p.NamespaceMemoryBudget,
+	} {
+		if value == "" {
+			continue
+		}
+		q, err := resource.ParseQuantity(value)
+		if err != nil {
+			klog.Errorf("Invalid namespace %s budget %q: %v", name, value, err)
+			continue
+		}
+		budget[name] = q
+	}
+	return budget
+}
+
+// namespaceUsage 查询命名空间中所有未结束的 pod 的 request 总和, 结果会缓存 namespaceUsageTTL
+func (s *WebhookServer) namespaceUsage(ctx context.Context, namespace string) (corev1.ResourceList, error) {
+	s.usageMu.Lock()
+	cached, ok := s.usage[namespace]
+	s.usageMu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.requests, nil
+	}
+
+	ctx, cancel := s.lookupContext(ctx)
+	defer cancel()
+	pods, err := s.Client.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	used := corev1.ResourceList{}
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+			continue
+		}
+		addResources(used, podRequests(pod))
+	}
+
+	s.usageMu.Lock()
+	if s.usage == nil {
+		s.usage = make(map[string]namespaceUsage)
+	}
+	s.usage[namespace] = namespaceUsage{requests: used, expires: time.Now().Add(namespaceUsageTTL)}
+	s.usageMu.Unlock()
+	return used, nil
+}
+
+// podRequests 计算 pod 实际占用的 request: 所有容器之和与最大的 init 容器两者取大
+func podRequests(pod *corev1.Pod) corev1.ResourceList {
+	requests := corev1.ResourceList{}
+	for _, container := range pod.Spec.Containers {
+		addResources(requests, container.Resources.Requests)
+	}
+	for _, container := range pod.Spec.InitContainers {
+		for name, q := range container.Resources.Requests {
+			if current, ok := requests[name]; !ok || q.Cmp(current) > 0 {
+				requests[name] = q.DeepCopy()
+			}
+		}
+	}
+	return requests
+}
+
+func addResources(total, add corev1.ResourceList) {
+	for name, q := range add {
+		current := total[name]
+		current.Add(q)
+		total[name] = current
+	}
+}
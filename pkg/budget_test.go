@@ -0,0 +1,97 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func requestPod(name, cpu string, phase corev1.PodPhase) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "team", Name: name},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{
+			Name:      "app",
+			Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(cpu)}},
+		}}},
+		Status: corev1.PodStatus{Phase: phase},
+	}
+}
+
+func TestCheckNamespaceBudget(t *testing.T) {
+	tests := []struct {
+		name     string
+		cpu      string
+		wantCode int
+	}{
+		{name: "fits the remaining budget", cpu: "500m"},
+		{name: "exceeds the budget", cpu: "1500m", wantCode: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 已经使用 1500m, 结束的 pod 不计入
+			client := fake.NewSimpleClientset(
+				requestPod("running", "1", corev1.PodRunning),
+				requestPod("pending", "500m", corev1.PodPending),
+				requestPod("done", "4", corev1.PodSucceeded),
+			)
+			s := &WebhookServer{Client: client}
+			policy := &Policy{NamespaceCPUBudget: "2"}
+			req := admissionRequest("")
+			d := s.checkNamespaceBudget(context.Background(), policy, req, requestPod("new", tt.cpu, ""))
+			if code := denialCode(d); code != tt.wantCode {
+				t.Errorf("got code %d (%v), want %d", code, d, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestNamespaceBudgetBurst(t *testing.T) {
+	client := fake.NewSimpleClientset(requestPod("running", "1", corev1.PodRunning))
+	s := &WebhookServer{Client: client}
+	policy := &Policy{NamespaceCPUBudget: "2"}
+	req := admissionRequest("")
+	// 缓存期间连续创建的 pod 不能一起超出预算
+	if d := s.checkNamespaceBudget(context.Background(), policy, req, requestPod("first", "600m", "")); d != nil {
+		t.Fatalf("first pod denied: %s", d.message)
+	}
+	if d := s.checkNamespaceBudget(context.Background(), policy, req, requestPod("second", "600m", "")); d == nil {
+		t.Error("second pod within the cache TTL exceeded the budget but was allowed")
+	}
+	if lists := len(client.Actions()); lists != 1 {
+		t.Errorf("listed pods %d times, want 1", lists)
+	}
+}
+
+func TestNamespaceBudgetConcurrent(t *testing.T) {
+	client := fake.NewSimpleClientset(requestPod("running", "500m", corev1.PodRunning))
+	s := &WebhookServer{Client: client}
+	policy := &Policy{NamespaceCPUBudget: "1500m"}
+	req := admissionRequest("")
+	// 预热缓存之后同时创建 20 个 pod, 预算只够其中 10 个
+	if d := s.checkNamespaceBudget(context.Background(), policy, req, requestPod("first", "0", "")); d != nil {
+		t.Fatalf("empty pod denied: %s", d.message)
+	}
+	var allowed int32
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if d := s.checkNamespaceBudget(context.Background(), policy, req, requestPod(fmt.Sprintf("replica-%d", i), "100m", "")); d == nil {
+				atomic.AddInt32(&allowed, 1)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if allowed != 10 {
+		t.Errorf("allowed %d concurrent pods, want 10", allowed)
+	}
+}
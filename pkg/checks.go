@@ -0,0 +1,487 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	admissionV1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog"
+)
+
+// Request 检查时用到的请求信息
+type Request struct {
+	*admissionV1.AdmissionRequest
+	Object runtime.Object // 按照 Kind 解析后的对象
+	Policy *Policy        // 请求所在命名空间最终生效的策略
+	Debug  bool           // 请求被采样时打印每个检查的结果
+	Time   time.Time      // 执行检查的时间, 用于判断 Policy.EnforceAfter
+}
+
+// Severity 检查不通过时的严重程度
+type Severity string
+
+const (
+	SeverityEnforce Severity = "enforce" // 拒绝请求
+	SeverityWarn    Severity = "warn"    // 只返回警告
+)
+
+// Result 单个检查的结果
+type Result struct {
+	Allowed          bool
+	Message          string
+	Severity         Severity
+	Code             int                 // 拒绝时返回的 HTTP 状态码
+	Reason           metav1.StatusReason // 拒绝的原因, 为空时按照检查的名字映射
+	AuditAnnotations map[string]string   // 拒绝时附带的审计信息, 方便自动化工具处理
+	Field            string              // 不通过的字段路径, 比如 spec.containers[0].image, 不确定时为空
+	Check            string              // 产生结果的检查的名字, 由 CheckRegistry 设置
+}
+
+// Allow 检查通过的结果
+func Allow() Result {
+	return Result{Allowed: true}
+}
+
+// Check 一个准入检查, 新增策略只需要实现这个接口并注册到 CheckRegistry 中
+type Check interface {
+	// Name 检查的名字, 可以通过 Policy.DisabledChecks 按名字关闭
+	Name() string
+	// Evaluate 执行检查, pod 为请求中的 pod 或者工作负载的 pod 模板, 其它对象为 nil
+	Evaluate(ctx context.Context, pod *corev1.Pod, req *Request) Result
+}
+
+// podCheck 把只检查 pod 的函数包装成 Check, 请求中没有 pod 时直接通过
+type podCheck struct {
+	name string
+	fn   func(context.Context, *Policy, *corev1.Pod) *denial
+}
+
+func (c podCheck) Name() string { return c.name }
+
+func (c podCheck) Evaluate(ctx context.Context, pod *corev1.Pod, req *Request) Result {
+	if pod == nil {
+		return Allow()
+	}
+	result := c.fn(ctx, req.Policy, pod).result()
+	if result.Field != "" {
+		result.Field = podFieldPath(req.Kind.Kind, result.Field)
+	}
+	return result
+}
+
+// objectCheck 把检查对象本身(比如工作负载的副本数)的函数包装成 Check
+type objectCheck struct {
+	name string
+	fn   func(context.Context, *Policy, *admissionV1.AdmissionRequest, runtime.Object) *denial
+}
+
+func (c objectCheck) Name() string { return c.name }
+
+func (c objectCheck) Evaluate(ctx context.Context, _ *corev1.Pod, req *Request) Result {
+	return c.fn(ctx, req.Policy, req.AdmissionRequest, req.Object).result()
+}
+
+// CheckRegistry 按注册顺序执行检查
+type CheckRegistry struct {
+	checks []Check
+}
+
+// NewCheckRegistry 创建包含 checks 的 CheckRegistry
+func NewCheckRegistry(checks ...Check) *CheckRegistry {
+	return &CheckRegistry{checks: checks}
+}
+
+// Register 在最后追加一个检查
+func (r *CheckRegistry) Register(check Check) {
+	r.checks = append(r.checks, check)
+}
+
+// Checks 返回按执行顺序排列的所有检查
+func (r *CheckRegistry) Checks() []Check {
+	return r.checks
+}
+
+// Run 依次执行策略中没有关闭的检查
+// 默认遇到第一个 enforce 级别的拒绝就返回; 策略开启 CollectAllViolations 时执行所有检查, 把所有拒绝原因一起返回
+// warn 级别的结果不会拒绝请求, 会作为警告返回给用户
+// 策略配置了 LatencyBudget 时, 所有检查的总耗时超过预算后剩下的检查不再执行,
+// 预算同时作为 ctx 的超时, 正在执行的检查中的外部调用在预算用完时被取消, 按照各自的 fail-open/closed 配置处理
+func (r *CheckRegistry) Run(ctx context.Context, pod *corev1.Pod, req *Request) *admissionV1.AdmissionResponse {
+	var warnings []string
+	var violations []Result
+	var skipped []string
+	runStart := time.Now()
+	if budget := req.Policy.LatencyBudget.Duration; budget > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, budget)
+		defer cancel()
+	}
+	for _, check := range r.checks {
+		if skip, exempt := req.skipCheck(check.Name()); skip {
+			if exempt {
+				klog.Infof("Skipping check %s for exempt user %s, UID=%s", check.Name(), req.UserInfo.Username, req.UID)
+			}
+			continue
+		}
+		if budget := req.Policy.LatencyBudget.Duration; budget > 0 && time.Since(runStart) >= budget {
+			skipped = append(skipped, check.Name())
+			continue
+		}
+		start := time.Now()
+		result := check.Evaluate(ctx, pod, req)
+		checkDuration.WithLabelValues(check.Name()).Observe(time.Since(start).Seconds())
+		result, shadow := req.enforce(check.Name(), result)
+		if req.Debug {
+			debugLog(req.UID, "check evaluated", "check", check.Name(), "result", result)
+		}
+		if result.Allowed {
+			continue
+		}
+		// shadow 模式的检查只记录结果, 不影响最终的决定, 用来在生效之前评估新检查的影响
+		if shadow {
+			klog.Infof("Shadow check %s would deny UID=%s: %s", check.Name(), req.UID, result.Message)
+			shadowDeniedTotal.WithLabelValues(check.Name()).Inc()
+			continue
+		}
+		if result.Severity == SeverityWarn {
+			warnings = append(warnings, result.Message)
+			continue
+		}
+		violations = append(violations, result)
+		if !req.Policy.CollectAllViolations {
+			break
+		}
+	}
+	if len(skipped) > 0 {
+		if result := req.Policy.budgetExhausted(req.UID, skipped); !result.Allowed {
+			violations = append(violations, result)
+		}
+	}
+	if len(violations) == 0 {
+		return &admissionV1.AdmissionResponse{
+			Allowed:  true,
+			Warnings: warnings,
+			Result: &metav1.Status{
+				Code: http.StatusOK,
+			},
+		}
+	}
+	return deniedResponse(violations, warnings, req.Policy.DenialCauses)
+}
+
+// skipCheck 判断是否跳过检查: 策略关闭了检查, 或者请求的用户豁免了检查, exempt 为 true 表示是因为用户豁免
+func (r *Request) skipCheck(name string) (skip, exempt bool) {
+	if containsString(r.Policy.DisabledChecks, name) {
+		return true, false
+	}
+	if r.Policy.exemptUser(r.UserInfo.Username, name) {
+		return true, true
+	}
+	return false, false
+}
+
+// enforce 按照策略处理检查的结果: 不通过时设置检查的名字和原因, 还没到 EnforceAfter 的检查只产生警告
+// shadow 为 true 表示检查处于 shadow 模式, 结果不影响最终的决定
+func (r *Request) enforce(name string, result Result) (_ Result, shadow bool) {
+	if result.Allowed {
+		return result, false
+	}
+	result.Check = name
+	result = r.Policy.withReason(name, result)
+	if enforceAfter, ok := r.Policy.EnforceAfter[name]; ok && r.Time.Before(enforceAfter) {
+		result.Severity = SeverityWarn
+		result.Message = fmt.Sprintf("%s (will be denied after %s)", result.Message, enforceAfter.Format(time.RFC3339))
+	}
+	return result, containsString(r.Policy.ShadowChecks, name)
+}
+
+// budgetExhausted 处理因为超过延迟预算没有执行的检查, 默认放行, 策略开启 LatencyBudgetFailClosed 时拒绝
+func (p *Policy) budgetExhausted(uid types.UID, skipped []string) Result {
+	klog.Warningf("Latency budget %s exhausted, skipped checks %s, UID=%s", p.LatencyBudget.Duration, strings.Join(skipped, ", "), uid)
+	latencyBudgetExhaustedTotal.WithLabelValues(skipped[0]).Inc()
+	if !p.LatencyBudgetFailClosed {
+		return Allow()
+	}
+	return Result{
+		Check:    "latency-budget",
+		Severity: SeverityEnforce,
+		Code:     http.StatusInternalServerError,
+		Reason:   metav1.StatusReasonInternalError,
+		Message:  fmt.Sprintf("latency budget of %s exhausted before checks %s could run", p.LatencyBudget.Duration, strings.Join(skipped, ", ")),
+	}
+}
+
+// Advise 执行检查但不做决定, 返回所有不通过的检查的提示, 用于在 mutate 的响应中提前提示用户
+// shadow 模式的检查不会返回, 检查的耗时和拒绝次数也不计入指标, 这些在 validate 时统计
+func (r *CheckRegistry) Advise(ctx context.Context, pod *corev1.Pod, req *Request) []string {
+	ctx = context.WithValue(ctx, advisoryKey{}, true)
+	var advisories []string
+	for _, check := range r.checks {
+		if skip, _ := req.skipCheck(check.Name()); skip || containsString(req.Policy.ShadowChecks, check.Name()) {
+			continue
+		}
+		if result := check.Evaluate(ctx, pod, req); !result.Allowed {
+			advisories = append(advisories, fmt.Sprintf("%s: %s", check.Name(), result.Message))
+		}
+	}
+	return advisories
+}
+
+// Denials 执行检查但不做决定, 返回会拒绝请求的检查, 用于评估策略对已有 pod 的影响
+// 和 Run 一样处理关闭, 豁免, shadow 和 EnforceAfter, 只返回 enforce 级别的拒绝; 和 Advise 一样不计入指标
+func (r *CheckRegistry) Denials(ctx context.Context, pod *corev1.Pod, req *Request) []string {
+	ctx = context.WithValue(ctx, advisoryKey{}, true)
+	var denials []string
+	for _, check := range r.checks {
+		if skip, _ := req.skipCheck(check.Name()); skip {
+			continue
+		}
+		result, shadow := req.enforce(check.Name(), check.Evaluate(ctx, pod, req))
+		if result.Allowed || shadow || result.Severity == SeverityWarn {
+			continue
+		}
+		denials = append(denials, check.Name())
+	}
+	return denials
+}
+
+type advisoryKey struct{}
+
+// advisory 判断检查是否由 Advise 执行, 这时不应该记录拒绝的指标
+func advisory(ctx context.Context) bool {
+	v, _ := ctx.Value(advisoryKey{}).(bool)
+	return v
+}
+
+// 审计信息中记录拒绝请求的 policy ID 的 key, 多个检查拒绝时用逗号分隔
+const policyIDAnnotation = "policy-id"
+
+// deniedResponse 合并所有拒绝的结果, 状态码以第一个拒绝为准, 审计信息 key 相同时也以先执行的检查为准
+// 检查的名字就是它稳定的 policy ID, 会附加在每条拒绝原因的后面, 并记录在审计信息中, 方便按策略统计和查找文档
+// withCauses 为 true 时在 Details.Causes 中逐条列出拒绝的原因, type 为检查的名字, 方便自动化工具解析
+func deniedResponse(violations []Result, warnings []string, withCauses bool) *admissionV1.AdmissionResponse {
+	messages := make([]string, 0, len(violations))
+	var policyIDs []string
+	annotations := make(map[string]string)
+	var details *metav1.StatusDetails
+	if withCauses {
+		details = &metav1.StatusDetails{}
+	}
+	for _, v := range violations {
+		message := v.Message
+		if v.Check != "" {
+			message = fmt.Sprintf("%s [policy-id: %s]", v.Message, v.Check)
+			policyIDs = append(policyIDs, v.Check)
+		}
+		messages = append(messages, message)
+		if details != nil {
+			details.Causes = append(details.Causes, metav1.StatusCause{
+				Type:    metav1.CauseType(v.Check),
+				Message: v.Message,
+				Field:   v.Field,
+			})
+		}
+		for k, val := range v.AuditAnnotations {
+			if _, ok := annotations[k]; !ok {
+				annotations[k] = val
+			}
+		}
+	}
+	if len(policyIDs) > 0 {
+		annotations[policyIDAnnotation] = strings.Join(policyIDs, ",")
+	}
+	if len(annotations) == 0 {
+		annotations = nil
+	}
+	return &admissionV1.AdmissionResponse{
+		Allowed:          false,
+		AuditAnnotations: annotations,
+		Warnings:         warnings,
+		Result: &metav1.Status{
+			Code:    int32(violations[0].Code),
+			Reason:  violations[0].Reason,
+			Message: strings.Join(messages, "; "),
+			Details: details,
+		},
+	}
+}
+
+// 内置检查拒绝时默认的原因, 没有列出的检查都是 Forbidden
+// 请求本身不合法(而不是违反了策略)的检查使用 Invalid, 客户端可以据此区分是否需要修改对象
+var defaultCheckReasons = map[string]metav1.StatusReason{
+	"explicit-tag":    metav1.StatusReasonInvalid,
+	"container-names": metav1.StatusReasonInvalid,
+	"empty-pod":       metav1.StatusReasonInvalid,
+	"gpu-resources":   metav1.StatusReasonInvalid,
+}
+
+// 每种原因对应的 HTTP 状态码
+var reasonCodes = map[metav1.StatusReason]int{
+	metav1.StatusReasonForbidden:  http.StatusForbidden,
+	metav1.StatusReasonInvalid:    http.StatusUnprocessableEntity,
+	metav1.StatusReasonBadRequest: http.StatusBadRequest,
+}
+
+// withReason 按照检查的名字设置策略拒绝 (403) 的原因和状态码, 策略中的 CheckReasons 优先于默认的映射
+// 检查已经设置了原因时保持不变; 其它状态码, 比如无法解析对象的 400 和查询失败的 500, 只按状态码补上原因
+func (p *Policy) withReason(check string, result Result) Result {
+	if result.Reason != "" {
+		return result
+	}
+	if result.Code >= http.StatusInternalServerError {
+		result.Reason = metav1.StatusReasonInternalError
+		return result
+	}
+	if result.Code != 0 && result.Code != http.StatusForbidden {
+		for reason, code := range reasonCodes {
+			if code == result.Code {
+				result.Reason = reason
+			}
+		}
+		return result
+	}
+	reason, ok := p.CheckReasons[check]
+	if !ok {
+		reason, ok = defaultCheckReasons[check]
+	}
+	if !ok {
+		reason = metav1.StatusReasonForbidden
+	}
+	result.Reason = reason
+	if code, ok := reasonCodes[reason]; ok {
+		result.Code = code
+	}
+	return result
+}
+
+// checks 返回检查的注册表, 没有配置时使用所有内置的检查
+func (s *WebhookServer) checks() *CheckRegistry {
+	if s.Checks != nil {
+		return s.Checks
+	}
+	return s.builtinChecks()
+}
+
+// builtinChecks 所有内置的检查, 按顺序执行: 先检查对象本身, 再检查 pod
+// 每个检查根据 Policy 中对应的字段决定是否生效, 没有配置时直接通过
+func (s *WebhookServer) builtinChecks() *CheckRegistry {
+	return NewCheckRegistry(
+		objectCheck{"deployment", checkDeployment},
+		objectCheck{"revision-history-limit", checkRevisionHistoryLimit},
+		objectCheck{"cronjob", checkCronJob},
+		objectCheck{"job", checkJob},
+		objectCheck{"pod-disruption-budget", s.checkPodDisruptionBudget},
+		objectCheck{"pdb-compatibility", s.checkPDBCompatibility},
+		objectCheck{"hpa-requests", s.checkHPARequests},
+		objectCheck{"headless-service", s.checkHeadlessService},
+		objectCheck{"immutable-images", checkImmutableImages},
+		objectCheck{"namespace-budget", s.checkNamespaceBudget},
+		objectCheck{"network-policy", s.checkNetworkPolicy},
+		objectCheck{"limit-range", s.checkLimitRange},
+		objectCheck{"persistent-volume-claim", checkPersistentVolumeClaim},
+		objectCheck{"service-type", checkServiceType},
+		objectCheck{"service-account-automount", checkServiceAccountAutomount},
+		objectCheck{"secret-type", checkSecretType},
+		objectCheck{"ingress", checkIngress},
+		objectCheck{"field-rules", checkFieldRules},
+
+		podCheck{"empty-pod", checkEmptyPod},
+		podCheck{"registries", s.checkRegistries},
+		podCheck{"docker-hub", checkDockerHub},
+		podCheck{"registry-tier", checkRegistryTier},
+		podCheck{"base-images", s.checkBaseImages},
+		podCheck{"control-plane-scheduling", checkControlPlaneScheduling},
+		podCheck{"regulated-zone", checkRegulatedZone},
+		podCheck{"forbidden-nodes", checkForbiddenNodes},
+		podCheck{"scheduler-name", checkSchedulerName},
+		podCheck{"explicit-tag", checkExplicitTag},
+		podCheck{"floating-tags", s.checkFloatingTags},
+		podCheck{"tag-pattern", checkTagPattern},
+		podCheck{"arch-tags", checkArchTags},
+		podCheck{"region", checkRegion},
+		podCheck{"image-size", s.checkImageSize},
+		podCheck{"image-age", s.checkImageAge},
+		podCheck{"vulnerabilities", s.checkVulnerabilities},
+		podCheck{"scan-freshness", s.checkScanFreshness},
+		podCheck{"sbom", s.checkSBOM},
+		podCheck{"locked-images", s.checkLockedImages},
+		podCheck{"sensitive-env", checkSensitiveEnv},
+		podCheck{"secret-env", checkSecretEnv},
+		podCheck{"required-annotations", checkRequiredAnnotations},
+		podCheck{"required-labels", checkRequiredLabels},
+		podCheck{"storage-classes", s.checkStorageClasses},
+		podCheck{"encrypted-storage", s.checkEncryptedStorage},
+		podCheck{"config-references", s.checkConfigReferences},
+		podCheck{"volume-types", checkVolumeTypes},
+		podCheck{"empty-dir-size", checkEmptyDirSize},
+		podCheck{"token-mounts", checkTokenMounts},
+		podCheck{"gpu-images", checkGPUImages},
+		podCheck{"gpu-resources", checkGPUResources},
+		podCheck{"init-containers", checkInitContainers},
+		podCheck{"init-container-order", checkInitContainerOrder},
+		podCheck{"container-names", checkContainerNames},
+		podCheck{"container-ports", checkContainerPorts},
+		podCheck{"host-ports", checkHostPorts},
+		podCheck{"seccomp-profile", checkSeccompProfile},
+		podCheck{"apparmor-profile", checkAppArmorProfile},
+		podCheck{"sysctls", checkSysctls},
+		podCheck{"run-as-user", checkRunAsUser},
+		podCheck{"security-context-downgrade", checkSecurityContextDowngrade},
+		podCheck{"runtime-class", checkRuntimeClass},
+		podCheck{"pod-overhead", s.checkPodOverhead},
+		podCheck{"priority-class", checkPriorityClass},
+		podCheck{"qos-class", checkQoSClass},
+		podCheck{"termination-grace-period", checkTerminationGracePeriod},
+		podCheck{"read-only-root-filesystem", checkReadOnlyRootFS},
+		objectCheck{"deprecated-fields", checkDeprecatedFields},
+	)
+}
+
+// denial 内置检查拒绝的原因, nil 表示通过
+type denial struct {
+	code             int
+	message          string
+	auditAnnotations map[string]string // 拒绝时附带的审计信息, 方便自动化工具处理
+	warning          bool              // 只产生警告, 不拒绝请求
+	field            string            // 不通过的字段相对于 pod spec 的路径, 比如 containers[0].image, 只有 pod 检查使用
+}
+
+func (d *denial) result() Result {
+	if d == nil {
+		return Allow()
+	}
+	severity := SeverityEnforce
+	if d.warning {
+		severity = SeverityWarn
+	}
+	return Result{
+		Message:          d.message,
+		Severity:         severity,
+		Code:             d.code,
+		AuditAnnotations: d.auditAnnotations,
+		Field:            d.field,
+	}
+}
+
+// podFieldPath 把相对于 pod spec 的字段路径转换成对象中的完整路径, 比如 Deployment 的 spec.template.spec.containers[0]
+func podFieldPath(kind, field string) string {
+	if kind == "" {
+		kind = "Pod"
+	}
+	return strings.Replace(strings.TrimPrefix(podSpecPath(kind), "/"), "/", ".", -1) + "." + field
+}
+
+// containerField 返回 podContainers(pod) 中第 i 个容器相对于 pod spec 的字段路径
+func containerField(pod *corev1.Pod, i int) string {
+	if i < len(pod.Spec.InitContainers) {
+		return fmt.Sprintf("initContainers[%d]", i)
+	}
+	return fmt.Sprintf("containers[%d]", i-len(pod.Spec.InitContainers))
+}
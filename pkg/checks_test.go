@@ -0,0 +1,471 @@
+package pkg
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/haozi4263/admission-registry/pkg/testutil"
+	"github.com/prometheus/client_golang/prometheus"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	admissionV1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestWithReason(t *testing.T) {
+	policy := &Policy{CheckReasons: map[string]metav1.StatusReason{"custom": metav1.StatusReasonInvalid}}
+	tests := []struct {
+		name       string
+		check      string
+		code       int
+		wantCode   int
+		wantReason metav1.StatusReason
+	}{
+		{name: "policy denial", check: "registries", code: http.StatusForbidden, wantCode: http.StatusForbidden, wantReason: metav1.StatusReasonForbidden},
+		{name: "default mapping", check: "explicit-tag", code: http.StatusForbidden, wantCode: http.StatusUnprocessableEntity, wantReason: metav1.StatusReasonInvalid},
+		{name: "policy mapping", check: "custom", code: http.StatusForbidden, wantCode: http.StatusUnprocessableEntity, wantReason: metav1.StatusReasonInvalid},
+		{name: "decode error keeps 400", check: "immutable-images", code: http.StatusBadRequest, wantCode: http.StatusBadRequest, wantReason: metav1.StatusReasonBadRequest},
+		{name: "other 4xx keeps its code", check: "custom", code: http.StatusConflict, wantCode: http.StatusConflict},
+		{name: "internal error", check: "registries", code: http.StatusInternalServerError, wantCode: http.StatusInternalServerError, wantReason: metav1.StatusReasonInternalError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := policy.withReason(tt.check, Result{Code: tt.code})
+			if got.Code != tt.wantCode || got.Reason != tt.wantReason {
+				t.Errorf("got %d %q, want %d %q", got.Code, got.Reason, tt.wantCode, tt.wantReason)
+			}
+		})
+	}
+}
+
+// funcCheck 测试用的检查, 执行时调用 fn
+type funcCheck struct {
+	name string
+	fn   func(ctx context.Context) Result
+}
+
+func (c funcCheck) Name() string { return c.name }
+
+func (c funcCheck) Evaluate(ctx context.Context, _ *corev1.Pod, _ *Request) Result { return c.fn(ctx) }
+
+func TestRunLatencyBudget(t *testing.T) {
+	var ran []string
+	check := func(name string, sleep time.Duration) Check {
+		return funcCheck{name, func(context.Context) Result {
+			ran = append(ran, name)
+			time.Sleep(sleep)
+			return Result{Allowed: true}
+		}}
+	}
+	registry := NewCheckRegistry(check("fast", 0), check("slow", 30*time.Millisecond), check("later", 0))
+	tests := []struct {
+		name        string
+		policy      Policy
+		wantAllowed bool
+		wantRan     []string
+	}{
+		{name: "no budget", wantAllowed: true, wantRan: []string{"fast", "slow", "later"}},
+		{
+			name:        "fail open",
+			policy:      Policy{LatencyBudget: metav1.Duration{Duration: 10 * time.Millisecond}},
+			wantAllowed: true,
+			wantRan:     []string{"fast", "slow"},
+		},
+		{
+			name:    "fail closed",
+			policy:  Policy{LatencyBudget: metav1.Duration{Duration: 10 * time.Millisecond}, LatencyBudgetFailClosed: true},
+			wantRan: []string{"fast", "slow"},
+		},
+	}
+	for _, tt := range tests {
+		ran = nil
+		resp := registry.Run(context.Background(), &corev1.Pod{}, &Request{AdmissionRequest: &admissionV1.AdmissionRequest{}, Policy: &tt.policy})
+		if resp.Allowed != tt.wantAllowed || !reflect.DeepEqual(ran, tt.wantRan) {
+			t.Errorf("%s: allowed %v, ran %v, want %v and %v", tt.name, resp.Allowed, ran, tt.wantAllowed, tt.wantRan)
+		}
+	}
+}
+
+func TestRunLatencyBudgetCancelsContext(t *testing.T) {
+	// 外部调用在预算用完时被取消, 不会一直等到 api-server 的超时
+	blocking := funcCheck{"external", func(ctx context.Context) Result {
+		select {
+		case <-ctx.Done():
+			return Result{Allowed: true}
+		case <-time.After(time.Second):
+			return Result{Message: "budget was not applied to the context"}
+		}
+	}}
+	policy := &Policy{LatencyBudget: metav1.Duration{Duration: 10 * time.Millisecond}}
+	start := time.Now()
+	resp := NewCheckRegistry(blocking).Run(context.Background(), &corev1.Pod{}, &Request{AdmissionRequest: &admissionV1.AdmissionRequest{}, Policy: policy})
+	if !resp.Allowed || time.Since(start) > 500*time.Millisecond {
+		t.Errorf("check was not cancelled at the budget: allowed %v after %s", resp.Allowed, time.Since(start))
+	}
+}
+
+func TestCheckRegistryRun(t *testing.T) {
+	var ran []string
+	check := func(name string, result Result) Check {
+		return funcCheck{name, func(context.Context) Result {
+			ran = append(ran, name)
+			return result
+		}}
+	}
+	registry := NewCheckRegistry(
+		check("first", Allow()),
+		check("warned", Result{Message: "warned", Severity: SeverityWarn}),
+		check("denied", Result{Message: "denied", Severity: SeverityEnforce, Code: http.StatusForbidden}),
+		check("denied-again", Result{Message: "denied again", Severity: SeverityEnforce, Code: http.StatusForbidden}),
+		check("last", Allow()),
+	)
+	tests := []struct {
+		name         string
+		policy       Policy
+		wantAllowed  bool
+		wantRan      []string
+		wantMessage  string
+		wantWarnings []string
+	}{
+		{
+			name:         "short-circuit",
+			wantRan:      []string{"first", "warned", "denied"},
+			wantMessage:  "denied [policy-id: denied]",
+			wantWarnings: []string{"warned"},
+		},
+		{
+			name:         "collect all",
+			policy:       Policy{CollectAllViolations: true},
+			wantRan:      []string{"first", "warned", "denied", "denied-again", "last"},
+			wantMessage:  "denied [policy-id: denied]; denied again [policy-id: denied-again]",
+			wantWarnings: []string{"warned"},
+		},
+		{
+			name:         "disabled checks are skipped",
+			policy:       Policy{DisabledChecks: []string{"denied", "denied-again"}},
+			wantAllowed:  true,
+			wantRan:      []string{"first", "warned", "last"},
+			wantWarnings: []string{"warned"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ran = nil
+			resp := registry.Run(context.Background(), &corev1.Pod{}, &Request{AdmissionRequest: &admissionV1.AdmissionRequest{}, Policy: &tt.policy})
+			if resp.Allowed != tt.wantAllowed {
+				t.Errorf("allowed %v, want %v", resp.Allowed, tt.wantAllowed)
+			}
+			if !reflect.DeepEqual(ran, tt.wantRan) {
+				t.Errorf("ran %v, want %v", ran, tt.wantRan)
+			}
+			if !tt.wantAllowed && resp.Result.Message != tt.wantMessage {
+				t.Errorf("message %q, want %q", resp.Result.Message, tt.wantMessage)
+			}
+			if !reflect.DeepEqual(resp.Warnings, tt.wantWarnings) {
+				t.Errorf("warnings %v, want %v", resp.Warnings, tt.wantWarnings)
+			}
+		})
+	}
+}
+
+func TestBuiltinChecks(t *testing.T) {
+	s := &WebhookServer{Client: fake.NewSimpleClientset()}
+	pod := imagePod("nginx:1.21")
+	pod.Namespace = "team"
+	req := &Request{
+		AdmissionRequest: &admissionV1.AdmissionRequest{
+			Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+			Namespace: "team",
+			Operation: admissionV1.Create,
+		},
+		Object: pod,
+		Policy: &Policy{WhiteListRegistries: []string{"docker.io"}},
+	}
+	// 每个内置检查都是一个有唯一名字的 Check, 除了镜像仓库的白名单, 没有配置时直接通过
+	names := make(map[string]bool)
+	for _, check := range s.builtinChecks().Checks() {
+		if check.Name() == "" || names[check.Name()] {
+			t.Errorf("built-in check name %q is empty or duplicated", check.Name())
+		}
+		names[check.Name()] = true
+		if result := check.Evaluate(context.Background(), pod, req); !result.Allowed {
+			t.Errorf("%s denied a pod without any policy: %s", check.Name(), result.Message)
+		}
+	}
+
+	// 通过 Check 接口执行时按照策略拒绝
+	tests := []struct {
+		check  string
+		policy Policy
+	}{
+		{check: "registries", policy: Policy{WhiteListRegistries: []string{"registry.example.com"}}},
+		{check: "required-labels", policy: Policy{RequiredLabels: map[string]string{"app": ".+"}}},
+		{check: "explicit-tag", policy: Policy{RequireExplicitTagOrDigest: true}},
+	}
+	for _, tt := range tests {
+		var check Check
+		for _, c := range s.builtinChecks().Checks() {
+			if c.Name() == tt.check {
+				check = c
+			}
+		}
+		if check == nil {
+			t.Fatalf("%s is not a built-in check", tt.check)
+		}
+		req.Policy = &tt.policy
+		if result := check.Evaluate(context.Background(), imagePod("nginx"), req); result.Allowed {
+			t.Errorf("%s allowed a pod that violates the policy", tt.check)
+		}
+	}
+}
+
+func TestDisabledChecksPerNamespace(t *testing.T) {
+	s := &WebhookServer{}
+	s.SetConfig(Config{
+		Base: Policy{WhiteListRegistries: []string{"docker.io"}, RequireExplicitTagOrDigest: true},
+		Namespaces: map[string]Policy{
+			"sandbox": {DisabledChecks: []string{"registries"}},
+		},
+	})
+	tests := []struct {
+		namespace, image string
+		wantAllowed      bool
+	}{
+		{namespace: "team", image: "quay.io/app:1", wantAllowed: false},
+		{namespace: "sandbox", image: "quay.io/app:1", wantAllowed: true},
+		// 关闭一个检查不影响其它检查
+		{namespace: "sandbox", image: "quay.io/app", wantAllowed: false},
+	}
+	for _, tt := range tests {
+		pod := testPod("web", tt.image)
+		pod.Namespace = tt.namespace
+		resp := s.Review(testutil.NewPodAdmissionReview(pod, admissionV1.Create))
+		if resp.Allowed != tt.wantAllowed {
+			t.Errorf("%s in %s: got allowed %v (%v), want %v", tt.image, tt.namespace, resp.Allowed, resp.Result, tt.wantAllowed)
+		}
+	}
+}
+
+func TestShadowChecks(t *testing.T) {
+	denied := Result{Message: "would deny", Severity: SeverityEnforce, Code: http.StatusForbidden}
+	registry := NewCheckRegistry(
+		funcCheck{"shadow-test-new", func(context.Context) Result { return denied }},
+		funcCheck{"shadow-test-enforced", func(context.Context) Result { return denied }},
+	)
+	tests := []struct {
+		name        string
+		shadow      []string
+		wantAllowed bool
+		wantShadow  map[string]float64
+	}{
+		{name: "new check in shadow mode", shadow: []string{"shadow-test-new"}, wantShadow: map[string]float64{"shadow-test-new": 1}},
+		{name: "all checks in shadow mode", shadow: []string{"shadow-test-new", "shadow-test-enforced"}, wantAllowed: true,
+			wantShadow: map[string]float64{"shadow-test-new": 1, "shadow-test-enforced": 1}},
+		{name: "no shadow checks", wantShadow: map[string]float64{}},
+	}
+	for _, tt := range tests {
+		shadowDeniedTotal.Reset()
+		policy := &Policy{ShadowChecks: tt.shadow, CollectAllViolations: true}
+		resp := registry.Run(context.Background(), &corev1.Pod{}, &Request{AdmissionRequest: &admissionV1.AdmissionRequest{}, Policy: policy})
+		if resp.Allowed != tt.wantAllowed {
+			t.Errorf("%s: got allowed %v, want %v", tt.name, resp.Allowed, tt.wantAllowed)
+		}
+		if n := promtestutil.CollectAndCount(shadowDeniedTotal); n != len(tt.wantShadow) {
+			t.Errorf("%s: got %d shadow series, want %d", tt.name, n, len(tt.wantShadow))
+		}
+		for check, want := range tt.wantShadow {
+			if got := promtestutil.ToFloat64(shadowDeniedTotal.WithLabelValues(check)); got != want {
+				t.Errorf("%s: shadow denials of %s = %v, want %v", tt.name, check, got, want)
+			}
+		}
+	}
+	shadowDeniedTotal.Reset()
+}
+
+func TestEnforceAfter(t *testing.T) {
+	denied := Result{Message: "would deny", Severity: SeverityEnforce, Code: http.StatusForbidden}
+	registry := NewCheckRegistry(funcCheck{"enforce-after-test", func(context.Context) Result { return denied }})
+	enforceAfter := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name         string
+		enforceAfter map[string]time.Time
+		now          time.Time
+		wantAllowed  bool
+		wantWarning  string
+	}{
+		{name: "before enforceAfter", enforceAfter: map[string]time.Time{"enforce-after-test": enforceAfter}, now: enforceAfter.Add(-time.Hour),
+			wantAllowed: true, wantWarning: "would deny (will be denied after 2026-01-01T00:00:00Z)"},
+		{name: "at enforceAfter", enforceAfter: map[string]time.Time{"enforce-after-test": enforceAfter}, now: enforceAfter},
+		{name: "after enforceAfter", enforceAfter: map[string]time.Time{"enforce-after-test": enforceAfter}, now: enforceAfter.Add(time.Hour)},
+		{name: "other check", enforceAfter: map[string]time.Time{"registries": enforceAfter}, now: enforceAfter.Add(-time.Hour)},
+		{name: "not configured", now: enforceAfter.Add(-time.Hour)},
+	}
+	for _, tt := range tests {
+		policy := &Policy{EnforceAfter: tt.enforceAfter}
+		resp := registry.Run(context.Background(), &corev1.Pod{}, &Request{AdmissionRequest: &admissionV1.AdmissionRequest{}, Policy: policy, Time: tt.now})
+		if resp.Allowed != tt.wantAllowed {
+			t.Errorf("%s: got allowed %v, want %v", tt.name, resp.Allowed, tt.wantAllowed)
+		}
+		if tt.wantWarning != "" && !reflect.DeepEqual(resp.Warnings, []string{tt.wantWarning}) {
+			t.Errorf("%s: got warnings %q, want %q", tt.name, resp.Warnings, tt.wantWarning)
+		}
+	}
+}
+
+// checkDurationSamples 返回 checkDuration 中每个检查记录的样本数
+func checkDurationSamples(t *testing.T) map[string]uint64 {
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	samples := make(map[string]uint64)
+	for _, family := range families {
+		if family.GetName() != "admission_check_duration_seconds" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			for _, label := range metric.GetLabel() {
+				if label.GetName() == "check" {
+					samples[label.GetValue()] = metric.GetHistogram().GetSampleCount()
+				}
+			}
+		}
+	}
+	return samples
+}
+
+func TestRunRecordsCheckDuration(t *testing.T) {
+	checkDuration.Reset()
+	defer checkDuration.Reset()
+	registry := NewCheckRegistry(
+		funcCheck{"duration-test-allowed", func(context.Context) Result { return Allow() }},
+		funcCheck{"duration-test-denied", func(context.Context) Result {
+			return Result{Message: "denied", Severity: SeverityEnforce, Code: http.StatusForbidden}
+		}},
+		funcCheck{"duration-test-disabled", func(context.Context) Result { return Allow() }},
+	)
+	policy := &Policy{DisabledChecks: []string{"duration-test-disabled"}}
+	for i := 0; i < 2; i++ {
+		registry.Run(context.Background(), &corev1.Pod{}, &Request{AdmissionRequest: &admissionV1.AdmissionRequest{}, Policy: policy})
+	}
+	want := map[string]uint64{"duration-test-allowed": 2, "duration-test-denied": 2}
+	if got := checkDurationSamples(t); !reflect.DeepEqual(got, want) {
+		t.Errorf("got check duration samples %v, want %v", got, want)
+	}
+}
+
+func TestPodFieldPath(t *testing.T) {
+	tests := []struct {
+		kind, field, want string
+	}{
+		{kind: "Pod", field: "containers[0].image", want: "spec.containers[0].image"},
+		{kind: "", field: "initContainers[1].image", want: "spec.initContainers[1].image"},
+		{kind: "Deployment", field: "containers[0].image", want: "spec.template.spec.containers[0].image"},
+		{kind: "CronJob", field: "containers[0].image", want: "spec.jobTemplate.spec.template.spec.containers[0].image"},
+	}
+	for _, tt := range tests {
+		if got := podFieldPath(tt.kind, tt.field); got != tt.want {
+			t.Errorf("podFieldPath(%q, %q) = %q, want %q", tt.kind, tt.field, got, tt.want)
+		}
+	}
+	pod := &corev1.Pod{Spec: corev1.PodSpec{InitContainers: []corev1.Container{{Name: "setup"}}, Containers: []corev1.Container{{Name: "app"}, {Name: "sidecar"}}}}
+	for i, want := range []string{"initContainers[0]", "containers[0]", "containers[1]"} {
+		if got := containerField(pod, i); got != want {
+			t.Errorf("containerField(%d) = %q, want %q", i, got, want)
+		}
+	}
+}
+
+func TestDenialCauses(t *testing.T) {
+	pod := testPod("web", "quay.io/app")
+	deploy, err := testutil.NewAdmissionReview(
+		metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"},
+		metav1.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"},
+		"team", "web", appsv1.Deployment{Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{Spec: pod.Spec}}}, admissionV1.Create)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		review     *admissionV1.AdmissionReview
+		causes     bool
+		wantCauses []metav1.StatusCause
+	}{
+		{name: "pod", review: testutil.NewPodAdmissionReview(pod, admissionV1.Create), causes: true, wantCauses: []metav1.StatusCause{
+			{Type: "registries", Message: "quay.io/app image comes from untrusted registry! Only images form [docker.io] are allowed.", Field: "spec.containers[0].image"},
+			{Type: "explicit-tag", Message: "quay.io/app image of container app has neither a tag nor a digest! Please reference the image as name:tag or name@digest.", Field: "spec.containers[0].image"},
+		}},
+		{name: "deployment", review: deploy, causes: true, wantCauses: []metav1.StatusCause{
+			{Type: "registries", Message: "quay.io/app image comes from untrusted registry! Only images form [docker.io] are allowed.", Field: "spec.template.spec.containers[0].image"},
+			{Type: "explicit-tag", Message: "quay.io/app image of container app has neither a tag nor a digest! Please reference the image as name:tag or name@digest.", Field: "spec.template.spec.containers[0].image"},
+		}},
+		{name: "not configured", review: testutil.NewPodAdmissionReview(pod, admissionV1.Create)},
+	}
+	discardLogs(t)
+	for _, tt := range tests {
+		s := &WebhookServer{}
+		s.SetConfig(Config{Base: Policy{
+			WhiteListRegistries:        []string{"docker.io"},
+			RequireExplicitTagOrDigest: true,
+			CollectAllViolations:       true,
+			DenialCauses:               tt.causes,
+		}})
+		resp := s.Review(tt.review)
+		if resp.Allowed || resp.Result == nil {
+			t.Fatalf("%s: got allowed %v, result %+v", tt.name, resp.Allowed, resp.Result)
+		}
+		var causes []metav1.StatusCause
+		if resp.Result.Details != nil {
+			causes = resp.Result.Details.Causes
+		}
+		if !reflect.DeepEqual(causes, tt.wantCauses) {
+			t.Errorf("%s: got causes %+v, want %+v", tt.name, causes, tt.wantCauses)
+		}
+	}
+}
+
+func TestDeniedResponsePolicyID(t *testing.T) {
+	tests := []struct {
+		name            string
+		violations      []Result
+		wantMessage     string
+		wantAnnotations map[string]string
+	}{
+		{
+			name:            "one check",
+			violations:      []Result{{Message: "untrusted registry", Check: "registries"}},
+			wantMessage:     "untrusted registry [policy-id: registries]",
+			wantAnnotations: map[string]string{"policy-id": "registries"},
+		},
+		{
+			name: "every denying check",
+			violations: []Result{
+				{Message: "untrusted registry", Check: "registries"},
+				{Message: "no tag", Check: "explicit-tag", AuditAnnotations: map[string]string{"image": "quay.io/app"}},
+			},
+			wantMessage:     "untrusted registry [policy-id: registries]; no tag [policy-id: explicit-tag]",
+			wantAnnotations: map[string]string{"policy-id": "registries,explicit-tag", "image": "quay.io/app"},
+		},
+		{
+			name:        "unnamed check",
+			violations:  []Result{{Message: "denied"}},
+			wantMessage: "denied",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := deniedResponse(tt.violations, nil, false)
+			if resp.Result.Message != tt.wantMessage {
+				t.Errorf("message %q, want %q", resp.Result.Message, tt.wantMessage)
+			}
+			if !reflect.DeepEqual(resp.AuditAnnotations, tt.wantAnnotations) {
+				t.Errorf("audit annotations %v, want %v", resp.AuditAnnotations, tt.wantAnnotations)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package pkg
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// EffectiveConfig 当前生效的配置, 用于确认重新加载之后的策略是否符合预期
+type EffectiveConfig struct {
+	PolicyVersion string            `json:"policyVersion,omitempty"`
+	Checks        []string          `json:"checks"`               // 注册的检查, 按执行顺序
+	Base          Policy            `json:"base"`                 // 基础策略, 没有单独配置的命名空间使用这个策略
+	Namespaces    map[string]Policy `json:"namespaces,omitempty"` // 和基础策略合并之后的命名空间策略
+	Allowlist     *AllowlistStatus  `json:"allowlist,omitempty"`  // 从外部服务获取的白名单
+}
+
+// AllowlistStatus 外部白名单的来源和当前缓存的内容
+type AllowlistStatus struct {
+	URL        string   `json:"url"` // 去掉了用户名密码和查询参数, 其中可能带有凭证
+	Registries []string `json:"registries"`
+}
+
+// effectiveConfig 返回当前生效的配置
+func (s *WebhookServer) effectiveConfig() *EffectiveConfig {
+	cache := s.policyCache()
+	config := &EffectiveConfig{
+		PolicyVersion: s.policyVersion(),
+		Base:          cache.base,
+		Namespaces:    cache.namespaces,
+	}
+	for _, check := range s.checks().Checks() {
+		config.Checks = append(config.Checks, check.Name())
+	}
+	if s.Allowlist != nil {
+		config.Allowlist = &AllowlistStatus{
+			URL:        redactURL(s.Allowlist.URL),
+			Registries: s.Allowlist.Registries(),
+		}
+	}
+	return config
+}
+
+// redactURL 去掉 url 中的用户名密码和查询参数
+func redactURL(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "REDACTED"
+	}
+	if u.User != nil {
+		u.User = url.User("REDACTED")
+	}
+	if u.RawQuery != "" {
+		u.RawQuery = "REDACTED"
+	}
+	return u.String()
+}
+
+// authorizeToken 检查请求是否带有 Bearer ConfigToken, 不通过时写入错误响应并返回 false
+// 没有配置 ConfigToken 时返回 404, 需要 token 的接口都视为关闭
+func (s *WebhookServer) authorizeToken(writer http.ResponseWriter, request *http.Request) bool {
+	if s.ConfigToken == "" {
+		http.Error(writer, request.URL.Path+" endpoint is disabled", http.StatusNotFound)
+		return false
+	}
+	token := strings.TrimPrefix(request.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(s.ConfigToken)) != 1 {
+		http.Error(writer, "unauthorized", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// ConfigHandler 以 json 返回当前生效的配置, 需要在 Authorization header 中带上 Bearer ConfigToken
+// 没有配置 ConfigToken 时关闭这个接口
+func (s *WebhookServer) ConfigHandler(writer http.ResponseWriter, request *http.Request) {
+	if !s.authorizeToken(writer, request) {
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(writer).Encode(s.effectiveConfig())
+}
@@ -0,0 +1,98 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+)
+
+// checkEmptyPod 拒绝没有普通容器的 pod, 这种 pod 通常是写错了 spec, 默认所有镜像检查都会直接通过
+// 只有 init 容器的 pod 在 AllowInitOnlyPods 开启时放行
+func checkEmptyPod(_ context.Context, policy *Policy, pod *corev1.Pod) *denial {
+	if !policy.DenyEmptyPods || len(pod.Spec.Containers) > 0 {
+		return nil
+	}
+	if len(pod.Spec.InitContainers) > 0 {
+		if policy.AllowInitOnlyPods {
+			return nil
+		}
+		return &denial{
+			code:    http.StatusForbidden,
+			message: fmt.Sprintf("pod %s only has init containers! Please add at least one container to spec.containers.", pod.Name),
+		}
+	}
+	return &denial{
+		code:    http.StatusForbidden,
+		message: fmt.Sprintf("pod %s has no containers! Please add at least one container to spec.containers.", pod.Name),
+	}
+}
+
+// checkInitContainers init 容器的数量不能超过 MaxInitContainers, 太多的 init 容器会拖慢 pod 启动
+func checkInitContainers(_ context.Context, policy *Policy, pod *corev1.Pod) *denial {
+	if policy.MaxInitContainers <= 0 || len(pod.Spec.InitContainers) <= policy.MaxInitContainers {
+		return nil
+	}
+	return &denial{
+		code: http.StatusForbidden,
+		message: fmt.Sprintf("pod %s has %d init containers, at most %d are allowed.",
+			pod.Name, len(pod.Spec.InitContainers), policy.MaxInitContainers),
+	}
+}
+
+// checkInitContainerOrder init 容器按照 InitContainerOrder 中的名字前缀排序, 比如 ["setup-", "migrate-"]
+// 要求 setup- 开头的 init 容器都在 migrate- 开头的前面, 不匹配任何前缀的 init 容器必须排在最后
+func checkInitContainerOrder(_ context.Context, policy *Policy, pod *corev1.Pod) *denial {
+	if len(policy.InitContainerOrder) == 0 {
+		return nil
+	}
+	rank := func(name string) int {
+		for i, prefix := range policy.InitContainerOrder {
+			if strings.HasPrefix(name, prefix) {
+				return i
+			}
+		}
+		return len(policy.InitContainerOrder)
+	}
+	for i := 1; i < len(pod.Spec.InitContainers); i++ {
+		prev, cur := pod.Spec.InitContainers[i-1].Name, pod.Spec.InitContainers[i].Name
+		if rank(cur) >= rank(prev) {
+			continue
+		}
+		return &denial{
+			code: http.StatusForbidden,
+			message: fmt.Sprintf("init container %q in pod %s must run before %q! Init containers must be ordered by prefix: %s",
+				cur, pod.Name, prev, strings.Join(policy.InitContainerOrder, ", ")),
+			field: fmt.Sprintf("initContainers[%d].name", i),
+		}
+	}
+	return nil
+}
+
+// checkContainerNames init 容器和普通容器的名字不能重复, 并且必须是合法的 DNS label
+// api-server 也会做类似的校验, 这里提前拒绝是为了给出更明确的错误信息
+func checkContainerNames(_ context.Context, policy *Policy, pod *corev1.Pod) *denial {
+	if !policy.ValidateContainerNames {
+		return nil
+	}
+	seen := make(map[string]bool)
+	for _, container := range podContainers(pod) {
+		if errs := validation.IsDNS1123Label(container.Name); len(errs) > 0 {
+			return &denial{
+				code:    http.StatusForbidden,
+				message: fmt.Sprintf("container name %q in pod %s is not a valid DNS label: %s", container.Name, pod.Name, strings.Join(errs, "; ")),
+			}
+		}
+		if seen[container.Name] {
+			return &denial{
+				code:    http.StatusForbidden,
+				message: fmt.Sprintf("container name %q is used more than once in pod %s! Container names must be unique across init and regular containers.", container.Name, pod.Name),
+			}
+		}
+		seen[container.Name] = true
+	}
+	return nil
+}
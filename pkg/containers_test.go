@@ -0,0 +1,111 @@
+package pkg
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// initPod 构造有这些 init 容器和一个普通容器的 pod
+func initPod(names ...string) *corev1.Pod {
+	pod := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "nginx"}}}}
+	for _, name := range names {
+		pod.Spec.InitContainers = append(pod.Spec.InitContainers, corev1.Container{Name: name, Image: "busybox"})
+	}
+	return pod
+}
+
+func TestCheckInitContainers(t *testing.T) {
+	tests := []struct {
+		name     string
+		max      int
+		pod      *corev1.Pod
+		wantCode int
+	}{
+		{name: "no limit", pod: initPod("a", "b", "c", "d")},
+		{name: "within the limit", max: 2, pod: initPod("a", "b")},
+		{name: "over the limit", max: 2, pod: initPod("a", "b", "c"), wantCode: http.StatusForbidden},
+		{name: "no init containers", max: 1, pod: initPod()},
+	}
+	for _, tt := range tests {
+		policy := &Policy{MaxInitContainers: tt.max}
+		if code := denialCode(checkInitContainers(context.Background(), policy, tt.pod)); code != tt.wantCode {
+			t.Errorf("%s: got code %d, want %d", tt.name, code, tt.wantCode)
+		}
+	}
+}
+
+func TestCheckContainerNames(t *testing.T) {
+	policy := &Policy{ValidateContainerNames: true}
+	tests := []struct {
+		name     string
+		pod      *corev1.Pod
+		wantCode int
+	}{
+		{name: "unique names", pod: initPod("setup", "migrate")},
+		{name: "duplicate init containers", pod: initPod("setup", "setup"), wantCode: http.StatusForbidden},
+		{name: "init container named like the app", pod: initPod("app"), wantCode: http.StatusForbidden},
+		{name: "upper case", pod: initPod("Setup"), wantCode: http.StatusForbidden},
+		{name: "underscore", pod: initPod("db_migrate"), wantCode: http.StatusForbidden},
+		{name: "too long", pod: initPod("a23456789012345678901234567890123456789012345678901234567890abcd"), wantCode: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		if code := denialCode(checkContainerNames(context.Background(), policy, tt.pod)); code != tt.wantCode {
+			t.Errorf("%s: got code %d, want %d", tt.name, code, tt.wantCode)
+		}
+	}
+	if d := checkContainerNames(context.Background(), &Policy{}, initPod("app")); d != nil {
+		t.Errorf("check is off by default, got %v", d.message)
+	}
+}
+
+func TestCheckEmptyPod(t *testing.T) {
+	initOnly := initPod("migrate")
+	initOnly.Spec.Containers = nil
+	tests := []struct {
+		name     string
+		pod      *corev1.Pod
+		policy   Policy
+		wantCode int
+	}{
+		{name: "regular containers", pod: initPod("migrate"), policy: Policy{DenyEmptyPods: true}},
+		{name: "no containers", pod: &corev1.Pod{}, policy: Policy{DenyEmptyPods: true}, wantCode: http.StatusForbidden},
+		{name: "only init containers", pod: initOnly, policy: Policy{DenyEmptyPods: true}, wantCode: http.StatusForbidden},
+		{name: "init-only pods allowed", pod: initOnly, policy: Policy{DenyEmptyPods: true, AllowInitOnlyPods: true}},
+		{name: "init-only pods allowed still denies no containers", pod: &corev1.Pod{}, policy: Policy{DenyEmptyPods: true, AllowInitOnlyPods: true}, wantCode: http.StatusForbidden},
+		{name: "not configured", pod: &corev1.Pod{}},
+	}
+	for _, tt := range tests {
+		if code := denialCode(checkEmptyPod(context.Background(), &tt.policy, tt.pod)); code != tt.wantCode {
+			t.Errorf("%s: got code %d, want %d", tt.name, code, tt.wantCode)
+		}
+	}
+}
+
+func TestCheckInitContainerOrder(t *testing.T) {
+	order := []string{"setup-", "migrate-"}
+	tests := []struct {
+		name      string
+		order     []string
+		pod       *corev1.Pod
+		wantCode  int
+		wantField string
+	}{
+		{name: "ordered", order: order, pod: initPod("setup-certs", "setup-dirs", "migrate-db", "warmup")},
+		{name: "out of order", order: order, pod: initPod("migrate-db", "setup-certs"), wantCode: http.StatusForbidden, wantField: "initContainers[1].name"},
+		{name: "unmatched before matched", order: order, pod: initPod("setup-certs", "warmup", "migrate-db"), wantCode: http.StatusForbidden, wantField: "initContainers[2].name"},
+		{name: "only unmatched", order: order, pod: initPod("warmup", "cache")},
+		{name: "not configured", pod: initPod("migrate-db", "setup-certs")},
+	}
+	for _, tt := range tests {
+		d := checkInitContainerOrder(context.Background(), &Policy{InitContainerOrder: tt.order}, tt.pod)
+		if code := denialCode(d); code != tt.wantCode {
+			t.Errorf("%s: got code %d, want %d", tt.name, code, tt.wantCode)
+		}
+		if d != nil && d.field != tt.wantField {
+			t.Errorf("%s: denied field %q, want %q", tt.name, d.field, tt.wantField)
+		}
+	}
+}
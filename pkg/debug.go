@@ -0,0 +1,78 @@
+package pkg
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"math/rand"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog"
+)
+
+// sampled 判断这个请求是否需要打印详细的调试日志
+// DebugSampleByUID 打开时按 UID 计算, 同一个 UID 的结果是固定的
+func (s *WebhookServer) sampled(uid types.UID) bool {
+	if s.DebugSampleRate <= 0 {
+		return false
+	}
+	if s.DebugSampleRate >= 1 {
+		return true
+	}
+	if s.DebugSampleByUID {
+		h := fnv.New64a()
+		h.Write([]byte(uid))
+		return float64(h.Sum64()%10000)/10000 < s.DebugSampleRate
+	}
+	return rand.Float64() < s.DebugSampleRate
+}
+
+// debugLog 打印被采样请求的调试日志
+func debugLog(uid types.UID, msg string, keysAndValues ...interface{}) {
+	args := []interface{}{"debug: uid=", uid, " ", msg}
+	for i := 0; i+1 < len(keysAndValues); i += 2 {
+		value := keysAndValues[i+1]
+		if _, ok := value.(string); !ok {
+			if data, err := json.Marshal(value); err == nil {
+				value = string(data)
+			}
+		}
+		args = append(args, " ", keysAndValues[i], "=", value)
+	}
+	klog.Info(args...)
+}
+
+// 调试日志中替换敏感内容使用的值
+const redacted = "[REDACTED]"
+
+// 默认在调试日志中隐藏的环境变量名, 和 SensitiveEnvPatterns、RedactEnvPatterns 一起生效
+var defaultRedactEnvPatterns = []string{".*PASSWORD.*", ".*SECRET.*", ".*TOKEN.*", ".*KEY.*"}
+
+// redactPod 返回隐藏了敏感内容的 pod 副本, 用于打印调试日志
+// 名字匹配的环境变量的 value, 以及 RedactAnnotations 中的注解的值会被替换成 [REDACTED]
+func redactPod(policy *Policy, pod *corev1.Pod) *corev1.Pod {
+	pod = pod.DeepCopy()
+	patterns := append(append(append([]string{}, defaultRedactEnvPatterns...), policy.SensitiveEnvPatterns...), policy.RedactEnvPatterns...)
+	for _, containers := range [][]corev1.Container{pod.Spec.InitContainers, pod.Spec.Containers} {
+		for i := range containers {
+			for j := range containers[i].Env {
+				env := &containers[i].Env[j]
+				if env.Value == "" {
+					continue
+				}
+				for _, pattern := range patterns {
+					if matchPattern(pattern, env.Name) {
+						env.Value = redacted
+						break
+					}
+				}
+			}
+		}
+	}
+	for _, key := range policy.RedactAnnotations {
+		if _, ok := pod.Annotations[key]; ok {
+			pod.Annotations[key] = redacted
+		}
+	}
+	return pod
+}
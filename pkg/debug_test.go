@@ -0,0 +1,82 @@
+package pkg
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestSampled(t *testing.T) {
+	tests := []struct {
+		name     string
+		rate     float64
+		byUID    bool
+		min, max int
+	}{
+		{name: "disabled", rate: 0, min: 0, max: 0},
+		{name: "all requests", rate: 1, min: 1000, max: 1000},
+		{name: "random", rate: 0.1, min: 50, max: 150},
+		{name: "by UID", rate: 0.1, byUID: true, min: 50, max: 150},
+	}
+	for _, tt := range tests {
+		s := &WebhookServer{DebugSampleRate: tt.rate, DebugSampleByUID: tt.byUID}
+		n := 0
+		for i := 0; i < 1000; i++ {
+			if s.sampled(types.UID(fmt.Sprintf("uid-%d", i))) {
+				n++
+			}
+		}
+		if n < tt.min || n > tt.max {
+			t.Errorf("%s: sampled %d of 1000 requests, want %d to %d", tt.name, n, tt.min, tt.max)
+		}
+	}
+}
+
+func TestSampledByUIDIsDeterministic(t *testing.T) {
+	s := &WebhookServer{DebugSampleRate: 0.5, DebugSampleByUID: true}
+	for i := 0; i < 100; i++ {
+		uid := types.UID(fmt.Sprintf("uid-%d", i))
+		first := s.sampled(uid)
+		for j := 0; j < 3; j++ {
+			if s.sampled(uid) != first {
+				t.Fatalf("sampling of %s changed between calls", uid)
+			}
+		}
+	}
+}
+
+func TestRedactPod(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Annotations: map[string]string{"vault/token": "s.abc", "owner": "team-a"}},
+		Spec: corev1.PodSpec{
+			InitContainers: []corev1.Container{{Name: "migrate", Env: []corev1.EnvVar{{Name: "DB_PASSWORD", Value: "hunter2"}}}},
+			Containers: []corev1.Container{{Name: "app", Env: []corev1.EnvVar{
+				{Name: "API_TOKEN", Value: "t0ken"},
+				{Name: "LICENSE", Value: "enterprise"},
+				{Name: "LOG_LEVEL", Value: "debug"},
+				{Name: "EMPTY_SECRET"},
+			}}},
+		},
+	}
+	policy := &Policy{RedactEnvPatterns: []string{"LICENSE"}, RedactAnnotations: []string{"vault/token", "missing"}}
+	got := redactPod(policy, pod)
+	wantEnv := map[string]string{"DB_PASSWORD": redacted, "API_TOKEN": redacted, "LICENSE": redacted, "LOG_LEVEL": "debug", "EMPTY_SECRET": ""}
+	for _, container := range podContainers(got) {
+		for _, env := range container.Env {
+			if env.Value != wantEnv[env.Name] {
+				t.Errorf("env %s = %q, want %q", env.Name, env.Value, wantEnv[env.Name])
+			}
+		}
+	}
+	wantAnnotations := map[string]string{"vault/token": redacted, "owner": "team-a"}
+	if !reflect.DeepEqual(got.Annotations, wantAnnotations) {
+		t.Errorf("got annotations %v, want %v", got.Annotations, wantAnnotations)
+	}
+	if pod.Spec.Containers[0].Env[0].Value != "t0ken" || pod.Annotations["vault/token"] != "s.abc" {
+		t.Error("redactPod modified the original pod")
+	}
+}
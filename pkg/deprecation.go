@@ -0,0 +1,134 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	admissionV1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// deprecation 检测 pod 中一种已经废弃的写法, 返回具体的字段和替代的写法, 没有使用时返回空
+// req 为 pod 所在的请求, 有些写法在解析之后的对象中看不出来, 需要检查原始的对象
+type deprecation struct {
+	name   string
+	detect func(pod *corev1.Pod, req *admissionV1.AdmissionRequest) []string
+}
+
+// 已经废弃的 label 和替代的 label
+var deprecatedNodeLabels = map[string]string{
+	"beta.kubernetes.io/arch":                  "kubernetes.io/arch",
+	"beta.kubernetes.io/os":                    "kubernetes.io/os",
+	"failure-domain.beta.kubernetes.io/zone":   "topology.kubernetes.io/zone",
+	"failure-domain.beta.kubernetes.io/region": "topology.kubernetes.io/region",
+}
+
+const (
+	seccompPodAnnotation             = "seccomp.security.alpha.kubernetes.io/pod"
+	seccompContainerAnnotationPrefix = "container.seccomp.security.alpha.kubernetes.io/"
+)
+
+// deprecations 内置的废弃写法检测, 可以通过 Policy.IgnoredDeprecations 按名字跳过
+var deprecations = []deprecation{
+	{"service-account", func(pod *corev1.Pod, req *admissionV1.AdmissionRequest) []string {
+		if pod.Spec.DeprecatedServiceAccount == "" {
+			return nil
+		}
+		if pod.Spec.DeprecatedServiceAccount != pod.Spec.ServiceAccountName || setsDeprecatedServiceAccount(req) {
+			return []string{"spec.serviceAccount (use spec.serviceAccountName)"}
+		}
+		return nil
+	}},
+	{"seccomp-annotations", func(pod *corev1.Pod, _ *admissionV1.AdmissionRequest) []string {
+		var found []string
+		for key := range pod.Annotations {
+			if key == seccompPodAnnotation || strings.HasPrefix(key, seccompContainerAnnotationPrefix) {
+				found = append(found, fmt.Sprintf("annotation %s (use securityContext.seccompProfile)", key))
+			}
+		}
+		return found
+	}},
+	{"node-labels", func(pod *corev1.Pod, _ *admissionV1.AdmissionRequest) []string {
+		var found []string
+		for key := range pod.Spec.NodeSelector {
+			if replacement, ok := deprecatedNodeLabels[key]; ok {
+				found = append(found, fmt.Sprintf("nodeSelector %s (use %s)", key, replacement))
+			}
+		}
+		if affinity := pod.Spec.Affinity; affinity != nil && affinity.NodeAffinity != nil &&
+			affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution != nil {
+			for _, term := range affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms {
+				for _, expr := range term.MatchExpressions {
+					if replacement, ok := deprecatedNodeLabels[expr.Key]; ok {
+						found = append(found, fmt.Sprintf("node affinity %s (use %s)", expr.Key, replacement))
+					}
+				}
+			}
+		}
+		return found
+	}},
+}
+
+// checkDeprecatedFields pod 或者工作负载的 pod 模板使用了废弃的字段或者注解时按照 DeprecatedFields 警告或者拒绝, 方便集群升级之前提前迁移
+func checkDeprecatedFields(_ context.Context, policy *Policy, req *admissionV1.AdmissionRequest, obj runtime.Object) *denial {
+	pod := objectPod(obj)
+	if policy.DeprecatedFields == "" || pod == nil {
+		return nil
+	}
+	var found []string
+	for _, d := range deprecations {
+		if containsString(policy.IgnoredDeprecations, d.name) {
+			continue
+		}
+		found = append(found, d.detect(pod, req)...)
+	}
+	if len(found) == 0 {
+		return nil
+	}
+	sort.Strings(found)
+	return policy.DeprecatedFields.violation(http.StatusForbidden,
+		fmt.Sprintf("pod %s uses deprecated fields: %s.", pod.Name, strings.Join(found, ", ")))
+}
+
+// setsDeprecatedServiceAccount 判断提交请求的人是否写了 spec.serviceAccount
+// api-server 转换对象时总会把 serviceAccountName 复制到 serviceAccount, 只看解析之后的对象分不出是谁写的, 所以检查原始的对象:
+//   - 对象中有 serviceAccount 没有 serviceAccountName, 比如没有经过 api-server 转换的回放请求
+//   - kubectl apply 保存在 last-applied-configuration 注解中的配置写了 serviceAccount
+//   - managedFields 中有 manager 设置了 serviceAccount
+func setsDeprecatedServiceAccount(req *admissionV1.AdmissionRequest) bool {
+	if req == nil || len(req.Object.Raw) == 0 {
+		return false
+	}
+	specPath := strings.Split(strings.TrimPrefix(podSpecPath(req.Kind.Kind), "/"), "/")
+	spec := rawField(req.Object.Raw, specPath...)
+	if rawField(spec, "serviceAccount") != nil && rawField(spec, "serviceAccountName") == nil {
+		return true
+	}
+	var meta struct {
+		Annotations   map[string]string `json:"annotations"`
+		ManagedFields []struct {
+			FieldsV1 json.RawMessage `json:"fieldsV1"`
+		} `json:"managedFields"`
+	}
+	if json.Unmarshal(rawField(req.Object.Raw, "metadata"), &meta) != nil {
+		return false
+	}
+	if applied := meta.Annotations[lastAppliedAnnotation]; applied != "" && rawField([]byte(applied), append(specPath, "serviceAccount")...) != nil {
+		return true
+	}
+	managedPath := make([]string, 0, len(specPath)+1)
+	for _, key := range append(specPath, "serviceAccount") {
+		managedPath = append(managedPath, "f:"+key)
+	}
+	for _, entry := range meta.ManagedFields {
+		if rawField(entry.FieldsV1, managedPath...) != nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	admissionV1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// deprecationRequest 构造请求, raw 为原始对象的 json, obj 为解析之后的对象
+func deprecationRequest(t *testing.T, kind, raw string) (*admissionV1.AdmissionRequest, runtime.Object) {
+	t.Helper()
+	req := &admissionV1.AdmissionRequest{Kind: metav1.GroupVersionKind{Kind: kind}}
+	req.Object.Raw = []byte(raw)
+	var obj runtime.Object = &corev1.Pod{}
+	if kind == "Deployment" {
+		obj = &appsv1.Deployment{}
+	}
+	if err := json.Unmarshal(req.Object.Raw, obj); err != nil {
+		t.Fatal(err)
+	}
+	return req, obj
+}
+
+func TestCheckDeprecatedServiceAccount(t *testing.T) {
+	tests := []struct {
+		name string
+		kind string
+		raw  string
+		want bool
+	}{
+		{name: "only serviceAccountName", kind: "Pod",
+			raw: `{"spec":{"serviceAccountName":"app","serviceAccount":"app"},"metadata":{"managedFields":[{"fieldsV1":{"f:spec":{"f:serviceAccountName":{}}}}]}}`},
+		{name: "only serviceAccount in the raw object", kind: "Pod", raw: `{"spec":{"serviceAccount":"app"}}`, want: true},
+		{name: "serviceAccount in managedFields", kind: "Pod",
+			raw: `{"spec":{"serviceAccountName":"app","serviceAccount":"app"},"metadata":{"managedFields":[{"fieldsV1":{"f:spec":{"f:serviceAccount":{}}}}]}}`, want: true},
+		{name: "serviceAccount in last-applied-configuration", kind: "Pod",
+			raw: `{"spec":{"serviceAccountName":"app","serviceAccount":"app"},"metadata":{"annotations":{"kubectl.kubernetes.io/last-applied-configuration":"{\"spec\":{\"serviceAccount\":\"app\"}}"}}}`, want: true},
+		{name: "serviceAccount in a deployment template", kind: "Deployment",
+			raw: `{"spec":{"template":{"spec":{"serviceAccountName":"app","serviceAccount":"app"}}},"metadata":{"managedFields":[{"fieldsV1":{"f:spec":{"f:template":{"f:spec":{"f:serviceAccount":{}}}}}}]}}`, want: true},
+		{name: "different values", kind: "Pod", raw: `{"spec":{"serviceAccountName":"new","serviceAccount":"old"}}`, want: true},
+	}
+	policy := &Policy{DeprecatedFields: ActionDeny}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, obj := deprecationRequest(t, tt.kind, tt.raw)
+			d := checkDeprecatedFields(context.Background(), policy, req, obj)
+			if got := d != nil; got != tt.want {
+				t.Errorf("denied = %v (%v), want %v", got, d, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckDeprecatedFieldsIgnored(t *testing.T) {
+	req, obj := deprecationRequest(t, "Pod", `{"spec":{"serviceAccount":"app","nodeSelector":{"beta.kubernetes.io/os":"linux"}}}`)
+	policy := &Policy{DeprecatedFields: ActionWarn, IgnoredDeprecations: []string{"service-account"}}
+	d := checkDeprecatedFields(context.Background(), policy, req, obj)
+	if d == nil || !d.warning {
+		t.Fatalf("got %v, want a warning for the node label", d)
+	}
+	if want := "pod  uses deprecated fields: nodeSelector beta.kubernetes.io/os (use kubernetes.io/os)."; d.message != want {
+		t.Errorf("message = %q, want %q", d.message, want)
+	}
+}
@@ -0,0 +1,66 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// checkSensitiveEnv 敏感的环境变量必须通过 valueFrom 引用 Secret, 不能直接写明文的 value
+func checkSensitiveEnv(_ context.Context, policy *Policy, pod *corev1.Pod) *denial {
+	if len(policy.SensitiveEnvPatterns) == 0 {
+		return nil
+	}
+	for _, container := range podContainers(pod) {
+		for _, env := range container.Env {
+			if env.Value == "" {
+				continue
+			}
+			for _, pattern := range policy.SensitiveEnvPatterns {
+				if matchPattern(pattern, env.Name) {
+					return &denial{
+						code: http.StatusForbidden,
+						message: fmt.Sprintf("container %s sets sensitive env %s in plaintext! Please use valueFrom.secretKeyRef instead.",
+							container.Name, env.Name),
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// 确实需要通过环境变量使用 Secret 的 pod 使用的注解, 值为 "true" 时跳过 DenySecretEnv 检查
+const secretEnvAllowedAnnotation = "admission-registry/allow-secret-env"
+
+// checkSecretEnv 不允许通过 envFrom.secretRef 或 valueFrom.secretKeyRef 把 Secret 注入环境变量
+// 环境变量可能通过 /proc 或者 crash dump 泄露, Secret 应该以文件的方式挂载
+func checkSecretEnv(_ context.Context, policy *Policy, pod *corev1.Pod) *denial {
+	if !policy.DenySecretEnv || pod.Annotations[secretEnvAllowedAnnotation] == "true" {
+		return nil
+	}
+	for _, container := range podContainers(pod) {
+		secret := ""
+		for _, envFrom := range container.EnvFrom {
+			if envFrom.SecretRef != nil {
+				secret = envFrom.SecretRef.Name
+				break
+			}
+		}
+		for _, env := range container.Env {
+			if secret == "" && env.ValueFrom != nil && env.ValueFrom.SecretKeyRef != nil {
+				secret = env.ValueFrom.SecretKeyRef.Name
+			}
+		}
+		if secret != "" {
+			return &denial{
+				code: http.StatusForbidden,
+				message: fmt.Sprintf("container %s uses Secret %s as environment variables! Mount the secret as a volume instead, or add annotation %s: \"true\".",
+					container.Name, secret, secretEnvAllowedAnnotation),
+			}
+		}
+	}
+	return nil
+}
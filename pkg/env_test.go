@@ -0,0 +1,79 @@
+package pkg
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// envPod 构造一个容器设置了这些环境变量的 pod
+func envPod(env ...corev1.EnvVar) *corev1.Pod {
+	return &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "nginx", Env: env}}}}
+}
+
+func TestCheckSensitiveEnv(t *testing.T) {
+	policy := &Policy{SensitiveEnvPatterns: []string{".*PASSWORD.*", "API_TOKEN"}}
+	initPod := envPod()
+	initPod.Spec.InitContainers = []corev1.Container{{Name: "migrate", Env: []corev1.EnvVar{{Name: "ROOT_PASSWORD", Value: "hunter2"}}}}
+	secretRef := &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "db"}, Key: "password"}}
+	tests := []struct {
+		name     string
+		pod      *corev1.Pod
+		wantCode int
+	}{
+		{name: "plain env", pod: envPod(corev1.EnvVar{Name: "LOG_LEVEL", Value: "debug"})},
+		{name: "plaintext password", pod: envPod(corev1.EnvVar{Name: "DB_PASSWORD", Value: "hunter2"}), wantCode: http.StatusForbidden},
+		{name: "password from secret", pod: envPod(corev1.EnvVar{Name: "DB_PASSWORD", ValueFrom: secretRef})},
+		{name: "empty value", pod: envPod(corev1.EnvVar{Name: "DB_PASSWORD"})},
+		// 正则需要完整匹配变量名
+		{name: "exact pattern", pod: envPod(corev1.EnvVar{Name: "API_TOKEN", Value: "abc"}), wantCode: http.StatusForbidden},
+		{name: "partial match", pod: envPod(corev1.EnvVar{Name: "API_TOKEN_TTL", Value: "3600"})},
+		{name: "init container", pod: initPod, wantCode: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		if code := denialCode(checkSensitiveEnv(context.Background(), policy, tt.pod)); code != tt.wantCode {
+			t.Errorf("%s: got code %d, want %d", tt.name, code, tt.wantCode)
+		}
+	}
+	if d := checkSensitiveEnv(context.Background(), &Policy{}, envPod(corev1.EnvVar{Name: "DB_PASSWORD", Value: "hunter2"})); d != nil {
+		t.Errorf("check is off without patterns, got %v", d.message)
+	}
+}
+
+func TestCheckSecretEnv(t *testing.T) {
+	secretKeyRef := corev1.EnvVar{Name: "DB_PASSWORD", ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "db"}, Key: "password"}}}
+	configMapKeyRef := corev1.EnvVar{Name: "LOG_LEVEL", ValueFrom: &corev1.EnvVarSource{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "app"}, Key: "level"}}}
+	envFromSecret := envPod()
+	envFromSecret.Spec.Containers[0].EnvFrom = []corev1.EnvFromSource{{SecretRef: &corev1.SecretEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: "api-keys"}}}}
+	initSecret := envPod()
+	initSecret.Spec.InitContainers = []corev1.Container{{Name: "migrate", Env: []corev1.EnvVar{secretKeyRef}}}
+	annotated := envPod(secretKeyRef)
+	annotated.Annotations = map[string]string{secretEnvAllowedAnnotation: "true"}
+	tests := []struct {
+		name       string
+		pod        *corev1.Pod
+		deny       bool
+		wantCode   int
+		wantSecret string
+	}{
+		{name: "secretKeyRef", pod: envPod(secretKeyRef), deny: true, wantCode: http.StatusForbidden, wantSecret: "Secret db"},
+		{name: "envFrom secretRef", pod: envFromSecret, deny: true, wantCode: http.StatusForbidden, wantSecret: "Secret api-keys"},
+		{name: "init container", pod: initSecret, deny: true, wantCode: http.StatusForbidden, wantSecret: "container migrate"},
+		{name: "configMap reference", pod: envPod(configMapKeyRef, corev1.EnvVar{Name: "MODE", Value: "prod"}), deny: true},
+		{name: "exception annotation", pod: annotated, deny: true},
+		{name: "not configured", pod: envPod(secretKeyRef)},
+	}
+	for _, tt := range tests {
+		d := checkSecretEnv(context.Background(), &Policy{DenySecretEnv: tt.deny}, tt.pod)
+		if code := denialCode(d); code != tt.wantCode {
+			t.Errorf("%s: got code %d, want %d", tt.name, code, tt.wantCode)
+			continue
+		}
+		if d != nil && !strings.Contains(d.message, tt.wantSecret) {
+			t.Errorf("%s: got message %q, want it to contain %q", tt.name, d.message, tt.wantSecret)
+		}
+	}
+}
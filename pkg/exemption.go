@@ -0,0 +1,20 @@
+package pkg
+
+// UserExemption 按照发起请求的用户跳过部分检查, 比如代替用户创建 pod 的 GitOps 控制器
+type UserExemption struct {
+	Username string   `json:"username"`         // 请求的 userInfo.username, ServiceAccount 为 system:serviceaccount:<namespace>:<name>, 支持正则
+	Checks   []string `json:"checks,omitempty"` // 跳过的检查, 为空时跳过所有检查
+}
+
+// exemptUser 判断用户发起的请求是否跳过名为 check 的检查
+func (p *Policy) exemptUser(username, check string) bool {
+	for _, exemption := range p.ExemptUsers {
+		if !matchPattern(exemption.Username, username) {
+			continue
+		}
+		if len(exemption.Checks) == 0 || containsString(exemption.Checks, check) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,63 @@
+package pkg
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	admissionV1 "k8s.io/api/admission/v1"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestExemptUser(t *testing.T) {
+	policy := &Policy{ExemptUsers: []UserExemption{
+		{Username: "system:serviceaccount:argocd:.*", Checks: []string{"registries"}},
+		{Username: "admin@corp.com"},
+	}}
+	tests := []struct {
+		username, check string
+		want            bool
+	}{
+		{username: "system:serviceaccount:argocd:application-controller", check: "registries", want: true},
+		{username: "system:serviceaccount:argocd:application-controller", check: "explicit-tag"},
+		// 正则需要完整匹配
+		{username: "system:serviceaccount:argocd-evil:controller", check: "registries"},
+		{username: "admin@corp.com", check: "registries", want: true},
+		{username: "admin@corp.com", check: "explicit-tag", want: true},
+		{username: "admin@corp.com.evil", check: "registries"},
+		{username: "developer@corp.com", check: "registries"},
+	}
+	for _, tt := range tests {
+		if got := policy.exemptUser(tt.username, tt.check); got != tt.want {
+			t.Errorf("exemptUser(%q, %q) = %v, want %v", tt.username, tt.check, got, tt.want)
+		}
+	}
+}
+
+func TestRunSkipsExemptedChecks(t *testing.T) {
+	denied := Result{Message: "denied", Severity: SeverityEnforce, Code: http.StatusForbidden}
+	registry := NewCheckRegistry(
+		funcCheck{"exemption-test-first", func(context.Context) Result { return denied }},
+		funcCheck{"exemption-test-second", func(context.Context) Result { return denied }},
+	)
+	policy := &Policy{ExemptUsers: []UserExemption{
+		{Username: "system:serviceaccount:argocd:controller", Checks: []string{"exemption-test-first", "exemption-test-second"}},
+		{Username: "system:serviceaccount:ci:builder", Checks: []string{"exemption-test-first"}},
+	}}
+	tests := []struct {
+		username    string
+		wantAllowed bool
+	}{
+		{username: "system:serviceaccount:argocd:controller", wantAllowed: true},
+		{username: "system:serviceaccount:ci:builder"},
+		{username: "developer@corp.com"},
+	}
+	discardLogs(t)
+	for _, tt := range tests {
+		req := &Request{AdmissionRequest: &admissionV1.AdmissionRequest{UserInfo: authenticationv1.UserInfo{Username: tt.username}}, Policy: policy}
+		if resp := registry.Run(context.Background(), &corev1.Pod{}, req); resp.Allowed != tt.wantAllowed {
+			t.Errorf("%s: got allowed %v, want %v", tt.username, resp.Allowed, tt.wantAllowed)
+		}
+	}
+}
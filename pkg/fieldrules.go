@@ -0,0 +1,162 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	admissionV1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/util/jsonpath"
+	"k8s.io/klog"
+)
+
+// FieldRule 按字段路径对任意类型的对象做检查, 包括没有内置支持的 CRD
+// 比如要求 Deployment 至少两个副本: {kind: Deployment, path: "{.spec.replicas}", operator: ">=", value: "2"}
+type FieldRule struct {
+	Kind     string `json:"kind"`              // 生效的对象类型, 为空时对所有类型生效
+	Path     string `json:"path"`              // JSONPath 表达式, 比如 {.spec.replicas}
+	Operator string `json:"operator"`          // exists, ==, !=, >, >=, <, <=, matches
+	Value    string `json:"value,omitempty"`   // 比较的值, matches 时为正则
+	Message  string `json:"message,omitempty"` // 不满足时返回的提示, 为空时自动生成
+}
+
+// checkFieldRules 依次执行 Policy.FieldRules, 字段不存在时只有 exists 规则会失败, 其它规则视为不满足
+func checkFieldRules(_ context.Context, policy *Policy, req *admissionV1.AdmissionRequest, _ runtime.Object) *denial {
+	if len(policy.FieldRules) == 0 || len(req.Object.Raw) == 0 {
+		return nil
+	}
+	var data interface{}
+	if err := json.Unmarshal(req.Object.Raw, &data); err != nil {
+		return nil
+	}
+	for _, rule := range policy.FieldRules {
+		if rule.Kind != "" && rule.Kind != req.Kind.Kind {
+			continue
+		}
+		values, err := fieldValues(rule.Path, data)
+		if err != nil {
+			// LoadConfig 已经校验过路径, 这里出错说明规则有问题, 拒绝而不是跳过
+			klog.Errorf("Invalid field rule path %q: %v", rule.Path, err)
+			return &denial{
+				code:    http.StatusInternalServerError,
+				message: fmt.Sprintf("invalid field rule path %q: %v", rule.Path, err),
+			}
+		}
+		if rule.satisfied(values) {
+			continue
+		}
+		message := rule.Message
+		if message == "" {
+			message = fmt.Sprintf("%s %s must satisfy %s.", req.Kind.Kind, req.Name,
+				strings.TrimSpace(rule.Path+" "+rule.Operator+" "+rule.Value))
+		}
+		return &denial{
+			code:    http.StatusForbidden,
+			message: message,
+		}
+	}
+	return nil
+}
+
+// 数值比较的运算符
+var numericOperators = []string{">", ">=", "<", "<="}
+
+// validate 检查规则的运算符, 路径和比较的值, 在加载配置时调用
+func (r FieldRule) validate() error {
+	if _, err := parseFieldPath(r.Path); err != nil {
+		return fmt.Errorf("invalid path %q: %v", r.Path, err)
+	}
+	switch {
+	case r.Operator == "exists", r.Operator == "==", r.Operator == "!=":
+	case r.Operator == "matches":
+		if _, err := compilePattern(r.Value); err != nil {
+			return fmt.Errorf("invalid pattern %q: %v", r.Value, err)
+		}
+	case containsString(numericOperators, r.Operator):
+		if _, err := strconv.ParseFloat(r.Value, 64); err != nil {
+			return fmt.Errorf("operator %s needs a number, got %q", r.Operator, r.Value)
+		}
+	default:
+		return fmt.Errorf("unknown operator %q", r.Operator)
+	}
+	return nil
+}
+
+func parseFieldPath(path string) (*jsonpath.JSONPath, error) {
+	if !strings.HasPrefix(path, "{") {
+		path = "{" + path + "}"
+	}
+	jp := jsonpath.New("field-rule").AllowMissingKeys(true)
+	if err := jp.Parse(path); err != nil {
+		return nil, err
+	}
+	return jp, nil
+}
+
+// fieldValues 返回 JSONPath 选中的所有值, 路径不存在时返回空
+func fieldValues(path string, data interface{}) ([]interface{}, error) {
+	jp, err := parseFieldPath(path)
+	if err != nil {
+		return nil, err
+	}
+	results, err := jp.FindResults(data)
+	if err != nil {
+		return nil, err
+	}
+	var values []interface{}
+	for _, result := range results {
+		for _, v := range result {
+			values = append(values, v.Interface())
+		}
+	}
+	return values, nil
+}
+
+// satisfied 选中多个值时每个值都必须满足规则
+func (r FieldRule) satisfied(values []interface{}) bool {
+	if r.Operator == "exists" {
+		return len(values) > 0
+	}
+	if len(values) == 0 {
+		return false
+	}
+	for _, v := range values {
+		if !r.compare(fmt.Sprint(v)) {
+			return false
+		}
+	}
+	return true
+}
+
+func (r FieldRule) compare(actual string) bool {
+	switch r.Operator {
+	case "==":
+		return actual == r.Value
+	case "!=":
+		return actual != r.Value
+	case "matches":
+		return matchPattern(r.Value, actual)
+	}
+	a, err1 := strconv.ParseFloat(actual, 64)
+	b, err2 := strconv.ParseFloat(r.Value, 64)
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	switch r.Operator {
+	case ">":
+		return a > b
+	case ">=":
+		return a >= b
+	case "<":
+		return a < b
+	case "<=":
+		return a <= b
+	}
+	// 未知的运算符在 LoadConfig 时就会被拒绝, 这里视为不满足
+	klog.Errorf("Unknown field rule operator %q", r.Operator)
+	return false
+}
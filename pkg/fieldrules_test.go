@@ -0,0 +1,74 @@
+package pkg
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	admissionV1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestCheckFieldRules(t *testing.T) {
+	deployment := `{"metadata":{"name":"web","labels":{"team":"payments"}},"spec":{"replicas":3,"template":{"spec":{"containers":[{"name":"a","image":"a:1"},{"name":"b","image":"b:1"}]}}}}`
+	tests := []struct {
+		name     string
+		rule     FieldRule
+		wantCode int
+	}{
+		{name: "exists", rule: FieldRule{Path: "{.metadata.labels.team}", Operator: "exists"}},
+		{name: "missing field fails exists", rule: FieldRule{Path: "{.metadata.labels.owner}", Operator: "exists"}, wantCode: http.StatusForbidden},
+		{name: "missing field fails comparison", rule: FieldRule{Path: "{.spec.paused}", Operator: "==", Value: "false"}, wantCode: http.StatusForbidden},
+		{name: "equal", rule: FieldRule{Path: "{.metadata.labels.team}", Operator: "==", Value: "payments"}},
+		{name: "not equal", rule: FieldRule{Path: "{.metadata.labels.team}", Operator: "!=", Value: "payments"}, wantCode: http.StatusForbidden},
+		{name: "greater or equal", rule: FieldRule{Path: ".spec.replicas", Operator: ">=", Value: "2"}},
+		{name: "less than", rule: FieldRule{Path: ".spec.replicas", Operator: "<", Value: "3"}, wantCode: http.StatusForbidden},
+		{name: "matches every selected value", rule: FieldRule{Path: "{.spec.template.spec.containers[*].image}", Operator: "matches", Value: "[ab]:1"}},
+		{name: "one selected value does not match", rule: FieldRule{Path: "{.spec.template.spec.containers[*].image}", Operator: "matches", Value: "a:.*"}, wantCode: http.StatusForbidden},
+		{name: "other kind is skipped", rule: FieldRule{Kind: "StatefulSet", Path: ".spec.replicas", Operator: ">", Value: "5"}},
+		{name: "unknown operator denies", rule: FieldRule{Path: ".spec.replicas", Operator: "=>", Value: "2"}, wantCode: http.StatusForbidden},
+		{name: "invalid path denies", rule: FieldRule{Path: "{.spec.replicas", Operator: "exists"}, wantCode: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &admissionV1.AdmissionRequest{
+				Kind:   metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"},
+				Name:   "web",
+				Object: runtime.RawExtension{Raw: []byte(deployment)},
+			}
+			policy := &Policy{FieldRules: []FieldRule{tt.rule}}
+			d := checkFieldRules(context.Background(), policy, req, nil)
+			if code := denialCode(d); code != tt.wantCode {
+				t.Errorf("got code %d (%v), want %d", code, d, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestFieldRuleValidate(t *testing.T) {
+	tests := []struct {
+		rule    FieldRule
+		wantErr bool
+	}{
+		{rule: FieldRule{Path: ".spec.replicas", Operator: ">=", Value: "2"}},
+		{rule: FieldRule{Path: ".metadata.name", Operator: "matches", Value: "web-.*"}},
+		{rule: FieldRule{Path: ".metadata.name", Operator: "exists"}},
+		{rule: FieldRule{Path: ".spec.replicas", Operator: "=>", Value: "2"}, wantErr: true},
+		{rule: FieldRule{Path: ".spec.replicas", Operator: ">", Value: "two"}, wantErr: true},
+		{rule: FieldRule{Path: ".metadata.name", Operator: "matches", Value: "web-("}, wantErr: true},
+		{rule: FieldRule{Path: "{.spec.replicas", Operator: "exists"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		if err := tt.rule.validate(); (err != nil) != tt.wantErr {
+			t.Errorf("validate(%+v) = %v, want error %v", tt.rule, err, tt.wantErr)
+		}
+	}
+}
+
+func TestLoadConfigRejectsInvalidFieldRule(t *testing.T) {
+	_, err := loadTestConfig(t, "namespaces:\n  prod:\n    fieldRules:\n    - path: .spec.replicas\n      operator: '=>'\n      value: '2'\n")
+	if err == nil {
+		t.Error("LoadConfig accepted a field rule with an unknown operator")
+	}
+}
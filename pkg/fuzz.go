@@ -0,0 +1,61 @@
+//go:build gofuzz
+// +build gofuzz
+
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	admissionV1 "k8s.io/api/admission/v1"
+)
+
+// fuzzServer 开启了大部分检查的 WebhookServer, 不访问集群和镜像仓库
+var fuzzServer = newFuzzServer()
+
+func newFuzzServer() *WebhookServer {
+	s := &WebhookServer{Inspector: NewCachedInspector(OfflineInspector{}), LargeObjectSize: 4096}
+	s.SetConfig(Config{Base: Policy{
+		WhiteListRegistries:      []string{"docker.io"},
+		CollectAllViolations:     true,
+		RequiredLabels:           map[string]string{"app": ".+"},
+		RequireEmptyDirSizeLimit: true,
+		MaxEmptyDirSize:          "1Gi",
+		AllowedContainerPorts:    []string{"80", "8000-8999"},
+		DenyHostPorts:            true,
+		ForbiddenUIDs:            []string{"0"},
+		RequireCronJobGuards:     true,
+		FloatingTags:             ActionDeny,
+		MutateWarnings:           true,
+	}})
+	return s
+}
+
+// Fuzz go-fuzz 的入口, 把 data 作为请求 body 发给 validate 和 mutate
+// 处理器必须总是返回响应, review 中被 recover 的 panic 也当作崩溃报告出来
+// 运行方式: go-fuzz-build ./pkg && go-fuzz -bin pkg-fuzz.zip -workdir pkg/testdata/fuzz
+func Fuzz(data []byte) int {
+	interesting := 0
+	for _, path := range []string{ValidatePath, MutatePath} {
+		request := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(data))
+		request.Header.Set("Content-Type", "application/json")
+		recorder := httptest.NewRecorder()
+		fuzzServer.Handler(recorder, request)
+		if recorder.Code != http.StatusOK {
+			continue
+		}
+		var review admissionV1.AdmissionReview
+		if err := json.Unmarshal(recorder.Body.Bytes(), &review); err != nil || review.Response == nil {
+			panic(fmt.Sprintf("%s returned 200 without an AdmissionResponse: %q", path, recorder.Body.String()))
+		}
+		if result := review.Response.Result; result != nil && strings.HasPrefix(result.Message, "internal error while reviewing") {
+			panic(fmt.Sprintf("%s: %s", path, result.Message))
+		}
+		interesting = 1
+	}
+	return interesting
+}
@@ -0,0 +1,47 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	admissionV1 "k8s.io/api/admission/v1"
+)
+
+// TestFuzzCorpus 用 go-fuzz 的种子语料测试处理器, 保证不运行 go-fuzz 时这些边界情况同样被覆盖
+func TestFuzzCorpus(t *testing.T) {
+	files, err := filepath.Glob("testdata/fuzz/corpus/*")
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no seed corpus: %v", err)
+	}
+	s := &WebhookServer{Inspector: NewCachedInspector(OfflineInspector{}), LargeObjectSize: 4096}
+	s.SetConfig(Config{Base: Policy{WhiteListRegistries: []string{"docker.io"}, CollectAllViolations: true, MutateWarnings: true}})
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, path := range []string{ValidatePath, MutatePath} {
+			request := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(data))
+			request.Header.Set("Content-Type", "application/json")
+			recorder := httptest.NewRecorder()
+			s.Handler(recorder, request)
+			if recorder.Code != http.StatusOK {
+				continue
+			}
+			var review admissionV1.AdmissionReview
+			if err := json.Unmarshal(recorder.Body.Bytes(), &review); err != nil || review.Response == nil {
+				t.Errorf("%s %s: 200 without an AdmissionResponse: %q", filepath.Base(file), path, recorder.Body.String())
+				continue
+			}
+			if result := review.Response.Result; result != nil && strings.HasPrefix(result.Message, "internal error while reviewing") {
+				t.Errorf("%s %s: %s", filepath.Base(file), path, result.Message)
+			}
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// GPU 资源的名字
+const gpuResource corev1.ResourceName = "nvidia.com/gpu"
+
+// requestsGPU 判断容器是否申请了 GPU
+func requestsGPU(container *corev1.Container) bool {
+	_, inRequests := container.Resources.Requests[gpuResource]
+	_, inLimits := container.Resources.Limits[gpuResource]
+	return inRequests || inLimits
+}
+
+// checkGPUImages 申请 GPU 的容器只能使用 GPU 白名单中的镜像, 没有申请 GPU 的容器不受影响
+func checkGPUImages(_ context.Context, policy *Policy, pod *corev1.Pod) *denial {
+	if len(policy.GPUWhiteListRegistries) == 0 {
+		return nil
+	}
+	for _, container := range podContainers(pod) {
+		if !requestsGPU(&container) || whitelisted(policy.canonicalImage(container.Image), policy.GPUWhiteListRegistries) {
+			continue
+		}
+		return &denial{
+			code: http.StatusForbidden,
+			message: fmt.Sprintf("container %s requests %s but its image %s is not approved for GPU nodes! Only images from %v are allowed.",
+				container.Name, gpuResource, container.Image, policy.GPUWhiteListRegistries),
+		}
+	}
+	return nil
+}
+
+// checkGPUResources 申请 GPU 的容器必须同时设置 GPU 的 request 和 limit, 并且两者相等
+// api-server 之后也会拒绝这样的 pod, 这里提前拒绝是为了给出更明确的错误信息
+func checkGPUResources(_ context.Context, policy *Policy, pod *corev1.Pod) *denial {
+	if !policy.ValidateGPUResources {
+		return nil
+	}
+	for _, container := range podContainers(pod) {
+		if !requestsGPU(&container) {
+			continue
+		}
+		request, hasRequest := container.Resources.Requests[gpuResource]
+		limit, hasLimit := container.Resources.Limits[gpuResource]
+		if !hasRequest || !hasLimit {
+			return &denial{
+				code: http.StatusForbidden,
+				message: fmt.Sprintf("container %s must set both resources.requests and resources.limits for %s!",
+					container.Name, gpuResource),
+			}
+		}
+		if request.Cmp(limit) != 0 {
+			return &denial{
+				code: http.StatusForbidden,
+				message: fmt.Sprintf("container %s requests %s %s but limits it to %s! GPU requests must equal limits.",
+					container.Name, request.String(), gpuResource, limit.String()),
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,67 @@
+package pkg
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// gpuPod 构造一个容器的 pod, requests 和 limits 为空字符串时不设置对应的 GPU 资源
+func gpuPod(image, requests, limits string) *corev1.Pod {
+	pod := imagePod(image)
+	if requests != "" {
+		pod.Spec.Containers[0].Resources.Requests = corev1.ResourceList{gpuResource: resource.MustParse(requests)}
+	}
+	if limits != "" {
+		pod.Spec.Containers[0].Resources.Limits = corev1.ResourceList{gpuResource: resource.MustParse(limits)}
+	}
+	return pod
+}
+
+func TestCheckGPUImages(t *testing.T) {
+	config, err := loadTestConfig(t, "base:\n  gpuWhiteListRegistries: [nvcr.io/nvidia]\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		pod      *corev1.Pod
+		wantCode int
+	}{
+		{name: "approved GPU image", pod: gpuPod("nvcr.io/nvidia/cuda:11.2", "1", "1")},
+		{name: "unapproved GPU image", pod: gpuPod("nginx:1.21", "", "1"), wantCode: http.StatusForbidden},
+		{name: "only requests", pod: gpuPod("quay.io/ml/train:1", "1", ""), wantCode: http.StatusForbidden},
+		{name: "path boundary", pod: gpuPod("nvcr.io/nvidia-evil/cuda:11.2", "1", "1"), wantCode: http.StatusForbidden},
+		{name: "no GPU", pod: gpuPod("nginx:1.21", "", "")},
+	}
+	for _, tt := range tests {
+		if code := denialCode(checkGPUImages(context.Background(), &config.Base, tt.pod)); code != tt.wantCode {
+			t.Errorf("%s: got code %d, want %d", tt.name, code, tt.wantCode)
+		}
+	}
+}
+
+func TestCheckGPUResources(t *testing.T) {
+	tests := []struct {
+		name             string
+		requests, limits string
+		validate         bool
+		wantCode         int
+	}{
+		{name: "request equals limit", requests: "2", limits: "2", validate: true},
+		{name: "request differs from limit", requests: "1", limits: "2", validate: true, wantCode: http.StatusForbidden},
+		{name: "only limit", limits: "1", validate: true, wantCode: http.StatusForbidden},
+		{name: "only request", requests: "1", validate: true, wantCode: http.StatusForbidden},
+		{name: "no GPU", validate: true},
+		{name: "not configured", requests: "1", limits: "2"},
+	}
+	for _, tt := range tests {
+		pod := gpuPod("nvcr.io/nvidia/cuda:11.0", tt.requests, tt.limits)
+		if code := denialCode(checkGPUResources(context.Background(), &Policy{ValidateGPUResources: tt.validate}, pod)); code != tt.wantCode {
+			t.Errorf("%s: got code %d, want %d", tt.name, code, tt.wantCode)
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"k8s.io/klog"
+)
+
+// 依赖探测失败时返回的结果, 具体的错误包含内部地址, 只打印到日志中
+const dependencyUnreachable = "unreachable"
+
+// 探测外部依赖的默认超时时间, 就绪检查本身也有超时, 这里要短一些
+const defaultProbeTimeout = 2 * time.Second
+
+// Dependency 就绪检查时探测的外部依赖, 比如白名单服务和漏洞扫描服务
+type Dependency struct {
+	Name string
+	URL  string
+}
+
+// ReadyStatus 就绪检查的结果
+type ReadyStatus struct {
+	Status       string            `json:"status"`                 // ready、degraded 或 not-ready
+	Dependencies map[string]string `json:"dependencies,omitempty"` // 每个依赖的探测结果, 成功时为 ok, 失败时为 unreachable
+}
+
+// ReadyHandler 探测所有外部依赖, 有依赖不可达时返回 503
+// 配置了 ReadyWhenDegraded 时仍然返回 200, 状态为 degraded, 避免依赖故障时所有副本都被摘掉
+func (s *WebhookServer) ReadyHandler(writer http.ResponseWriter, request *http.Request) {
+	status := s.ready(request.Context())
+	code := http.StatusOK
+	if status.Status == "not-ready" {
+		code = http.StatusServiceUnavailable
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.WriteHeader(code)
+	json.NewEncoder(writer).Encode(status)
+}
+
+func (s *WebhookServer) ready(ctx context.Context) ReadyStatus {
+	status := ReadyStatus{Status: "ready", Dependencies: make(map[string]string, len(s.Dependencies))}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, dep := range s.Dependencies {
+		wg.Add(1)
+		go func(dep Dependency) {
+			defer wg.Done()
+			result := "ok"
+			if err := s.probe(ctx, dep.URL); err != nil {
+				klog.Warningf("Readiness probe of dependency %s failed: %v", dep.Name, err)
+				result = dependencyUnreachable
+			}
+			mu.Lock()
+			status.Dependencies[dep.Name] = result
+			mu.Unlock()
+		}(dep)
+	}
+	wg.Wait()
+	for _, result := range status.Dependencies {
+		if result != "ok" {
+			status.Status = "not-ready"
+			if s.ReadyWhenDegraded {
+				status.Status = "degraded"
+			}
+			break
+		}
+	}
+	return status
+}
+
+// probe 请求依赖的地址, 能连上并且没有返回 5xx 就认为可达, 不关心具体的响应内容
+func (s *WebhookServer) probe(ctx context.Context, url string) error {
+	timeout := s.ProbeTimeout
+	if timeout == 0 {
+		timeout = defaultProbeTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("returned %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestReadyHandler(t *testing.T) {
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer healthy.Close()
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer failing.Close()
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(200 * time.Millisecond)
+	}))
+	defer slow.Close()
+	stopped := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	stopped.Close()
+
+	tests := []struct {
+		name          string
+		dependencies  []Dependency
+		degraded      bool
+		wantCode      int
+		wantStatus    string
+		wantUnhealthy string
+	}{
+		{name: "no dependencies", wantCode: http.StatusOK, wantStatus: "ready"},
+		{name: "reachable", dependencies: []Dependency{{"allowlist", healthy.URL}}, wantCode: http.StatusOK, wantStatus: "ready"},
+		{
+			name:          "5xx",
+			dependencies:  []Dependency{{"allowlist", healthy.URL}, {"scanner", failing.URL}},
+			wantCode:      http.StatusServiceUnavailable,
+			wantStatus:    "not-ready",
+			wantUnhealthy: "scanner",
+		},
+		{
+			name:          "connection refused",
+			dependencies:  []Dependency{{"scanner", stopped.URL}},
+			wantCode:      http.StatusServiceUnavailable,
+			wantStatus:    "not-ready",
+			wantUnhealthy: "scanner",
+		},
+		{
+			name:          "timeout",
+			dependencies:  []Dependency{{"scanner", slow.URL}},
+			wantCode:      http.StatusServiceUnavailable,
+			wantStatus:    "not-ready",
+			wantUnhealthy: "scanner",
+		},
+		{
+			name:          "degraded",
+			dependencies:  []Dependency{{"scanner", stopped.URL}},
+			degraded:      true,
+			wantCode:      http.StatusOK,
+			wantStatus:    "degraded",
+			wantUnhealthy: "scanner",
+		},
+	}
+	discardLogs(t)
+	for _, tt := range tests {
+		s := &WebhookServer{Dependencies: tt.dependencies, ReadyWhenDegraded: tt.degraded, ProbeTimeout: 50 * time.Millisecond}
+		recorder := httptest.NewRecorder()
+		s.ReadyHandler(recorder, httptest.NewRequest(http.MethodGet, "/readyz", nil).WithContext(context.Background()))
+		var status ReadyStatus
+		if err := json.NewDecoder(recorder.Body).Decode(&status); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if recorder.Code != tt.wantCode || status.Status != tt.wantStatus {
+			t.Errorf("%s: got %d %s, want %d %s", tt.name, recorder.Code, status.Status, tt.wantCode, tt.wantStatus)
+		}
+		for name, result := range status.Dependencies {
+			want := "ok"
+			if name == tt.wantUnhealthy {
+				want = dependencyUnreachable
+			}
+			if result != want {
+				t.Errorf("%s: dependency %s reported %q, want %q", tt.name, name, result, want)
+			}
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package pkg
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	admissionV1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+	"k8s.io/klog"
+)
+
+// denialCode 返回拒绝的状态码, 放行时返回 0
+func denialCode(d *denial) int {
+	if d == nil {
+		return 0
+	}
+	return d.code
+}
+
+// assertDenial 检查检查结果的状态码, 拒绝时还检查是否只是警告
+func assertDenial(t *testing.T, d *denial, wantCode int, wantWarning bool) {
+	t.Helper()
+	if code := denialCode(d); code != wantCode {
+		t.Errorf("got code %d (%v), want %d", code, d, wantCode)
+		return
+	}
+	if d != nil && d.warning != wantWarning {
+		t.Errorf("warning = %v, want %v", d.warning, wantWarning)
+	}
+}
+
+// errAPIServer failRequests 让假的 API server 返回的错误
+var errAPIServer = errors.New("api-server unavailable")
+
+// failRequests 让 client 对 resource 的 verb 请求都返回 errAPIServer, 用于测试查询失败时的行为
+func failRequests(client *fake.Clientset, verb, resource string) {
+	client.PrependReactor(verb, resource, func(k8stesting.Action) (bool, runtime.Object, error) {
+		return true, nil, errAPIServer
+	})
+}
+
+// admissionRequest 返回 operation 的准入请求, operation 为空时为 Create
+func admissionRequest(operation admissionV1.Operation) *admissionV1.AdmissionRequest {
+	if operation == "" {
+		operation = admissionV1.Create
+	}
+	return &admissionV1.AdmissionRequest{Operation: operation}
+}
+
+// testDigest 返回由 seed 生成的合法 digest, 镜像地址中的 digest 必须是 64 位十六进制
+func testDigest(seed string) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256([]byte(seed)))
+}
+
+// imagePod 构造使用这些镜像的 pod
+func imagePod(images ...string) *corev1.Pod {
+	pod := &corev1.Pod{}
+	for i, image := range images {
+		pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{Name: fmt.Sprintf("c%d", i), Image: image})
+	}
+	return pod
+}
+
+// loadTestConfig 把 content 写入临时的配置文件并加载
+func loadTestConfig(t *testing.T, content string) (*Config, error) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "config.yaml")
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return LoadConfig(path)
+}
+
+// fakeInspector 假的 ImageInspector 和 TagLister, 按镜像地址返回 digest, 按 digest 返回元数据
+type fakeInspector struct {
+	digests map[string]string
+	infos   map[string]*ImageInfo
+	tags    map[string][]string
+	err     error
+}
+
+func (f *fakeInspector) Digest(_ context.Context, image string) (string, error) {
+	if f.err != nil {
+		return "", f.err
+	}
+	if digest, ok := f.digests[image]; ok {
+		return digest, nil
+	}
+	return testDigest(image), nil
+}
+
+func (f *fakeInspector) Inspect(_ context.Context, _, digest string) (*ImageInfo, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	if info, ok := f.infos[digest]; ok {
+		return info, nil
+	}
+	return &ImageInfo{Digest: digest}, nil
+}
+
+func (f *fakeInspector) Tags(_ context.Context, image string) ([]string, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.tags[parseImage(image).Repository], nil
+}
+
+// discardLogs 丢弃 tb 执行期间的日志, 每个请求都会打印日志, 不丢弃时 benchmark 的结果主要是写 stderr 的时间
+func discardLogs(tb testing.TB) {
+	fs := flag.NewFlagSet("klog", flag.ContinueOnError)
+	klog.InitFlags(fs)
+	fs.Set("logtostderr", "false")
+	fs.Set("stderrthreshold", "FATAL")
+	klog.SetOutput(ioutil.Discard)
+	tb.Cleanup(func() {
+		fs.Set("logtostderr", "true")
+		fs.Set("stderrthreshold", "ERROR")
+	})
+}
@@ -0,0 +1,74 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	admissionV1 "k8s.io/api/admission/v1"
+	autoscalingv2beta2 "k8s.io/api/autoscaling/v2beta2"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// checkHPARequests 按资源使用率扩缩容的 HPA, 目标工作负载的所有容器都必须声明对应资源的 requests, 否则 HPA 算不出使用率
+// 只写了 limits 的资源, api-server 创建 pod 时会把 requests 设置成和 limits 一样, 同样算作声明了 requests
+// 只支持 Deployment 和 StatefulSet 作为扩缩容目标
+func (s *WebhookServer) checkHPARequests(ctx context.Context, policy *Policy, _ *admissionV1.AdmissionRequest, obj runtime.Object) *denial {
+	hpa, ok := obj.(*autoscalingv2beta2.HorizontalPodAutoscaler)
+	if !ok || policy.RequireHPARequests == "" || s.Client == nil {
+		return nil
+	}
+	var resources []corev1.ResourceName
+	for _, metric := range hpa.Spec.Metrics {
+		if metric.Type == autoscalingv2beta2.ResourceMetricSourceType && metric.Resource != nil &&
+			metric.Resource.Target.Type == autoscalingv2beta2.UtilizationMetricType {
+			resources = append(resources, metric.Resource.Name)
+		}
+	}
+	if len(resources) == 0 {
+		return nil
+	}
+
+	target := hpa.Spec.ScaleTargetRef
+	lookupCtx, cancel := s.lookupContext(ctx)
+	defer cancel()
+	var template *corev1.PodTemplateSpec
+	switch target.Kind {
+	case "Deployment":
+		deploy, err := s.Client.AppsV1().Deployments(hpa.Namespace).Get(lookupCtx, target.Name, metav1.GetOptions{})
+		if err != nil {
+			return lookupFailed(policy, "Deployment "+target.Name, err)
+		}
+		template = &deploy.Spec.Template
+	case "StatefulSet":
+		sts, err := s.Client.AppsV1().StatefulSets(hpa.Namespace).Get(lookupCtx, target.Name, metav1.GetOptions{})
+		if err != nil {
+			return lookupFailed(policy, "StatefulSet "+target.Name, err)
+		}
+		template = &sts.Spec.Template
+	default:
+		return nil
+	}
+
+	for _, container := range template.Spec.Containers {
+		for _, resource := range resources {
+			if !hasEffectiveRequest(container.Resources, resource) {
+				return policy.RequireHPARequests.violation(http.StatusForbidden,
+					fmt.Sprintf("HorizontalPodAutoscaler %s scales %s %s on %s utilization, but container %s has no %s request!",
+						hpa.Name, target.Kind, target.Name, resource, container.Name, resource))
+			}
+		}
+	}
+	return nil
+}
+
+// hasEffectiveRequest 判断容器是否声明了资源的 requests, 只写了 limits 时 requests 默认等于 limits
+func hasEffectiveRequest(resources corev1.ResourceRequirements, name corev1.ResourceName) bool {
+	if _, ok := resources.Requests[name]; ok {
+		return true
+	}
+	_, ok := resources.Limits[name]
+	return ok
+}
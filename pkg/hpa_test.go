@@ -0,0 +1,72 @@
+package pkg
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2beta2 "k8s.io/api/autoscaling/v2beta2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func cpuHPA(target string) *autoscalingv2beta2.HorizontalPodAutoscaler {
+	utilization := int32(80)
+	return &autoscalingv2beta2.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "team", Name: "web"},
+		Spec: autoscalingv2beta2.HorizontalPodAutoscalerSpec{
+			ScaleTargetRef: autoscalingv2beta2.CrossVersionObjectReference{Kind: "Deployment", Name: target},
+			Metrics: []autoscalingv2beta2.MetricSpec{{
+				Type: autoscalingv2beta2.ResourceMetricSourceType,
+				Resource: &autoscalingv2beta2.ResourceMetricSource{
+					Name:   corev1.ResourceCPU,
+					Target: autoscalingv2beta2.MetricTarget{Type: autoscalingv2beta2.UtilizationMetricType, AverageUtilization: &utilization},
+				},
+			}},
+		},
+	}
+}
+
+func resourceDeployment(name string, resources corev1.ResourceRequirements) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "team", Name: name},
+		Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{
+			Containers: []corev1.Container{{Name: "app", Resources: resources}},
+		}}},
+	}
+}
+
+func TestCheckHPARequests(t *testing.T) {
+	cpu := corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")}
+	client := fake.NewSimpleClientset(
+		resourceDeployment("requests", corev1.ResourceRequirements{Requests: cpu}),
+		resourceDeployment("limits-only", corev1.ResourceRequirements{Limits: cpu}),
+		resourceDeployment("none", corev1.ResourceRequirements{}),
+	)
+	s := &WebhookServer{Client: client}
+	tests := []struct {
+		name     string
+		target   string
+		policy   Policy
+		wantCode int
+	}{
+		{name: "target has requests", target: "requests", policy: Policy{RequireHPARequests: ActionDeny}},
+		{name: "limits are effective requests", target: "limits-only", policy: Policy{RequireHPARequests: ActionDeny}},
+		{name: "target lacks requests", target: "none", policy: Policy{RequireHPARequests: ActionDeny}, wantCode: http.StatusForbidden},
+		{name: "missing target fails open", target: "missing", policy: Policy{RequireHPARequests: ActionDeny}},
+		{name: "missing target fails closed", target: "missing", policy: Policy{RequireHPARequests: ActionDeny, LookupFailClosed: true},
+			wantCode: http.StatusInternalServerError},
+		{name: "check disabled", target: "none"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := s.checkHPARequests(context.Background(), &tt.policy, nil, cpuHPA(tt.target))
+			if code := denialCode(d); code != tt.wantCode {
+				t.Errorf("got code %d (%v), want %d", code, d, tt.wantCode)
+			}
+		})
+	}
+}
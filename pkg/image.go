@@ -0,0 +1,238 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/docker/distribution/reference"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// 白名单中表示允许所有镜像的一项, 只有单独写 * 时才生效, reg* 这样的写法仍然按前缀匹配
+const allowAllRegistries = "*"
+
+// whitelisted 判断镜像是否来自白名单中的仓库, 比较的是规范化之后的镜像地址
+// 所以 nginx、library/nginx 和 docker.io/library/nginx 都能匹配白名单中的 docker.io/library
+// 只在路径的边界处匹配 (hasRegistryPrefix), gcr.io 不会匹配 gcr.io.evil.com/app
+// 白名单同样需要规范化 (normalizeRegistryPrefixes), LoadConfig、环境变量和拉取外部白名单时已经处理
+// 策略中配置了规范化规则时, 调用方先用 Policy.canonicalImage 转换镜像地址
+func whitelisted(image string, whitelist []string) bool {
+	image = normalizeImage(image)
+	for _, reg := range whitelist {
+		if reg == allowAllRegistries || hasRegistryPrefix(image, reg) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkDockerHub 开启 DenyDockerHub 时拒绝来自 docker.io 的镜像
+// 和白名单使用同样的规范化, nginx、library/nginx、docker.io/library/nginx 都算作 docker.io
+func checkDockerHub(_ context.Context, policy *Policy, pod *corev1.Pod) *denial {
+	if !policy.DenyDockerHub {
+		return nil
+	}
+	for _, container := range podContainers(pod) {
+		if !strings.HasPrefix(policy.canonicalImage(container.Image), "docker.io/") {
+			continue
+		}
+		message := fmt.Sprintf("%s image of container %s comes from docker.io! Docker Hub images are not allowed.", container.Image, container.Name)
+		if policy.DockerHubMirror != "" {
+			message += fmt.Sprintf(" Use the mirror %s instead.", policy.DockerHubMirror)
+		}
+		return &denial{
+			code:             http.StatusForbidden,
+			message:          message,
+			auditAnnotations: map[string]string{"rejected-image": container.Image},
+		}
+	}
+	return nil
+}
+
+// canonicalImage 按照策略中的规则把镜像地址转换成规范的形式, 再和白名单比较
+//   - RegistryAliases: 镜像地址的仓库是别名时替换成真实的仓库, 比如 myhub.local/app 转换成 registry.corp.com/app
+//     别名必须是 kubelet 真正会去拉取的仓库域名, myhub/app 这样的地址会从 docker.io/myhub 拉取, 不做替换
+//   - 补上省略的 docker.io 和 library/, 同 normalizeImage
+//   - DefaultRegistryNamespaces: 只有一级路径的镜像补上仓库的默认命名空间, 比如 registry.corp.com/app 转换成 registry.corp.com/base/app
+func (p *Policy) canonicalImage(image string) string {
+	if i := strings.Index(image, "/"); i != -1 && isRegistryHost(image[:i]) {
+		if registry, ok := p.RegistryAliases[image[:i]]; ok {
+			image = registry + image[i:]
+		}
+	}
+	image = normalizeImage(image)
+	ref := parseImage(image)
+	if namespace, ok := p.DefaultRegistryNamespaces[ref.Registry]; ok && !strings.Contains(ref.Repository, "/") {
+		image = ref.Registry + "/" + namespace + "/" + strings.TrimPrefix(image, ref.Registry+"/")
+	}
+	return image
+}
+
+// normalizeRegistryPrefix 把配置中的仓库前缀转换成和 normalizeImage 一致的形式, 加载配置时调用
+//   - 写了仓库域名的前缀 (比如 gcr.io, docker.io/library) 保持不变, 只把 index.docker.io 转换成 docker.io
+//   - 以 / 结尾的前缀表示 docker.io 中的一个命名空间, 比如 library/ 转换成 docker.io/library
+//   - 只有一级的前缀是 docker.io 中的用户命名空间, 比如 haozi4263 转换成 docker.io/haozi4263
+//   - 其它的按镜像名规范化, 比如 library/nginx 转换成 docker.io/library/nginx
+func normalizeRegistryPrefix(prefix string) string {
+	if prefix == "" || prefix == allowAllRegistries {
+		return prefix
+	}
+	namespace := strings.HasSuffix(prefix, "/")
+	prefix = strings.TrimSuffix(prefix, "/")
+	host := prefix
+	if i := strings.Index(prefix, "/"); i != -1 {
+		host = prefix[:i]
+	}
+	switch {
+	case isRegistryHost(host):
+		if host == "index.docker.io" {
+			return "docker.io" + prefix[len(host):]
+		}
+		return prefix
+	case namespace || host == prefix:
+		return "docker.io/" + prefix
+	default:
+		return normalizeImage(prefix)
+	}
+}
+
+// normalizeRegistryPrefixes 规范化一组仓库前缀, 返回新的切片
+// 只有一级的前缀同时匹配同名的用户命名空间和官方镜像, 比如 nginx 转换成 docker.io/nginx 和 docker.io/library/nginx,
+// 和直接按前缀匹配镜像地址时 haozi4263/app 和 nginx:1.21 都能匹配的行为一致
+func normalizeRegistryPrefixes(prefixes []string) []string {
+	if prefixes == nil {
+		return nil
+	}
+	normalized := make([]string, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		normalized = append(normalized, normalizeRegistryPrefix(prefix))
+		if !strings.ContainsAny(prefix, "/.:") && prefix != "localhost" && prefix != allowAllRegistries && prefix != "" {
+			normalized = append(normalized, "docker.io/library/"+prefix)
+		}
+	}
+	return normalized
+}
+
+// hasRegistryPrefix 判断规范化之后的镜像地址是否以 prefix 开头, 只在路径的边界处断开
+// 只有域名的前缀后面必须是 /, 带路径的前缀后面还可以是 tag 或 digest, 以 / 结尾的前缀本身就在边界处
+func hasRegistryPrefix(image, prefix string) bool {
+	if !strings.HasPrefix(image, prefix) {
+		return false
+	}
+	if len(image) == len(prefix) || strings.HasSuffix(prefix, "/") {
+		return true
+	}
+	next := image[len(prefix)]
+	if !strings.Contains(prefix, "/") {
+		return next == '/'
+	}
+	return next == '/' || next == ':' || next == '@'
+}
+
+// exceptedImage 判断镜像是否在例外列表中, 支持完整匹配和 path.Match 风格的通配符 (* 不匹配 /)
+func exceptedImage(image string, exceptions []string) bool {
+	for _, pattern := range exceptions {
+		if pattern == image {
+			return true
+		}
+		if ok, _ := path.Match(pattern, image); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// imageRegistry 返回镜像地址中的仓库域名, 没有写仓库的镜像默认来自 docker.io
+func imageRegistry(image string) string {
+	i := strings.Index(image, "/")
+	if i == -1 || !isRegistryHost(image[:i]) {
+		return "docker.io"
+	}
+	return image[:i]
+}
+
+// isRegistryHost 判断镜像地址的第一段是不是仓库域名, 和 docker 的规则一致: 包含 . 或 :, 或者是 localhost
+func isRegistryHost(host string) bool {
+	return strings.ContainsAny(host, ".:") || host == "localhost"
+}
+
+// imageRef 解析后的镜像地址
+type imageRef struct {
+	Registry   string // 仓库域名
+	Repository string // 镜像路径, docker.io 的官方镜像会补上 library/
+	Tag        string
+	Digest     string
+}
+
+// parseImage 用 docker/distribution/reference 把镜像地址拆分成仓库、路径、tag 和 digest, 规则和 docker 拉取镜像时一致
+// 不是合法的镜像地址时 (比如包含大写字母) 整个地址作为路径, kubelet 同样无法拉取这样的镜像
+func parseImage(image string) imageRef {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return imageRef{Registry: imageRegistry(image), Repository: image}
+	}
+	ref := imageRef{Registry: reference.Domain(named), Repository: reference.Path(named)}
+	if tagged, ok := named.(reference.Tagged); ok {
+		ref.Tag = tagged.Tag()
+	}
+	if digested, ok := named.(reference.Digested); ok {
+		ref.Digest = digested.Digest().String()
+	}
+	return ref
+}
+
+// normalizeImage 把镜像地址转换成完整的形式, 补上省略的 docker.io 和 library/, index.docker.io 转换成 docker.io
+// 不是合法的镜像地址时原样返回
+func normalizeImage(image string) string {
+	named, err := reference.ParseNormalizedNamed(image)
+	if err != nil {
+		return image
+	}
+	return named.String()
+}
+
+// nearestRegistry 按编辑距离找出白名单中和镜像仓库最接近的一项, 用于提示用户
+func nearestRegistry(image string, whitelist []string) string {
+	registry := imageRegistry(image)
+	nearest, best := "", -1
+	for _, reg := range whitelist {
+		if d := levenshtein(registry, reg); best == -1 || d < best {
+			nearest, best = reg, d
+		}
+	}
+	return nearest
+}
+
+// levenshtein 计算两个字符串的编辑距离
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
@@ -0,0 +1,237 @@
+package pkg
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestCanonicalImageAliases(t *testing.T) {
+	policy := &Policy{
+		RegistryAliases:           map[string]string{"myhub.local": "registry.corp.com", "myhub": "registry.corp.com"},
+		DefaultRegistryNamespaces: map[string]string{"registry.corp.com": "base"},
+		WhiteListRegistries:       []string{"registry.corp.com/"},
+	}
+	tests := []struct {
+		image       string
+		want        string
+		whitelisted bool
+	}{
+		{image: "myhub.local/team/app:1", want: "registry.corp.com/team/app:1", whitelisted: true},
+		{image: "myhub.local/app:1", want: "registry.corp.com/base/app:1", whitelisted: true},
+		// 第一段不是域名时 kubelet 从 docker.io 拉取, 别名不能生效, 否则可以绕过白名单
+		{image: "myhub/app:1", want: "docker.io/myhub/app:1"},
+		{image: "nginx", want: "docker.io/library/nginx"},
+		{image: "registry.corp.com/app@" + testDigest("app"), want: "registry.corp.com/base/app@" + testDigest("app"), whitelisted: true},
+	}
+	for _, tt := range tests {
+		got := policy.canonicalImage(tt.image)
+		if got != tt.want {
+			t.Errorf("canonicalImage(%q) = %q, want %q", tt.image, got, tt.want)
+		}
+		if ok := whitelisted(got, policy.WhiteListRegistries); ok != tt.whitelisted {
+			t.Errorf("whitelisted(%q) = %v, want %v", got, ok, tt.whitelisted)
+		}
+	}
+}
+
+func TestLoadConfigRejectsNonHostAlias(t *testing.T) {
+	if _, err := loadTestConfig(t, "base:\n  registryAliases:\n    myhub.local: registry.corp.com\n"); err != nil {
+		t.Errorf("LoadConfig rejected a host alias: %v", err)
+	}
+	if _, err := loadTestConfig(t, "base:\n  registryAliases:\n    myhub: registry.corp.com\n"); err == nil {
+		t.Error("LoadConfig accepted an alias that is pulled from docker.io")
+	}
+}
+
+func TestParseImage(t *testing.T) {
+	digest := testDigest("nginx")
+	tests := []struct {
+		image string
+		want  imageRef
+	}{
+		{"nginx", imageRef{Registry: "docker.io", Repository: "library/nginx"}},
+		{"library/nginx:1.21", imageRef{Registry: "docker.io", Repository: "library/nginx", Tag: "1.21"}},
+		{"index.docker.io/team/app", imageRef{Registry: "docker.io", Repository: "team/app"}},
+		{"localhost:5000/app:v1@" + digest, imageRef{Registry: "localhost:5000", Repository: "app", Tag: "v1", Digest: digest}},
+		{"gcr.io/project/app@" + digest, imageRef{Registry: "gcr.io", Repository: "project/app", Digest: digest}},
+		// 不合法的地址整个作为路径, 不会被当成带 tag 的镜像
+		{"Registry.corp.com/App:1", imageRef{Registry: "Registry.corp.com", Repository: "Registry.corp.com/App:1"}},
+	}
+	for _, tt := range tests {
+		if got := parseImage(tt.image); got != tt.want {
+			t.Errorf("parseImage(%q) = %+v, want %+v", tt.image, got, tt.want)
+		}
+	}
+}
+
+func TestWhitelistedEquivalentReferences(t *testing.T) {
+	for _, entry := range []string{"docker.io/library", "docker.io/library/", "library/", "index.docker.io/library/"} {
+		config, err := loadTestConfig(t, "base:\n  whiteListRegistries: [\""+entry+"\"]\n")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, image := range []string{"nginx", "library/nginx", "docker.io/library/nginx:1.21"} {
+			if !whitelisted(image, config.Base.WhiteListRegistries) {
+				t.Errorf("%s is not allowed by whitelist entry %q (normalized to %v)", image, entry, config.Base.WhiteListRegistries)
+			}
+		}
+		if whitelisted("docker.io/team/app", config.Base.WhiteListRegistries) {
+			t.Errorf("docker.io/team/app is allowed by whitelist entry %q", entry)
+		}
+	}
+}
+
+func TestLoadConfigNormalizesShortWhitelistEntries(t *testing.T) {
+	config, err := loadTestConfig(t, "base:\n  whiteListRegistries: [nginx, library/redis, gcr.io, index.docker.io]\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		image string
+		want  bool
+	}{
+		{"nginx:1.21", true},
+		{"docker.io/library/nginx", true},
+		{"redis", true},
+		{"gcr.io/project/app", true},
+		{"docker.io/team/app", true},
+		{"quay.io/team/app", false},
+	}
+	for _, tt := range tests {
+		if got := whitelisted(tt.image, config.Base.WhiteListRegistries); got != tt.want {
+			t.Errorf("whitelisted(%q, %v) = %v, want %v", tt.image, config.Base.WhiteListRegistries, got, tt.want)
+		}
+	}
+}
+
+func TestWhitelistEntryForms(t *testing.T) {
+	tests := []struct {
+		entry string
+		image string
+		want  bool
+	}{
+		// 只有一级的条目: docker.io 的用户命名空间, 以及同名的官方镜像
+		{entry: "haozi4263", image: "haozi4263/app:1", want: true},
+		{entry: "haozi4263", image: "docker.io/haozi4263/app:1", want: true},
+		{entry: "haozi4263", image: "haozi4263x/app:1"},
+		{entry: "nginx", image: "nginx:1.21", want: true},
+		{entry: "nginx", image: "nginx-evil:1.21"},
+		// docker.io 中的命名空间和镜像
+		{entry: "library/", image: "redis", want: true},
+		{entry: "haozi4263/", image: "haozi4263/app:1", want: true},
+		{entry: "library/redis", image: "redis:6", want: true},
+		{entry: "library/redis", image: "redis-evil:6"},
+		// 写了仓库域名的条目
+		{entry: "docker.io", image: "haozi4263/app:1", want: true},
+		{entry: "gcr.io", image: "gcr.io/project/app:1", want: true},
+		{entry: "gcr.io", image: "gcr.io.evil.com/x:1"},
+		{entry: "gcr.io", image: "gcr.iox/x:1"},
+		{entry: "quay.io/team", image: "quay.io/team/app:1", want: true},
+		{entry: "quay.io/team", image: "quay.io/teamx/app:1"},
+		{entry: "localhost:5000", image: "localhost:5000/app", want: true},
+	}
+	for _, tt := range tests {
+		config, err := loadTestConfig(t, "base:\n  whiteListRegistries: [\""+tt.entry+"\"]\n")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := whitelisted(tt.image, config.Base.WhiteListRegistries); got != tt.want {
+			t.Errorf("config entry %q (%v): whitelisted(%q) = %v, want %v", tt.entry, config.Base.WhiteListRegistries, tt.image, got, tt.want)
+		}
+		// 环境变量 WHITELIST_REGISTRIES 中的条目和配置文件中的一样规范化
+		var env Config
+		env.AddWhiteListRegistries([]string{tt.entry})
+		if got := whitelisted(tt.image, env.Base.WhiteListRegistries); got != tt.want {
+			t.Errorf("env entry %q (%v): whitelisted(%q) = %v, want %v", tt.entry, env.Base.WhiteListRegistries, tt.image, got, tt.want)
+		}
+	}
+}
+
+func TestDeployWhitelist(t *testing.T) {
+	// deploy/deploy.yaml 中的 WHITELIST_REGISTRIES
+	var config Config
+	config.AddWhiteListRegistries([]string{"docker.io", "gcr.io", "haozi4263"})
+	for _, image := range []string{"haozi4263/app:1", "nginx:1.21", "gcr.io/google-containers/pause:3.2"} {
+		if !whitelisted(image, config.Base.WhiteListRegistries) {
+			t.Errorf("%s is not allowed by the shipped whitelist %v", image, config.Base.WhiteListRegistries)
+		}
+	}
+	for _, image := range []string{"gcr.io.evil.com/x:1", "quay.io/app:1"} {
+		if whitelisted(image, config.Base.WhiteListRegistries) {
+			t.Errorf("%s is allowed by the shipped whitelist %v", image, config.Base.WhiteListRegistries)
+		}
+	}
+}
+
+func TestNearestRegistry(t *testing.T) {
+	whitelist := []string{"docker.io", "gcr.io", "registry.corp.com"}
+	tests := []struct {
+		image, want string
+	}{
+		{"gcr.oi/app:1", "gcr.io"},
+		{"registry.crop.com/team/app:1", "registry.corp.com"},
+		{"nginx:1.21", "docker.io"},
+		{"localhost:5000/app", "docker.io"},
+	}
+	for _, tt := range tests {
+		if got := nearestRegistry(tt.image, whitelist); got != tt.want {
+			t.Errorf("nearestRegistry(%q) = %q, want %q", tt.image, got, tt.want)
+		}
+	}
+}
+
+func TestExceptedImage(t *testing.T) {
+	exceptions := []string{"quay.io/vendor/agent:1.4", "quay.io/monitoring/*", "gcr.io/tools/kubectl:v1.20.[0-9]"}
+	tests := []struct {
+		image string
+		want  bool
+	}{
+		{"quay.io/vendor/agent:1.4", true},
+		{"quay.io/vendor/agent:1.5", false},
+		{"quay.io/monitoring/node-exporter:v1.1.2", true},
+		// * 不匹配 /
+		{"quay.io/monitoring/team/exporter:1", false},
+		{"gcr.io/tools/kubectl:v1.20.4", true},
+		{"gcr.io/tools/kubectl:v1.21.0", false},
+	}
+	for _, tt := range tests {
+		if got := exceptedImage(tt.image, exceptions); got != tt.want {
+			t.Errorf("exceptedImage(%q) = %v, want %v", tt.image, got, tt.want)
+		}
+	}
+}
+
+func TestCheckDockerHub(t *testing.T) {
+	tests := []struct {
+		name        string
+		image       string
+		policy      Policy
+		wantCode    int
+		wantMessage string
+	}{
+		{name: "short name", image: "nginx", policy: Policy{DenyDockerHub: true}, wantCode: http.StatusForbidden},
+		{name: "library path", image: "library/nginx:1.21", policy: Policy{DenyDockerHub: true}, wantCode: http.StatusForbidden},
+		{name: "full reference", image: "docker.io/library/nginx:1.21", policy: Policy{DenyDockerHub: true}, wantCode: http.StatusForbidden},
+		{name: "index alias", image: "index.docker.io/bitnami/redis:6", policy: Policy{DenyDockerHub: true}, wantCode: http.StatusForbidden},
+		{name: "mirror hint", image: "nginx", policy: Policy{DenyDockerHub: true, DockerHubMirror: "mirror.corp.com/dockerhub"}, wantCode: http.StatusForbidden,
+			wantMessage: "Use the mirror mirror.corp.com/dockerhub instead."},
+		{name: "other registry", image: "registry.corp.com/nginx:1.21", policy: Policy{DenyDockerHub: true}},
+		{name: "registry named like docker.io", image: "docker.io.corp.com/nginx", policy: Policy{DenyDockerHub: true}},
+		{name: "not configured", image: "nginx"},
+	}
+	for _, tt := range tests {
+		d := checkDockerHub(context.Background(), &tt.policy, imagePod(tt.image))
+		if code := denialCode(d); code != tt.wantCode {
+			t.Errorf("%s: got code %d, want %d", tt.name, code, tt.wantCode)
+			continue
+		}
+		if d != nil && !strings.HasSuffix(d.message, tt.wantMessage) {
+			t.Errorf("%s: got message %q, want it to end with %q", tt.name, d.message, tt.wantMessage)
+		}
+		if d != nil && d.auditAnnotations["rejected-image"] != tt.image {
+			t.Errorf("%s: got audit annotations %v", tt.name, d.auditAnnotations)
+		}
+	}
+}
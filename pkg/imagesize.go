@@ -0,0 +1,76 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog"
+)
+
+// checkImageSize 拒绝压缩后超过 MaxImageSize 的镜像, 避免节点拉取镜像太慢
+func (s *WebhookServer) checkImageSize(ctx context.Context, policy *Policy, pod *corev1.Pod) *denial {
+	if policy.MaxImageSize <= 0 || s.Inspector == nil {
+		return nil
+	}
+	for _, container := range podContainers(pod) {
+		info, err := s.Inspector.inspectImage(ctx, container.Image)
+		if err != nil {
+			if d := inspectFailed(policy, container.Image, err); d != nil {
+				return d
+			}
+			continue
+		}
+		if info.Size > policy.MaxImageSize {
+			return &denial{
+				code: http.StatusForbidden,
+				message: fmt.Sprintf("%s image is %d bytes, larger than the allowed %d bytes.",
+					container.Image, info.Size, policy.MaxImageSize),
+			}
+		}
+	}
+	return nil
+}
+
+// checkImageAge 拒绝构建时间早于 MaxImageAge 的镜像, 避免部署长期没有更新的镜像
+// 镜像 config 中没有记录构建时间的镜像无法判断, 直接放行
+func (s *WebhookServer) checkImageAge(ctx context.Context, policy *Policy, pod *corev1.Pod) *denial {
+	if policy.MaxImageAge.Duration <= 0 || s.Inspector == nil {
+		return nil
+	}
+	for _, container := range podContainers(pod) {
+		info, err := s.Inspector.inspectImage(ctx, container.Image)
+		if err != nil {
+			if d := inspectFailed(policy, container.Image, err); d != nil {
+				return d
+			}
+			continue
+		}
+		if info.Created.IsZero() {
+			klog.Warningf("%s image has no creation time, skip the image age check", container.Image)
+			continue
+		}
+		if age := s.now().Sub(info.Created); age > policy.MaxImageAge.Duration {
+			return &denial{
+				code: http.StatusForbidden,
+				message: fmt.Sprintf("%s image was built at %s, older than the allowed %s! Please rebuild the image.",
+					container.Image, info.Created.Format(time.RFC3339), policy.MaxImageAge.Duration),
+			}
+		}
+	}
+	return nil
+}
+
+// inspectFailed 处理查询镜像元数据失败的情况, 默认放行, 配置了 InspectFailClosed 时拒绝
+func inspectFailed(policy *Policy, image string, err error) *denial {
+	klog.Errorf("Can't inspect image %s: %v", image, err)
+	if !policy.InspectFailClosed {
+		return nil
+	}
+	return &denial{
+		code:    http.StatusInternalServerError,
+		message: fmt.Sprintf("can't inspect image %s: %v", image, err),
+	}
+}
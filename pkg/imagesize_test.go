@@ -0,0 +1,75 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCheckImageSize(t *testing.T) {
+	small, large := "registry.corp.com/small:1", "registry.corp.com/large:1"
+	inspector := &fakeInspector{infos: map[string]*ImageInfo{
+		testDigest(small): {Size: 100 << 20},
+		testDigest(large): {Size: 2 << 30},
+	}}
+	tests := []struct {
+		name     string
+		image    string
+		policy   Policy
+		err      error
+		wantCode int
+	}{
+		{name: "small", image: small},
+		{name: "large", image: large, wantCode: http.StatusForbidden},
+		{name: "exactly the limit", image: small, policy: Policy{MaxImageSize: 100 << 20}},
+		{name: "inspect error fails open", image: large, err: errors.New("registry unavailable")},
+		{name: "inspect error fails closed", image: large, policy: Policy{InspectFailClosed: true}, err: errors.New("registry unavailable"), wantCode: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		inspector.err = tt.err
+		s := &WebhookServer{Inspector: NewCachedInspector(inspector)}
+		policy := tt.policy
+		if policy.MaxImageSize == 0 {
+			policy.MaxImageSize = 1 << 30
+		}
+		if code := denialCode(s.checkImageSize(context.Background(), &policy, imagePod(tt.image))); code != tt.wantCode {
+			t.Errorf("%s: got code %d, want %d", tt.name, code, tt.wantCode)
+		}
+	}
+}
+
+func TestCheckImageAge(t *testing.T) {
+	now := time.Date(2026, 6, 1, 0, 0, 0, 0, time.UTC)
+	fresh, stale, unknown := "registry.corp.com/fresh:1", "registry.corp.com/stale:1", "registry.corp.com/unknown:1"
+	inspector := &fakeInspector{infos: map[string]*ImageInfo{
+		testDigest(fresh): {Created: now.Add(-24 * time.Hour)},
+		testDigest(stale): {Created: now.Add(-100 * 24 * time.Hour)},
+	}}
+	maxAge := metav1.Duration{Duration: 90 * 24 * time.Hour}
+	tests := []struct {
+		name     string
+		image    string
+		policy   Policy
+		err      error
+		wantCode int
+	}{
+		{name: "fresh", image: fresh, policy: Policy{MaxImageAge: maxAge}},
+		{name: "stale", image: stale, policy: Policy{MaxImageAge: maxAge}, wantCode: http.StatusForbidden},
+		{name: "no creation time", image: unknown, policy: Policy{MaxImageAge: maxAge}},
+		{name: "inspect error fails open", image: stale, policy: Policy{MaxImageAge: maxAge}, err: errors.New("registry unavailable")},
+		{name: "inspect error fails closed", image: fresh, policy: Policy{MaxImageAge: maxAge, InspectFailClosed: true}, err: errors.New("registry unavailable"), wantCode: http.StatusInternalServerError},
+		{name: "not configured", image: stale},
+	}
+	discardLogs(t)
+	for _, tt := range tests {
+		inspector.err = tt.err
+		s := &WebhookServer{Inspector: NewCachedInspector(inspector), Clock: func() time.Time { return now }}
+		if code := denialCode(s.checkImageAge(context.Background(), &tt.policy, imagePod(tt.image))); code != tt.wantCode {
+			t.Errorf("%s: got code %d, want %d", tt.name, code, tt.wantCode)
+		}
+	}
+}
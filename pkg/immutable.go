@@ -0,0 +1,45 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	admissionV1 "k8s.io/api/admission/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// 修改镜像时需要带上的审批注解, 值为 "true" 时允许修改
+const imageChangeApprovedAnnotation = "admission-registry/image-change-approved"
+
+// checkImmutableImages 锁定的命名空间中, UPDATE 时不允许修改容器的镜像, 除非带有审批注解
+func checkImmutableImages(_ context.Context, policy *Policy, req *admissionV1.AdmissionRequest, obj runtime.Object) *denial {
+	if !policy.LockImages || req.Operation != admissionV1.Update || len(req.OldObject.Raw) == 0 {
+		return nil
+	}
+	_, oldPod, err := decodeOldObject(req)
+	if err != nil {
+		return &denial{
+			code:    http.StatusBadRequest,
+			message: fmt.Sprintf("can't decode old object: %v", err),
+		}
+	}
+	pod := objectPod(obj)
+	if oldPod == nil || pod == nil || pod.Annotations[imageChangeApprovedAnnotation] == "true" {
+		return nil
+	}
+	oldImages := make(map[string]string)
+	for _, container := range podContainers(oldPod) {
+		oldImages[container.Name] = container.Image
+	}
+	for _, container := range podContainers(pod) {
+		if old, ok := oldImages[container.Name]; ok && old != container.Image {
+			return &denial{
+				code: http.StatusForbidden,
+				message: fmt.Sprintf("image of container %s can't be changed from %s to %s in namespace %s without the %s annotation.",
+					container.Name, old, container.Image, req.Namespace, imageChangeApprovedAnnotation),
+			}
+		}
+	}
+	return nil
+}
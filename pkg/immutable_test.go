@@ -0,0 +1,50 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	admissionV1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestCheckImmutableImages(t *testing.T) {
+	old := testPod("web", "registry.corp.com/app:1")
+	oldRaw, err := json.Marshal(old)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changed := testPod("web", "registry.corp.com/app:2")
+	approved := testPod("web", "registry.corp.com/app:2")
+	approved.Annotations = map[string]string{imageChangeApprovedAnnotation: "true"}
+	renamed := testPod("web", "registry.corp.com/app:2")
+	renamed.Spec.Containers[0].Name = "server"
+	tests := []struct {
+		name      string
+		operation admissionV1.Operation
+		old       []byte
+		obj       runtime.Object
+		wantCode  int
+	}{
+		{name: "same image", operation: admissionV1.Update, old: oldRaw, obj: &old},
+		{name: "changed image", operation: admissionV1.Update, old: oldRaw, obj: &changed, wantCode: http.StatusForbidden},
+		{name: "approved change", operation: admissionV1.Update, old: oldRaw, obj: &approved},
+		{name: "new container", operation: admissionV1.Update, old: oldRaw, obj: &renamed},
+		{name: "create", operation: admissionV1.Create, obj: &changed},
+		{name: "undecodable old object", operation: admissionV1.Update, old: []byte(`{"spec": {"containers": "app"}}`), obj: &changed, wantCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		req := &admissionV1.AdmissionRequest{
+			Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+			Namespace: "team",
+			Operation: tt.operation,
+			OldObject: runtime.RawExtension{Raw: tt.old},
+		}
+		if code := denialCode(checkImmutableImages(context.Background(), &Policy{LockImages: true}, req, tt.obj)); code != tt.wantCode {
+			t.Errorf("%s: got code %d, want %d", tt.name, code, tt.wantCode)
+		}
+	}
+}
@@ -0,0 +1,174 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	admissionV1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog"
+)
+
+// 评估策略影响时每页列出的 pod 数量, 整个评估最多花费的时间, 以及两个 pod 之间的间隔
+// 评估时部分检查会查询 api-server, 限制评估的速度, 避免重新加载配置时给 api-server 带来一波压力
+const (
+	impactPageSize      = 500
+	impactReportTimeout = 10 * time.Minute
+	impactPodInterval   = 10 * time.Millisecond
+)
+
+// impactSkippedChecks 评估影响时不执行的检查
+// 这些检查要查询镜像仓库, 扫描服务或者 attestation, 对每个正在运行的 pod 都查一次代价太大;
+// namespace-budget 允许时会把 pod 计入命名空间的用量, 评估已有的 pod 会让之后真正的请求被拒绝
+var impactSkippedChecks = []string{
+	"base-images", "floating-tags", "image-size", "image-age", "vulnerabilities",
+	"scan-freshness", "sbom", "locked-images", "namespace-budget",
+}
+
+var (
+	impactPods = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "admission_policy_impact_pods",
+		Help: "Number of running pods evaluated against the policy after the last config reload, by result (allowed or denied).",
+	}, []string{"result"})
+
+	impactDeniedPods = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "admission_policy_impact_denied_pods",
+		Help: "Number of running pods the policy after the last config reload would deny, by check.",
+	}, []string{"check"})
+
+	impactLastSuccess = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "admission_policy_impact_last_success_timestamp_seconds",
+		Help: "Unix time of the last policy impact report that completed.",
+	})
+)
+
+func init() {
+	prometheus.MustRegister(impactPods, impactDeniedPods, impactLastSuccess)
+}
+
+// ImpactReport 当前策略对集群中正在运行的 pod 的影响
+type ImpactReport struct {
+	PolicyVersion     string
+	Evaluated         int            // 评估的 pod 数量
+	Denied            int            // 重新创建时会被拒绝的 pod 数量
+	DeniedByCheck     map[string]int // 按检查统计会被拒绝的 pod 数量, 一个 pod 可能被多个检查拒绝
+	DeniedByNamespace map[string]int // 按命名空间统计会被拒绝的 pod 数量
+}
+
+// PolicyImpact 列出集群中所有正在运行的 pod, 按照当前的策略评估重新创建时是否会被拒绝
+// 只做评估, 不会影响正在运行的 pod, 已经结束的 pod 不计入, impactSkippedChecks 中的检查不执行
+func (s *WebhookServer) PolicyImpact(ctx context.Context) (*ImpactReport, error) {
+	report := &ImpactReport{
+		PolicyVersion:     s.policyVersion(),
+		DeniedByCheck:     make(map[string]int),
+		DeniedByNamespace: make(map[string]int),
+	}
+	opts := metav1.ListOptions{Limit: impactPageSize}
+	ticker := time.NewTicker(impactPodInterval)
+	defer ticker.Stop()
+	for {
+		pods, err := s.Client.CoreV1().Pods(metav1.NamespaceAll).List(ctx, opts)
+		if err != nil {
+			return nil, err
+		}
+		for i := range pods.Items {
+			pod := &pods.Items[i]
+			if pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed {
+				continue
+			}
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case <-ticker.C:
+			}
+			report.Evaluated++
+			denials := s.podDenials(ctx, pod)
+			if len(denials) == 0 {
+				continue
+			}
+			klog.V(2).Infof("Policy %s would deny running pod %s/%s: %v", report.PolicyVersion, pod.Namespace, pod.Name, denials)
+			report.Denied++
+			report.DeniedByNamespace[pod.Namespace]++
+			for _, check := range denials {
+				report.DeniedByCheck[check]++
+			}
+		}
+		if pods.Continue == "" {
+			return report, nil
+		}
+		opts.Continue = pods.Continue
+	}
+}
+
+// podDenials 把正在运行的 pod 当作一个新建的请求评估, 返回会拒绝它的检查
+func (s *WebhookServer) podDenials(ctx context.Context, pod *corev1.Pod) []string {
+	raw, err := json.Marshal(pod)
+	if err != nil {
+		klog.Errorf("Can't encode pod %s/%s: %v", pod.Namespace, pod.Name, err)
+		return nil
+	}
+	req := &admissionV1.AdmissionRequest{
+		UID:       types.UID("impact-" + string(pod.UID)),
+		Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+		Resource:  metav1.GroupVersionResource{Version: "v1", Resource: "pods"},
+		Namespace: pod.Namespace,
+		Name:      pod.Name,
+		Operation: admissionV1.Create,
+	}
+	req.Object.Raw = raw
+	policy := s.namespacePolicy(ctx, pod.Namespace)
+	policy.DisabledChecks = append(append([]string(nil), policy.DisabledChecks...), impactSkippedChecks...)
+	return s.checks().Denials(ctx, pod, &Request{AdmissionRequest: req, Object: pod, Policy: &policy, Time: s.now()})
+}
+
+// ReportPolicyImpact 重新加载配置之后在后台评估新策略的影响, 结果打印到日志并更新指标
+// 上一次评估还没有结束时跳过这一次; 评估失败时清空指标, 避免把旧策略的结果当成新策略的
+func (s *WebhookServer) ReportPolicyImpact() {
+	if s.Client == nil {
+		klog.Warning("No kubernetes client, skipping the policy impact report")
+		return
+	}
+	if !atomic.CompareAndSwapInt32(&s.impactRunning, 0, 1) {
+		klog.Warning("A policy impact report is still running, skipping this one")
+		return
+	}
+	defer atomic.StoreInt32(&s.impactRunning, 0)
+	ctx, cancel := context.WithTimeout(context.Background(), impactReportTimeout)
+	defer cancel()
+
+	report, err := s.PolicyImpact(ctx)
+	if err != nil {
+		klog.Errorf("Failed to compute the policy impact report: %v", err)
+		impactPods.Reset()
+		impactDeniedPods.Reset()
+		return
+	}
+	impactLastSuccess.Set(float64(s.now().Unix()))
+	impactPods.WithLabelValues("allowed").Set(float64(report.Evaluated - report.Denied))
+	impactPods.WithLabelValues("denied").Set(float64(report.Denied))
+	impactDeniedPods.Reset()
+	checks := make([]string, 0, len(report.DeniedByCheck))
+	for check, n := range report.DeniedByCheck {
+		impactDeniedPods.WithLabelValues(check).Set(float64(n))
+		checks = append(checks, check)
+	}
+	sort.Strings(checks)
+	klog.Infof("Policy impact of policyVersion=%s: %d of %d running pods would be denied", report.PolicyVersion, report.Denied, report.Evaluated)
+	for _, check := range checks {
+		klog.Infof("Policy impact: check %s would deny %d pods", check, report.DeniedByCheck[check])
+	}
+	namespaces := make([]string, 0, len(report.DeniedByNamespace))
+	for namespace := range report.DeniedByNamespace {
+		namespaces = append(namespaces, namespace)
+	}
+	sort.Strings(namespaces)
+	for _, namespace := range namespaces {
+		klog.Infof("Policy impact: %d pods in namespace %s would be denied", report.DeniedByNamespace[namespace], namespace)
+	}
+}
@@ -0,0 +1,110 @@
+package pkg
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func runningPod(namespace, name string, labels map[string]string, phase corev1.PodPhase) *corev1.Pod {
+	pod := requestPod(name, "100m", phase)
+	pod.Namespace = namespace
+	pod.Labels = labels
+	pod.Spec.Containers[0].Image = "docker.io/library/nginx:1.21"
+	return pod
+}
+
+const impactConfig = `
+policyVersion: v2
+base:
+  whiteListRegistries: [docker.io]
+  requiredLabels:
+    app: ".+"
+  namespaceCPUBudget: "1m"
+namespaces:
+  shadow:
+    shadowChecks: [required-labels]
+  later:
+    enforceAfter:
+      required-labels: "2100-01-01T00:00:00Z"
+`
+
+func TestPolicyImpactAfterReload(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		runningPod("team", "labelled", map[string]string{"app": "web"}, corev1.PodRunning),
+		runningPod("team", "unlabelled", nil, corev1.PodRunning),
+		runningPod("team", "pending", nil, corev1.PodPending),
+		runningPod("team", "finished", nil, corev1.PodSucceeded),
+		runningPod("shadow", "unlabelled", nil, corev1.PodRunning),
+		runningPod("later", "unlabelled", nil, corev1.PodRunning),
+	)
+	s := &WebhookServer{Client: client}
+	s.SetConfig(Config{PolicyVersion: "v1", Base: Policy{WhiteListRegistries: []string{"docker.io"}}})
+
+	report, err := s.PolicyImpact(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Evaluated != 5 || report.Denied != 0 {
+		t.Fatalf("before reload: evaluated %d, denied %d, want 5 and 0", report.Evaluated, report.Denied)
+	}
+
+	config, err := loadTestConfig(t, impactConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetConfig(*config)
+	report, err = s.PolicyImpact(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// shadow 和还没到 EnforceAfter 的检查不计入, namespace-budget 在评估时不执行
+	want := &ImpactReport{
+		PolicyVersion:     "v2",
+		Evaluated:         5,
+		Denied:            2,
+		DeniedByCheck:     map[string]int{"required-labels": 2},
+		DeniedByNamespace: map[string]int{"team": 2},
+	}
+	if !reflect.DeepEqual(report, want) {
+		t.Errorf("got %+v, want %+v", report, want)
+	}
+	if _, ok := s.usage["team"]; ok {
+		t.Error("impact report reserved namespace budget for running pods")
+	}
+}
+
+func TestReportPolicyImpactMetrics(t *testing.T) {
+	client := fake.NewSimpleClientset(runningPod("team", "unlabelled", nil, corev1.PodRunning))
+	s := &WebhookServer{Client: client}
+	config, err := loadTestConfig(t, impactConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.SetConfig(*config)
+	s.Clock = func() time.Time { return time.Unix(1000, 0) }
+
+	s.ReportPolicyImpact()
+	if got := testutil.ToFloat64(impactPods.WithLabelValues("denied")); got != 1 {
+		t.Errorf("denied pods gauge = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(impactLastSuccess); got != 1000 {
+		t.Errorf("last success = %v, want 1000", got)
+	}
+
+	// 评估失败时清空指标, 最近一次成功的时间不变
+	failRequests(client, "list", "pods")
+	s.Clock = func() time.Time { return time.Unix(2000, 0) }
+	s.ReportPolicyImpact()
+	if n := testutil.CollectAndCount(impactPods); n != 0 {
+		t.Errorf("impact gauges kept %d series after a failed report", n)
+	}
+	if got := testutil.ToFloat64(impactLastSuccess); got != 1000 {
+		t.Errorf("last success = %v after a failed report, want 1000", got)
+	}
+}
@@ -0,0 +1,62 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	admissionV1 "k8s.io/api/admission/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// checkIngress 要求 Ingress 的域名都在 AllowedIngressDomains 下, 配置了 RequireIngressTLS 时每个域名都必须配置 TLS
+func checkIngress(_ context.Context, policy *Policy, _ *admissionV1.AdmissionRequest, obj runtime.Object) *denial {
+	ingress, ok := obj.(*networkingv1.Ingress)
+	if !ok || (!policy.RequireIngressTLS && len(policy.AllowedIngressDomains) == 0) {
+		return nil
+	}
+	tlsHosts := make(map[string]bool)
+	for _, tls := range ingress.Spec.TLS {
+		for _, host := range tls.Hosts {
+			tlsHosts[host] = true
+		}
+	}
+	if policy.RequireIngressTLS && len(ingress.Spec.TLS) == 0 {
+		return &denial{
+			code:    http.StatusForbidden,
+			message: fmt.Sprintf("ingress %s has no TLS configuration! Plaintext ingresses are not allowed in namespace %s.", ingress.Name, ingress.Namespace),
+		}
+	}
+	for _, rule := range ingress.Spec.Rules {
+		if rule.Host == "" {
+			continue
+		}
+		if len(policy.AllowedIngressDomains) > 0 && !allowedDomain(rule.Host, policy.AllowedIngressDomains) {
+			return &denial{
+				code: http.StatusForbidden,
+				message: fmt.Sprintf("ingress %s uses host %s! Only hosts under %v are allowed.",
+					ingress.Name, rule.Host, policy.AllowedIngressDomains),
+			}
+		}
+		if policy.RequireIngressTLS && !tlsHosts[rule.Host] {
+			return &denial{
+				code:    http.StatusForbidden,
+				message: fmt.Sprintf("host %s of ingress %s is not covered by its TLS configuration.", rule.Host, ingress.Name),
+			}
+		}
+	}
+	return nil
+}
+
+// allowedDomain 判断 host 是否等于或者属于某个域名, example.com 允许 example.com 和 a.example.com, 不允许 badexample.com
+func allowedDomain(host string, domains []string) bool {
+	for _, domain := range domains {
+		domain = strings.TrimPrefix(domain, ".")
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return true
+		}
+	}
+	return false
+}
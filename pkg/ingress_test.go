@@ -0,0 +1,47 @@
+package pkg
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ingress 构造使用这些域名的 Ingress, tlsHosts 为 nil 时不配置 TLS
+func ingress(hosts []string, tlsHosts []string) *networkingv1.Ingress {
+	ing := &networkingv1.Ingress{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "team"}}
+	for _, host := range hosts {
+		ing.Spec.Rules = append(ing.Spec.Rules, networkingv1.IngressRule{Host: host})
+	}
+	if tlsHosts != nil {
+		ing.Spec.TLS = []networkingv1.IngressTLS{{Hosts: tlsHosts, SecretName: "web-tls"}}
+	}
+	return ing
+}
+
+func TestCheckIngress(t *testing.T) {
+	domains := []string{"corp.com", ".apps.example.com"}
+	tests := []struct {
+		name     string
+		ingress  *networkingv1.Ingress
+		policy   Policy
+		wantCode int
+	}{
+		{name: "allowed domain with TLS", ingress: ingress([]string{"web.corp.com"}, []string{"web.corp.com"}), policy: Policy{RequireIngressTLS: true, AllowedIngressDomains: domains}},
+		{name: "domain itself", ingress: ingress([]string{"corp.com"}, nil), policy: Policy{AllowedIngressDomains: domains}},
+		{name: "leading dot", ingress: ingress([]string{"web.apps.example.com"}, nil), policy: Policy{AllowedIngressDomains: domains}},
+		{name: "domain suffix without a dot", ingress: ingress([]string{"badcorp.com"}, nil), policy: Policy{AllowedIngressDomains: domains}, wantCode: http.StatusForbidden},
+		{name: "other domain", ingress: ingress([]string{"web.example.com"}, nil), policy: Policy{AllowedIngressDomains: domains}, wantCode: http.StatusForbidden},
+		{name: "no TLS", ingress: ingress([]string{"web.corp.com"}, nil), policy: Policy{RequireIngressTLS: true}, wantCode: http.StatusForbidden},
+		{name: "host not covered by TLS", ingress: ingress([]string{"web.corp.com", "api.corp.com"}, []string{"web.corp.com"}), policy: Policy{RequireIngressTLS: true}, wantCode: http.StatusForbidden},
+		{name: "rule without a host", ingress: ingress([]string{""}, nil), policy: Policy{AllowedIngressDomains: domains}},
+		{name: "not configured", ingress: ingress([]string{"web.example.com"}, nil)},
+	}
+	for _, tt := range tests {
+		if code := denialCode(checkIngress(context.Background(), &tt.policy, nil, tt.ingress)); code != tt.wantCode {
+			t.Errorf("%s: got code %d, want %d", tt.name, code, tt.wantCode)
+		}
+	}
+}
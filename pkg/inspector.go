@@ -0,0 +1,324 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 访问镜像仓库的默认超时时间
+const defaultInspectTimeout = 5 * time.Second
+
+// ImageInfo 镜像的元数据
+type ImageInfo struct {
+	Digest  string            // manifest 的 digest
+	Labels  map[string]string // 镜像 config 中的 label
+	Size    int64             // 压缩后的总大小, config 加上所有 layer
+	Created time.Time         // 镜像的构建时间, 来自镜像 config, 没有记录时为零值
+}
+
+// ImageInspector 用于查询镜像的元数据, 测试时可以替换成假的实现
+type ImageInspector interface {
+	// Digest 解析镜像地址对应的 digest
+	Digest(ctx context.Context, image string) (string, error)
+	// Inspect 获取镜像的元数据, digest 为 Digest 方法返回的值
+	Inspect(ctx context.Context, image, digest string) (*ImageInfo, error)
+}
+
+// TagLister 列出镜像仓库中的所有 tag, RegistryInspector 实现了这个接口
+type TagLister interface {
+	Tags(ctx context.Context, image string) ([]string, error)
+}
+
+// errOffline OfflineInspector 返回的错误
+var errOffline = errors.New("registry lookups are disabled")
+
+// OfflineInspector 不访问镜像仓库的 ImageInspector, 所有查询都返回错误, 按照策略的 InspectFailClosed 处理
+// 用于回放请求, 结果只取决于请求和策略, 不会因为仓库中 tag 的变化而不同
+type OfflineInspector struct{}
+
+func (OfflineInspector) Digest(context.Context, string) (string, error) {
+	return "", errOffline
+}
+
+func (OfflineInspector) Inspect(context.Context, string, string) (*ImageInfo, error) {
+	return nil, errOffline
+}
+
+func (OfflineInspector) Tags(context.Context, string) ([]string, error) {
+	return nil, errOffline
+}
+
+// CachedInspector 按 digest 缓存镜像元数据, 同一个 digest 的内容是不可变的, 所以缓存不需要过期
+type CachedInspector struct {
+	Inspector ImageInspector
+	Timeout   time.Duration    // 单次查询的超时时间, 为 0 时使用默认值
+	Breaker   *CircuitBreakers // 按镜像仓库熔断, 为 nil 时不熔断
+
+	mu    sync.RWMutex
+	cache map[string]*ImageInfo
+}
+
+// NewCachedInspector 创建带缓存的 ImageInspector
+func NewCachedInspector(inspector ImageInspector) *CachedInspector {
+	return &CachedInspector{
+		Inspector: inspector,
+		cache:     make(map[string]*ImageInfo),
+	}
+}
+
+// inspectImage 解析镜像 digest 并获取元数据, 命中缓存时不会再请求 Inspect
+func (c *CachedInspector) inspectImage(ctx context.Context, image string) (*ImageInfo, error) {
+	ctx, span := startSpan(ctx, "inspect image")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, c.timeout())
+	defer cancel()
+
+	digest, err := c.digest(ctx, image)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.RLock()
+	info, ok := c.cache[digest]
+	c.mu.RUnlock()
+	if ok {
+		return info, nil
+	}
+
+	err = c.Breaker.For(imageRegistry(image)).Do(func() error {
+		info, err = c.Inspector.Inspect(ctx, image, digest)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.cache[digest] = info
+	c.mu.Unlock()
+	return info, nil
+}
+
+// digest 返回镜像的 digest, 镜像地址中已经带有 digest 时不需要查询仓库
+func (c *CachedInspector) digest(ctx context.Context, image string) (string, error) {
+	if digest := parseImage(image).Digest; digest != "" {
+		return digest, nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.timeout())
+	defer cancel()
+	var digest string
+	err := c.Breaker.For(imageRegistry(image)).Do(func() (err error) {
+		digest, err = c.Inspector.Digest(ctx, image)
+		return err
+	})
+	return digest, err
+}
+
+func (c *CachedInspector) timeout() time.Duration {
+	if c.Timeout == 0 {
+		return defaultInspectTimeout
+	}
+	return c.Timeout
+}
+
+// tags 列出镜像仓库中的所有 tag, tag 会随着发布变化, 所以不做缓存
+func (c *CachedInspector) tags(ctx context.Context, image string) ([]string, error) {
+	lister, ok := c.Inspector.(TagLister)
+	if !ok {
+		return nil, fmt.Errorf("image inspector can't list tags")
+	}
+	ctx, cancel := context.WithTimeout(ctx, c.timeout())
+	defer cancel()
+	var tags []string
+	err := c.Breaker.For(imageRegistry(image)).Do(func() (err error) {
+		tags, err = lister.Tags(ctx, image)
+		return err
+	})
+	return tags, err
+}
+
+// errRegistryNotFound 仓库中不存在请求的 manifest 或 blob
+var errRegistryNotFound = errors.New("not found")
+
+// RegistryInspector 通过 Docker Registry HTTP API V2 查询镜像元数据, 只支持匿名访问
+type RegistryInspector struct {
+	Client *http.Client
+}
+
+var manifestMediaTypes = []string{
+	"application/vnd.docker.distribution.manifest.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+}
+
+// Digest 通过 HEAD manifest 获取镜像的 digest
+func (r *RegistryInspector) Digest(ctx context.Context, image string) (string, error) {
+	ref := parseImage(image)
+	tag := ref.Tag
+	if tag == "" {
+		tag = "latest"
+	}
+	resp, err := r.do(ctx, http.MethodHead, ref, "manifests/"+tag, manifestMediaTypes)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+	digest := resp.Header.Get("Docker-Content-Digest")
+	if digest == "" {
+		return "", fmt.Errorf("registry returned no digest for %s", image)
+	}
+	return digest, nil
+}
+
+// Inspect 获取 manifest 以及其中引用的 config
+func (r *RegistryInspector) Inspect(ctx context.Context, image, digest string) (*ImageInfo, error) {
+	ref := parseImage(image)
+	type descriptor struct {
+		Digest string `json:"digest"`
+		Size   int64  `json:"size"`
+	}
+	var manifest struct {
+		Config descriptor   `json:"config"`
+		Layers []descriptor `json:"layers"`
+	}
+	if err := r.getJSON(ctx, ref, "manifests/"+digest, manifestMediaTypes, &manifest); err != nil {
+		return nil, err
+	}
+	var config struct {
+		Created time.Time `json:"created"`
+		Config  struct {
+			Labels map[string]string `json:"Labels"`
+		} `json:"config"`
+	}
+	if err := r.getJSON(ctx, ref, "blobs/"+manifest.Config.Digest, nil, &config); err != nil {
+		return nil, err
+	}
+	size := manifest.Config.Size
+	for _, layer := range manifest.Layers {
+		size += layer.Size
+	}
+	return &ImageInfo{
+		Digest:  digest,
+		Labels:  config.Config.Labels,
+		Size:    size,
+		Created: config.Created,
+	}, nil
+}
+
+// Tags 通过 tags/list 获取仓库中的所有 tag
+func (r *RegistryInspector) Tags(ctx context.Context, image string) ([]string, error) {
+	var list struct {
+		Tags []string `json:"tags"`
+	}
+	if err := r.getJSON(ctx, parseImage(image), "tags/list", nil, &list); err != nil {
+		return nil, err
+	}
+	return list.Tags, nil
+}
+
+func (r *RegistryInspector) getJSON(ctx context.Context, ref imageRef, path string, accept []string, v interface{}) error {
+	resp, err := r.do(ctx, http.MethodGet, ref, path, accept)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// do 发送请求, 如果仓库要求 Bearer 认证则先匿名获取 token 再重试
+func (r *RegistryInspector) do(ctx context.Context, method string, ref imageRef, path string, accept []string) (*http.Response, error) {
+	registry := ref.Registry
+	if registry == "docker.io" {
+		registry = "registry-1.docker.io"
+	}
+	url := fmt.Sprintf("https://%s/v2/%s/%s", registry, ref.Repository, path)
+	token := ""
+	for i := 0; i < 2; i++ {
+		req, err := http.NewRequest(method, url, nil)
+		if err != nil {
+			return nil, err
+		}
+		req = req.WithContext(ctx)
+		req.Header.Set("Accept", strings.Join(accept, ","))
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := r.client().Do(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode == http.StatusUnauthorized && token == "" {
+			resp.Body.Close()
+			token, err = r.token(ctx, resp.Header.Get("WWW-Authenticate"))
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if resp.StatusCode == http.StatusNotFound {
+			resp.Body.Close()
+			return nil, fmt.Errorf("registry returned %s for %s: %w", resp.Status, url, errRegistryNotFound)
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return nil, &statusError{Code: resp.StatusCode, Message: fmt.Sprintf("registry returned %s for %s", resp.Status, url)}
+		}
+		return resp, nil
+	}
+	return nil, fmt.Errorf("registry unauthorized for %s", url)
+}
+
+// token 按照 WWW-Authenticate 中的 realm/service/scope 匿名获取 token
+func (r *RegistryInspector) token(ctx context.Context, challenge string) (string, error) {
+	if !strings.HasPrefix(challenge, "Bearer ") {
+		return "", fmt.Errorf("unsupported registry auth challenge: %q", challenge)
+	}
+	params := make(map[string]string)
+	for _, part := range strings.Split(strings.TrimPrefix(challenge, "Bearer "), ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) == 2 {
+			params[kv[0]] = strings.Trim(kv[1], `"`)
+		}
+	}
+	req, err := http.NewRequest(http.MethodGet, params["realm"], nil)
+	if err != nil {
+		return "", err
+	}
+	q := req.URL.Query()
+	for _, k := range []string{"service", "scope"} {
+		if params[k] != "" {
+			q.Set(k, params[k])
+		}
+	}
+	req.URL.RawQuery = q.Encode()
+	resp, err := r.client().Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", &statusError{Code: resp.StatusCode, Message: fmt.Sprintf("registry token endpoint returned %s", resp.Status)}
+	}
+	var body struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+	if body.Token != "" {
+		return body.Token, nil
+	}
+	return body.AccessToken, nil
+}
+
+func (r *RegistryInspector) client() *http.Client {
+	if r.Client != nil {
+		return r.Client
+	}
+	return http.DefaultClient
+}
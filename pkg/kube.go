@@ -0,0 +1,54 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/klog"
+)
+
+// 查询集群中其它资源的默认超时时间
+const defaultLookupTimeout = 2 * time.Second
+
+// lookupContext 返回带超时的 context, 用于访问 api-server, 调用 cancel 时同时结束对应的 span
+func (s *WebhookServer) lookupContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	timeout := s.LookupTimeout
+	if timeout == 0 {
+		timeout = defaultLookupTimeout
+	}
+	ctx, span := startSpan(ctx, "cluster lookup")
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	return ctx, func() {
+		cancel()
+		span.End()
+	}
+}
+
+// lookupFailed 处理查询集群状态失败的情况, 默认放行, 配置了 LookupFailClosed 时拒绝
+func lookupFailed(policy *Policy, what string, err error) *denial {
+	klog.Errorf("Failed to look up %s: %v", what, err)
+	if !policy.LookupFailClosed {
+		return nil
+	}
+	return &denial{
+		code:    http.StatusInternalServerError,
+		message: fmt.Sprintf("failed to look up %s: %v", what, err),
+	}
+}
+
+// NewInClusterClient 使用 pod 的 ServiceAccount 创建访问 api-server 的客户端
+func NewInClusterClient() (kubernetes.Interface, error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		return nil, err
+	}
+	client, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	return client, nil
+}
@@ -0,0 +1,76 @@
+package pkg
+
+import (
+	"encoding/json"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// kubectl apply 保存的上一次配置, 是整个对象的副本, 检查不会用到
+const lastAppliedAnnotation = "kubectl.kubernetes.io/last-applied-configuration"
+
+// skipJSON 解析时直接丢弃对应的字段, 不会为它分配任何对象
+type skipJSON struct{}
+
+func (*skipJSON) UnmarshalJSON([]byte) error { return nil }
+
+// decodeLargeWorkload 只解析工作负载中检查需要的部分: metadata 和 spec
+// status 和 metadata.managedFields 不会被解析, last-applied-configuration 注解解析之后立即丢弃,
+// 这几部分在大对象中往往占了一大半, 解析成结构体之后占用的内存是原始 json 的几倍
+// 不是工作负载的对象返回 ok 为 false, 由调用方按照完整的对象解析
+func decodeLargeWorkload(kind, namespace string, raw []byte) (obj runtime.Object, pod *corev1.Pod, ok bool, err error) {
+	switch kind {
+	case "Deployment":
+		var deploy appsv1.Deployment
+		err = decodeMetaAndSpec(raw, &deploy.TypeMeta, &deploy.ObjectMeta, &deploy.Spec)
+		deploy.Namespace = namespace
+		obj = &deploy
+	case "StatefulSet":
+		var sts appsv1.StatefulSet
+		err = decodeMetaAndSpec(raw, &sts.TypeMeta, &sts.ObjectMeta, &sts.Spec)
+		sts.Namespace = namespace
+		obj = &sts
+	case "CronJob":
+		var cronJob batchv1beta1.CronJob
+		err = decodeMetaAndSpec(raw, &cronJob.TypeMeta, &cronJob.ObjectMeta, &cronJob.Spec)
+		cronJob.Namespace = namespace
+		obj = &cronJob
+	case "Job":
+		var job batchv1.Job
+		err = decodeMetaAndSpec(raw, &job.TypeMeta, &job.ObjectMeta, &job.Spec)
+		job.Namespace = namespace
+		obj = &job
+	default:
+		return nil, nil, false, nil
+	}
+	if err != nil {
+		return nil, nil, true, decodeError(kind, raw, err)
+	}
+	return obj, objectPod(obj), true, nil
+}
+
+// decodeMetaAndSpec 把 raw 中的 metadata 和 spec 直接解析到 meta 和 spec 指向的结构体中
+func decodeMetaAndSpec(raw []byte, typeMeta *metav1.TypeMeta, meta *metav1.ObjectMeta, spec interface{}) error {
+	var obj struct {
+		*metav1.TypeMeta `json:",inline"`
+		Metadata         struct {
+			*metav1.ObjectMeta `json:",inline"`
+			ManagedFields      skipJSON `json:"managedFields"`
+		} `json:"metadata"`
+		Spec   interface{} `json:"spec"`
+		Status skipJSON    `json:"status"`
+	}
+	obj.TypeMeta = typeMeta
+	obj.Metadata.ObjectMeta = meta
+	obj.Spec = spec
+	if err := json.Unmarshal(raw, &obj); err != nil {
+		return err
+	}
+	delete(meta.Annotations, lastAppliedAnnotation)
+	return nil
+}
@@ -0,0 +1,106 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// largeDeployment 构造带有大量 env, managedFields, last-applied-configuration 和 status 的 Deployment
+func largeDeployment(tb testing.TB, containers, envs int) []byte {
+	var spec corev1.PodSpec
+	for i := 0; i < containers; i++ {
+		container := corev1.Container{Name: fmt.Sprintf("c%d", i), Image: "docker.io/library/nginx:1.21"}
+		for j := 0; j < envs; j++ {
+			container.Env = append(container.Env, corev1.EnvVar{Name: fmt.Sprintf("ENV_%d", j), Value: strings.Repeat("v", 64)})
+		}
+		spec.Containers = append(spec.Containers, container)
+	}
+	deploy := appsv1.Deployment{
+		TypeMeta: metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "large",
+			Labels: map[string]string{"app": "large"},
+		},
+		Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{
+			ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "large"}},
+			Spec:       spec,
+		}},
+		Status: appsv1.DeploymentStatus{Replicas: 3, Conditions: []appsv1.DeploymentCondition{{Type: appsv1.DeploymentAvailable, Message: strings.Repeat("m", 1024)}}},
+	}
+	lastApplied, err := json.Marshal(deploy)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	deploy.Annotations = map[string]string{lastAppliedAnnotation: string(lastApplied)}
+	// managedFields 中每个容器的每个 env 都有一项, 和 spec 差不多大
+	var fields []string
+	for i := 0; i < containers; i++ {
+		for j := 0; j < envs; j++ {
+			fields = append(fields, fmt.Sprintf(`"k:{\"name\":\"c%d\"}/k:{\"name\":\"ENV_%d\"}":{".":{},"f:name":{},"f:value":{}}`, i, j))
+		}
+	}
+	for _, manager := range []string{"kubectl-client-side-apply", "kube-controller-manager", "helm"} {
+		deploy.ManagedFields = append(deploy.ManagedFields, metav1.ManagedFieldsEntry{
+			Manager:    manager,
+			Operation:  metav1.ManagedFieldsOperationUpdate,
+			FieldsV1:   &metav1.FieldsV1{Raw: []byte(`{"f:spec":{"f:template":{"f:spec":{"f:containers":{` + strings.Join(fields, ",") + `}}}}}`)},
+			APIVersion: "apps/v1",
+		})
+	}
+	raw, err := json.Marshal(deploy)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return raw
+}
+
+func TestDecodeLargeWorkloadMatchesFullDecode(t *testing.T) {
+	raw := largeDeployment(t, 3, 10)
+	_, fullPod, err := decodeRaw("Deployment", "team", raw, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, pod, err := decodeRaw("Deployment", "team", raw, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(pod.Spec, fullPod.Spec) || !reflect.DeepEqual(pod.Labels, fullPod.Labels) {
+		t.Error("targeted extraction produced a different pod template than the full decode")
+	}
+	deploy := obj.(*appsv1.Deployment)
+	if deploy.Namespace != "team" || deploy.Name != "large" {
+		t.Errorf("got %s/%s, want team/large", deploy.Namespace, deploy.Name)
+	}
+	if len(deploy.ManagedFields) != 0 || deploy.Annotations[lastAppliedAnnotation] != "" || deploy.Status.Replicas != 0 {
+		t.Error("targeted extraction kept managedFields, last-applied-configuration or status")
+	}
+}
+
+// BenchmarkDecodeLargeWorkload 比较完整解析和只解析 metadata/spec 时的内存分配
+func BenchmarkDecodeLargeWorkload(b *testing.B) {
+	raw := largeDeployment(b, 20, 50)
+	for _, tt := range []struct {
+		name            string
+		largeObjectSize int
+	}{
+		{"full", 0},
+		{"targeted", 1},
+	} {
+		b.Run(tt.name, func(b *testing.B) {
+			b.ReportAllocs()
+			b.SetBytes(int64(len(raw)))
+			for i := 0; i < b.N; i++ {
+				if _, _, err := decodeRaw("Deployment", "team", raw, tt.largeObjectSize); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,72 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	admissionV1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// 命名空间 LimitRange 查询结果的缓存时间, 和资源用量一样, 短时间内连续创建的 pod 共用一次查询
+const limitRangeTTL = 10 * time.Second
+
+type limitRangeDefaults struct {
+	found   bool
+	expires time.Time
+}
+
+// checkLimitRange 创建 pod 时要求命名空间中有为容器提供默认 requests/limits 的 LimitRange
+// 这样没有声明资源的容器也会带上默认值, 而不是不受限制地使用节点资源
+func (s *WebhookServer) checkLimitRange(ctx context.Context, policy *Policy, req *admissionV1.AdmissionRequest, obj runtime.Object) *denial {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok || policy.RequireLimitRange == "" || req.Operation != admissionV1.Create || s.Client == nil {
+		return nil
+	}
+	found, err := s.hasLimitRangeDefaults(ctx, pod.Namespace)
+	if err != nil {
+		return lookupFailed(policy, "LimitRanges in namespace "+pod.Namespace, err)
+	}
+	if found {
+		return nil
+	}
+	return policy.RequireLimitRange.violation(http.StatusForbidden,
+		fmt.Sprintf("namespace %s has no LimitRange with default container requests or limits! Please create one before deploying pods.", pod.Namespace))
+}
+
+// hasLimitRangeDefaults 判断命名空间中是否有设置了容器默认 requests 或 limits 的 LimitRange, 结果会缓存 limitRangeTTL
+func (s *WebhookServer) hasLimitRangeDefaults(ctx context.Context, namespace string) (bool, error) {
+	s.limitRangeMu.Lock()
+	cached, ok := s.limitRanges[namespace]
+	s.limitRangeMu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.found, nil
+	}
+
+	ctx, cancel := s.lookupContext(ctx)
+	defer cancel()
+	ranges, err := s.Client.CoreV1().LimitRanges(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return false, err
+	}
+	found := false
+	for _, lr := range ranges.Items {
+		for _, item := range lr.Spec.Limits {
+			if item.Type == corev1.LimitTypeContainer && (len(item.Default) > 0 || len(item.DefaultRequest) > 0) {
+				found = true
+			}
+		}
+	}
+
+	s.limitRangeMu.Lock()
+	if s.limitRanges == nil {
+		s.limitRanges = make(map[string]limitRangeDefaults)
+	}
+	s.limitRanges[namespace] = limitRangeDefaults{found: found, expires: time.Now().Add(limitRangeTTL)}
+	s.limitRangeMu.Unlock()
+	return found, nil
+}
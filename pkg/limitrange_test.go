@@ -0,0 +1,87 @@
+package pkg
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	admissionV1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func limitRange(namespace string, item corev1.LimitRangeItem) *corev1.LimitRange {
+	return &corev1.LimitRange{
+		ObjectMeta: metav1.ObjectMeta{Name: "defaults", Namespace: namespace},
+		Spec:       corev1.LimitRangeSpec{Limits: []corev1.LimitRangeItem{item}},
+	}
+}
+
+func TestCheckLimitRange(t *testing.T) {
+	cpu := corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")}
+	ranges := []runtime.Object{
+		limitRange("limits", corev1.LimitRangeItem{Type: corev1.LimitTypeContainer, Default: cpu}),
+		limitRange("requests", corev1.LimitRangeItem{Type: corev1.LimitTypeContainer, DefaultRequest: cpu}),
+		limitRange("max-only", corev1.LimitRangeItem{Type: corev1.LimitTypeContainer, Max: cpu}),
+		limitRange("pod-level", corev1.LimitRangeItem{Type: corev1.LimitTypePod, Max: cpu}),
+	}
+	tests := []struct {
+		name        string
+		namespace   string
+		operation   admissionV1.Operation
+		policy      Policy
+		failLists   bool
+		wantCode    int
+		wantWarning bool
+	}{
+		{name: "default limits", namespace: "limits"},
+		{name: "default requests", namespace: "requests"},
+		{name: "no LimitRange", namespace: "empty", wantCode: http.StatusForbidden},
+		{name: "LimitRange without defaults", namespace: "max-only", wantCode: http.StatusForbidden},
+		{name: "pod LimitRange", namespace: "pod-level", wantCode: http.StatusForbidden},
+		{name: "warn", namespace: "empty", policy: Policy{RequireLimitRange: ActionWarn}, wantCode: http.StatusForbidden, wantWarning: true},
+		{name: "update is not checked", namespace: "empty", operation: admissionV1.Update},
+		{name: "lookup fails open", namespace: "limits", failLists: true},
+		{name: "lookup fails closed", namespace: "limits", policy: Policy{LookupFailClosed: true}, failLists: true, wantCode: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset(ranges...)
+			if tt.failLists {
+				failRequests(client, "list", "limitranges")
+			}
+			policy := tt.policy
+			if policy.RequireLimitRange == "" {
+				policy.RequireLimitRange = ActionDeny
+			}
+			s := &WebhookServer{Client: client}
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: tt.namespace}}
+			assertDenial(t, s.checkLimitRange(context.Background(), &policy, admissionRequest(tt.operation), pod), tt.wantCode, tt.wantWarning)
+		})
+	}
+}
+
+func TestCheckLimitRangeCachesLookups(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	s := &WebhookServer{Client: client}
+	policy := &Policy{RequireLimitRange: ActionDeny}
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "team"}}
+	req := admissionRequest("")
+	for i := 0; i < 3; i++ {
+		if d := s.checkLimitRange(context.Background(), policy, req, pod); denialCode(d) != http.StatusForbidden {
+			t.Fatalf("request %d: got %v, want a denial", i, d)
+		}
+	}
+	// 缓存过期之前, 新创建的 LimitRange 不会生效, 连续创建的 pod 只查询一次
+	cpu := corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")}
+	client.Tracker().Add(limitRange("team", corev1.LimitRangeItem{Type: corev1.LimitTypeContainer, Default: cpu}))
+	if d := s.checkLimitRange(context.Background(), policy, req, pod); d == nil {
+		t.Error("cached result was not used")
+	}
+	if n := len(client.Actions()); n != 1 {
+		t.Errorf("got %d LimitRange lookups, want 1", n)
+	}
+}
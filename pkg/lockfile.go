@@ -0,0 +1,79 @@
+package pkg
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/opencontainers/go-digest"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog"
+)
+
+// LoadImageLock 加载镜像锁文件, 每行一个 digest, 可以写成 sha256:... 或者 image@sha256:..., # 开头的行是注释
+func LoadImageLock(path string) (map[string]bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	digests := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		entry := strings.TrimSpace(scanner.Text())
+		if entry == "" || strings.HasPrefix(entry, "#") {
+			continue
+		}
+		if i := strings.Index(entry, "@"); i != -1 {
+			entry = entry[i+1:]
+		}
+		if _, err := digest.Parse(entry); err != nil {
+			return nil, fmt.Errorf("%s:%d: %q is not a digest: %v", path, line, entry, err)
+		}
+		digests[entry] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return digests, nil
+}
+
+// lockedDigests 返回当前配置的锁文件中的 digest
+func (s *WebhookServer) lockedDigests() map[string]bool {
+	s.policyMu.RLock()
+	defer s.policyMu.RUnlock()
+	return s.Config.lockedDigests
+}
+
+// checkLockedImages 只允许 digest 在锁文件中的镜像, 使用 tag 的镜像先查询仓库得到 digest
+// 用于只部署经过发布流程锁定的镜像的生产命名空间, 查询失败时总是拒绝
+func (s *WebhookServer) checkLockedImages(ctx context.Context, policy *Policy, pod *corev1.Pod) *denial {
+	if !policy.RequireLockedImages {
+		return nil
+	}
+	locked := s.lockedDigests()
+	for _, container := range podContainers(pod) {
+		digest := parseImage(container.Image).Digest
+		if digest == "" && s.Inspector != nil {
+			var err error
+			if digest, err = s.Inspector.digest(ctx, container.Image); err != nil {
+				klog.Errorf("Can't resolve digest of %s: %v", container.Image, err)
+				return &denial{
+					code:    http.StatusInternalServerError,
+					message: fmt.Sprintf("can't resolve digest of %s to check it against the image lock: %v", container.Image, err),
+				}
+			}
+		}
+		if !locked[digest] {
+			return &denial{
+				code: http.StatusForbidden,
+				message: fmt.Sprintf("%s image is not in the image lock! Only locked image digests are allowed in namespace %s.",
+					container.Image, pod.Namespace),
+			}
+		}
+	}
+	return nil
+}
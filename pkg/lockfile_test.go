@@ -0,0 +1,84 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadConfigImageLock(t *testing.T) {
+	app, tool := testDigest("app"), testDigest("tool")
+	tests := []struct {
+		name    string
+		lock    string
+		want    map[string]bool
+		wantErr string
+	}{
+		{name: "digests and references", lock: "# release 1.2\n" + app + "\n\n  registry.corp.com/tool@" + tool + "  \n", want: map[string]bool{app: true, tool: true}},
+		{name: "tag instead of a digest", lock: app + "\nregistry.corp.com/app:1.2\n", wantErr: `lock.txt:2: "registry.corp.com/app:1.2" is not a digest`},
+		{name: "truncated digest", lock: "sha256:0123abcd\n", wantErr: `lock.txt:1: "sha256:0123abcd" is not a digest`},
+	}
+	for _, tt := range tests {
+		dir, err := ioutil.TempDir("", "lock")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		if err := ioutil.WriteFile(filepath.Join(dir, "lock.txt"), []byte(tt.lock), 0644); err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, "config.yaml")
+		// 相对路径相对于配置文件所在的目录
+		if err := ioutil.WriteFile(path, []byte("imageLockFile: lock.txt\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		config, err := LoadConfig(path)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: got error %v, want %q", tt.name, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if !reflect.DeepEqual(config.lockedDigests, tt.want) {
+			t.Errorf("%s: got locked digests %v, want %v", tt.name, config.lockedDigests, tt.want)
+		}
+	}
+	if _, err := loadTestConfig(t, "imageLockFile: missing.txt\n"); err == nil || !strings.Contains(err.Error(), "imageLockFile") {
+		t.Errorf("missing lock file: got error %v", err)
+	}
+}
+
+func TestCheckLockedImages(t *testing.T) {
+	locked, unlocked := "registry.corp.com/app:1.2", "registry.corp.com/app:1.3"
+	tests := []struct {
+		name     string
+		image    string
+		policy   Policy
+		err      error
+		wantCode int
+	}{
+		{name: "locked tag", image: locked, policy: Policy{RequireLockedImages: true}},
+		{name: "locked digest", image: "registry.corp.com/app@" + testDigest(locked), policy: Policy{RequireLockedImages: true}},
+		{name: "unlocked tag", image: unlocked, policy: Policy{RequireLockedImages: true}, wantCode: http.StatusForbidden},
+		{name: "unlocked digest", image: "registry.corp.com/app@" + testDigest(unlocked), policy: Policy{RequireLockedImages: true}, wantCode: http.StatusForbidden},
+		{name: "resolve error fails closed", image: locked, policy: Policy{RequireLockedImages: true}, err: errors.New("registry unavailable"), wantCode: http.StatusInternalServerError},
+		{name: "not required", image: unlocked},
+	}
+	discardLogs(t)
+	for _, tt := range tests {
+		s := &WebhookServer{Inspector: NewCachedInspector(&fakeInspector{err: tt.err})}
+		s.SetConfig(Config{lockedDigests: map[string]bool{testDigest(locked): true}})
+		if code := denialCode(s.checkLockedImages(context.Background(), &tt.policy, imagePod(tt.image))); code != tt.wantCode {
+			t.Errorf("%s: got code %d, want %d", tt.name, code, tt.wantCode)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package pkg
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	admissionV1 "k8s.io/api/admission/v1"
+	"k8s.io/klog"
+)
+
+// 日志的输出格式
+const (
+	LogFormatText = "text" // klog 默认的文本格式
+	LogFormatJSON = "json" // 每行一个 json 对象
+)
+
+// jsonLog 不为 nil 时使用 json 格式输出日志
+var jsonLog *jsonLogWriter
+
+// SetLogFormat 设置日志的输出格式, 需要在打印任何日志之前调用
+func SetLogFormat(format string) error {
+	switch format {
+	case "", LogFormatText:
+		return nil
+	case LogFormatJSON:
+	default:
+		return fmt.Errorf("unknown log format %q", format)
+	}
+	// klog 默认直接写 stderr, 关闭之后日志才会写到 SetOutputBySeverity 设置的 writer
+	// klog 会把高级别的日志同时写到所有低级别的 writer, 所以只在 INFO 上输出, 避免重复
+	fs := flag.NewFlagSet("klog", flag.ContinueOnError)
+	klog.InitFlags(fs)
+	if err := fs.Set("logtostderr", "false"); err != nil {
+		return err
+	}
+	if err := fs.Set("stderrthreshold", "FATAL"); err != nil {
+		return err
+	}
+	jsonLog = &jsonLogWriter{w: os.Stderr}
+	klog.SetOutputBySeverity("INFO", jsonLog)
+	for _, severity := range []string{"WARNING", "ERROR", "FATAL"} {
+		klog.SetOutputBySeverity(severity, ioutil.Discard)
+	}
+	return nil
+}
+
+// SetLogVerbosity 设置 klog 的日志级别, 可以在运行时调用, 用于排查线上问题时临时打开详细日志
+// level 为 nil (配置中没有写 logVerbosity) 时保持当前的级别
+func SetLogVerbosity(level *int) error {
+	if level == nil {
+		return nil
+	}
+	fs := flag.NewFlagSet("klog", flag.ContinueOnError)
+	klog.InitFlags(fs)
+	return fs.Set("v", strconv.Itoa(*level))
+}
+
+var logLevels = map[byte]string{'I': "info", 'W': "warning", 'E': "error", 'F': "fatal"}
+
+// jsonLogWriter 把 klog 格式化好的日志行转换成 json
+// klog 的日志格式为 "Lmmdd hh:mm:ss.uuuuuu threadid file:line] msg"
+type jsonLogWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (j *jsonLogWriter) Write(p []byte) (int, error) {
+	line := strings.TrimSuffix(string(p), "\n")
+	entry := map[string]interface{}{"msg": line}
+	if end := strings.Index(line, "] "); end > 0 && len(line) > 30 {
+		header := line[:end]
+		entry["level"] = logLevels[header[0]]
+		if i := strings.LastIndex(header, " "); i >= 0 {
+			entry["caller"] = header[i+1:]
+		}
+		entry["msg"] = line[end+2:]
+	}
+	if err := j.write(entry); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (j *jsonLogWriter) write(entry map[string]interface{}) error {
+	entry["ts"] = time.Now().UTC().Format(time.RFC3339Nano)
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	_, err = j.w.Write(append(data, '\n'))
+	return err
+}
+
+// logDecision 记录准入决定, json 格式下决定的各个字段分别作为单独的 key 输出
+func logDecision(req *admissionV1.AdmissionRequest, resp *admissionV1.AdmissionResponse, policyVersion string) {
+	if jsonLog == nil {
+		klog.Infof("Admission decision for UID=%s: allowed=%v, policyVersion=%s",
+			req.UID, resp.Allowed, policyVersion)
+		return
+	}
+	decision := "allowed"
+	if !resp.Allowed {
+		decision = "denied"
+	}
+	entry := map[string]interface{}{
+		"level":         "info",
+		"msg":           "Admission decision",
+		"uid":           req.UID,
+		"kind":          req.Kind.Kind,
+		"namespace":     req.Namespace,
+		"name":          req.Name,
+		"operation":     req.Operation,
+		"decision":      decision,
+		"policyVersion": policyVersion,
+	}
+	if _, file, line, ok := runtime.Caller(1); ok {
+		entry["caller"] = fmt.Sprintf("%s:%d", file[strings.LastIndex(file, "/")+1:], line)
+	}
+	if resp.Result != nil && resp.Result.Message != "" {
+		entry["reason"] = resp.Result.Message
+	}
+	if err := jsonLog.write(entry); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write log: %v\n", err)
+	}
+}
@@ -0,0 +1,95 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	admissionV1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+)
+
+// captureJSONLogs 和 SetLogFormat(LogFormatJSON) 一样输出 json 格式的日志, 但是写到返回的 buffer 中
+func captureJSONLogs(t *testing.T) *bytes.Buffer {
+	discardLogs(t)
+	var buf bytes.Buffer
+	jsonLog = &jsonLogWriter{w: &buf}
+	klog.SetOutputBySeverity("INFO", jsonLog)
+	t.Cleanup(func() {
+		jsonLog = nil
+		klog.SetOutput(ioutil.Discard)
+	})
+	return &buf
+}
+
+func TestJSONLogs(t *testing.T) {
+	buf := captureJSONLogs(t)
+	klog.Infof("Policy reloaded")
+	klog.Warningf("Registry unavailable")
+	logDecision(&admissionV1.AdmissionRequest{
+		UID:       "42",
+		Kind:      metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+		Namespace: "team",
+		Name:      "web",
+		Operation: admissionV1.Create,
+	}, &admissionV1.AdmissionResponse{Allowed: false, Result: &metav1.Status{Message: "untrusted registry"}}, "v2")
+	klog.Flush()
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("got %d log lines, want 3:\n%s", len(lines), buf.String())
+	}
+	var entries []map[string]interface{}
+	for _, line := range lines {
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("log line is not json: %q: %v", line, err)
+		}
+		for _, key := range []string{"level", "msg", "ts", "caller"} {
+			if _, ok := entry[key]; !ok {
+				t.Errorf("log line %q has no %s", line, key)
+			}
+		}
+		entries = append(entries, entry)
+	}
+
+	tests := []struct {
+		entry int
+		key   string
+		want  interface{}
+	}{
+		{0, "level", "info"},
+		{0, "msg", "Policy reloaded"},
+		{1, "level", "warning"},
+		{1, "msg", "Registry unavailable"},
+		{2, "msg", "Admission decision"},
+		{2, "uid", "42"},
+		{2, "kind", "Pod"},
+		{2, "namespace", "team"},
+		{2, "name", "web"},
+		{2, "operation", "CREATE"},
+		{2, "decision", "denied"},
+		{2, "policyVersion", "v2"},
+		{2, "reason", "untrusted registry"},
+	}
+	for _, tt := range tests {
+		if got := entries[tt.entry][tt.key]; got != tt.want {
+			t.Errorf("line %d: %s = %v, want %v", tt.entry, tt.key, got, tt.want)
+		}
+	}
+	if caller, _ := entries[2]["caller"].(string); !strings.HasPrefix(caller, "logging_test.go:") {
+		t.Errorf("decision caller = %q, want the line that logged the decision", caller)
+	}
+}
+
+func TestSetLogFormat(t *testing.T) {
+	if err := SetLogFormat(LogFormatText); err != nil || jsonLog != nil {
+		t.Errorf("text format: err %v, json enabled %v", err, jsonLog != nil)
+	}
+	if err := SetLogFormat("yaml"); err == nil {
+		t.Error("unknown format was accepted")
+	}
+}
@@ -0,0 +1,55 @@
+package pkg
+
+import (
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// 统计拒绝次数时最多记录的仓库数量, 超过之后统一记为 other, 避免 label 数量无限增长
+const maxDeniedRegistries = 100
+
+var (
+	deniedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "admission_denied_total",
+		Help: "Number of images denied because they come from untrusted registries, by registry host.",
+	}, []string{"registry"})
+
+	shadowDeniedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "admission_shadow_denied_total",
+		Help: "Number of requests that shadow-mode checks would have denied, by check.",
+	}, []string{"check"})
+
+	checkDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "admission_check_duration_seconds",
+		Help:    "Time spent evaluating each admission check.",
+		Buckets: []float64{0.0001, 0.0005, 0.001, 0.005, 0.01, 0.05, 0.1, 0.5, 1, 2, 5},
+	}, []string{"check"})
+
+	latencyBudgetExhaustedTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "admission_latency_budget_exhausted_total",
+		Help: "Number of requests whose latency budget ran out before all checks were evaluated, by the first skipped check.",
+	}, []string{"check"})
+
+	deniedRegistriesMu sync.Mutex
+	deniedRegistries   = make(map[string]struct{})
+)
+
+func init() {
+	prometheus.MustRegister(deniedTotal, shadowDeniedTotal, checkDuration, latencyBudgetExhaustedTotal)
+}
+
+// recordDenied 记录一次来自不可信仓库的拒绝
+func recordDenied(image string) {
+	registry := imageRegistry(image)
+	deniedRegistriesMu.Lock()
+	if _, ok := deniedRegistries[registry]; !ok {
+		if len(deniedRegistries) >= maxDeniedRegistries {
+			registry = "other"
+		} else {
+			deniedRegistries[registry] = struct{}{}
+		}
+	}
+	deniedRegistriesMu.Unlock()
+	deniedTotal.WithLabelValues(registry).Inc()
+}
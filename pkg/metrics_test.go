@@ -0,0 +1,50 @@
+package pkg
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// resetDeniedMetrics 清空拒绝次数的指标和已经记录的仓库, 测试结束后同样清空
+func resetDeniedMetrics(t *testing.T) {
+	reset := func() {
+		deniedTotal.Reset()
+		deniedRegistriesMu.Lock()
+		deniedRegistries = make(map[string]struct{})
+		deniedRegistriesMu.Unlock()
+	}
+	reset()
+	t.Cleanup(reset)
+}
+
+func TestRecordDenied(t *testing.T) {
+	resetDeniedMetrics(t)
+	for _, image := range []string{"quay.io/app:1", "quay.io/other:2", "nginx", "localhost:5000/app"} {
+		recordDenied(image)
+	}
+	for registry, want := range map[string]float64{"quay.io": 2, "docker.io": 1, "localhost:5000": 1} {
+		if got := testutil.ToFloat64(deniedTotal.WithLabelValues(registry)); got != want {
+			t.Errorf("denied total for %s = %v, want %v", registry, got, want)
+		}
+	}
+}
+
+func TestRecordDeniedLimitsRegistries(t *testing.T) {
+	resetDeniedMetrics(t)
+	for i := 0; i < maxDeniedRegistries+10; i++ {
+		recordDenied(fmt.Sprintf("registry%d.example.com/app", i))
+	}
+	// 已经记录过的仓库仍然使用自己的 label
+	recordDenied("registry0.example.com/app")
+	if n := testutil.CollectAndCount(deniedTotal); n != maxDeniedRegistries+1 {
+		t.Errorf("got %d registry series, want %d plus other", n, maxDeniedRegistries)
+	}
+	if got := testutil.ToFloat64(deniedTotal.WithLabelValues("other")); got != 10 {
+		t.Errorf("denied total for other = %v, want 10", got)
+	}
+	if got := testutil.ToFloat64(deniedTotal.WithLabelValues("registry0.example.com")); got != 2 {
+		t.Errorf("denied total for registry0.example.com = %v, want 2", got)
+	}
+}
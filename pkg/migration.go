@@ -0,0 +1,41 @@
+package pkg
+
+import (
+	"time"
+
+	"k8s.io/klog"
+)
+
+// 临时仓库距离过期不到这个时间时, 每次使用都打印警告
+const temporaryRegistryWarnBefore = 7 * 24 * time.Hour
+
+// TemporaryRegistry 迁移期间临时允许的仓库, 过期之后按照不可信的仓库处理
+type TemporaryRegistry struct {
+	Registry string    `json:"registry"`
+	Expires  time.Time `json:"expires"` // RFC3339 格式, 比如 2021-06-30T00:00:00Z
+}
+
+// now 返回当前时间, 测试时可以通过 WebhookServer.Clock 替换
+func (s *WebhookServer) now() time.Time {
+	if s.Clock != nil {
+		return s.Clock()
+	}
+	return time.Now()
+}
+
+// temporarilyAllowed 判断镜像是否来自还没有过期的临时仓库
+func (s *WebhookServer) temporarilyAllowed(image, namespace string, registries []TemporaryRegistry) bool {
+	now := s.now()
+	normalized := normalizeImage(image)
+	for _, reg := range registries {
+		if !hasRegistryPrefix(normalized, reg.Registry) || !now.Before(reg.Expires) {
+			continue
+		}
+		if reg.Expires.Sub(now) < temporaryRegistryWarnBefore {
+			klog.Warningf("%s image in namespace %s comes from temporary registry %s, which will be denied after %s",
+				image, namespace, reg.Registry, reg.Expires.Format(time.RFC3339))
+		}
+		return true
+	}
+	return false
+}
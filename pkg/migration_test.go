@@ -0,0 +1,67 @@
+package pkg
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestTemporarilyAllowed(t *testing.T) {
+	now := time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)
+	s := &WebhookServer{Clock: func() time.Time { return now }}
+	registries := []TemporaryRegistry{
+		{Registry: "old.corp.com", Expires: now.Add(30 * 24 * time.Hour)},
+		{Registry: "legacy.corp.com/team", Expires: now.Add(time.Hour)},
+		{Registry: "expired.corp.com", Expires: now},
+	}
+	tests := []struct {
+		image string
+		want  bool
+	}{
+		{"old.corp.com/app:1", true},
+		{"legacy.corp.com/team/app:1", true},
+		{"expired.corp.com/app:1", false},
+		// 只按路径边界匹配
+		{"old.corp.com.evil.com/app:1", false},
+		{"old.corp.comx/app:1", false},
+		{"legacy.corp.com/teamx/app:1", false},
+	}
+	for _, tt := range tests {
+		if got := s.temporarilyAllowed(tt.image, "default", registries); got != tt.want {
+			t.Errorf("temporarilyAllowed(%q) = %v, want %v", tt.image, got, tt.want)
+		}
+	}
+}
+
+func TestCheckRegistriesTemporaryRegistry(t *testing.T) {
+	config, err := loadTestConfig(t, `base:
+  whiteListRegistries: [registry.corp.com]
+  temporaryRegistries:
+  - registry: old.corp.com
+    expires: 2021-06-30T00:00:00Z
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		now      string
+		wantCode int
+	}{
+		{now: "2021-06-01T00:00:00Z"},
+		{now: "2021-06-29T23:59:59Z"},
+		{now: "2021-06-30T00:00:00Z", wantCode: http.StatusForbidden},
+		{now: "2021-07-15T00:00:00Z", wantCode: http.StatusForbidden},
+	}
+	discardLogs(t)
+	for _, tt := range tests {
+		now, err := time.Parse(time.RFC3339, tt.now)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s := &WebhookServer{Clock: func() time.Time { return now }}
+		if code := denialCode(s.checkRegistries(context.Background(), &config.Base, imagePod("old.corp.com/app:1"))); code != tt.wantCode {
+			t.Errorf("at %s: got code %d, want %d", tt.now, code, tt.wantCode)
+		}
+	}
+}
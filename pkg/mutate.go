@@ -0,0 +1,93 @@
+package pkg
+
+import (
+	"context"
+	"net/http"
+
+	admissionV1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+)
+
+// pod 主动要求挂载 ServiceAccount token 时使用的注解, 值为 "true" 时不会注入 automountServiceAccountToken: false
+const automountTokenAnnotation = "admission-registry/automount-service-account-token"
+
+func (s *WebhookServer) mutate(ctx context.Context, ar *admissionV1.AdmissionReview) *admissionV1.AdmissionResponse {
+	ctx, span := startSpan(ctx, "mutate")
+	defer span.End()
+	req := ar.Request
+	klog.Infof("AdmissionReview for Kind=%s, Namespace=%s, Name=%s, UID=%s, Operation=%s",
+		req.Kind.Kind, req.Namespace, req.Name, req.UID, req.Operation)
+	policy := s.namespacePolicy(ctx, req.Namespace)
+	if skipRequest(&policy, req) {
+		klog.Infof("Skipping %s of %s/%s for UID=%s: no object to mutate", req.Operation, req.Resource.Resource, req.SubResource, req.UID)
+		return &admissionV1.AdmissionResponse{Allowed: true}
+	}
+	obj, pod, err := decodeObject(req, s.LargeObjectSize)
+	if err != nil {
+		klog.Errorf("Can't unmarshal object raw: %v", err)
+		return &admissionV1.AdmissionResponse{
+			Result: &metav1.Status{
+				Code:    http.StatusBadRequest,
+				Message: err.Error(),
+			},
+		}
+	}
+	if pod == nil {
+		return &admissionV1.AdmissionResponse{Allowed: true}
+	}
+
+	// 在 mutate 时就把校验不通过的原因作为警告返回, 不需要等到 validate 拒绝才看到
+	var warnings []string
+	if policy.MutateWarnings {
+		warnings = s.checks().Advise(ctx, pod, &Request{AdmissionRequest: req, Object: obj, Policy: &policy, Time: s.now()})
+	}
+
+	specPath := podSpecPath(req.Kind.Kind)
+	var patch PatchBuilder
+	mutateAutomountToken(&policy, pod, specPath, &patch)
+	if patch.Len() == 0 {
+		return &admissionV1.AdmissionResponse{Allowed: true, Warnings: warnings}
+	}
+
+	patchBytes, err := patch.Marshal()
+	if err != nil {
+		klog.Errorf("Can't encode patches: %v", err)
+		return &admissionV1.AdmissionResponse{
+			Result: &metav1.Status{
+				Code:    http.StatusInternalServerError,
+				Message: err.Error(),
+			},
+		}
+	}
+	klog.Infof("AdmissionResponse: patch=%s", string(patchBytes))
+	patchType := admissionV1.PatchTypeJSONPatch
+	return &admissionV1.AdmissionResponse{
+		Allowed:   true,
+		Patch:     patchBytes,
+		PatchType: &patchType,
+		Warnings:  warnings,
+	}
+}
+
+// podSpecPath 返回对象中 pod spec 的 JSONPatch 路径, 没有 Kind 的请求和 decodeObject 一样按 Pod 处理
+func podSpecPath(kind string) string {
+	switch kind {
+	case "Pod", "":
+		return "/spec"
+	case "CronJob":
+		return "/spec/jobTemplate/spec/template/spec"
+	}
+	return "/spec/template/spec"
+}
+
+// mutateAutomountToken 没有设置 automountServiceAccountToken 的 pod 默认不挂载 token
+// 已经显式设置了的 pod 保持不变, 带有 automountTokenAnnotation 注解的 pod 也不处理
+func mutateAutomountToken(policy *Policy, pod *corev1.Pod, specPath string, patch *PatchBuilder) {
+	if !policy.DisableAutomountServiceAccountToken || pod.Spec.AutomountServiceAccountToken != nil ||
+		pod.Annotations[automountTokenAnnotation] == "true" {
+		return
+	}
+	patch.Add("add", specPath+"/automountServiceAccountToken", false)
+}
@@ -0,0 +1,137 @@
+package pkg
+
+import (
+	"net/http"
+	"reflect"
+	"testing"
+
+	"github.com/haozi4263/admission-registry/pkg/testutil"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	admissionV1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestMutateHandler(t *testing.T) {
+	pod := testPod("web", "quay.io/app:1.0")
+	optedOut := testPod("web", "nginx")
+	optedOut.Annotations = map[string]string{automountTokenAnnotation: "true"}
+	explicit := testPod("web", "nginx")
+	mount := true
+	explicit.Spec.AutomountServiceAccountToken = &mount
+	deploy, err := testutil.NewAdmissionReview(
+		metav1.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"},
+		metav1.GroupVersionResource{Group: "apps", Version: "v1", Resource: "deployments"},
+		"team", "web", appsv1.Deployment{Spec: appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{Spec: pod.Spec}}}, admissionV1.Create)
+	if err != nil {
+		t.Fatal(err)
+	}
+	service, err := testutil.NewAdmissionReview(
+		metav1.GroupVersionKind{Version: "v1", Kind: "Service"},
+		metav1.GroupVersionResource{Version: "v1", Resource: "services"},
+		"team", "web", corev1.Service{}, admissionV1.Create)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	disabled := Policy{DisableAutomountServiceAccountToken: true}
+	tests := []struct {
+		name      string
+		policy    Policy
+		review    *admissionV1.AdmissionReview
+		wantPatch string
+	}{
+		{name: "no mutation configured", review: testutil.NewPodAdmissionReview(pod, admissionV1.Create)},
+		{name: "pod", policy: disabled, review: testutil.NewPodAdmissionReview(pod, admissionV1.Create),
+			wantPatch: `[{"op":"add","path":"/spec/automountServiceAccountToken","value":false}]`},
+		{name: "deployment", policy: disabled, review: deploy,
+			wantPatch: `[{"op":"add","path":"/spec/template/spec/automountServiceAccountToken","value":false}]`},
+		{name: "opted out", policy: disabled, review: testutil.NewPodAdmissionReview(optedOut, admissionV1.Create)},
+		{name: "explicitly set", policy: disabled, review: testutil.NewPodAdmissionReview(explicit, admissionV1.Create)},
+		{name: "not a workload", policy: disabled, review: service},
+	}
+	for _, tt := range tests {
+		s := &WebhookServer{}
+		s.SetConfig(Config{Base: tt.policy})
+		resp, err := testutil.ServeReview(http.HandlerFunc(s.Handler), MutatePath, tt.review)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		// mutate 从不拒绝请求, 拒绝由 validate 负责
+		if !resp.Allowed || resp.UID != tt.review.Request.UID {
+			t.Errorf("%s: got allowed %v, UID %q", tt.name, resp.Allowed, resp.UID)
+		}
+		if string(resp.Patch) != tt.wantPatch {
+			t.Errorf("%s: got patch %s, want %s", tt.name, resp.Patch, tt.wantPatch)
+		}
+		if tt.wantPatch != "" && (resp.PatchType == nil || *resp.PatchType != admissionV1.PatchTypeJSONPatch) {
+			t.Errorf("%s: got patch type %v, want JSONPatch", tt.name, resp.PatchType)
+		}
+	}
+}
+
+func TestMutateHandlerWarnings(t *testing.T) {
+	tests := []struct {
+		name         string
+		image        string
+		policy       Policy
+		wantWarnings []string
+		wantPatch    bool
+	}{
+		{name: "violation as a warning", image: "quay.io/app:1.0", policy: Policy{MutateWarnings: true},
+			wantWarnings: []string{"registries: quay.io/app:1.0 image comes from untrusted registry! Only images form [docker.io] are allowed."}},
+		{name: "warning with a patch", image: "quay.io/app:1.0", policy: Policy{MutateWarnings: true, DisableAutomountServiceAccountToken: true},
+			wantWarnings: []string{"registries: quay.io/app:1.0 image comes from untrusted registry! Only images form [docker.io] are allowed."}, wantPatch: true},
+		{name: "disabled check", image: "quay.io/app:1.0", policy: Policy{MutateWarnings: true, DisabledChecks: []string{"registries"}}},
+		{name: "shadow check", image: "quay.io/app:1.0", policy: Policy{MutateWarnings: true, ShadowChecks: []string{"registries"}}},
+		{name: "compliant pod", image: "nginx:1.21", policy: Policy{MutateWarnings: true}},
+		{name: "not configured", image: "quay.io/app:1.0"},
+	}
+	resetDeniedMetrics(t)
+	for _, tt := range tests {
+		s := &WebhookServer{}
+		policy := tt.policy
+		policy.WhiteListRegistries = []string{"docker.io"}
+		s.SetConfig(Config{Base: policy})
+		resp, err := testutil.ServeReview(http.HandlerFunc(s.Handler), MutatePath,
+			testutil.NewPodAdmissionReview(testPod("web", tt.image), admissionV1.Create))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if !resp.Allowed || !reflect.DeepEqual(resp.Warnings, tt.wantWarnings) {
+			t.Errorf("%s: got allowed %v, warnings %q, want warnings %q", tt.name, resp.Allowed, resp.Warnings, tt.wantWarnings)
+		}
+		if (len(resp.Patch) > 0) != tt.wantPatch {
+			t.Errorf("%s: got patch %s, want patch %v", tt.name, resp.Patch, tt.wantPatch)
+		}
+	}
+	// 拒绝次数在 validate 时统计, mutate 的提示不计入
+	if n := promtestutil.CollectAndCount(deniedTotal); n != 0 {
+		t.Errorf("mutate warnings recorded %d denied series, want none", n)
+	}
+}
+
+func TestMutateAutomountTokenPaths(t *testing.T) {
+	policy := &Policy{DisableAutomountServiceAccountToken: true}
+	tests := []struct {
+		kind, want string
+	}{
+		{"", `[{"op":"add","path":"/spec/automountServiceAccountToken","value":false}]`},
+		{"Pod", `[{"op":"add","path":"/spec/automountServiceAccountToken","value":false}]`},
+		{"StatefulSet", `[{"op":"add","path":"/spec/template/spec/automountServiceAccountToken","value":false}]`},
+		{"CronJob", `[{"op":"add","path":"/spec/jobTemplate/spec/template/spec/automountServiceAccountToken","value":false}]`},
+	}
+	for _, tt := range tests {
+		pod := testPod("web", "nginx")
+		var patch PatchBuilder
+		mutateAutomountToken(policy, &pod, podSpecPath(tt.kind), &patch)
+		got, err := patch.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("%q: got patch %s, want %s", tt.kind, got, tt.want)
+		}
+	}
+}
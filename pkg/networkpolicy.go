@@ -0,0 +1,31 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	admissionV1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// checkNetworkPolicy 创建 pod 时要求命名空间中至少有一个 NetworkPolicy, 作为默认拒绝的网络隔离基线
+func (s *WebhookServer) checkNetworkPolicy(ctx context.Context, policy *Policy, req *admissionV1.AdmissionRequest, obj runtime.Object) *denial {
+	pod, ok := obj.(*corev1.Pod)
+	if !ok || policy.RequireNetworkPolicy == "" || req.Operation != admissionV1.Create || s.Client == nil {
+		return nil
+	}
+	ctx, cancel := s.lookupContext(ctx)
+	defer cancel()
+	policies, err := s.Client.NetworkingV1().NetworkPolicies(pod.Namespace).List(ctx, metav1.ListOptions{Limit: 1})
+	if err != nil {
+		return lookupFailed(policy, "NetworkPolicies", err)
+	}
+	if len(policies.Items) > 0 {
+		return nil
+	}
+	return policy.RequireNetworkPolicy.violation(http.StatusForbidden,
+		fmt.Sprintf("namespace %s has no NetworkPolicy! Pods can only be created in network-isolated namespaces.", pod.Namespace))
+}
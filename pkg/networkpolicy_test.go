@@ -0,0 +1,54 @@
+package pkg
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	admissionV1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestCheckNetworkPolicy(t *testing.T) {
+	defaultDeny := &networkingv1.NetworkPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "default-deny", Namespace: "isolated"},
+		Spec:       networkingv1.NetworkPolicySpec{PolicyTypes: []networkingv1.PolicyType{networkingv1.PolicyTypeIngress}},
+	}
+	tests := []struct {
+		name        string
+		namespace   string
+		operation   admissionV1.Operation
+		policy      Policy
+		failLists   bool
+		wantCode    int
+		wantWarning bool
+	}{
+		{name: "namespace with a policy", namespace: "isolated", policy: Policy{RequireNetworkPolicy: ActionDeny}},
+		{name: "namespace without policies", namespace: "open", policy: Policy{RequireNetworkPolicy: ActionDeny}, wantCode: http.StatusForbidden},
+		{name: "warn only", namespace: "open", policy: Policy{RequireNetworkPolicy: ActionWarn}, wantCode: http.StatusForbidden, wantWarning: true},
+		{name: "not configured", namespace: "open"},
+		{name: "update is not checked", namespace: "open", operation: admissionV1.Update, policy: Policy{RequireNetworkPolicy: ActionDeny}},
+		{name: "lookup error fails open", namespace: "open", policy: Policy{RequireNetworkPolicy: ActionDeny}, failLists: true},
+		{
+			name:      "lookup error fails closed",
+			namespace: "open",
+			policy:    Policy{RequireNetworkPolicy: ActionDeny, LookupFailClosed: true},
+			failLists: true,
+			wantCode:  http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset(defaultDeny)
+			if tt.failLists {
+				failRequests(client, "list", "networkpolicies")
+			}
+			s := &WebhookServer{Client: client}
+			pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: tt.namespace}}
+			assertDenial(t, s.checkNetworkPolicy(context.Background(), &tt.policy, admissionRequest(tt.operation), pod), tt.wantCode, tt.wantWarning)
+		})
+	}
+}
@@ -0,0 +1,182 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+)
+
+// 命名空间通过注解开启 Config.OptInPolicies 中的策略, 注解为 <optInAnnotationPrefix><名字>: "true"
+const optInAnnotationPrefix = "admission-registry/enforce-"
+
+// Namespace 注解的缓存时间, 命名空间的所有者修改注解之后最多这么久生效
+const namespaceOptInTTL = 30 * time.Second
+
+type namespaceOptIns struct {
+	names   []string // 开启的策略名字, 按名字排序
+	expires time.Time
+}
+
+// namespacePolicy 返回命名空间最终生效的策略: 在 resolvePolicy 的结果上追加外部白名单, 再叠加命名空间通过注解开启的策略
+// 叠加的规则见 applyOptIn, 注解只能让策略更严格, 不能放宽已有的限制
+// 查询 Namespace 失败时不知道命名空间开启了哪些策略, 按照全部开启处理
+func (s *WebhookServer) namespacePolicy(ctx context.Context, namespace string) Policy {
+	policy := s.resolvePolicy(namespace)
+	s.addAllowlist(&policy)
+	optIns := s.policyCache().optIns
+	if len(optIns) == 0 || namespace == "" || s.Client == nil {
+		return policy
+	}
+	names, err := s.namespaceOptIns(ctx, namespace)
+	if err != nil {
+		klog.Errorf("Failed to look up opt-in annotations of namespace %s, applying all opt-in policies: %v", namespace, err)
+		names = make([]string, 0, len(optIns))
+		for name := range optIns {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+	for _, name := range names {
+		if optIn, ok := optIns[name]; ok {
+			policy = applyOptIn(policy, optIn)
+		}
+	}
+	return policy
+}
+
+// optInAllowlist opt-in 策略中可以收紧的允许列表
+type optInAllowlist struct {
+	list           func(*Policy) *[]string
+	covers         func(entry, value string) bool // entry 是否允许 value
+	emptyAllowsAll bool                           // 列表为空时是否表示不做限制
+}
+
+// coversPrefix 按前缀匹配的允许列表, 比如镜像仓库
+func coversPrefix(entry, value string) bool {
+	return entry == allowAllRegistries || hasRegistryPrefix(value, entry)
+}
+
+// coversExact 按名字完整匹配的允许列表, 比如 StorageClass
+func coversExact(entry, value string) bool { return entry == value }
+
+// optInAllowlists 叠加 opt-in 策略时取交集的允许列表, key 为配置中的字段名, 用于打印日志
+var optInAllowlists = map[string]optInAllowlist{
+	"whiteListRegistries":    {func(p *Policy) *[]string { return &p.WhiteListRegistries }, coversPrefix, false},
+	"gpuWhiteListRegistries": {func(p *Policy) *[]string { return &p.GPUWhiteListRegistries }, coversPrefix, true},
+	"allowedBaseImages":      {func(p *Policy) *[]string { return &p.AllowedBaseImages }, coversPrefix, true},
+	"regulatedZones":         {func(p *Policy) *[]string { return &p.RegulatedZones }, coversExact, true},
+	"allowedSchedulers":      {func(p *Policy) *[]string { return &p.AllowedSchedulers }, coversExact, true},
+	"allowedStorageClasses":  {func(p *Policy) *[]string { return &p.AllowedStorageClasses }, coversExact, true},
+	"allowedAccessModes":     {func(p *Policy) *[]string { return &p.AllowedAccessModes }, coversExact, true},
+	"allowedVolumeTypes":     {func(p *Policy) *[]string { return &p.AllowedVolumeTypes }, coversExact, true},
+	"allowedRuntimeClasses":  {func(p *Policy) *[]string { return &p.AllowedRuntimeClasses }, coversExact, true},
+	"allowedPriorityClasses": {func(p *Policy) *[]string { return &p.AllowedPriorityClasses }, coversExact, true},
+}
+
+// optInForbiddenFields opt-in 策略中不能配置的字段: 它们会放宽策略 (关闭或者豁免检查, 增加例外),
+// 或者是没法按条目取交集的允许列表 (端口范围, 域名后缀等)
+var optInForbiddenFields = []string{
+	"DisabledChecks", "ShadowChecks", "SkippedSubResources", "ExemptUsers", "EnforceAfter", "SystemNamespaces",
+	"AllowedImages", "TemporaryRegistries", "RegistryAliases", "DefaultRegistryNamespaces", "RegistryTiers", "RegionRegistries",
+	"IgnoredDeprecations", "AllowedUnsafeSysctls", "TokenMountPaths", "AllowedSeccompProfiles",
+	"AllowedContainerPorts", "AllowedHostPorts", "AllowedIngressDomains",
+}
+
+// validateOptInPolicy 检查 opt-in 策略没有配置 optInForbiddenFields 中的字段, 加载配置时调用
+func validateOptInPolicy(name string, policy *Policy) error {
+	v := reflect.ValueOf(policy).Elem()
+	for _, field := range optInForbiddenFields {
+		if v.FieldByName(field).Len() > 0 {
+			f, _ := v.Type().FieldByName(field)
+			return fmt.Errorf("optInPolicies.%s.%s: can't be set in an opt-in policy, opt-ins can only make the policy stricter",
+				name, strings.Split(f.Tag.Get("json"), ",")[0])
+		}
+	}
+	return nil
+}
+
+// applyOptIn 在 policy 上叠加 opt-in 策略
+// optInAllowlists 中的允许列表取交集, 只有两边都允许的才允许; 其它字段和命名空间覆盖的合并规则相同, 但是 opt-in 中显式配置的零值不会覆盖
+// 交集为空, 而列表为空表示不做限制时, 没法表示什么都不允许, 保留原来的列表并打印警告
+func applyOptIn(policy, optIn Policy) Policy {
+	// opt-in 只能让策略更严格, 显式配置的 false 和 0 不能关闭命名空间中已经打开的检查
+	optIn.explicit = nil
+	merged := mergePolicy(policy, optIn)
+	for name, allowlist := range optInAllowlists {
+		base, restrict := *allowlist.list(&policy), *allowlist.list(&optIn)
+		list := base
+		switch {
+		case len(restrict) == 0:
+		case len(base) == 0 && allowlist.emptyAllowsAll:
+			list = restrict
+		default:
+			list = intersectAllowlist(base, restrict, allowlist.covers)
+			if len(list) == 0 && allowlist.emptyAllowsAll {
+				klog.Warningf("Opt-in %s %v doesn't overlap with %v, keeping the namespace's list", name, restrict, base)
+				list = base
+			}
+		}
+		*allowlist.list(&merged) = list
+	}
+	return merged
+}
+
+// intersectAllowlist 返回 a 和 b 都允许的条目: a 中被 b 允许的, 以及 b 中被 a 允许的
+func intersectAllowlist(a, b []string, covers func(entry, value string) bool) []string {
+	var list []string
+	add := func(entries, by []string) {
+		for _, e := range entries {
+			if containsString(list, e) {
+				continue
+			}
+			for _, allowed := range by {
+				if covers(allowed, e) {
+					list = append(list, e)
+					break
+				}
+			}
+		}
+	}
+	add(a, b)
+	add(b, a)
+	return list
+}
+
+// namespaceOptIns 读取 Namespace 上值为 "true" 的 opt-in 注解, 结果会缓存 namespaceOptInTTL
+func (s *WebhookServer) namespaceOptIns(ctx context.Context, namespace string) ([]string, error) {
+	s.optInMu.Lock()
+	cached, ok := s.optIns[namespace]
+	s.optInMu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.names, nil
+	}
+
+	ctx, cancel := s.lookupContext(ctx)
+	defer cancel()
+	ns, err := s.Client.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for key, value := range ns.Annotations {
+		if strings.HasPrefix(key, optInAnnotationPrefix) && value == "true" {
+			names = append(names, strings.TrimPrefix(key, optInAnnotationPrefix))
+		}
+	}
+	// 按名字排序, 多个策略修改同一个标量字段时结果是确定的
+	sort.Strings(names)
+
+	s.optInMu.Lock()
+	if s.optIns == nil {
+		s.optIns = make(map[string]namespaceOptIns)
+	}
+	s.optIns[namespace] = namespaceOptIns{names: names, expires: time.Now().Add(namespaceOptInTTL)}
+	s.optInMu.Unlock()
+	return names, nil
+}
@@ -0,0 +1,94 @@
+package pkg
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const optInConfig = `
+base:
+  whiteListRegistries: [docker.io, registry.corp.com]
+  allowedStorageClasses: [standard, encrypted]
+optInPolicies:
+  prod:
+    whiteListRegistries: [registry.corp.com/prod/, quay.io]
+    allowedStorageClasses: [encrypted, fast]
+    allowedRuntimeClasses: [gvisor]
+`
+
+func optInNamespace(name string, annotations map[string]string) *corev1.Namespace {
+	return &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Annotations: annotations}}
+}
+
+func TestNamespacePolicyOptIn(t *testing.T) {
+	config, err := loadTestConfig(t, optInConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := fake.NewSimpleClientset(
+		optInNamespace("prod", map[string]string{"admission-registry/enforce-prod": "true"}),
+		optInNamespace("dev", nil),
+	)
+	s := &WebhookServer{Client: client}
+	s.SetConfig(*config)
+
+	tests := []struct {
+		namespace      string
+		registries     []string
+		storageClasses []string
+		runtimeClasses []string
+	}{
+		// opt-in 的白名单和原来的取交集, quay.io 不在原来的白名单中, 不能通过 opt-in 加进来
+		{"prod", []string{"registry.corp.com/prod"}, []string{"encrypted"}, []string{"gvisor"}},
+		{"dev", []string{"docker.io", "registry.corp.com"}, []string{"standard", "encrypted"}, nil},
+		// 查询 Namespace 失败时按照开启了所有 opt-in 处理
+		{"missing", []string{"registry.corp.com/prod"}, []string{"encrypted"}, []string{"gvisor"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.namespace, func(t *testing.T) {
+			policy := s.namespacePolicy(context.Background(), tt.namespace)
+			if !reflect.DeepEqual(policy.WhiteListRegistries, tt.registries) {
+				t.Errorf("whiteListRegistries = %v, want %v", policy.WhiteListRegistries, tt.registries)
+			}
+			if !reflect.DeepEqual(policy.AllowedStorageClasses, tt.storageClasses) {
+				t.Errorf("allowedStorageClasses = %v, want %v", policy.AllowedStorageClasses, tt.storageClasses)
+			}
+			if !reflect.DeepEqual(policy.AllowedRuntimeClasses, tt.runtimeClasses) {
+				t.Errorf("allowedRuntimeClasses = %v, want %v", policy.AllowedRuntimeClasses, tt.runtimeClasses)
+			}
+		})
+	}
+}
+
+func TestIntersectAllowlist(t *testing.T) {
+	tests := []struct {
+		a, b []string
+		want []string
+	}{
+		{[]string{"registry.corp.com"}, []string{"registry.corp.com/prod", "quay.io"}, []string{"registry.corp.com/prod"}},
+		{[]string{"registry.corp.com/prod"}, []string{"registry.corp.com"}, []string{"registry.corp.com/prod"}},
+		{[]string{"*"}, []string{"quay.io"}, []string{"quay.io"}},
+		{[]string{"docker.io"}, []string{"quay.io"}, nil},
+		// 只按路径边界匹配
+		{[]string{"gcr.io"}, []string{"gcr.io.evil.com", "gcr.iox"}, nil},
+		{[]string{"quay.io/team"}, []string{"quay.io/teamx", "quay.io/team/app"}, []string{"quay.io/team/app"}},
+	}
+	for _, tt := range tests {
+		if got := intersectAllowlist(tt.a, tt.b, coversPrefix); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("intersectAllowlist(%v, %v) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestLoadConfigRejectsLooseningOptIn(t *testing.T) {
+	for _, field := range []string{"disabledChecks: [registries]", "allowedImages: [quay.io/app]", "shadowChecks: [registries]"} {
+		if _, err := loadTestConfig(t, "base: {}\noptInPolicies:\n  prod:\n    "+field+"\n"); err == nil {
+			t.Errorf("LoadConfig accepted an opt-in policy with %s", field)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package pkg
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// patchOperation JSONPatch 中的一个操作
+type patchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+// PatchBuilder 收集多个 mutator 产生的 JSONPatch 操作, 合并成一个 patch
+// 操作按照添加的顺序执行, 往同一个 map 或数组中添加元素时:
+//   - 对象中原来没有这个 map/数组时, 第一次添加会先创建它, 之后的添加都在它下面进行, 不会互相覆盖
+//   - 数组元素总是追加到末尾 (/-), 前面的操作不会让后面操作的下标失效
+type PatchBuilder struct {
+	ops     []patchOperation
+	created map[string]bool // patch 中已经创建的 map/数组
+}
+
+// Add 添加一个原始的操作, op 为 add/replace/remove
+func (b *PatchBuilder) Add(op, path string, value interface{}) {
+	b.ops = append(b.ops, patchOperation{Op: op, Path: path, Value: value})
+}
+
+// SetKey 设置 map 中的一个 key, exists 表示对象中原来是否已经有这个 map
+func (b *PatchBuilder) SetKey(mapPath string, exists bool, key string, value interface{}) {
+	if !exists && !b.created[mapPath] {
+		b.markCreated(mapPath)
+		b.Add("add", mapPath, map[string]interface{}{key: value})
+		return
+	}
+	b.Add("add", mapPath+"/"+escapePatchKey(key), value)
+}
+
+// Append 在数组末尾追加一个元素, exists 表示对象中原来是否已经有这个数组
+func (b *PatchBuilder) Append(arrayPath string, exists bool, value interface{}) {
+	if !exists && !b.created[arrayPath] {
+		b.markCreated(arrayPath)
+		b.Add("add", arrayPath, []interface{}{value})
+		return
+	}
+	b.Add("add", arrayPath+"/-", value)
+}
+
+func (b *PatchBuilder) markCreated(path string) {
+	if b.created == nil {
+		b.created = make(map[string]bool)
+	}
+	b.created[path] = true
+}
+
+// Len 返回已经添加的操作数量
+func (b *PatchBuilder) Len() int {
+	return len(b.ops)
+}
+
+// Marshal 把所有操作编码成 JSONPatch
+func (b *PatchBuilder) Marshal() ([]byte, error) {
+	return json.Marshal(b.ops)
+}
+
+// escapePatchKey 按照 JSON Pointer 的规则转义 key 中的 ~ 和 /, 比如 label 名 app.kubernetes.io/name
+func escapePatchKey(key string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(key)
+}
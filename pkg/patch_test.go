@@ -0,0 +1,145 @@
+package pkg
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPatchBuilderLabelsAndSidecars(t *testing.T) {
+	sidecar := corev1.Container{Name: "proxy", Image: "envoyproxy/envoy:v1.18.3"}
+	initSidecar := corev1.Container{Name: "iptables", Image: "istio/proxyv2:1.10.0"}
+	tests := []struct {
+		name string
+		pod  corev1.Pod
+	}{
+		{
+			name: "without labels or init containers",
+			pod:  corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "nginx:1.21"}}}},
+		},
+		{
+			name: "with labels and init containers",
+			pod: corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app": "web"}},
+				Spec: corev1.PodSpec{
+					InitContainers: []corev1.Container{{Name: "migrate", Image: "app:1.0"}},
+					Containers:     []corev1.Container{{Name: "app", Image: "nginx:1.21"}},
+				},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := tt.pod
+			// 两个 mutator 分别注入标签和 sidecar, 往同一个 map 和数组中添加多个元素
+			var patch PatchBuilder
+			hasLabels := pod.Labels != nil
+			hasInit := len(pod.Spec.InitContainers) > 0
+			patch.SetKey("/metadata/labels", hasLabels, "app.kubernetes.io/managed-by", "admission-registry")
+			patch.Append("/spec/initContainers", hasInit, initSidecar)
+			patch.SetKey("/metadata/labels", hasLabels, "sidecar.example.com/injected", "true")
+			patch.Append("/spec/containers", true, sidecar)
+			patch.Append("/spec/initContainers", hasInit, initSidecar)
+
+			data, err := patch.Marshal()
+			if err != nil {
+				t.Fatal(err)
+			}
+			decoded, err := jsonpatch.DecodePatch(data)
+			if err != nil {
+				t.Fatalf("invalid patch %s: %v", data, err)
+			}
+			original, err := json.Marshal(pod)
+			if err != nil {
+				t.Fatal(err)
+			}
+			patched, err := decoded.Apply(original)
+			if err != nil {
+				t.Fatalf("patch %s does not apply: %v", data, err)
+			}
+			var got corev1.Pod
+			if err := json.Unmarshal(patched, &got); err != nil {
+				t.Fatal(err)
+			}
+
+			want := *pod.DeepCopy()
+			if want.Labels == nil {
+				want.Labels = map[string]string{}
+			}
+			want.Labels["app.kubernetes.io/managed-by"] = "admission-registry"
+			want.Labels["sidecar.example.com/injected"] = "true"
+			want.Spec.InitContainers = append(want.Spec.InitContainers, initSidecar, initSidecar)
+			want.Spec.Containers = append(want.Spec.Containers, sidecar)
+			if !reflect.DeepEqual(got.Labels, want.Labels) {
+				t.Errorf("labels = %v, want %v", got.Labels, want.Labels)
+			}
+			if !reflect.DeepEqual(got.Spec, want.Spec) {
+				t.Errorf("spec = %+v, want %+v", got.Spec, want.Spec)
+			}
+		})
+	}
+}
+
+func TestEscapePatchKey(t *testing.T) {
+	tests := []struct{ key, want string }{
+		{"app", "app"},
+		{"app.kubernetes.io/name", "app.kubernetes.io~1name"},
+		{"a~b/c", "a~0b~1c"},
+	}
+	for _, tt := range tests {
+		if got := escapePatchKey(tt.key); got != tt.want {
+			t.Errorf("escapePatchKey(%q) = %q, want %q", tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestPatchBuilderOperations(t *testing.T) {
+	tests := []struct {
+		name  string
+		build func(b *PatchBuilder)
+		want  string
+	}{
+		{name: "empty", build: func(b *PatchBuilder) {}, want: `null`},
+		{
+			name: "keys in a new map",
+			build: func(b *PatchBuilder) {
+				b.SetKey("/metadata/labels", false, "app", "web")
+				b.SetKey("/metadata/labels", false, "app.kubernetes.io/name", "web")
+			},
+			want: `[{"op":"add","path":"/metadata/labels","value":{"app":"web"}},{"op":"add","path":"/metadata/labels/app.kubernetes.io~1name","value":"web"}]`,
+		},
+		{
+			name:  "key in an existing map",
+			build: func(b *PatchBuilder) { b.SetKey("/metadata/annotations", true, "team", "payments") },
+			want:  `[{"op":"add","path":"/metadata/annotations/team","value":"payments"}]`,
+		},
+		{
+			name: "appends to a new array",
+			build: func(b *PatchBuilder) {
+				b.Append("/spec/initContainers", false, "a")
+				b.Append("/spec/initContainers", false, "b")
+			},
+			want: `[{"op":"add","path":"/spec/initContainers","value":["a"]},{"op":"add","path":"/spec/initContainers/-","value":"b"}]`,
+		},
+		{
+			name:  "append to an existing array",
+			build: func(b *PatchBuilder) { b.Append("/spec/containers", true, "a") },
+			want:  `[{"op":"add","path":"/spec/containers/-","value":"a"}]`,
+		},
+	}
+	for _, tt := range tests {
+		var b PatchBuilder
+		tt.build(&b)
+		got, err := b.Marshal()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
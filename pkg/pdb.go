@@ -0,0 +1,105 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	admissionV1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
+
+// checkPodDisruptionBudget 创建 Deployment 时要求命名空间中已有选中其 pod 的 PodDisruptionBudget
+func (s *WebhookServer) checkPodDisruptionBudget(ctx context.Context, policy *Policy, req *admissionV1.AdmissionRequest, obj runtime.Object) *denial {
+	deploy, ok := obj.(*appsv1.Deployment)
+	if !ok || policy.RequirePDB == "" || req.Operation != admissionV1.Create || s.Client == nil {
+		return nil
+	}
+	ctx, cancel := s.lookupContext(ctx)
+	defer cancel()
+	pdbs, err := s.Client.PolicyV1beta1().PodDisruptionBudgets(deploy.Namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return lookupFailed(policy, "PodDisruptionBudgets", err)
+	}
+	podLabels := labels.Set(deploy.Spec.Template.Labels)
+	for _, pdb := range pdbs.Items {
+		selector, err := metav1.LabelSelectorAsSelector(pdb.Spec.Selector)
+		if err != nil || selector.Empty() {
+			continue
+		}
+		if selector.Matches(podLabels) {
+			return nil
+		}
+	}
+	return policy.RequirePDB.violation(http.StatusForbidden,
+		fmt.Sprintf("deployment %s has no matching PodDisruptionBudget in namespace %s.", deploy.Name, deploy.Namespace))
+}
+
+// checkPDBCompatibility Deployment 滚动更新的 maxUnavailable 不能超过匹配的 PodDisruptionBudget 允许中断的 pod 数
+// PDB 一个 pod 都不允许中断时, 节点排空会一直卡住, 同样不通过
+func (s *WebhookServer) checkPDBCompatibility(ctx context.Context, policy *Policy, _ *admissionV1.AdmissionRequest, obj runtime.Object) *denial {
+	deploy, ok := obj.(*appsv1.Deployment)
+	if !ok || policy.CheckPDBCompatibility == "" || s.Client == nil || deploy.Spec.Strategy.Type == appsv1.RecreateDeploymentStrategyType {
+		return nil
+	}
+	replicas := 1
+	if deploy.Spec.Replicas != nil {
+		replicas = int(*deploy.Spec.Replicas)
+	}
+	// 和 Deployment 控制器一样, 没有设置时默认 25%, 百分比向下取整
+	maxUnavailable := intstr.FromString("25%")
+	if ru := deploy.Spec.Strategy.RollingUpdate; ru != nil && ru.MaxUnavailable != nil {
+		maxUnavailable = *ru.MaxUnavailable
+	}
+	unavailable, err := intstr.GetScaledValueFromIntOrPercent(&maxUnavailable, replicas, false)
+	if err != nil {
+		return nil
+	}
+
+	ctx, cancel := s.lookupContext(ctx)
+	defer cancel()
+	pdbs, err := s.Client.PolicyV1beta1().PodDisruptionBudgets(deploy.Namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return lookupFailed(policy, "PodDisruptionBudgets", err)
+	}
+	podLabels := labels.Set(deploy.Spec.Template.Labels)
+	for _, pdb := range pdbs.Items {
+		selector, err := metav1.LabelSelectorAsSelector(pdb.Spec.Selector)
+		if err != nil || selector.Empty() || !selector.Matches(podLabels) {
+			continue
+		}
+		allowed, ok := pdbAllowedDisruptions(pdb.Spec.MinAvailable, pdb.Spec.MaxUnavailable, replicas)
+		if !ok {
+			continue
+		}
+		if allowed <= 0 || unavailable > allowed {
+			return policy.CheckPDBCompatibility.violation(http.StatusForbidden,
+				fmt.Sprintf("deployment %s allows %d of %d pods unavailable, but PodDisruptionBudget %s allows only %d disruptions!",
+					deploy.Name, unavailable, replicas, pdb.Name, allowed))
+		}
+	}
+	return nil
+}
+
+// pdbAllowedDisruptions 按副本数计算 PDB 允许同时中断的 pod 数, minAvailable 的百分比向上取整, maxUnavailable 的百分比向下取整
+func pdbAllowedDisruptions(minAvailable, maxUnavailable *intstr.IntOrString, replicas int) (int, bool) {
+	if minAvailable != nil {
+		n, err := intstr.GetScaledValueFromIntOrPercent(minAvailable, replicas, true)
+		if err != nil {
+			return 0, false
+		}
+		return replicas - n, true
+	}
+	if maxUnavailable != nil {
+		n, err := intstr.GetScaledValueFromIntOrPercent(maxUnavailable, replicas, false)
+		if err != nil {
+			return 0, false
+		}
+		return n, true
+	}
+	return 0, false
+}
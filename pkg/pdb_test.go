@@ -0,0 +1,147 @@
+package pkg
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	admissionV1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	policyv1beta1 "k8s.io/api/policy/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func labelledDeployment(labels map[string]string) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "team"},
+		Spec:       appsv1.DeploymentSpec{Template: corev1.PodTemplateSpec{ObjectMeta: metav1.ObjectMeta{Labels: labels}}},
+	}
+}
+
+func selectorPDB(name string, selector map[string]string) *policyv1beta1.PodDisruptionBudget {
+	return &policyv1beta1.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "team"},
+		Spec:       policyv1beta1.PodDisruptionBudgetSpec{Selector: &metav1.LabelSelector{MatchLabels: selector}},
+	}
+}
+
+func TestCheckPodDisruptionBudget(t *testing.T) {
+	web := map[string]string{"app": "web", "tier": "frontend"}
+	tests := []struct {
+		name        string
+		pdbs        []runtime.Object
+		policy      Policy
+		operation   admissionV1.Operation
+		failLists   bool
+		wantCode    int
+		wantWarning bool
+	}{
+		{name: "matching PDB", pdbs: []runtime.Object{selectorPDB("web", map[string]string{"app": "web"})}},
+		{name: "no PDB", wantCode: http.StatusForbidden},
+		{name: "PDB for other pods", pdbs: []runtime.Object{selectorPDB("api", map[string]string{"app": "api"})}, wantCode: http.StatusForbidden},
+		{name: "empty selector is ignored", pdbs: []runtime.Object{selectorPDB("all", nil)}, wantCode: http.StatusForbidden},
+		{name: "warn", policy: Policy{RequirePDB: ActionWarn}, wantCode: http.StatusForbidden, wantWarning: true},
+		{name: "update is not checked", operation: admissionV1.Update},
+		{name: "lookup fails open", failLists: true},
+		{name: "lookup fails closed", policy: Policy{LookupFailClosed: true}, failLists: true, wantCode: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset(tt.pdbs...)
+			if tt.failLists {
+				failRequests(client, "list", "poddisruptionbudgets")
+			}
+			policy := tt.policy
+			if policy.RequirePDB == "" {
+				policy.RequirePDB = ActionDeny
+			}
+			s := &WebhookServer{Client: client}
+			d := s.checkPodDisruptionBudget(context.Background(), &policy, admissionRequest(tt.operation), labelledDeployment(web))
+			assertDenial(t, d, tt.wantCode, tt.wantWarning)
+		})
+	}
+}
+
+// rollingDeployment 有 replicas 个副本的 Deployment, maxUnavailable 为空时使用默认的 25%
+func rollingDeployment(replicas int32, maxUnavailable string) *appsv1.Deployment {
+	deploy := labelledDeployment(map[string]string{"app": "web"})
+	deploy.Spec.Replicas = &replicas
+	if maxUnavailable != "" {
+		value := intstr.Parse(maxUnavailable)
+		deploy.Spec.Strategy.RollingUpdate = &appsv1.RollingUpdateDeployment{MaxUnavailable: &value}
+	}
+	return deploy
+}
+
+// budgetPDB 选中 app: web 的 PDB, minAvailable 和 maxUnavailable 为空时不设置
+func budgetPDB(minAvailable, maxUnavailable string) *policyv1beta1.PodDisruptionBudget {
+	pdb := selectorPDB("web", map[string]string{"app": "web"})
+	if minAvailable != "" {
+		value := intstr.Parse(minAvailable)
+		pdb.Spec.MinAvailable = &value
+	}
+	if maxUnavailable != "" {
+		value := intstr.Parse(maxUnavailable)
+		pdb.Spec.MaxUnavailable = &value
+	}
+	return pdb
+}
+
+func TestCheckPDBCompatibility(t *testing.T) {
+	recreate := rollingDeployment(4, "")
+	recreate.Spec.Strategy.Type = appsv1.RecreateDeploymentStrategyType
+	tests := []struct {
+		name        string
+		deploy      *appsv1.Deployment
+		pdbs        []runtime.Object
+		policy      Policy
+		failLists   bool
+		wantCode    int
+		wantWarning bool
+	}{
+		{name: "default maxUnavailable within minAvailable", deploy: rollingDeployment(4, ""), pdbs: []runtime.Object{budgetPDB("3", "")}},
+		{name: "maxUnavailable over minAvailable", deploy: rollingDeployment(4, "2"), pdbs: []runtime.Object{budgetPDB("3", "")}, wantCode: http.StatusForbidden},
+		{name: "percent minAvailable rounds up", deploy: rollingDeployment(4, "1"), pdbs: []runtime.Object{budgetPDB("75%", "")}},
+		{name: "percent maxUnavailable rounds down", deploy: rollingDeployment(5, "50%"), pdbs: []runtime.Object{budgetPDB("", "50%")}},
+		{name: "maxUnavailable over PDB maxUnavailable", deploy: rollingDeployment(4, "3"), pdbs: []runtime.Object{budgetPDB("", "2")}, wantCode: http.StatusForbidden},
+		{name: "PDB allows no disruptions", deploy: rollingDeployment(4, "0"), pdbs: []runtime.Object{budgetPDB("100%", "")}, wantCode: http.StatusForbidden},
+		{name: "PDB for other pods", deploy: rollingDeployment(4, "4"), pdbs: []runtime.Object{selectorPDB("api", map[string]string{"app": "api"})}},
+		{name: "no PDB", deploy: rollingDeployment(4, "4")},
+		{name: "recreate is not checked", deploy: recreate, pdbs: []runtime.Object{budgetPDB("100%", "")}},
+		{
+			name:        "warn",
+			deploy:      rollingDeployment(4, "2"),
+			pdbs:        []runtime.Object{budgetPDB("3", "")},
+			policy:      Policy{CheckPDBCompatibility: ActionWarn},
+			wantCode:    http.StatusForbidden,
+			wantWarning: true,
+		},
+		{name: "lookup fails open", deploy: rollingDeployment(4, "2"), failLists: true},
+		{
+			name:      "lookup fails closed",
+			deploy:    rollingDeployment(4, "2"),
+			policy:    Policy{LookupFailClosed: true},
+			failLists: true,
+			wantCode:  http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset(tt.pdbs...)
+			if tt.failLists {
+				failRequests(client, "list", "poddisruptionbudgets")
+			}
+			policy := tt.policy
+			if policy.CheckPDBCompatibility == "" {
+				policy.CheckPDBCompatibility = ActionDeny
+			}
+			s := &WebhookServer{Client: client}
+			d := s.checkPDBCompatibility(context.Background(), &policy, admissionRequest(""), tt.deploy)
+			assertDenial(t, d, tt.wantCode, tt.wantWarning)
+		})
+	}
+}
@@ -0,0 +1,589 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog"
+	"sigs.k8s.io/yaml"
+)
+
+// Policy 准入策略, 所有的策略开关和参数都放在这里
+type Policy struct {
+	DisabledChecks       []string `json:"disabledChecks,omitempty"`       // 按名字关闭的检查, 比如 registries
+	CollectAllViolations bool     `json:"collectAllViolations,omitempty"` // 执行所有检查并返回所有拒绝原因, 默认遇到第一个拒绝就返回
+	ShadowChecks         []string `json:"shadowChecks,omitempty"`         // shadow 模式的检查, 只记录日志和指标, 不影响准入结果
+	SkippedSubResources  []string `json:"skippedSubResources,omitempty"`  // 直接放行的子资源, 默认 exec/attach/portforward/log
+
+	ExemptUsers []UserExemption `json:"exemptUsers,omitempty"` // 按照发起请求的用户跳过的检查
+
+	LatencyBudget           metav1.Duration `json:"latencyBudget,omitempty"`           // 单个请求所有检查的总耗时预算, 超过之后剩下的检查不再执行, 为 0 时不限制
+	LatencyBudgetFailClosed bool            `json:"latencyBudgetFailClosed,omitempty"` // 有检查因为超过预算没有执行时拒绝请求, 默认放行
+
+	DenialCauses bool `json:"denialCauses,omitempty"` // 拒绝时在 status.details.causes 中逐条列出每个检查的拒绝原因和字段
+
+	CheckReasons map[string]metav1.StatusReason `json:"checkReasons,omitempty"` // 按检查名字覆盖拒绝的原因, 比如 registries: Forbidden
+	EnforceAfter map[string]time.Time           `json:"enforceAfter,omitempty"` // 按检查名字配置的生效时间, 在这之前检查不通过只产生警告
+
+	WhiteListRegistries []string `json:"whiteListRegistries,omitempty"` // 白名单的镜像仓库列表
+	AllowedImages       []string `json:"allowedImages,omitempty"`       // 例外的镜像, 不管来自哪个仓库都允许, 支持通配符
+
+	RegistryAliases           map[string]string `json:"registryAliases,omitempty"`           // 仓库的别名, 比如 myhub.local: registry.corp.com, 匹配白名单之前替换, 别名必须是仓库域名
+	DefaultRegistryNamespaces map[string]string `json:"defaultRegistryNamespaces,omitempty"` // 仓库的默认命名空间, 类似 docker.io 的 library, 匹配白名单之前补上
+
+	RegistryTiers        map[string]int `json:"registryTiers,omitempty"`        // 仓库前缀的信任等级, 1 最可信, 比如 registry.corp.com: 1, docker.io: 3
+	RequiredRegistryTier int            `json:"requiredRegistryTier,omitempty"` // 镜像所在仓库至少需要的信任等级, 为 0 时不检查, 一般只在生产命名空间配置
+
+	TemporaryRegistries []TemporaryRegistry `json:"temporaryRegistries,omitempty"` // 迁移期间临时允许的仓库, 到期之后拒绝
+
+	DenyDockerHub   bool   `json:"denyDockerHub,omitempty"`   // 拒绝来自 docker.io 的镜像, 包括 nginx 这样省略了仓库的镜像
+	DockerHubMirror string `json:"dockerHubMirror,omitempty"` // 内部的 docker.io 镜像仓库, 拒绝时提示用户改用
+
+	AllowedBaseImages []string `json:"allowedBaseImages,omitempty"` // 允许的基础镜像, 为空时不检查
+	BaseImageLabel    string   `json:"baseImageLabel,omitempty"`    // 记录基础镜像的 label, 默认 base-image
+
+	MinReplicas          int32 `json:"minReplicas,omitempty"`          // Deployment 的最小副本数, 为 0 时不检查
+	RequireRollingUpdate bool  `json:"requireRollingUpdate,omitempty"` // Deployment 必须使用 RollingUpdate 策略
+	RequireCronJobGuards bool  `json:"requireCronJobGuards,omitempty"` // CronJob 必须禁止并发执行, 并设置 startingDeadlineSeconds 和 activeDeadlineSeconds
+
+	RequireJobGuards   bool  `json:"requireJobGuards,omitempty"`   // Job 必须设置 activeDeadlineSeconds, 并且 backoffLimit 不超过 MaxJobBackoffLimit
+	MaxJobBackoffLimit int32 `json:"maxJobBackoffLimit,omitempty"` // Job 允许的最大 backoffLimit, 为 0 时使用 Kubernetes 的默认值 6
+
+	MaxRevisionHistoryLimit int32 `json:"maxRevisionHistoryLimit,omitempty"` // Deployment/StatefulSet 必须设置 revisionHistoryLimit 且不超过该值, 为 0 时不检查
+
+	RequirePDB              Action `json:"requirePDB,omitempty"`              // 创建 Deployment 时必须已有匹配的 PodDisruptionBudget
+	CheckPDBCompatibility   Action `json:"checkPDBCompatibility,omitempty"`   // Deployment 的 maxUnavailable 不能超过匹配的 PodDisruptionBudget 允许的中断数
+	RequireNetworkPolicy    Action `json:"requireNetworkPolicy,omitempty"`    // 创建 pod 时命名空间中必须已有 NetworkPolicy
+	RequireLimitRange       Action `json:"requireLimitRange,omitempty"`       // 创建 pod 时命名空间中必须已有提供容器默认 requests/limits 的 LimitRange
+	RequireConfigReferences Action `json:"requireConfigReferences,omitempty"` // pod 引用的 ConfigMap/Secret 必须已经存在
+	RequireHPARequests      Action `json:"requireHPARequests,omitempty"`      // 按资源使用率扩缩容的 HPA, 目标工作负载必须声明对应资源的 requests
+	RequireHeadlessService  Action `json:"requireHeadlessService,omitempty"`  // StatefulSet 的 serviceName 必须是已经存在的 headless Service
+
+	LookupFailClosed bool `json:"lookupFailClosed,omitempty"` // 查询集群状态失败时拒绝请求, 默认放行
+
+	SystemNamespaces           []string `json:"systemNamespaces,omitempty"`           // 系统命名空间, 默认只有 kube-system
+	DenyControlPlaneScheduling bool     `json:"denyControlPlaneScheduling,omitempty"` // 禁止非系统命名空间的 pod 调度到控制平面节点
+	RegulatedZones             []string `json:"regulatedZones,omitempty"`             // 带有 compliance: regulated 标签的 pod 允许的可用区
+	ForbiddenNodes             []string `json:"forbiddenNodes,omitempty"`             // 不允许通过 nodeName 直接指定的节点
+
+	AllowedSchedulers []string `json:"allowedSchedulers,omitempty"` // 允许的 spec.schedulerName, 没有指定时视为 default-scheduler, 需要默认调度器时要列出来
+
+	RequireExplicitTagOrDigest bool   `json:"requireExplicitTagOrDigest,omitempty"` // 镜像必须显式指定 tag 或 digest
+	FloatingTags               Action `json:"floatingTags,omitempty"`               // 使用浮动 tag (比如 1.2 同时存在 1.2.3) 时的处理方式, 一般只在生产命名空间配置
+	AllowedTagPattern          string `json:"allowedTagPattern,omitempty"`          // 镜像 tag 需要完整匹配的正则, 比如 release-.*
+	TagPatternForDigests       bool   `json:"tagPatternForDigests,omitempty"`       // 使用 digest 的镜像同样检查 tag, 默认不检查
+
+	ArchTagPatterns map[string]string `json:"archTagPatterns,omitempty"` // 每种节点架构的镜像 tag 需要完整匹配的正则, 比如 arm64: .*-arm64, 加载配置时编译
+
+	Region           string              `json:"region,omitempty"`           // 集群所在的地域
+	RegionRegistries map[string][]string `json:"regionRegistries,omitempty"` // 每个地域的镜像仓库前缀
+
+	MaxImageSize      int64           `json:"maxImageSize,omitempty"`      // 镜像压缩后的最大字节数, 为 0 时不检查
+	MaxImageAge       metav1.Duration `json:"maxImageAge,omitempty"`       // 镜像构建之后最多可以使用多久, 比如 2160h, 一般只在生产命名空间配置
+	InspectFailClosed bool            `json:"inspectFailClosed,omitempty"` // 查询镜像元数据失败时拒绝请求, 默认放行
+
+	VulnerabilityThreshold string `json:"vulnerabilityThreshold,omitempty"` // 拒绝存在该级别及以上漏洞的镜像, 比如 HIGH, 为空时不检查
+	ScanFailClosed         bool   `json:"scanFailClosed,omitempty"`         // 查询扫描结果失败时拒绝请求, 默认放行
+
+	MaxScanAge metav1.Duration `json:"maxScanAge,omitempty"` // 镜像最近一次漏洞扫描最多是多久之前, 比如 168h, 没有扫描过的镜像同样拒绝
+
+	RequireLockedImages bool `json:"requireLockedImages,omitempty"` // 只允许 digest 在镜像锁文件中的镜像, 一般只在生产命名空间配置
+
+	RequireSBOM bool `json:"requireSBOM,omitempty"` // 镜像必须带有 SBOM attestation, 查询失败时拒绝, 用于合规命名空间
+
+	SensitiveEnvPatterns []string `json:"sensitiveEnvPatterns,omitempty"` // 敏感环境变量名的正则, 比如 .*PASSWORD.*, 这些变量不能明文设置
+	DenySecretEnv        bool     `json:"denySecretEnv,omitempty"`        // 不允许把 Secret 注入环境变量, 只能以卷的方式挂载, 用于严格的命名空间
+
+	RedactEnvPatterns []string `json:"redactEnvPatterns,omitempty"` // 调试日志中隐藏 value 的环境变量名的正则, 默认隐藏 PASSWORD/SECRET/TOKEN/KEY
+	RedactAnnotations []string `json:"redactAnnotations,omitempty"` // 调试日志中隐藏值的注解
+
+	RequiredAnnotations map[string]string `json:"requiredAnnotations,omitempty"` // pod 必须带有的注解, value 为注解值需要匹配的正则
+	RequiredLabels      map[string]string `json:"requiredLabels,omitempty"`      // pod 必须带有的标签, value 为标签值需要匹配的正则, 加载配置时编译
+
+	DisableAutomountServiceAccountToken bool `json:"disableAutomountServiceAccountToken,omitempty"` // mutate 时默认设置 automountServiceAccountToken: false
+	MutateWarnings                      bool `json:"mutateWarnings,omitempty"`                      // mutate 时执行所有检查, 把不通过的原因作为警告返回
+
+	AllowedStorageClasses []string `json:"allowedStorageClasses,omitempty"` // pod 引用的 PVC 允许使用的 StorageClass
+	MaxPVCSize            string   `json:"maxPVCSize,omitempty"`            // PVC 最多可以申请的容量, 比如 100Gi
+	AllowedAccessModes    []string `json:"allowedAccessModes,omitempty"`    // PVC 允许使用的访问模式, 比如 ReadWriteOnce
+	AllowedVolumeTypes    []string `json:"allowedVolumeTypes,omitempty"`    // pod 允许使用的卷类型, 比如 configMap、secret、emptyDir、persistentVolumeClaim
+
+	RequireEmptyDirSizeLimit bool   `json:"requireEmptyDirSizeLimit,omitempty"` // emptyDir 必须设置 sizeLimit
+	MaxEmptyDirSize          string `json:"maxEmptyDirSize,omitempty"`          // 使用节点磁盘的 emptyDir 的 sizeLimit 上限, 比如 10Gi
+	MaxMemoryEmptyDirSize    string `json:"maxMemoryEmptyDirSize,omitempty"`    // medium 为 Memory 的 emptyDir 的 sizeLimit 上限
+
+	RequireEncryptedStorage bool   `json:"requireEncryptedStorage,omitempty"` // pod 引用的 PVC 必须使用加密的 StorageClass, 用于受监管的命名空间
+	EncryptedStorageKey     string `json:"encryptedStorageKey,omitempty"`     // StorageClass 的参数或注解, 值为 true 表示加密, 默认 encrypted
+
+	RestrictTokenMounts bool     `json:"restrictTokenMounts,omitempty"` // 限制 ServiceAccount token 的挂载, 用于敏感命名空间
+	TokenMountPaths     []string `json:"tokenMountPaths,omitempty"`     // 允许挂载 token 的目录前缀, 默认 /var/run/secrets/
+
+	DeniedSecretTypes []string `json:"deniedSecretTypes,omitempty"` // 不允许创建的 Secret 类型, 比如 kubernetes.io/basic-auth
+
+	DenyServiceAccountAutomount bool `json:"denyServiceAccountAutomount,omitempty"` // 不允许 ServiceAccount 设置 automountServiceAccountToken: true
+
+	GPUWhiteListRegistries []string `json:"gpuWhiteListRegistries,omitempty"` // 申请 GPU 的容器允许使用的镜像
+	ValidateGPUResources   bool     `json:"validateGPUResources,omitempty"`   // 申请 GPU 的容器的 request 和 limit 必须相等
+
+	DenyEmptyPods     bool `json:"denyEmptyPods,omitempty"`     // 拒绝没有普通容器的 pod
+	AllowInitOnlyPods bool `json:"allowInitOnlyPods,omitempty"` // 开启 DenyEmptyPods 时仍然允许只有 init 容器的 pod
+
+	MaxInitContainers      int  `json:"maxInitContainers,omitempty"`      // init 容器的最大数量, 为 0 时不限制
+	ValidateContainerNames bool `json:"validateContainerNames,omitempty"` // 容器名不能重复, 并且必须是合法的 DNS label
+
+	InitContainerOrder []string `json:"initContainerOrder,omitempty"` // init 容器名前缀的顺序, 名字匹配靠前前缀的 init 容器必须排在前面, 不匹配任何前缀的排在最后
+
+	AllowedContainerPorts []string `json:"allowedContainerPorts,omitempty"` // 非系统命名空间允许的 containerPort, 比如 "8080" 或 "1024-65535"
+	DenyHostPorts         bool     `json:"denyHostPorts,omitempty"`         // 非系统命名空间不允许使用 hostPort, AllowedHostPorts 中的除外
+	AllowedHostPorts      []string `json:"allowedHostPorts,omitempty"`      // 允许的 hostPort, 格式同 allowedContainerPorts
+
+	MaxTerminationGracePeriodSeconds int64 `json:"maxTerminationGracePeriodSeconds,omitempty"` // terminationGracePeriodSeconds 的上限, 为 0 时不限制
+	MinTerminationGracePeriodSeconds int64 `json:"minTerminationGracePeriodSeconds,omitempty"` // terminationGracePeriodSeconds 的下限, 为 0 时不限制
+
+	LockImages bool `json:"lockImages,omitempty"` // UPDATE 时不允许修改镜像, 除非带有审批注解
+
+	DenyNodePortServices     bool `json:"denyNodePortServices,omitempty"`     // 不允许 NodePort 类型的 Service, 用于受限的命名空间
+	DenyLoadBalancerServices bool `json:"denyLoadBalancerServices,omitempty"` // 不允许 LoadBalancer 类型的 Service
+
+	RequireIngressTLS     bool     `json:"requireIngressTLS,omitempty"`     // Ingress 的每个域名都必须配置 TLS
+	AllowedIngressDomains []string `json:"allowedIngressDomains,omitempty"` // Ingress 允许使用的域名后缀, 比如 example.com
+
+	FieldRules []FieldRule `json:"fieldRules,omitempty"` // 按字段路径的检查, 对任意类型的对象生效
+
+	DeprecatedFields    Action   `json:"deprecatedFields,omitempty"`    // pod 使用废弃的字段或注解时的处理方式, 比如 spec.serviceAccount
+	IgnoredDeprecations []string `json:"ignoredDeprecations,omitempty"` // 跳过的废弃写法检测, 比如 seccomp-annotations
+
+	RequireSeccompProfile  bool     `json:"requireSeccompProfile,omitempty"`  // 容器必须设置 seccomp profile, 可以继承 pod 级别的配置
+	AllowedSeccompProfiles []string `json:"allowedSeccompProfiles,omitempty"` // 允许的 profile 类型, 默认 RuntimeDefault 和 Localhost
+
+	RequireAppArmorProfile bool `json:"requireAppArmorProfile,omitempty"` // 容器必须通过注解使用 runtime/default 或 localhost 的 AppArmor profile
+
+	ForbiddenUIDs []string `json:"forbiddenUIDs,omitempty"` // 容器不能使用的 runAsUser, 比如 "0" 或 "1-999"
+
+	DenySecurityContextDowngrade bool `json:"denySecurityContextDowngrade,omitempty"` // 容器的 securityContext 不能覆盖掉 pod 级别更严格的 runAsNonRoot/runAsUser/runAsGroup/seccompProfile
+
+	RestrictSysctls      bool     `json:"restrictSysctls,omitempty"`      // 限制 pod 设置的 sysctl, 默认只允许 kubelet 的 safe sysctl
+	DeniedSysctls        []string `json:"deniedSysctls,omitempty"`        // 禁止的 sysctl, 支持通配符, 比如 kernel.shm*
+	AllowedUnsafeSysctls []string `json:"allowedUnsafeSysctls,omitempty"` // 额外允许的 unsafe sysctl, 支持通配符
+
+	RequireReadOnlyRootFS bool `json:"requireReadOnlyRootFS,omitempty"` // 容器必须使用只读的根文件系统, 可以通过注解豁免
+
+	AllowedRuntimeClasses   []string `json:"allowedRuntimeClasses,omitempty"`   // 允许的 runtimeClassName, 为空时不检查
+	DenyDefaultRuntimeClass bool     `json:"denyDefaultRuntimeClass,omitempty"` // 不允许没有设置 runtimeClassName 的 pod
+	ValidatePodOverhead     bool     `json:"validatePodOverhead,omitempty"`     // pod 声明的 overhead 必须和 RuntimeClass 中的一致
+
+	RequirePriorityClass   bool     `json:"requirePriorityClass,omitempty"`   // 非系统命名空间的 pod 必须设置 priorityClassName
+	AllowedPriorityClasses []string `json:"allowedPriorityClasses,omitempty"` // 允许的 priorityClassName, 为空时不限制
+
+	RequiredQoSClass corev1.PodQOSClass `json:"requiredQoSClass,omitempty"` // pod 必须是这个 QoS 等级, 比如 Guaranteed, 为空时不检查
+
+	NamespaceCPUBudget    string `json:"namespaceCPUBudget,omitempty"`    // 命名空间中所有 pod 的 CPU request 总和上限, 比如 "16"
+	NamespaceMemoryBudget string `json:"namespaceMemoryBudget,omitempty"` // 命名空间中所有 pod 的内存 request 总和上限, 比如 "64Gi"
+
+	explicit map[string]bool // 配置文件中写出的字段, key 为小写的 json 名字, 用于区分没有配置和配置成零值
+}
+
+// UnmarshalJSON 在解析字段的同时记录配置中写出了哪些字段
+func (p *Policy) UnmarshalJSON(data []byte) error {
+	type plain Policy
+	if err := json.Unmarshal(data, (*plain)(p)); err != nil {
+		return err
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	p.explicit = make(map[string]bool, len(fields))
+	for key := range fields {
+		p.explicit[strings.ToLower(key)] = true
+	}
+	return nil
+}
+
+func (p *Policy) systemNamespaces() []string {
+	if len(p.SystemNamespaces) == 0 {
+		return []string{"kube-system"}
+	}
+	return p.SystemNamespaces
+}
+
+// 默认直接放行的子资源, 这些请求中没有可以检查的对象
+var defaultSkippedSubResources = []string{"exec", "attach", "portforward", "log"}
+
+func (p *Policy) skippedSubResources() []string {
+	if len(p.SkippedSubResources) == 0 {
+		return defaultSkippedSubResources
+	}
+	return p.SkippedSubResources
+}
+
+func (p *Policy) isSystemNamespace(namespace string) bool {
+	return containsString(p.systemNamespaces(), namespace)
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Action 检查不通过时的处理方式, 为空时不做检查
+type Action string
+
+const (
+	ActionWarn Action = "warn" // 只返回警告
+	ActionDeny Action = "deny" // 拒绝请求
+)
+
+// violation 按照 Action 构造检查不通过的结果
+func (a Action) violation(code int, message string) *denial {
+	return &denial{
+		code:    code,
+		message: message,
+		warning: a != ActionDeny,
+	}
+}
+
+// Config webhook 的策略配置
+// Base 是所有命名空间都继承的基础策略, Namespaces 是按命名空间叠加的覆盖策略
+type Config struct {
+	PolicyVersion string `json:"policyVersion,omitempty"` // 策略版本, 会记录在每个请求的审计信息中
+	LogVerbosity  *int   `json:"logVerbosity,omitempty"`  // klog 的日志级别, 同 -v, 可以通过 SIGHUP 重新加载配置在运行时修改, 不写时保持当前的级别
+
+	Base       Policy            `json:"base"`
+	Namespaces map[string]Policy `json:"namespaces,omitempty"`
+
+	OptInPolicies map[string]Policy `json:"optInPolicies,omitempty"` // 命名空间通过注解 admission-registry/enforce-<名字>: "true" 自己开启的策略
+
+	ImageLockFile string          `json:"imageLockFile,omitempty"` // 镜像锁文件, 相对路径相对于配置文件所在的目录, 和配置一起重新加载
+	lockedDigests map[string]bool // 锁文件中的 digest
+}
+
+// AddWhiteListRegistries 把仓库追加到基础策略的白名单中, 和配置文件中的白名单一样规范化, 用于环境变量 WHITELIST_REGISTRIES
+func (c *Config) AddWhiteListRegistries(registries []string) {
+	c.Base.WhiteListRegistries = append(c.Base.WhiteListRegistries, normalizeRegistryPrefixes(registries)...)
+}
+
+// LoadConfig 从文件中加载配置, 支持 yaml 和 json
+func LoadConfig(path string) (*Config, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var config Config
+	if err := yaml.Unmarshal(data, &config); err != nil {
+		return nil, err
+	}
+	if err := validatePolicy("base", &config.Base); err != nil {
+		return nil, err
+	}
+	for namespace, policy := range config.Namespaces {
+		if err := validatePolicy("namespaces."+namespace, &policy); err != nil {
+			return nil, err
+		}
+		config.Namespaces[namespace] = policy
+	}
+	for name, policy := range config.OptInPolicies {
+		if err := validatePolicy("optInPolicies."+name, &policy); err != nil {
+			return nil, err
+		}
+		if err := validateOptInPolicy(name, &policy); err != nil {
+			return nil, err
+		}
+		config.OptInPolicies[name] = policy
+	}
+	if config.ImageLockFile != "" {
+		lockFile := config.ImageLockFile
+		if !filepath.IsAbs(lockFile) {
+			lockFile = filepath.Join(filepath.Dir(path), lockFile)
+		}
+		if config.lockedDigests, err = LoadImageLock(lockFile); err != nil {
+			return nil, fmt.Errorf("imageLockFile: %v", err)
+		}
+	}
+	return &config, nil
+}
+
+// validatePolicy 在加载时检查策略中的正则和取值, 配置错误时启动(或者重新加载)失败, 而不是每个请求都拒绝或者放行
+func validatePolicy(name string, policy *Policy) error {
+	for key, pattern := range policy.RequiredLabels {
+		if _, err := compilePattern(pattern); err != nil {
+			return fmt.Errorf("%s.requiredLabels.%s: %v", name, key, err)
+		}
+	}
+	for arch, pattern := range policy.ArchTagPatterns {
+		if _, err := compilePattern(pattern); err != nil {
+			return fmt.Errorf("%s.archTagPatterns.%s: %v", name, arch, err)
+		}
+	}
+	for key, pattern := range policy.RequiredAnnotations {
+		if _, err := compilePattern(pattern); err != nil {
+			return fmt.Errorf("%s.requiredAnnotations.%s: %v", name, key, err)
+		}
+	}
+	if _, err := compilePattern(policy.AllowedTagPattern); err != nil {
+		return fmt.Errorf("%s.allowedTagPattern: %v", name, err)
+	}
+	for _, patterns := range []struct {
+		field string
+		items []string
+	}{
+		{"sensitiveEnvPatterns", policy.SensitiveEnvPatterns},
+		{"redactEnvPatterns", policy.RedactEnvPatterns},
+	} {
+		for i, pattern := range patterns.items {
+			if _, err := compilePattern(pattern); err != nil {
+				return fmt.Errorf("%s.%s[%d]: %v", name, patterns.field, i, err)
+			}
+		}
+	}
+	for i, exemption := range policy.ExemptUsers {
+		if _, err := compilePattern(exemption.Username); err != nil {
+			return fmt.Errorf("%s.exemptUsers[%d].username: %v", name, i, err)
+		}
+	}
+	for alias := range policy.RegistryAliases {
+		if !isRegistryHost(alias) {
+			return fmt.Errorf("%s.registryAliases.%s: not a registry host, images like %s/app are pulled from docker.io", name, alias, alias)
+		}
+	}
+	// 白名单按前缀匹配规范化之后的镜像地址, 前缀同样要规范化, nginx 和 library/nginx 这样的简写才能匹配
+	policy.WhiteListRegistries = normalizeRegistryPrefixes(policy.WhiteListRegistries)
+	policy.GPUWhiteListRegistries = normalizeRegistryPrefixes(policy.GPUWhiteListRegistries)
+	for region, registries := range policy.RegionRegistries {
+		policy.RegionRegistries[region] = normalizeRegistryPrefixes(registries)
+	}
+	for i := range policy.TemporaryRegistries {
+		policy.TemporaryRegistries[i].Registry = normalizeRegistryPrefix(policy.TemporaryRegistries[i].Registry)
+	}
+	if len(policy.RegistryTiers) > 0 {
+		tiers := make(map[string]int, len(policy.RegistryTiers))
+		for registry, tier := range policy.RegistryTiers {
+			normalized := normalizeRegistryPrefix(registry)
+			if _, ok := tiers[normalized]; ok {
+				return fmt.Errorf("%s.registryTiers.%s: duplicates another registry after normalization (%s)", name, registry, normalized)
+			}
+			tiers[normalized] = tier
+		}
+		policy.RegistryTiers = tiers
+	}
+	for i, rule := range policy.FieldRules {
+		if err := rule.validate(); err != nil {
+			return fmt.Errorf("%s.fieldRules[%d]: %v", name, i, err)
+		}
+	}
+	for _, size := range []struct{ field, value string }{
+		{"maxEmptyDirSize", policy.MaxEmptyDirSize},
+		{"maxMemoryEmptyDirSize", policy.MaxMemoryEmptyDirSize},
+	} {
+		if size.value == "" {
+			continue
+		}
+		if _, err := resource.ParseQuantity(size.value); err != nil {
+			return fmt.Errorf("%s.%s: %v", name, size.field, err)
+		}
+	}
+	for i, item := range policy.AllowedContainerPorts {
+		if _, _, err := parsePortRange(item); err != nil {
+			return fmt.Errorf("%s.allowedContainerPorts[%d]: invalid port %q: %v", name, i, item, err)
+		}
+	}
+	for i, item := range policy.AllowedHostPorts {
+		if _, _, err := parsePortRange(item); err != nil {
+			return fmt.Errorf("%s.allowedHostPorts[%d]: invalid port %q: %v", name, i, item, err)
+		}
+	}
+	for i, item := range policy.ForbiddenUIDs {
+		if _, _, err := parseUIDRange(item); err != nil {
+			return fmt.Errorf("%s.forbiddenUIDs[%d]: invalid UID %q: %v", name, i, item, err)
+		}
+	}
+	if policy.VulnerabilityThreshold != "" && !validSeverity(policy.VulnerabilityThreshold) {
+		return fmt.Errorf("%s.vulnerabilityThreshold: unknown severity %q, must be one of %v",
+			name, policy.VulnerabilityThreshold, vulnerabilitySeverities)
+	}
+	return nil
+}
+
+// resolvePolicy 计算某个命名空间最终生效的策略
+// 合并规则:
+//   - 列表(比如白名单)取并集, 基础策略的元素在前, 命名空间的元素在后, 重复的只保留一个
+//   - map 合并, key 相同时以命名空间的值为准
+//   - 其它标量字段, 命名空间的配置中写出了这个字段时覆盖基础策略, 包括 false 和 0, 所以命名空间可以关闭开关或者取消上限;
+//     没有经过 LoadConfig 的策略无法区分没有配置和零值, 只有非零值才会覆盖
+//
+// 合并的结果按命名空间缓存, 配置变更(SetConfig)时重新计算; 返回的策略中的列表和 map 是共享的, 不能修改
+func (s *WebhookServer) resolvePolicy(namespace string) Policy {
+	cache := s.policyCache()
+	if policy, ok := cache.namespaces[namespace]; ok {
+		return policy
+	}
+	return cache.base
+}
+
+// policyCache 返回当前配置对应的策略缓存, 第一次调用时才计算
+func (s *WebhookServer) policyCache() *policyCache {
+	s.policyMu.RLock()
+	cache := s.policies
+	s.policyMu.RUnlock()
+	if cache != nil {
+		return cache
+	}
+	s.policyMu.Lock()
+	defer s.policyMu.Unlock()
+	if s.policies == nil {
+		s.policies = buildPolicyCache(&s.Config)
+	}
+	return s.policies
+}
+
+// policyCache 预先合并好的策略, 没有单独配置的命名空间都使用基础策略
+type policyCache struct {
+	base       Policy
+	namespaces map[string]Policy
+	optIns     map[string]Policy // 命名空间可以通过注解开启的策略, 不和基础策略合并
+}
+
+func buildPolicyCache(config *Config) *policyCache {
+	cache := &policyCache{
+		base:       mergePolicy(config.Base, Policy{}),
+		namespaces: make(map[string]Policy, len(config.Namespaces)),
+		optIns:     config.OptInPolicies,
+	}
+	for namespace, override := range config.Namespaces {
+		cache.namespaces[namespace] = mergePolicy(config.Base, override)
+	}
+	return cache
+}
+
+// SetConfig 替换策略配置, 用于运行时重新加载配置, 可以和请求的处理并发调用
+func (s *WebhookServer) SetConfig(config Config) {
+	cache := buildPolicyCache(&config)
+	s.policyMu.Lock()
+	s.Config = config
+	s.policies = cache
+	s.policyMu.Unlock()
+}
+
+// policyVersion 返回当前配置的策略版本
+func (s *WebhookServer) policyVersion() string {
+	s.policyMu.RLock()
+	defer s.policyMu.RUnlock()
+	return s.Config.PolicyVersion
+}
+
+func mergePolicy(base, override Policy) Policy {
+	var merged Policy
+	mergeValue(reflect.ValueOf(&merged).Elem(), reflect.ValueOf(base), reflect.ValueOf(override))
+	mergeExplicit(reflect.ValueOf(&merged).Elem(), reflect.ValueOf(override), override.explicit)
+	return merged
+}
+
+// mergeExplicit 用 override 中显式配置的标量字段覆盖 dst, 即使是零值; 列表和 map 仍然只能追加, 不能删除元素
+func mergeExplicit(dst, override reflect.Value, explicit map[string]bool) {
+	if len(explicit) == 0 {
+		return
+	}
+	for i := 0; i < dst.NumField(); i++ {
+		field := dst.Type().Field(i)
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		if !dst.Field(i).CanSet() || !explicit[strings.ToLower(name)] {
+			continue
+		}
+		if kind := field.Type.Kind(); kind != reflect.Slice && kind != reflect.Map {
+			dst.Field(i).Set(override.Field(i))
+		}
+	}
+}
+
+// mergeValue 按照 resolvePolicy 中描述的规则把 base 和 override 合并到 dst
+// 标量字段用零值表示"没有配置", 配置中显式写出的零值由 mergeExplicit 处理
+func mergeValue(dst, base, override reflect.Value) {
+	switch dst.Kind() {
+	case reflect.Struct:
+		for i := 0; i < dst.NumField(); i++ {
+			if !dst.Field(i).CanSet() {
+				continue
+			}
+			mergeValue(dst.Field(i), base.Field(i), override.Field(i))
+		}
+	case reflect.Slice:
+		if base.Len() == 0 && override.Len() == 0 {
+			return
+		}
+		merged := reflect.MakeSlice(dst.Type(), 0, base.Len()+override.Len())
+		for _, list := range []reflect.Value{base, override} {
+			for i := 0; i < list.Len(); i++ {
+				if !containsValue(merged, list.Index(i)) {
+					merged = reflect.Append(merged, list.Index(i))
+				}
+			}
+		}
+		dst.Set(merged)
+	case reflect.Map:
+		if base.Len() == 0 && override.Len() == 0 {
+			return
+		}
+		merged := reflect.MakeMapWithSize(dst.Type(), base.Len()+override.Len())
+		for _, m := range []reflect.Value{base, override} {
+			iter := m.MapRange()
+			for iter.Next() {
+				merged.SetMapIndex(iter.Key(), iter.Value())
+			}
+		}
+		dst.Set(merged)
+	default:
+		if !override.IsZero() {
+			dst.Set(override)
+		} else {
+			dst.Set(base)
+		}
+	}
+}
+
+func containsValue(list, v reflect.Value) bool {
+	for i := 0; i < list.Len(); i++ {
+		if reflect.DeepEqual(list.Index(i).Interface(), v.Interface()) {
+			return true
+		}
+	}
+	return false
+}
+
+var regexps sync.Map
+
+// matchPattern 判断 s 是否完整匹配正则 pattern, 编译后的正则会被缓存, 非法的正则视为不匹配
+func matchPattern(pattern, s string) bool {
+	re, err := compilePattern(pattern)
+	if err != nil {
+		klog.Errorf("Invalid pattern %q: %v", pattern, err)
+		return false
+	}
+	return re.MatchString(s)
+}
+
+// compilePattern 编译完整匹配的正则并缓存
+func compilePattern(pattern string) (*regexp.Regexp, error) {
+	if re, ok := regexps.Load(pattern); ok {
+		return re.(*regexp.Regexp), nil
+	}
+	compiled, err := regexp.Compile("^(?:" + pattern + ")$")
+	if err != nil {
+		return nil, err
+	}
+	re, _ := regexps.LoadOrStore(pattern, compiled)
+	return re.(*regexp.Regexp), nil
+}
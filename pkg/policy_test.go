@@ -0,0 +1,207 @@
+package pkg
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestMergePolicy(t *testing.T) {
+	tests := []struct {
+		name           string
+		base, override Policy
+		want           Policy
+	}{
+		{
+			name:     "lists are merged without duplicates",
+			base:     Policy{WhiteListRegistries: []string{"docker.io", "gcr.io"}},
+			override: Policy{WhiteListRegistries: []string{"gcr.io", "quay.io"}},
+			want:     Policy{WhiteListRegistries: []string{"docker.io", "gcr.io", "quay.io"}},
+		},
+		{
+			name:     "map keys from the override win",
+			base:     Policy{RequiredLabels: map[string]string{"app": ".+", "team": "a|b"}},
+			override: Policy{RequiredLabels: map[string]string{"team": "c"}},
+			want:     Policy{RequiredLabels: map[string]string{"app": ".+", "team": "c"}},
+		},
+		{
+			name:     "scalars set in the override win",
+			base:     Policy{MaxPVCSize: "100Gi", MaxInitContainers: 3},
+			override: Policy{MaxPVCSize: "1Ti", RequireEmptyDirSizeLimit: true},
+			want:     Policy{MaxPVCSize: "1Ti", MaxInitContainers: 3, RequireEmptyDirSizeLimit: true},
+		},
+		{
+			// 没有经过 LoadConfig 的策略中零值表示没有配置, 不会覆盖基础策略
+			name:     "zero values do not override",
+			base:     Policy{MaxPVCSize: "100Gi", MaxInitContainers: 3, RequireEmptyDirSizeLimit: true},
+			override: Policy{MaxPVCSize: "", MaxInitContainers: 0, RequireEmptyDirSizeLimit: false},
+			want:     Policy{MaxPVCSize: "100Gi", MaxInitContainers: 3, RequireEmptyDirSizeLimit: true},
+		},
+		{
+			name: "empty lists and maps stay nil",
+			want: Policy{},
+		},
+	}
+	for _, tt := range tests {
+		if got := mergePolicy(tt.base, tt.override); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestMergePolicyDoesNotShareBaseLists(t *testing.T) {
+	base := Policy{WhiteListRegistries: []string{"docker.io"}}
+	merged := mergePolicy(base, Policy{WhiteListRegistries: []string{"gcr.io"}})
+	merged.WhiteListRegistries[0] = "evil.example.com"
+	if base.WhiteListRegistries[0] != "docker.io" {
+		t.Error("merged policy shares its list with the base policy")
+	}
+}
+
+func TestResolvePolicy(t *testing.T) {
+	s := &WebhookServer{}
+	s.SetConfig(Config{
+		Base:       Policy{WhiteListRegistries: []string{"docker.io"}, MaxInitContainers: 3},
+		Namespaces: map[string]Policy{"team": {WhiteListRegistries: []string{"gcr.io"}, MaxInitContainers: 5}},
+	})
+	if got := s.resolvePolicy("team"); !reflect.DeepEqual(got.WhiteListRegistries, []string{"docker.io", "gcr.io"}) || got.MaxInitContainers != 5 {
+		t.Errorf("team: got whitelist %v, maxInitContainers %d", got.WhiteListRegistries, got.MaxInitContainers)
+	}
+	if got := s.resolvePolicy("other"); !reflect.DeepEqual(got.WhiteListRegistries, []string{"docker.io"}) || got.MaxInitContainers != 3 {
+		t.Errorf("other: got whitelist %v, maxInitContainers %d", got.WhiteListRegistries, got.MaxInitContainers)
+	}
+}
+
+func TestSetConfigReplacesCachedPolicies(t *testing.T) {
+	// 没有调用 SetConfig 时第一次请求按 Config 构建缓存
+	s := &WebhookServer{Config: Config{PolicyVersion: "v1", Base: Policy{WhiteListRegistries: []string{"docker.io"}}}}
+	if got := s.resolvePolicy("team"); !reflect.DeepEqual(got.WhiteListRegistries, []string{"docker.io"}) {
+		t.Errorf("before reload: got whitelist %v", got.WhiteListRegistries)
+	}
+	s.SetConfig(Config{
+		PolicyVersion: "v2",
+		Base:          Policy{WhiteListRegistries: []string{"quay.io"}},
+		Namespaces:    map[string]Policy{"team": {WhiteListRegistries: []string{"gcr.io"}}},
+	})
+	tests := []struct {
+		namespace string
+		want      []string
+	}{
+		{namespace: "team", want: []string{"quay.io", "gcr.io"}},
+		{namespace: "other", want: []string{"quay.io"}},
+	}
+	for _, tt := range tests {
+		if got := s.resolvePolicy(tt.namespace); !reflect.DeepEqual(got.WhiteListRegistries, tt.want) {
+			t.Errorf("%s after reload: got whitelist %v, want %v", tt.namespace, got.WhiteListRegistries, tt.want)
+		}
+	}
+	if version := s.policyVersion(); version != "v2" {
+		t.Errorf("got policy version %q after reload, want v2", version)
+	}
+}
+
+func TestSetConfigConcurrentWithRequests(t *testing.T) {
+	s := &WebhookServer{}
+	s.SetConfig(Config{Base: Policy{WhiteListRegistries: []string{"docker.io"}}})
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if got := s.resolvePolicy("team"); len(got.WhiteListRegistries) != 1 {
+					t.Errorf("got whitelist %v during reload", got.WhiteListRegistries)
+					return
+				}
+				s.policyVersion()
+			}
+		}()
+	}
+	for i := 0; i < 100; i++ {
+		s.SetConfig(Config{PolicyVersion: fmt.Sprint(i), Base: Policy{WhiteListRegistries: []string{"docker.io"}}})
+	}
+	wg.Wait()
+}
+
+func TestResolvePolicyExplicitOverrides(t *testing.T) {
+	config, err := loadTestConfig(t, `
+base:
+  whiteListRegistries: [docker.io]
+  requireEmptyDirSizeLimit: true
+  maxInitContainers: 3
+  maxPVCSize: 100Gi
+namespaces:
+  relaxed:
+    requireEmptyDirSizeLimit: false
+    maxInitContainers: 0
+  stricter:
+    maxPVCSize: 10Gi
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &WebhookServer{}
+	s.SetConfig(*config)
+	tests := []struct {
+		namespace         string
+		wantEmptyDirLimit bool
+		wantInit          int
+		wantPVCSize       string
+	}{
+		// 显式写出的 false 和 0 覆盖基础策略
+		{namespace: "relaxed", wantInit: 0, wantPVCSize: "100Gi"},
+		{namespace: "stricter", wantEmptyDirLimit: true, wantInit: 3, wantPVCSize: "10Gi"},
+		{namespace: "other", wantEmptyDirLimit: true, wantInit: 3, wantPVCSize: "100Gi"},
+	}
+	for _, tt := range tests {
+		got := s.resolvePolicy(tt.namespace)
+		if got.RequireEmptyDirSizeLimit != tt.wantEmptyDirLimit || got.MaxInitContainers != tt.wantInit || got.MaxPVCSize != tt.wantPVCSize {
+			t.Errorf("%s: got requireEmptyDirSizeLimit %v, maxInitContainers %d, maxPVCSize %s, want %v, %d, %s", tt.namespace,
+				got.RequireEmptyDirSizeLimit, got.MaxInitContainers, got.MaxPVCSize, tt.wantEmptyDirLimit, tt.wantInit, tt.wantPVCSize)
+		}
+		if !reflect.DeepEqual(got.WhiteListRegistries, []string{"docker.io"}) {
+			t.Errorf("%s: whitelist %v changed", tt.namespace, got.WhiteListRegistries)
+		}
+	}
+}
+
+func TestApplyOptInIgnoresExplicitZeroValues(t *testing.T) {
+	config, err := loadTestConfig(t, `
+optInPolicies:
+  relax:
+    requireEmptyDirSizeLimit: false
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	merged := applyOptIn(Policy{RequireEmptyDirSizeLimit: true}, config.OptInPolicies["relax"])
+	if !merged.RequireEmptyDirSizeLimit {
+		t.Error("opt-in turned off a check enabled for the namespace")
+	}
+}
+
+func TestLoadConfigRejectsInvalidPatterns(t *testing.T) {
+	tests := []struct {
+		policy string
+		want   string
+	}{
+		{"requiredLabels: {team: \"web-(\"}", "base.requiredLabels.team"},
+		{"requiredAnnotations: {owner: \"[a-z\"}", "base.requiredAnnotations.owner"},
+		{"allowedTagPattern: \"release-(\"", "base.allowedTagPattern"},
+		{"sensitiveEnvPatterns: [\".*PASSWORD.*\", \"*TOKEN\"]", "base.sensitiveEnvPatterns[1]"},
+		{"redactEnvPatterns: [\"(KEY\"]", "base.redactEnvPatterns[0]"},
+		{"exemptUsers: [{username: \"system:serviceaccount:argocd:(\"}]", "base.exemptUsers[0].username"},
+	}
+	for _, tt := range tests {
+		_, err := loadTestConfig(t, "base:\n  "+tt.policy+"\n")
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s: got error %v, want error about %s", tt.policy, err, tt.want)
+		}
+	}
+	// 合法的正则可以正常加载
+	if _, err := loadTestConfig(t, "base:\n  allowedTagPattern: release-.*\n  exemptUsers: [{username: \"system:serviceaccount:argocd:.*\"}]\n"); err != nil {
+		t.Errorf("valid patterns: %v", err)
+	}
+}
@@ -0,0 +1,95 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog"
+)
+
+// checkContainerPorts 容器暴露的端口必须在 AllowedContainerPorts 中, 系统命名空间不做检查
+func checkContainerPorts(_ context.Context, policy *Policy, pod *corev1.Pod) *denial {
+	if len(policy.AllowedContainerPorts) == 0 || policy.isSystemNamespace(pod.Namespace) {
+		return nil
+	}
+	for _, container := range podContainers(pod) {
+		for _, port := range container.Ports {
+			if !portAllowed(port.ContainerPort, policy.AllowedContainerPorts) {
+				return &denial{
+					code: http.StatusForbidden,
+					message: fmt.Sprintf("container %s exposes port %d! Only ports %v are allowed.",
+						container.Name, port.ContainerPort, policy.AllowedContainerPorts),
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// checkHostPorts 限制容器使用的 hostPort, hostPort 会绕过 Service 并且容易在节点上冲突
+// 开启 DenyHostPorts 或者配置了 AllowedHostPorts 时, 只允许 AllowedHostPorts 中的 hostPort, 系统命名空间不做检查
+func checkHostPorts(_ context.Context, policy *Policy, pod *corev1.Pod) *denial {
+	if (!policy.DenyHostPorts && len(policy.AllowedHostPorts) == 0) || policy.isSystemNamespace(pod.Namespace) {
+		return nil
+	}
+	for _, container := range podContainers(pod) {
+		for _, port := range container.Ports {
+			if port.HostPort == 0 || portAllowed(port.HostPort, policy.AllowedHostPorts) {
+				continue
+			}
+			message := fmt.Sprintf("container %s requests hostPort %d! Host ports are not allowed.", container.Name, port.HostPort)
+			if len(policy.AllowedHostPorts) > 0 {
+				message = fmt.Sprintf("container %s requests hostPort %d! Only host ports %v are allowed.",
+					container.Name, port.HostPort, policy.AllowedHostPorts)
+			}
+			return &denial{
+				code:    http.StatusForbidden,
+				message: message,
+			}
+		}
+	}
+	return nil
+}
+
+// portAllowed 判断端口是否在允许的列表中, 列表中的每一项为单个端口(8080)或者范围(1024-65535)
+// LoadConfig 会拒绝非法的项, 没有经过 LoadConfig 的非法项会被忽略, 即不放行任何端口
+func portAllowed(port int32, allowed []string) bool {
+	for _, item := range allowed {
+		low, high, err := parsePortRange(item)
+		if err != nil {
+			klog.Errorf("Invalid allowed port %q: %v", item, err)
+			continue
+		}
+		if port >= low && port <= high {
+			return true
+		}
+	}
+	return false
+}
+
+// parsePortRange 解析单个端口或者端口范围, 端口必须在 1-65535 之间, 范围的下限不能大于上限
+func parsePortRange(item string) (int32, int32, error) {
+	parts := strings.SplitN(item, "-", 2)
+	low, err := strconv.ParseInt(strings.TrimSpace(parts[0]), 10, 32)
+	if err != nil {
+		return 0, 0, err
+	}
+	high := low
+	if len(parts) == 2 {
+		if high, err = strconv.ParseInt(strings.TrimSpace(parts[1]), 10, 32); err != nil {
+			return 0, 0, err
+		}
+	}
+	if low < 1 || high > 65535 {
+		return 0, 0, errors.New("ports must be between 1 and 65535")
+	}
+	if low > high {
+		return 0, 0, fmt.Errorf("range start %d is greater than its end %d", low, high)
+	}
+	return int32(low), int32(high), nil
+}
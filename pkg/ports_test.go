@@ -0,0 +1,88 @@
+package pkg
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// portPod 构造暴露 containerPort 和 hostPort 的 pod
+func portPod(namespace string, containerPort, hostPort int32) *corev1.Pod {
+	pod := imagePod("nginx")
+	pod.Namespace = namespace
+	pod.Spec.Containers[0].Ports = []corev1.ContainerPort{{ContainerPort: containerPort, HostPort: hostPort}}
+	return pod
+}
+
+func TestCheckContainerPorts(t *testing.T) {
+	allowed := []string{"8080", "9000-9100"}
+	tests := []struct {
+		name      string
+		namespace string
+		port      int32
+		policy    Policy
+		wantCode  int
+	}{
+		{name: "single port", port: 8080, policy: Policy{AllowedContainerPorts: allowed}},
+		{name: "range start", port: 9000, policy: Policy{AllowedContainerPorts: allowed}},
+		{name: "range end", port: 9100, policy: Policy{AllowedContainerPorts: allowed}},
+		{name: "outside range", port: 9101, policy: Policy{AllowedContainerPorts: allowed}, wantCode: http.StatusForbidden},
+		{name: "system namespace", namespace: "kube-system", port: 22, policy: Policy{AllowedContainerPorts: allowed}},
+		{name: "not configured", port: 22},
+	}
+	for _, tt := range tests {
+		if code := denialCode(checkContainerPorts(context.Background(), &tt.policy, portPod(tt.namespace, tt.port, 0))); code != tt.wantCode {
+			t.Errorf("%s: got code %d, want %d", tt.name, code, tt.wantCode)
+		}
+	}
+}
+
+func TestCheckHostPorts(t *testing.T) {
+	tests := []struct {
+		name      string
+		namespace string
+		hostPort  int32
+		policy    Policy
+		wantCode  int
+	}{
+		{name: "no host port", policy: Policy{DenyHostPorts: true}},
+		{name: "host ports denied", hostPort: 8080, policy: Policy{DenyHostPorts: true}, wantCode: http.StatusForbidden},
+		{name: "allowed host port", hostPort: 8080, policy: Policy{AllowedHostPorts: []string{"8000-8100"}}},
+		{name: "other host port", hostPort: 80, policy: Policy{AllowedHostPorts: []string{"8000-8100"}}, wantCode: http.StatusForbidden},
+		{name: "system namespace", namespace: "kube-system", hostPort: 80, policy: Policy{DenyHostPorts: true}},
+		{name: "not configured", hostPort: 80},
+	}
+	for _, tt := range tests {
+		if code := denialCode(checkHostPorts(context.Background(), &tt.policy, portPod(tt.namespace, 8080, tt.hostPort))); code != tt.wantCode {
+			t.Errorf("%s: got code %d, want %d", tt.name, code, tt.wantCode)
+		}
+	}
+}
+
+func TestParsePortRange(t *testing.T) {
+	tests := []struct {
+		item      string
+		low, high int32
+		wantErr   bool
+	}{
+		{item: "8080", low: 8080, high: 8080},
+		{item: "1024-65535", low: 1024, high: 65535},
+		{item: " 80 - 90 ", low: 80, high: 90},
+		{item: "0", wantErr: true},
+		{item: "1-70000", wantErr: true},
+		{item: "9000-8000", wantErr: true},
+		{item: "http", wantErr: true},
+	}
+	for _, tt := range tests {
+		low, high, err := parsePortRange(tt.item)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%q: got error %v, want error %v", tt.item, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && (low != tt.low || high != tt.high) {
+			t.Errorf("%q: got %d-%d, want %d-%d", tt.item, low, high, tt.low, tt.high)
+		}
+	}
+}
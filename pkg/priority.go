@@ -0,0 +1,31 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// checkPriorityClass pod 必须设置 priorityClassName, 配置了 AllowedPriorityClasses 时还必须在其中
+// 系统命名空间的 pod 不做检查
+func checkPriorityClass(_ context.Context, policy *Policy, pod *corev1.Pod) *denial {
+	if !policy.RequirePriorityClass || policy.isSystemNamespace(pod.Namespace) {
+		return nil
+	}
+	if pod.Spec.PriorityClassName == "" {
+		return &denial{
+			code:    http.StatusForbidden,
+			message: fmt.Sprintf("pod %s has no priorityClassName! It is required in namespace %s.", pod.Name, pod.Namespace),
+		}
+	}
+	if len(policy.AllowedPriorityClasses) > 0 && !containsString(policy.AllowedPriorityClasses, pod.Spec.PriorityClassName) {
+		return &denial{
+			code: http.StatusForbidden,
+			message: fmt.Sprintf("pod %s uses priority class %s! Only priority classes %v are allowed.",
+				pod.Name, pod.Spec.PriorityClassName, policy.AllowedPriorityClasses),
+		}
+	}
+	return nil
+}
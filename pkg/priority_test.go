@@ -0,0 +1,33 @@
+package pkg
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestCheckPriorityClass(t *testing.T) {
+	allowed := []string{"team-low", "team-high"}
+	tests := []struct {
+		name          string
+		namespace     string
+		priorityClass string
+		policy        Policy
+		wantCode      int
+	}{
+		{name: "allowed class", priorityClass: "team-high", policy: Policy{RequirePriorityClass: true, AllowedPriorityClasses: allowed}},
+		{name: "other class", priorityClass: "system-cluster-critical", policy: Policy{RequirePriorityClass: true, AllowedPriorityClasses: allowed}, wantCode: http.StatusForbidden},
+		{name: "missing", policy: Policy{RequirePriorityClass: true}, wantCode: http.StatusForbidden},
+		{name: "any class without an allowlist", priorityClass: "system-cluster-critical", policy: Policy{RequirePriorityClass: true}},
+		{name: "system namespace", namespace: "kube-system", policy: Policy{RequirePriorityClass: true}},
+		{name: "not required"},
+	}
+	for _, tt := range tests {
+		pod := imagePod("nginx")
+		pod.Namespace = tt.namespace
+		pod.Spec.PriorityClassName = tt.priorityClass
+		if code := denialCode(checkPriorityClass(context.Background(), &tt.policy, pod)); code != tt.wantCode {
+			t.Errorf("%s: got code %d, want %d", tt.name, code, tt.wantCode)
+		}
+	}
+}
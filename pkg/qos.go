@@ -0,0 +1,62 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// 决定 QoS 等级的资源
+var qosResources = []corev1.ResourceName{corev1.ResourceCPU, corev1.ResourceMemory}
+
+// checkQoSClass pod 的 QoS 等级必须是 RequiredQoSClass, 拒绝时说明是哪个容器导致的
+func checkQoSClass(_ context.Context, policy *Policy, pod *corev1.Pod) *denial {
+	if policy.RequiredQoSClass == "" {
+		return nil
+	}
+	class, reason := podQoSClass(pod)
+	if class == policy.RequiredQoSClass {
+		return nil
+	}
+	return &denial{
+		code: http.StatusForbidden,
+		message: fmt.Sprintf("pod %s has QoS class %s because %s, only %s pods are allowed in namespace %s.",
+			pod.Name, class, reason, policy.RequiredQoSClass, pod.Namespace),
+	}
+}
+
+// podQoSClass 按照 kubelet 的规则计算 pod 的 QoS 等级, 同时返回不是 Guaranteed 的原因
+//   - 所有容器都没有设置 CPU/内存的 requests 和 limits: BestEffort
+//   - 所有容器都设置了 CPU 和内存的 limits, 并且 requests 和 limits 相等: Guaranteed
+//   - 其它情况: Burstable
+func podQoSClass(pod *corev1.Pod) (corev1.PodQOSClass, string) {
+	reason := ""
+	bestEffort := true
+	for _, container := range podContainers(pod) {
+		for _, name := range qosResources {
+			request, hasRequest := container.Resources.Requests[name]
+			limit, hasLimit := container.Resources.Limits[name]
+			if hasRequest || hasLimit {
+				bestEffort = false
+			}
+			if reason != "" {
+				continue
+			}
+			switch {
+			case !hasLimit:
+				reason = fmt.Sprintf("container %s has no %s limit", container.Name, name)
+			case hasRequest && request.Cmp(limit) != 0:
+				reason = fmt.Sprintf("container %s requests %s %s but limits it to %s", container.Name, request.String(), name, limit.String())
+			}
+		}
+	}
+	if bestEffort {
+		return corev1.PodQOSBestEffort, "no container sets cpu or memory requests or limits"
+	}
+	if reason == "" {
+		return corev1.PodQOSGuaranteed, "every container's cpu and memory requests equal its limits"
+	}
+	return corev1.PodQOSBurstable, reason
+}
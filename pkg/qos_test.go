@@ -0,0 +1,83 @@
+package pkg
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// qosContainer 按照 "cpu/memory" 的格式生成容器的 requests 和 limits, 空字符串表示不设置
+func qosContainer(name, requests, limits string) corev1.Container {
+	parse := func(s string) corev1.ResourceList {
+		if s == "" {
+			return nil
+		}
+		parts := strings.Split(s, "/")
+		list := corev1.ResourceList{}
+		if parts[0] != "" {
+			list[corev1.ResourceCPU] = resource.MustParse(parts[0])
+		}
+		if len(parts) > 1 && parts[1] != "" {
+			list[corev1.ResourceMemory] = resource.MustParse(parts[1])
+		}
+		return list
+	}
+	return corev1.Container{Name: name, Resources: corev1.ResourceRequirements{Requests: parse(requests), Limits: parse(limits)}}
+}
+
+func TestPodQoSClass(t *testing.T) {
+	tests := []struct {
+		name       string
+		containers []corev1.Container
+		want       corev1.PodQOSClass
+		wantReason string
+	}{
+		{name: "no resources", containers: []corev1.Container{qosContainer("app", "", "")}, want: corev1.PodQOSBestEffort},
+		{name: "limits only", containers: []corev1.Container{qosContainer("app", "", "500m/1Gi")}, want: corev1.PodQOSGuaranteed},
+		{name: "requests equal limits", containers: []corev1.Container{qosContainer("app", "500m/1Gi", "0.5/1Gi")}, want: corev1.PodQOSGuaranteed},
+		{name: "requests below limits", containers: []corev1.Container{qosContainer("app", "250m/1Gi", "500m/1Gi")}, want: corev1.PodQOSBurstable, wantReason: "container app requests 250m cpu but limits it to 500m"},
+		{name: "missing memory limit", containers: []corev1.Container{qosContainer("app", "500m/1Gi", "500m")}, want: corev1.PodQOSBurstable, wantReason: "container app has no memory limit"},
+		{name: "one best effort container", containers: []corev1.Container{qosContainer("app", "500m/1Gi", "500m/1Gi"), qosContainer("sidecar", "", "")}, want: corev1.PodQOSBurstable, wantReason: "container sidecar has no cpu limit"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := &corev1.Pod{Spec: corev1.PodSpec{Containers: tt.containers}}
+			got, reason := podQoSClass(pod)
+			if got != tt.want {
+				t.Errorf("QoS class %s (%s), want %s", got, reason, tt.want)
+			}
+			if tt.wantReason != "" && reason != tt.wantReason {
+				t.Errorf("reason %q, want %q", reason, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestCheckQoSClass(t *testing.T) {
+	guaranteed := qosContainer("app", "500m/1Gi", "500m/1Gi")
+	tests := []struct {
+		name     string
+		required corev1.PodQOSClass
+		init     []corev1.Container
+		app      corev1.Container
+		wantCode int
+	}{
+		{name: "not configured", app: qosContainer("app", "", "")},
+		{name: "guaranteed", required: corev1.PodQOSGuaranteed, app: guaranteed},
+		{name: "burstable", required: corev1.PodQOSGuaranteed, app: qosContainer("app", "250m/1Gi", "500m/1Gi"), wantCode: http.StatusForbidden},
+		{name: "best effort init container", required: corev1.PodQOSGuaranteed, init: []corev1.Container{qosContainer("setup", "", "")}, app: guaranteed, wantCode: http.StatusForbidden},
+		{name: "best effort required", required: corev1.PodQOSBestEffort, app: guaranteed, wantCode: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := &corev1.Pod{Spec: corev1.PodSpec{InitContainers: tt.init, Containers: []corev1.Container{tt.app}}}
+			pod.Name, pod.Namespace = "web", "default"
+			d := checkQoSClass(context.Background(), &Policy{RequiredQoSClass: tt.required}, pod)
+			assertDenial(t, d, tt.wantCode, false)
+		})
+	}
+}
@@ -0,0 +1,63 @@
+package pkg
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+)
+
+// RecentDecisions 在内存中保存最近的 N 个准入决定, 用于排查问题时通过 /recent 直接查看, 不需要外部系统
+// 实现了 DecisionSink, 写满之后覆盖最早的决定
+type RecentDecisions struct {
+	mu   sync.Mutex
+	buf  []Decision
+	next int  // 下一个写入的位置
+	full bool // buf 是否已经写满过一轮
+}
+
+// NewRecentDecisions 创建保存最近 size 个决定的 RecentDecisions
+func NewRecentDecisions(size int) *RecentDecisions {
+	return &RecentDecisions{buf: make([]Decision, size)}
+}
+
+// Send 记录一个决定
+func (r *RecentDecisions) Send(d Decision) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.buf) == 0 {
+		return
+	}
+	r.buf[r.next] = d
+	r.next++
+	if r.next == len(r.buf) {
+		r.next = 0
+		r.full = true
+	}
+}
+
+// List 按时间顺序返回保存的决定, 最新的在最后
+func (r *RecentDecisions) List() []Decision {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.full {
+		return append([]Decision(nil), r.buf[:r.next]...)
+	}
+	decisions := make([]Decision, 0, len(r.buf))
+	decisions = append(decisions, r.buf[r.next:]...)
+	return append(decisions, r.buf[:r.next]...)
+}
+
+// RecentHandler 以 json 返回最近的准入决定, 和 /config 一样需要 Bearer ConfigToken
+// 没有配置 ConfigToken 或者没有开启 Recent 时关闭这个接口
+func (s *WebhookServer) RecentHandler(writer http.ResponseWriter, request *http.Request) {
+	if s.Recent == nil {
+		http.Error(writer, "recent endpoint is disabled", http.StatusNotFound)
+		return
+	}
+	if !s.authorizeToken(writer, request) {
+		return
+	}
+	writer.Header().Set("Content-Type", "application/json")
+	writer.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(writer).Encode(s.Recent.List())
+}
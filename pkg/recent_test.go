@@ -0,0 +1,93 @@
+package pkg
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/haozi4263/admission-registry/pkg/testutil"
+	admissionV1 "k8s.io/api/admission/v1"
+)
+
+// decisionUIDs 返回决定的 UID, 用于比较顺序
+func decisionUIDs(decisions []Decision) []string {
+	uids := make([]string, 0, len(decisions))
+	for _, d := range decisions {
+		uids = append(uids, d.UID)
+	}
+	return uids
+}
+
+func TestRecentDecisions(t *testing.T) {
+	tests := []struct {
+		name string
+		size int
+		sent []string
+		want []string
+	}{
+		{name: "empty", size: 3, want: []string{}},
+		{name: "partially filled", size: 3, sent: []string{"a", "b"}, want: []string{"a", "b"}},
+		{name: "exactly full", size: 3, sent: []string{"a", "b", "c"}, want: []string{"a", "b", "c"}},
+		{name: "wrapped around", size: 3, sent: []string{"a", "b", "c", "d", "e"}, want: []string{"c", "d", "e"}},
+		{name: "zero size", sent: []string{"a"}, want: []string{}},
+	}
+	for _, tt := range tests {
+		recent := NewRecentDecisions(tt.size)
+		for _, uid := range tt.sent {
+			recent.Send(Decision{UID: uid})
+		}
+		if got := decisionUIDs(recent.List()); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: got decisions %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRecentHandler(t *testing.T) {
+	tests := []struct {
+		name          string
+		recent        bool
+		authorization string
+		wantStatus    int
+	}{
+		{name: "authorized", recent: true, authorization: "Bearer s3cret", wantStatus: http.StatusOK},
+		{name: "wrong token", recent: true, authorization: "Bearer guess", wantStatus: http.StatusUnauthorized},
+		{name: "recent decisions disabled", authorization: "Bearer s3cret", wantStatus: http.StatusNotFound},
+	}
+	discardLogs(t)
+	for _, tt := range tests {
+		s := &WebhookServer{ConfigToken: "s3cret"}
+		if tt.recent {
+			s.Recent = NewRecentDecisions(10)
+		}
+		s.SetConfig(Config{PolicyVersion: "v3", Base: Policy{WhiteListRegistries: []string{"docker.io"}}})
+		allowed := testutil.NewPodAdmissionReview(testPod("web", "nginx:1.21"), admissionV1.Create)
+		denied := testutil.NewPodAdmissionReview(testPod("web", "quay.io/app:1"), admissionV1.Create)
+		s.Review(allowed)
+		s.Review(denied)
+
+		request := httptest.NewRequest(http.MethodGet, "/recent", nil)
+		request.Header.Set("Authorization", tt.authorization)
+		recorder := httptest.NewRecorder()
+		s.RecentHandler(recorder, request)
+		if recorder.Code != tt.wantStatus {
+			t.Errorf("%s: got status %d, want %d", tt.name, recorder.Code, tt.wantStatus)
+			continue
+		}
+		if tt.wantStatus != http.StatusOK {
+			continue
+		}
+		var decisions []Decision
+		if err := json.Unmarshal(recorder.Body.Bytes(), &decisions); err != nil {
+			t.Fatalf("%s: can't decode %s: %v", tt.name, recorder.Body.String(), err)
+		}
+		want := []string{string(allowed.Request.UID), string(denied.Request.UID)}
+		if got := decisionUIDs(decisions); !reflect.DeepEqual(got, want) {
+			t.Fatalf("%s: got decisions %v, want %v", tt.name, got, want)
+		}
+		if !decisions[0].Allowed || decisions[1].Allowed || decisions[1].PolicyVersion != "v3" {
+			t.Errorf("%s: got decisions %+v", tt.name, decisions)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// objectReference pod 引用的 ConfigMap 或 Secret
+type objectReference struct {
+	kind string // ConfigMap 或 Secret
+	name string
+}
+
+// checkConfigReferences pod 通过卷或环境变量引用的 ConfigMap/Secret 必须已经存在, 避免 pod 一直卡在 ContainerCreating
+// 标记为 optional 的引用不做检查
+func (s *WebhookServer) checkConfigReferences(ctx context.Context, policy *Policy, pod *corev1.Pod) *denial {
+	if policy.RequireConfigReferences == "" || s.Client == nil {
+		return nil
+	}
+	for _, ref := range configReferences(pod) {
+		lookupCtx, cancel := s.lookupContext(ctx)
+		var err error
+		if ref.kind == "ConfigMap" {
+			_, err = s.Client.CoreV1().ConfigMaps(pod.Namespace).Get(lookupCtx, ref.name, metav1.GetOptions{})
+		} else {
+			_, err = s.Client.CoreV1().Secrets(pod.Namespace).Get(lookupCtx, ref.name, metav1.GetOptions{})
+		}
+		cancel()
+		if errors.IsNotFound(err) {
+			return policy.RequireConfigReferences.violation(http.StatusForbidden,
+				fmt.Sprintf("%s %s referenced by pod %s does not exist in namespace %s!", ref.kind, ref.name, pod.Name, pod.Namespace))
+		}
+		if err != nil {
+			if d := lookupFailed(policy, ref.kind+" "+ref.name, err); d != nil {
+				return d
+			}
+		}
+	}
+	return nil
+}
+
+// configReferences 返回 pod 中所有必需的 ConfigMap/Secret 引用, 已经去重
+func configReferences(pod *corev1.Pod) []objectReference {
+	var refs []objectReference
+	seen := make(map[objectReference]bool)
+	add := func(kind, name string, optional *bool) {
+		ref := objectReference{kind: kind, name: name}
+		if name == "" || (optional != nil && *optional) || seen[ref] {
+			return
+		}
+		seen[ref] = true
+		refs = append(refs, ref)
+	}
+	for _, volume := range pod.Spec.Volumes {
+		if cm := volume.ConfigMap; cm != nil {
+			add("ConfigMap", cm.Name, cm.Optional)
+		}
+		if secret := volume.Secret; secret != nil {
+			add("Secret", secret.SecretName, secret.Optional)
+		}
+		if volume.Projected != nil {
+			for _, source := range volume.Projected.Sources {
+				if cm := source.ConfigMap; cm != nil {
+					add("ConfigMap", cm.Name, cm.Optional)
+				}
+				if secret := source.Secret; secret != nil {
+					add("Secret", secret.Name, secret.Optional)
+				}
+			}
+		}
+	}
+	for _, container := range podContainers(pod) {
+		for _, envFrom := range container.EnvFrom {
+			if cm := envFrom.ConfigMapRef; cm != nil {
+				add("ConfigMap", cm.Name, cm.Optional)
+			}
+			if secret := envFrom.SecretRef; secret != nil {
+				add("Secret", secret.Name, secret.Optional)
+			}
+		}
+		for _, env := range container.Env {
+			if env.ValueFrom == nil {
+				continue
+			}
+			if cm := env.ValueFrom.ConfigMapKeyRef; cm != nil {
+				add("ConfigMap", cm.Name, cm.Optional)
+			}
+			if secret := env.ValueFrom.SecretKeyRef; secret != nil {
+				add("Secret", secret.Name, secret.Optional)
+			}
+		}
+	}
+	return refs
+}
@@ -0,0 +1,94 @@
+package pkg
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// referencingPod 通过 envFrom 和卷分别引用 configMap 和 secret, 名字为空时不引用
+func referencingPod(configMap, secret string) *corev1.Pod {
+	pod := imagePod("nginx:1.21")
+	pod.Name, pod.Namespace = "web", "team"
+	if configMap != "" {
+		pod.Spec.Containers[0].EnvFrom = []corev1.EnvFromSource{
+			{ConfigMapRef: &corev1.ConfigMapEnvSource{LocalObjectReference: corev1.LocalObjectReference{Name: configMap}}},
+		}
+	}
+	if secret != "" {
+		pod.Spec.Volumes = []corev1.Volume{
+			{Name: "tls", VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: secret}}},
+		}
+	}
+	return pod
+}
+
+func TestCheckConfigReferences(t *testing.T) {
+	existing := []runtime.Object{
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "settings", Namespace: "team"}},
+		&corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "web-tls", Namespace: "team"}},
+		&corev1.ConfigMap{ObjectMeta: metav1.ObjectMeta{Name: "elsewhere", Namespace: "other"}},
+	}
+	tests := []struct {
+		name        string
+		pod         *corev1.Pod
+		policy      Policy
+		failGets    bool
+		wantCode    int
+		wantWarning bool
+	}{
+		{name: "references exist", pod: referencingPod("settings", "web-tls"), policy: Policy{RequireConfigReferences: ActionDeny}},
+		{name: "missing ConfigMap", pod: referencingPod("missing", "web-tls"), policy: Policy{RequireConfigReferences: ActionDeny}, wantCode: http.StatusForbidden},
+		{name: "missing Secret", pod: referencingPod("settings", "missing"), policy: Policy{RequireConfigReferences: ActionDeny}, wantCode: http.StatusForbidden},
+		{name: "other namespace", pod: referencingPod("elsewhere", ""), policy: Policy{RequireConfigReferences: ActionDeny}, wantCode: http.StatusForbidden},
+		{name: "warn only", pod: referencingPod("missing", ""), policy: Policy{RequireConfigReferences: ActionWarn}, wantCode: http.StatusForbidden, wantWarning: true},
+		{name: "not configured", pod: referencingPod("missing", "missing")},
+		{name: "lookup error fails open", pod: referencingPod("settings", ""), policy: Policy{RequireConfigReferences: ActionDeny}, failGets: true},
+		{
+			name:     "lookup error fails closed",
+			pod:      referencingPod("settings", ""),
+			policy:   Policy{RequireConfigReferences: ActionDeny, LookupFailClosed: true},
+			failGets: true,
+			wantCode: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset(existing...)
+			if tt.failGets {
+				failRequests(client, "get", "configmaps")
+			}
+			s := &WebhookServer{Client: client}
+			assertDenial(t, s.checkConfigReferences(context.Background(), &tt.policy, tt.pod), tt.wantCode, tt.wantWarning)
+		})
+	}
+}
+
+func TestConfigReferences(t *testing.T) {
+	optional := true
+	pod := referencingPod("settings", "web-tls")
+	pod.Spec.InitContainers = []corev1.Container{{Name: "init", Env: []corev1.EnvVar{
+		{Name: "A", ValueFrom: &corev1.EnvVarSource{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "settings"}, Key: "a"}}},
+		{Name: "B", ValueFrom: &corev1.EnvVarSource{SecretKeyRef: &corev1.SecretKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "password"}, Key: "b"}}},
+		{Name: "C", ValueFrom: &corev1.EnvVarSource{ConfigMapKeyRef: &corev1.ConfigMapKeySelector{LocalObjectReference: corev1.LocalObjectReference{Name: "optional"}, Key: "c", Optional: &optional}}},
+	}}}
+	pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{Name: "projected", VolumeSource: corev1.VolumeSource{Projected: &corev1.ProjectedVolumeSource{
+		Sources: []corev1.VolumeProjection{{ConfigMap: &corev1.ConfigMapProjection{LocalObjectReference: corev1.LocalObjectReference{Name: "bundle"}}}},
+	}}})
+	// 去重, 跳过 optional, 卷在环境变量之前
+	want := []objectReference{
+		{kind: "Secret", name: "web-tls"},
+		{kind: "ConfigMap", name: "bundle"},
+		{kind: "ConfigMap", name: "settings"},
+		{kind: "Secret", name: "password"},
+	}
+	if got := configReferences(pod); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,42 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// checkRegion 多地域集群必须从本地域的仓库拉取镜像
+// 镜像来自其它地域的仓库时拒绝, 并提示使用本地域的镜像仓库; 不属于任何地域的仓库不受影响
+func checkRegion(_ context.Context, policy *Policy, pod *corev1.Pod) *denial {
+	local, ok := policy.RegionRegistries[policy.Region]
+	if policy.Region == "" || !ok {
+		return nil
+	}
+	regions := make([]string, 0, len(policy.RegionRegistries))
+	for region := range policy.RegionRegistries {
+		regions = append(regions, region)
+	}
+	sort.Strings(regions)
+	for _, container := range podContainers(pod) {
+		image := policy.canonicalImage(container.Image)
+		if whitelisted(image, local) {
+			continue
+		}
+		for _, region := range regions {
+			if region == policy.Region || !whitelisted(image, policy.RegionRegistries[region]) {
+				continue
+			}
+			return &denial{
+				code: http.StatusForbidden,
+				message: fmt.Sprintf("%s image comes from a registry in region %s, but the cluster is in region %s! Please use the local mirror %s.",
+					container.Image, region, policy.Region, strings.Join(local, ",")),
+			}
+		}
+	}
+	return nil
+}
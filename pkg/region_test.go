@@ -0,0 +1,44 @@
+package pkg
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestCheckRegion(t *testing.T) {
+	config, err := loadTestConfig(t, `base:
+  region: cn-north
+  regionRegistries:
+    cn-north: [registry.cn-north.corp.com]
+    us-east: [registry.us-east.corp.com, us-east.mirror.corp.com/team]
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		image    string
+		wantCode int
+	}{
+		{image: "registry.cn-north.corp.com/app:1"},
+		{image: "registry.us-east.corp.com/app:1", wantCode: http.StatusForbidden},
+		{image: "us-east.mirror.corp.com/team/app:1", wantCode: http.StatusForbidden},
+		// 不属于任何地域的仓库不受影响
+		{image: "us-east.mirror.corp.com/other/app:1"},
+		{image: "nginx:1.21"},
+	}
+	for _, tt := range tests {
+		if code := denialCode(checkRegion(context.Background(), &config.Base, imagePod("registry.cn-north.corp.com/sidecar:1", tt.image))); code != tt.wantCode {
+			t.Errorf("%s: got code %d, want %d", tt.image, code, tt.wantCode)
+		}
+	}
+
+	// 没有配置当前地域, 或者当前地域没有仓库时不检查
+	for _, region := range []string{"", "eu-west"} {
+		policy := config.Base
+		policy.Region = region
+		if d := checkRegion(context.Background(), &policy, imagePod("registry.us-east.corp.com/app:1")); d != nil {
+			t.Errorf("region %q: got %v", region, d.message)
+		}
+	}
+}
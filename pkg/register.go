@@ -0,0 +1,168 @@
+package pkg
+
+import (
+	"context"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog"
+)
+
+// Registration 启动时由 webhook 自己创建 ValidatingWebhookConfiguration 和 MutatingWebhookConfiguration
+// 规则和 deploy/registry.yaml 中的一致, 已经存在时会被更新, 所以重复注册不会有副作用
+type Registration struct {
+	Name              string // webhook 配置的名字, 同时用作 webhook 的名字
+	ServiceNamespace  string // webhook 服务所在的命名空间
+	ServiceName       string
+	CABundle          []byte                                    // 签发 webhook 服务证书的 CA
+	FailurePolicy     admissionregistrationv1.FailurePolicyType // 为空时使用 Fail
+	NamespaceSelector *metav1.LabelSelector                     // 只处理选中的命名空间, 为 nil 时处理所有命名空间
+	Cleanup           bool                                      // 关闭时删除创建的 webhook 配置
+
+	MutatePort int32 // mutate 监听在单独的端口时 Service 上对应的端口, 为 0 时使用 Service 的默认端口
+}
+
+// Register 创建或者更新 webhook 配置
+func (r *Registration) Register(ctx context.Context, client kubernetes.Interface) error {
+	validating := client.AdmissionregistrationV1().ValidatingWebhookConfigurations()
+	vwc := &admissionregistrationv1.ValidatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: r.Name},
+		Webhooks: []admissionregistrationv1.ValidatingWebhook{{
+			Name:                    r.webhookName(),
+			Rules:                   validatingRules(),
+			ClientConfig:            r.clientConfig(ValidatePath, 0),
+			FailurePolicy:           r.failurePolicy(),
+			NamespaceSelector:       r.NamespaceSelector,
+			SideEffects:             sideEffectsNone(),
+			AdmissionReviewVersions: []string{"v1"},
+		}},
+	}
+	existing, err := validating.Get(ctx, r.Name, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		_, err = validating.Create(ctx, vwc, metav1.CreateOptions{})
+	case err == nil:
+		vwc.ResourceVersion = existing.ResourceVersion
+		_, err = validating.Update(ctx, vwc, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return err
+	}
+
+	mutating := client.AdmissionregistrationV1().MutatingWebhookConfigurations()
+	mwc := &admissionregistrationv1.MutatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: r.Name},
+		Webhooks: []admissionregistrationv1.MutatingWebhook{{
+			Name:                    r.webhookName(),
+			Rules:                   mutatingRules(),
+			ClientConfig:            r.clientConfig(MutatePath, r.MutatePort),
+			FailurePolicy:           r.failurePolicy(),
+			NamespaceSelector:       r.NamespaceSelector,
+			SideEffects:             sideEffectsNone(),
+			AdmissionReviewVersions: []string{"v1"},
+		}},
+	}
+	existingMutating, err := mutating.Get(ctx, r.Name, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		_, err = mutating.Create(ctx, mwc, metav1.CreateOptions{})
+	case err == nil:
+		mwc.ResourceVersion = existingMutating.ResourceVersion
+		_, err = mutating.Update(ctx, mwc, metav1.UpdateOptions{})
+	}
+	if err != nil {
+		return err
+	}
+	klog.Infof("Registered webhook configurations %s", r.Name)
+	return nil
+}
+
+// Unregister 配置了 Cleanup 时删除 webhook 配置, 配置已经不存在时不报错
+func (r *Registration) Unregister(ctx context.Context, client kubernetes.Interface) error {
+	if !r.Cleanup {
+		return nil
+	}
+	err := client.AdmissionregistrationV1().ValidatingWebhookConfigurations().Delete(ctx, r.Name, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	err = client.AdmissionregistrationV1().MutatingWebhookConfigurations().Delete(ctx, r.Name, metav1.DeleteOptions{})
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	klog.Infof("Removed webhook configurations %s", r.Name)
+	return nil
+}
+
+// webhookName webhook 的名字必须是完整的域名, 使用服务的集群内地址
+func (r *Registration) webhookName() string {
+	return r.Name + "." + r.ServiceNamespace + ".svc"
+}
+
+// clientConfig 通过 Service 访问 webhook, port 为 0 时使用 Service 的默认端口 443
+func (r *Registration) clientConfig(path string, port int32) admissionregistrationv1.WebhookClientConfig {
+	config := admissionregistrationv1.WebhookClientConfig{
+		Service: &admissionregistrationv1.ServiceReference{
+			Namespace: r.ServiceNamespace,
+			Name:      r.ServiceName,
+			Path:      &path,
+		},
+		CABundle: r.CABundle,
+	}
+	if port != 0 {
+		config.Service.Port = &port
+	}
+	return config
+}
+
+func (r *Registration) failurePolicy() *admissionregistrationv1.FailurePolicyType {
+	policy := r.FailurePolicy
+	if policy == "" {
+		policy = admissionregistrationv1.Fail
+	}
+	return &policy
+}
+
+func sideEffectsNone() *admissionregistrationv1.SideEffectClass {
+	sideEffects := admissionregistrationv1.SideEffectClassNone
+	return &sideEffects
+}
+
+func rule(group, resource string, operations ...admissionregistrationv1.OperationType) admissionregistrationv1.RuleWithOperations {
+	return versionedRule(group, "v1", resource, operations...)
+}
+
+func versionedRule(group, version, resource string, operations ...admissionregistrationv1.OperationType) admissionregistrationv1.RuleWithOperations {
+	return admissionregistrationv1.RuleWithOperations{
+		Operations: operations,
+		Rule: admissionregistrationv1.Rule{
+			APIGroups:   []string{group},
+			APIVersions: []string{version},
+			Resources:   []string{resource},
+		},
+	}
+}
+
+func validatingRules() []admissionregistrationv1.RuleWithOperations {
+	return []admissionregistrationv1.RuleWithOperations{
+		rule("", "pods", admissionregistrationv1.Create),
+		rule("apps", "deployments", admissionregistrationv1.Create, admissionregistrationv1.Update),
+		rule("apps", "statefulsets", admissionregistrationv1.Create, admissionregistrationv1.Update),
+		rule("", "persistentvolumeclaims", admissionregistrationv1.Create),
+		rule("", "services", admissionregistrationv1.Create, admissionregistrationv1.Update),
+		rule("", "serviceaccounts", admissionregistrationv1.Create, admissionregistrationv1.Update),
+		rule("", "secrets", admissionregistrationv1.Create),
+		rule("networking.k8s.io", "ingresses", admissionregistrationv1.Create, admissionregistrationv1.Update),
+		rule("batch", "jobs", admissionregistrationv1.Create),
+		versionedRule("batch", "v1beta1", "cronjobs", admissionregistrationv1.Create, admissionregistrationv1.Update),
+		versionedRule("autoscaling", "v2beta2", "horizontalpodautoscalers", admissionregistrationv1.Create, admissionregistrationv1.Update),
+	}
+}
+
+func mutatingRules() []admissionregistrationv1.RuleWithOperations {
+	return []admissionregistrationv1.RuleWithOperations{
+		rule("", "pods", admissionregistrationv1.Create),
+	}
+}
@@ -0,0 +1,104 @@
+package pkg
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func testRegistration(cleanup bool) *Registration {
+	return &Registration{
+		Name:              "admission-registry",
+		ServiceNamespace:  "default",
+		ServiceName:       "admission-registry",
+		CABundle:          []byte("ca"),
+		FailurePolicy:     admissionregistrationv1.Ignore,
+		NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"admission-registry": "enabled"}},
+		Cleanup:           cleanup,
+		MutatePort:        8443,
+	}
+}
+
+func TestRegister(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	r := testRegistration(false)
+	// 重复注册只会更新已有的配置
+	for i := 0; i < 2; i++ {
+		if err := r.Register(context.Background(), client); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	vwcs, err := client.AdmissionregistrationV1().ValidatingWebhookConfigurations().List(context.Background(), metav1.ListOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(vwcs.Items) != 1 || len(vwcs.Items[0].Webhooks) != 1 {
+		t.Fatalf("got %d validating configurations, want exactly one with one webhook", len(vwcs.Items))
+	}
+	validating := vwcs.Items[0].Webhooks[0]
+	if validating.Name != "admission-registry.default.svc" {
+		t.Errorf("validating webhook name = %s", validating.Name)
+	}
+	if !reflect.DeepEqual(validating.Rules, validatingRules()) {
+		t.Errorf("validating rules = %+v, want %+v", validating.Rules, validatingRules())
+	}
+	if *validating.FailurePolicy != admissionregistrationv1.Ignore || !reflect.DeepEqual(validating.NamespaceSelector, r.NamespaceSelector) {
+		t.Errorf("validating webhook has failurePolicy %s and namespaceSelector %v", *validating.FailurePolicy, validating.NamespaceSelector)
+	}
+	if service := validating.ClientConfig.Service; *service.Path != ValidatePath || service.Port != nil || string(validating.ClientConfig.CABundle) != "ca" {
+		t.Errorf("validating client config = %+v", validating.ClientConfig)
+	}
+
+	mwc, err := client.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(context.Background(), r.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mutating := mwc.Webhooks[0]
+	if !reflect.DeepEqual(mutating.Rules, mutatingRules()) {
+		t.Errorf("mutating rules = %+v, want %+v", mutating.Rules, mutatingRules())
+	}
+	if service := mutating.ClientConfig.Service; *service.Path != MutatePath || service.Port == nil || *service.Port != 8443 {
+		t.Errorf("mutating client config = %+v", mutating.ClientConfig)
+	}
+}
+
+func TestUnregister(t *testing.T) {
+	tests := []struct {
+		name        string
+		cleanup     bool
+		wantRemoved bool
+	}{
+		{name: "cleanup", cleanup: true, wantRemoved: true},
+		{name: "no cleanup", cleanup: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset()
+			r := testRegistration(tt.cleanup)
+			if err := r.Register(context.Background(), client); err != nil {
+				t.Fatal(err)
+			}
+			if err := r.Unregister(context.Background(), client); err != nil {
+				t.Fatal(err)
+			}
+			_, err := client.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get(context.Background(), r.Name, metav1.GetOptions{})
+			if removed := apierrors.IsNotFound(err); removed != tt.wantRemoved {
+				t.Errorf("validating configuration removed = %v, want %v", removed, tt.wantRemoved)
+			}
+			_, err = client.AdmissionregistrationV1().MutatingWebhookConfigurations().Get(context.Background(), r.Name, metav1.GetOptions{})
+			if removed := apierrors.IsNotFound(err); removed != tt.wantRemoved {
+				t.Errorf("mutating configuration removed = %v, want %v", removed, tt.wantRemoved)
+			}
+			// 配置已经不存在时不报错
+			if err := r.Unregister(context.Background(), client); err != nil {
+				t.Errorf("second Unregister: %v", err)
+			}
+		})
+	}
+}
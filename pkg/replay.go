@@ -0,0 +1,98 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	admissionV1 "k8s.io/api/admission/v1"
+)
+
+// 期望结果文件的后缀, 和请求文件放在同一个目录, 比如 pod.json 对应 pod.expected.json
+const replayExpectedSuffix = ".expected.json"
+
+// ReplayExpectation 回放请求时期望的结果, Message 为空时不比较
+type ReplayExpectation struct {
+	Allowed bool   `json:"allowed"`
+	Code    int32  `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// ReplayResult 一个请求的回放结果
+type ReplayResult struct {
+	File     string
+	Response *admissionV1.AdmissionResponse
+	Mismatch string // 和期望结果不一致的地方, 没有期望结果或者一致时为空
+}
+
+// ReplayFromDir 依次回放目录中抓取的 AdmissionReview (*.json), 用于对策略的修改做回归测试
+// 存在对应的期望结果文件时, 会和实际的结果做比较
+func (s *WebhookServer) ReplayFromDir(dir string) ([]ReplayResult, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	var results []ReplayResult
+	for _, file := range files {
+		if strings.HasSuffix(file, replayExpectedSuffix) {
+			continue
+		}
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		var ar admissionV1.AdmissionReview
+		if _, _, err := deserializer.Decode(data, nil, &ar); err != nil {
+			return nil, fmt.Errorf("can't decode %s: %v", file, err)
+		}
+		result := ReplayResult{File: file, Response: s.Review(&ar)}
+		expected, err := readExpectation(strings.TrimSuffix(file, ".json") + replayExpectedSuffix)
+		if err != nil {
+			return nil, err
+		}
+		if expected != nil {
+			result.Mismatch = expected.diff(result.Response)
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func readExpectation(file string) (*ReplayExpectation, error) {
+	data, err := ioutil.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var expected ReplayExpectation
+	if err := json.Unmarshal(data, &expected); err != nil {
+		return nil, fmt.Errorf("can't decode %s: %v", file, err)
+	}
+	return &expected, nil
+}
+
+func (e *ReplayExpectation) diff(resp *admissionV1.AdmissionResponse) string {
+	var code int32
+	var message string
+	if resp.Result != nil {
+		code, message = resp.Result.Code, resp.Result.Message
+	}
+	var diffs []string
+	if resp.Allowed != e.Allowed {
+		diffs = append(diffs, fmt.Sprintf("allowed: expected %v, got %v", e.Allowed, resp.Allowed))
+	}
+	if e.Code != 0 && code != e.Code {
+		diffs = append(diffs, fmt.Sprintf("code: expected %d, got %d", e.Code, code))
+	}
+	if e.Message != "" && message != e.Message {
+		diffs = append(diffs, fmt.Sprintf("message: expected %q, got %q", e.Message, message))
+	}
+	return strings.Join(diffs, "; ")
+}
@@ -0,0 +1,71 @@
+package pkg
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const replayReview = `{
+  "apiVersion": "admission.k8s.io/v1",
+  "kind": "AdmissionReview",
+  "request": {
+    "uid": "%[1]s",
+    "kind": {"group": "", "version": "v1", "kind": "Pod"},
+    "resource": {"group": "", "version": "v1", "resource": "pods"},
+    "namespace": "team",
+    "operation": "CREATE",
+    "object": {"apiVersion": "v1", "kind": "Pod", "metadata": {"name": "%[1]s"}, "spec": {"containers": [{"name": "app", "image": "%[2]s"}]}}
+  }
+}`
+
+func TestReplayFromDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"allowed.json":          fmt.Sprintf(replayReview, "allowed", "docker.io/library/nginx:1.21"),
+		"allowed.expected.json": `{"allowed": true}`,
+		"denied.json":           fmt.Sprintf(replayReview, "denied", "quay.io/app:1.0"),
+		"denied.expected.json":  `{"allowed": false, "code": 403}`,
+		"changed.json":          fmt.Sprintf(replayReview, "changed", "quay.io/app:1.0"),
+		"changed.expected.json": `{"allowed": true}`,
+		"unchecked.json":        fmt.Sprintf(replayReview, "unchecked", "docker.io/library/nginx:1"),
+	}
+	for name, content := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 回放不访问镜像仓库, 需要查询仓库的检查按照 InspectFailClosed 处理
+	s := &WebhookServer{Inspector: NewCachedInspector(OfflineInspector{})}
+	s.SetConfig(Config{Base: Policy{WhiteListRegistries: []string{"docker.io"}, FloatingTags: ActionDeny}})
+	results, err := s.ReplayFromDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]struct {
+		allowed  bool
+		mismatch string
+	}{
+		"allowed.json":   {allowed: true},
+		"changed.json":   {mismatch: "allowed: expected true, got false"},
+		"denied.json":    {},
+		"unchecked.json": {allowed: true},
+	}
+	if len(results) != len(want) {
+		t.Fatalf("got %d results, want %d", len(results), len(want))
+	}
+	for _, result := range results {
+		w := want[filepath.Base(result.File)]
+		if result.Response.Allowed != w.allowed || result.Mismatch != w.mismatch {
+			t.Errorf("%s: allowed %v, mismatch %q, want %v and %q",
+				filepath.Base(result.File), result.Response.Allowed, result.Mismatch, w.allowed, w.mismatch)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// 确实需要可写根文件系统的 pod 使用的注解, 值为 "true" 时跳过 readOnlyRootFilesystem 检查
+const writableRootFSAnnotation = "admission-registry/writable-root-filesystem"
+
+// checkReadOnlyRootFS 每个容器都必须设置 securityContext.readOnlyRootFilesystem: true
+func checkReadOnlyRootFS(_ context.Context, policy *Policy, pod *corev1.Pod) *denial {
+	if !policy.RequireReadOnlyRootFS || pod.Annotations[writableRootFSAnnotation] == "true" {
+		return nil
+	}
+	for _, container := range podContainers(pod) {
+		sc := container.SecurityContext
+		if sc == nil || sc.ReadOnlyRootFilesystem == nil || !*sc.ReadOnlyRootFilesystem {
+			return &denial{
+				code: http.StatusForbidden,
+				message: fmt.Sprintf("container %s must set securityContext.readOnlyRootFilesystem: true! Add annotation %s: \"true\" if the container needs a writable root filesystem.",
+					container.Name, writableRootFSAnnotation),
+			}
+		}
+	}
+	return nil
+}
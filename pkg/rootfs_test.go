@@ -0,0 +1,37 @@
+package pkg
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestCheckReadOnlyRootFS(t *testing.T) {
+	readOnly, writable := true, false
+	tests := []struct {
+		name        string
+		readOnly    *bool
+		annotations map[string]string
+		policy      Policy
+		wantCode    int
+	}{
+		{name: "read-only root filesystem", readOnly: &readOnly, policy: Policy{RequireReadOnlyRootFS: true}},
+		{name: "writable root filesystem", readOnly: &writable, policy: Policy{RequireReadOnlyRootFS: true}, wantCode: http.StatusForbidden},
+		{name: "unset", policy: Policy{RequireReadOnlyRootFS: true}, wantCode: http.StatusForbidden},
+		{name: "exception annotation", annotations: map[string]string{writableRootFSAnnotation: "true"}, policy: Policy{RequireReadOnlyRootFS: true}},
+		{name: "annotation must be true", annotations: map[string]string{writableRootFSAnnotation: "yes"}, policy: Policy{RequireReadOnlyRootFS: true}, wantCode: http.StatusForbidden},
+		{name: "not required", readOnly: &writable},
+	}
+	for _, tt := range tests {
+		pod := imagePod("nginx")
+		pod.Annotations = tt.annotations
+		if tt.readOnly != nil {
+			pod.Spec.Containers[0].SecurityContext = &corev1.SecurityContext{ReadOnlyRootFilesystem: tt.readOnly}
+		}
+		if code := denialCode(checkReadOnlyRootFS(context.Background(), &tt.policy, pod)); code != tt.wantCode {
+			t.Errorf("%s: got code %d, want %d", tt.name, code, tt.wantCode)
+		}
+	}
+}
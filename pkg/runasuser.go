@@ -0,0 +1,81 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// checkRunAsUser 容器的 runAsUser 不能在 ForbiddenUIDs 中, 容器没有设置时继承 pod 级别的配置
+// 都没有设置时使用镜像中的用户, 这里无法判断, 由 runAsNonRoot 保证不是 root
+func checkRunAsUser(_ context.Context, policy *Policy, pod *corev1.Pod) *denial {
+	if len(policy.ForbiddenUIDs) == 0 {
+		return nil
+	}
+	var podUID *int64
+	if pod.Spec.SecurityContext != nil {
+		podUID = pod.Spec.SecurityContext.RunAsUser
+	}
+	for _, container := range podContainers(pod) {
+		uid := podUID
+		if container.SecurityContext != nil && container.SecurityContext.RunAsUser != nil {
+			uid = container.SecurityContext.RunAsUser
+		}
+		if uid == nil {
+			continue
+		}
+		forbidden, err := uidInRanges(*uid, policy.ForbiddenUIDs)
+		if err != nil {
+			// LoadConfig 已经检查过, 这里出错说明配置是直接通过 SetConfig 设置的, 忽略这一项会放行被禁止的 UID
+			return &denial{
+				code:    http.StatusInternalServerError,
+				message: fmt.Sprintf("invalid forbiddenUIDs: %v", err),
+			}
+		}
+		if forbidden {
+			return &denial{
+				code: http.StatusForbidden,
+				message: fmt.Sprintf("container %s runs as UID %d, UIDs %v are not allowed! Please set securityContext.runAsUser to another UID.",
+					container.Name, *uid, policy.ForbiddenUIDs),
+			}
+		}
+	}
+	return nil
+}
+
+// uidInRanges 判断 uid 是否在某一项中, 每一项是单个 UID 或者 "1-999" 这样的闭区间
+func uidInRanges(uid int64, ranges []string) (bool, error) {
+	for _, item := range ranges {
+		low, high, err := parseUIDRange(item)
+		if err != nil {
+			return false, fmt.Errorf("invalid UID %q: %v", item, err)
+		}
+		if uid >= low && uid <= high {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// parseUIDRange 解析单个 UID 或者 UID 范围, UID 不能是负数, 范围的下限不能大于上限
+func parseUIDRange(item string) (int64, int64, error) {
+	parts := strings.SplitN(item, "-", 2)
+	low, err := strconv.ParseInt(strings.TrimSpace(parts[0]), 10, 64)
+	if err != nil {
+		return 0, 0, err
+	}
+	high := low
+	if len(parts) == 2 {
+		if high, err = strconv.ParseInt(strings.TrimSpace(parts[1]), 10, 64); err != nil {
+			return 0, 0, err
+		}
+	}
+	if low > high {
+		return 0, 0, fmt.Errorf("range start %d is greater than its end %d", low, high)
+	}
+	return low, high, nil
+}
@@ -0,0 +1,69 @@
+package pkg
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func runAsUserPod(podUID, containerUID *int64) *corev1.Pod {
+	pod := &corev1.Pod{Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app"}}}}
+	if podUID != nil {
+		pod.Spec.SecurityContext = &corev1.PodSecurityContext{RunAsUser: podUID}
+	}
+	if containerUID != nil {
+		pod.Spec.Containers[0].SecurityContext = &corev1.SecurityContext{RunAsUser: containerUID}
+	}
+	return pod
+}
+
+func TestCheckRunAsUser(t *testing.T) {
+	uid := func(v int64) *int64 { return &v }
+	policy := &Policy{ForbiddenUIDs: []string{"0", "1-999"}}
+	tests := []struct {
+		name                 string
+		podUID, containerUID *int64
+		wantCode             int
+	}{
+		{name: "unset"},
+		{name: "root", containerUID: uid(0), wantCode: http.StatusForbidden},
+		{name: "system range", containerUID: uid(999), wantCode: http.StatusForbidden},
+		{name: "regular user", containerUID: uid(1000)},
+		{name: "inherited from pod", podUID: uid(33), wantCode: http.StatusForbidden},
+		{name: "container overrides pod", podUID: uid(33), containerUID: uid(1000)},
+	}
+	for _, tt := range tests {
+		d := checkRunAsUser(context.Background(), policy, runAsUserPod(tt.podUID, tt.containerUID))
+		if code := denialCode(d); code != tt.wantCode {
+			t.Errorf("%s: got code %d (%v), want %d", tt.name, code, d, tt.wantCode)
+		}
+	}
+
+	// 没有经过 LoadConfig 的非法项不能被忽略
+	policy = &Policy{ForbiddenUIDs: []string{"root", "0"}}
+	if code := denialCode(checkRunAsUser(context.Background(), policy, runAsUserPod(nil, uid(0)))); code != http.StatusInternalServerError {
+		t.Errorf("invalid forbiddenUIDs: got code %d, want %d", code, http.StatusInternalServerError)
+	}
+}
+
+func TestLoadConfigRejectsInvalidUIDs(t *testing.T) {
+	tests := []struct {
+		config, wantErr string
+	}{
+		{config: "base:\n  forbiddenUIDs: [root]\n", wantErr: "base.forbiddenUIDs[0]"},
+		{config: "base:\n  forbiddenUIDs: [\"0\", \"999-1\"]\n", wantErr: "base.forbiddenUIDs[1]"},
+		{config: "namespaces:\n  team:\n    forbiddenUIDs: [\"-1\"]\n", wantErr: "team.forbiddenUIDs[0]"},
+	}
+	for _, tt := range tests {
+		_, err := loadTestConfig(t, tt.config)
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%q: got error %v, want it to mention %s", tt.config, err, tt.wantErr)
+		}
+	}
+	if _, err := loadTestConfig(t, "base:\n  forbiddenUIDs: [\"0\", \"1-999\"]\n"); err != nil {
+		t.Errorf("valid UIDs rejected: %v", err)
+	}
+}
@@ -0,0 +1,131 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// checkRuntimeClass pod 只能使用允许的 RuntimeClass, 比如 gvisor/kata
+// 没有设置 runtimeClassName 的 pod 使用集群默认的运行时, 默认允许, 配置了 DenyDefaultRuntimeClass 时拒绝
+func checkRuntimeClass(_ context.Context, policy *Policy, pod *corev1.Pod) *denial {
+	if len(policy.AllowedRuntimeClasses) == 0 && !policy.DenyDefaultRuntimeClass {
+		return nil
+	}
+	if pod.Spec.RuntimeClassName == nil || *pod.Spec.RuntimeClassName == "" {
+		if !policy.DenyDefaultRuntimeClass {
+			return nil
+		}
+		return &denial{
+			code: http.StatusForbidden,
+			message: fmt.Sprintf("pod %s uses the default runtime! Please set runtimeClassName to one of %v.",
+				pod.Name, policy.AllowedRuntimeClasses),
+		}
+	}
+	class := *pod.Spec.RuntimeClassName
+	if !containsString(policy.AllowedRuntimeClasses, class) {
+		return &denial{
+			code: http.StatusForbidden,
+			message: fmt.Sprintf("pod %s uses runtime class %s! Only runtime classes %v are allowed.",
+				pod.Name, class, policy.AllowedRuntimeClasses),
+		}
+	}
+	return nil
+}
+
+// RuntimeClass 的 overhead 很少变化, 缓存一分钟
+const runtimeClassOverheadTTL = time.Minute
+
+type runtimeClassOverhead struct {
+	overhead corev1.ResourceList
+	expires  time.Time
+}
+
+// checkPodOverhead pod 声明的 spec.overhead 必须和 RuntimeClass 中配置的 overhead 一致, 否则调度时的资源计算会出错
+// 没有声明 overhead 的 pod 不做检查, 这种情况由 api-server 的 RuntimeClass 准入插件按 RuntimeClass 填充
+func (s *WebhookServer) checkPodOverhead(ctx context.Context, policy *Policy, pod *corev1.Pod) *denial {
+	if !policy.ValidatePodOverhead || len(pod.Spec.Overhead) == 0 || s.Client == nil {
+		return nil
+	}
+	if pod.Spec.RuntimeClassName == nil || *pod.Spec.RuntimeClassName == "" {
+		return &denial{
+			code:    http.StatusForbidden,
+			message: fmt.Sprintf("pod %s declares overhead without a runtimeClassName! Overhead is defined by the runtime class.", pod.Name),
+		}
+	}
+	class := *pod.Spec.RuntimeClassName
+	expected, err := s.runtimeClassOverhead(ctx, class)
+	if err != nil {
+		return lookupFailed(policy, "RuntimeClass "+class, err)
+	}
+	if !equalResources(pod.Spec.Overhead, expected) {
+		return &denial{
+			code: http.StatusForbidden,
+			message: fmt.Sprintf("pod %s declares overhead %s, but runtime class %s defines %s!",
+				pod.Name, formatResources(pod.Spec.Overhead), class, formatResources(expected)),
+		}
+	}
+	return nil
+}
+
+// runtimeClassOverhead 查询 RuntimeClass 的 overhead, 结果会缓存 runtimeClassOverheadTTL
+func (s *WebhookServer) runtimeClassOverhead(ctx context.Context, name string) (corev1.ResourceList, error) {
+	s.runtimeClassMu.Lock()
+	cached, ok := s.runtimeClasses[name]
+	s.runtimeClassMu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.overhead, nil
+	}
+
+	ctx, cancel := s.lookupContext(ctx)
+	defer cancel()
+	rc, err := s.Client.NodeV1().RuntimeClasses().Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	var overhead corev1.ResourceList
+	if rc.Overhead != nil {
+		overhead = rc.Overhead.PodFixed
+	}
+
+	s.runtimeClassMu.Lock()
+	if s.runtimeClasses == nil {
+		s.runtimeClasses = make(map[string]runtimeClassOverhead)
+	}
+	s.runtimeClasses[name] = runtimeClassOverhead{overhead: overhead, expires: time.Now().Add(runtimeClassOverheadTTL)}
+	s.runtimeClassMu.Unlock()
+	return overhead, nil
+}
+
+// equalResources 判断两组资源是否完全相同, 数量按值比较, 比如 1000m 和 1 相同
+func equalResources(a, b corev1.ResourceList) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, qa := range a {
+		qb, ok := b[name]
+		if !ok || qa.Cmp(qb) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// formatResources 按资源名排序输出, 比如 cpu=250m, memory=120Mi
+func formatResources(resources corev1.ResourceList) string {
+	if len(resources) == 0 {
+		return "none"
+	}
+	items := make([]string, 0, len(resources))
+	for name, q := range resources {
+		items = append(items, fmt.Sprintf("%s=%s", name, q.String()))
+	}
+	sort.Strings(items)
+	return strings.Join(items, ", ")
+}
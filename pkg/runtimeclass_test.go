@@ -0,0 +1,105 @@
+package pkg
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	nodev1 "k8s.io/api/node/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+// runtimeClassPod 构造使用 runtimeClass 的 pod, 为空时使用集群默认的运行时
+func runtimeClassPod(runtimeClass string) *corev1.Pod {
+	pod := imagePod("nginx")
+	pod.Name = "web"
+	if runtimeClass != "" {
+		pod.Spec.RuntimeClassName = &runtimeClass
+	}
+	return pod
+}
+
+func TestCheckRuntimeClass(t *testing.T) {
+	tests := []struct {
+		name         string
+		runtimeClass string
+		policy       Policy
+		wantCode     int
+	}{
+		{name: "allowed class", runtimeClass: "gvisor", policy: Policy{AllowedRuntimeClasses: []string{"gvisor", "kata"}}},
+		{name: "other class", runtimeClass: "runc-privileged", policy: Policy{AllowedRuntimeClasses: []string{"gvisor", "kata"}}, wantCode: http.StatusForbidden},
+		{name: "default runtime", policy: Policy{AllowedRuntimeClasses: []string{"gvisor"}}},
+		{name: "default runtime denied", policy: Policy{AllowedRuntimeClasses: []string{"gvisor"}, DenyDefaultRuntimeClass: true}, wantCode: http.StatusForbidden},
+		{name: "not configured", runtimeClass: "runc-privileged"},
+	}
+	for _, tt := range tests {
+		if code := denialCode(checkRuntimeClass(context.Background(), &tt.policy, runtimeClassPod(tt.runtimeClass))); code != tt.wantCode {
+			t.Errorf("%s: got code %d, want %d", tt.name, code, tt.wantCode)
+		}
+	}
+}
+
+func TestCheckPodOverhead(t *testing.T) {
+	overhead := func(cpu, memory string) corev1.ResourceList {
+		return corev1.ResourceList{corev1.ResourceCPU: resource.MustParse(cpu), corev1.ResourceMemory: resource.MustParse(memory)}
+	}
+	kata := &nodev1.RuntimeClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "kata"},
+		Handler:    "kata",
+		Overhead:   &nodev1.Overhead{PodFixed: overhead("250m", "120Mi")},
+	}
+	gvisor := &nodev1.RuntimeClass{ObjectMeta: metav1.ObjectMeta{Name: "gvisor"}, Handler: "runsc"}
+	tests := []struct {
+		name         string
+		runtimeClass string
+		overhead     corev1.ResourceList
+		policy       Policy
+		failLookups  bool
+		wantCode     int
+	}{
+		{name: "matching overhead", runtimeClass: "kata", overhead: overhead("250m", "120Mi"), policy: Policy{ValidatePodOverhead: true}},
+		{name: "equal quantities", runtimeClass: "kata", overhead: overhead("0.25", "125829120"), policy: Policy{ValidatePodOverhead: true}},
+		{name: "different overhead", runtimeClass: "kata", overhead: overhead("100m", "120Mi"), policy: Policy{ValidatePodOverhead: true}, wantCode: http.StatusForbidden},
+		{name: "missing resource", runtimeClass: "kata", overhead: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("250m")}, policy: Policy{ValidatePodOverhead: true}, wantCode: http.StatusForbidden},
+		{name: "class without overhead", runtimeClass: "gvisor", overhead: overhead("250m", "120Mi"), policy: Policy{ValidatePodOverhead: true}, wantCode: http.StatusForbidden},
+		{name: "no runtime class", overhead: overhead("250m", "120Mi"), policy: Policy{ValidatePodOverhead: true}, wantCode: http.StatusForbidden},
+		{name: "no overhead declared", runtimeClass: "kata", policy: Policy{ValidatePodOverhead: true}},
+		{name: "unknown class fails open", runtimeClass: "firecracker", overhead: overhead("250m", "120Mi"), policy: Policy{ValidatePodOverhead: true}},
+		{name: "lookup error fails closed", runtimeClass: "kata", overhead: overhead("250m", "120Mi"), policy: Policy{ValidatePodOverhead: true, LookupFailClosed: true},
+			failLookups: true, wantCode: http.StatusInternalServerError},
+		{name: "not configured", runtimeClass: "kata", overhead: overhead("100m", "120Mi")},
+	}
+	discardLogs(t)
+	for _, tt := range tests {
+		client := fake.NewSimpleClientset(kata, gvisor)
+		if tt.failLookups {
+			failRequests(client, "get", "runtimeclasses")
+		}
+		s := &WebhookServer{Client: client}
+		pod := runtimeClassPod(tt.runtimeClass)
+		pod.Spec.Overhead = tt.overhead
+		if code := denialCode(s.checkPodOverhead(context.Background(), &tt.policy, pod)); code != tt.wantCode {
+			t.Errorf("%s: got code %d, want %d", tt.name, code, tt.wantCode)
+		}
+	}
+}
+
+func TestRuntimeClassOverheadCached(t *testing.T) {
+	client := fake.NewSimpleClientset(&nodev1.RuntimeClass{
+		ObjectMeta: metav1.ObjectMeta{Name: "kata"},
+		Handler:    "kata",
+		Overhead:   &nodev1.Overhead{PodFixed: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("250m")}},
+	})
+	s := &WebhookServer{Client: client}
+	for i := 0; i < 3; i++ {
+		if _, err := s.runtimeClassOverhead(context.Background(), "kata"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(client.Actions()); n != 1 {
+		t.Errorf("looked up the runtime class %d times, want 1", n)
+	}
+}
@@ -0,0 +1,282 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog"
+)
+
+// 访问漏洞扫描服务的默认超时时间
+const defaultScanTimeout = 5 * time.Second
+
+// 默认缓存的扫描结果数量
+const defaultScanCacheSize = 10000
+
+// 漏洞的严重程度, 从低到高排列
+var vulnerabilitySeverities = []string{"UNKNOWN", "LOW", "MEDIUM", "HIGH", "CRITICAL"}
+
+// VulnerabilitySummary 镜像的漏洞统计, key 为严重程度, 比如 CRITICAL
+type VulnerabilitySummary map[string]int
+
+// validSeverity 判断 severity 是否是已知的严重程度, 不区分大小写
+func validSeverity(severity string) bool {
+	return containsString(vulnerabilitySeverities, strings.ToUpper(severity))
+}
+
+// atOrAbove 统计严重程度不低于 threshold 的漏洞数量, threshold 必须是已知的严重程度
+func (v VulnerabilitySummary) atOrAbove(threshold string) int {
+	count := 0
+	above := false
+	for _, severity := range vulnerabilitySeverities {
+		if severity == threshold {
+			above = true
+		}
+		if above {
+			count += v[severity]
+		}
+	}
+	return count
+}
+
+// Scanner 查询镜像的漏洞扫描结果, 测试时可以替换成假的实现
+type Scanner interface {
+	Scan(ctx context.Context, image, digest string) (VulnerabilitySummary, error)
+}
+
+// ScanTimeReporter 查询镜像最近一次扫描的时间, HTTPScanner 实现了这个接口
+type ScanTimeReporter interface {
+	// LastScanned 返回最近一次扫描的时间, 没有扫描过时返回零值
+	LastScanned(ctx context.Context, image, digest string) (time.Time, error)
+}
+
+// CachedScanner 按 digest 缓存扫描结果, 缓存的数量有上限, 超过时淘汰最久没有使用的结果
+type CachedScanner struct {
+	Scanner Scanner
+	Timeout time.Duration   // 单次查询的超时时间, 为 0 时使用默认值
+	Breaker *CircuitBreaker // 扫描服务不可用时熔断, 为 nil 时不熔断
+
+	cache     *lruCache // 按 digest 缓存的 VulnerabilitySummary
+	scanTimes *lruCache // 按 digest 缓存的最近扫描时间
+}
+
+// NewCachedScanner 创建带缓存的 Scanner
+func NewCachedScanner(scanner Scanner) *CachedScanner {
+	return &CachedScanner{
+		Scanner:   scanner,
+		cache:     newLRUCache(defaultScanCacheSize),
+		scanTimes: newLRUCache(defaultScanCacheSize),
+	}
+}
+
+func (c *CachedScanner) scan(ctx context.Context, image, digest string) (VulnerabilitySummary, error) {
+	if cached, ok := c.cache.Get(digest); ok {
+		return cached.(VulnerabilitySummary), nil
+	}
+	var summary VulnerabilitySummary
+
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = defaultScanTimeout
+	}
+	ctx, span := startSpan(ctx, "scan image")
+	defer span.End()
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	err := c.Breaker.Do(func() (err error) {
+		summary, err = c.Scanner.Scan(ctx, image, digest)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	c.cache.Add(digest, summary)
+	return summary, nil
+}
+
+// lastScanned 返回镜像最近一次扫描的时间, 缓存的时间晚于 notBefore 时直接使用
+// 镜像会被重新扫描, 所以缓存的时间不够新时要再查询一次, 看看有没有更新的扫描
+func (c *CachedScanner) lastScanned(ctx context.Context, image, digest string, notBefore time.Time) (time.Time, error) {
+	var scanned time.Time
+	if cached, ok := c.scanTimes.Get(digest); ok && cached.(time.Time).After(notBefore) {
+		return cached.(time.Time), nil
+	}
+	reporter, ok := c.Scanner.(ScanTimeReporter)
+	if !ok {
+		return time.Time{}, fmt.Errorf("scanner can't report scan times")
+	}
+
+	timeout := c.Timeout
+	if timeout == 0 {
+		timeout = defaultScanTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	err := c.Breaker.Do(func() (err error) {
+		scanned, err = reporter.LastScanned(ctx, image, digest)
+		return err
+	})
+	if err != nil {
+		return time.Time{}, err
+	}
+	c.scanTimes.Add(digest, scanned)
+	return scanned, nil
+}
+
+// HTTPScanner 通过 HTTP 查询扫描服务, 请求 GET {URL}?digest=sha256:...
+// 服务需要返回 {"vulnerabilities": {"CRITICAL": 1, "HIGH": 3}, "scannedAt": "2021-01-01T00:00:00Z"} 格式的 json
+// 没有扫描过的镜像不返回 scannedAt
+type HTTPScanner struct {
+	URL    string
+	Client *http.Client
+}
+
+type scanResult struct {
+	Vulnerabilities VulnerabilitySummary `json:"vulnerabilities"`
+	ScannedAt       time.Time            `json:"scannedAt"`
+}
+
+// Scan 查询 digest 对应的扫描结果
+func (h *HTTPScanner) Scan(ctx context.Context, image, digest string) (VulnerabilitySummary, error) {
+	result, err := h.get(ctx, image, digest)
+	if err != nil {
+		return nil, err
+	}
+	return result.Vulnerabilities, nil
+}
+
+// LastScanned 查询 digest 最近一次扫描的时间
+func (h *HTTPScanner) LastScanned(ctx context.Context, image, digest string) (time.Time, error) {
+	result, err := h.get(ctx, image, digest)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return result.ScannedAt, nil
+}
+
+func (h *HTTPScanner) get(ctx context.Context, image, digest string) (*scanResult, error) {
+	req, err := http.NewRequest(http.MethodGet, h.URL+"?digest="+url.QueryEscape(digest), nil)
+	if err != nil {
+		return nil, err
+	}
+	client := h.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, &statusError{Code: resp.StatusCode, Message: fmt.Sprintf("scanner returned %s for %s", resp.Status, image)}
+	}
+	var result scanResult
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// checkVulnerabilities 拒绝存在不低于 VulnerabilityThreshold 级别漏洞的镜像
+func (s *WebhookServer) checkVulnerabilities(ctx context.Context, policy *Policy, pod *corev1.Pod) *denial {
+	if policy.VulnerabilityThreshold == "" || s.Scanner == nil || s.Inspector == nil {
+		return nil
+	}
+	threshold := strings.ToUpper(policy.VulnerabilityThreshold)
+	// LoadConfig 会拒绝未知的级别, 这里再检查一次, 避免直接通过 SetConfig 设置的错误配置放行所有镜像
+	if !validSeverity(threshold) {
+		return &denial{
+			code:    http.StatusInternalServerError,
+			message: fmt.Sprintf("invalid vulnerabilityThreshold %q, must be one of %v", policy.VulnerabilityThreshold, vulnerabilitySeverities),
+		}
+	}
+	for _, container := range podContainers(pod) {
+		digest, err := s.Inspector.digest(ctx, container.Image)
+		if err != nil {
+			if d := inspectFailed(policy, container.Image, err); d != nil {
+				return d
+			}
+			continue
+		}
+		summary, err := s.Scanner.scan(ctx, container.Image, digest)
+		if err != nil {
+			if d := scanFailed(policy, container.Image, err); d != nil {
+				return d
+			}
+			continue
+		}
+		if n := summary.atOrAbove(threshold); n > 0 {
+			return &denial{
+				code: http.StatusForbidden,
+				message: fmt.Sprintf("%s image has %d vulnerabilities of severity %s or above! Please update the image.",
+					container.Image, n, threshold),
+			}
+		}
+	}
+	return nil
+}
+
+// checkScanFreshness 镜像最近一次漏洞扫描不能早于 MaxScanAge, 没有扫描过的镜像同样拒绝
+// 查询失败时按照 ScanFailClosed 处理, 生产命名空间应该同时开启 ScanFailClosed
+func (s *WebhookServer) checkScanFreshness(ctx context.Context, policy *Policy, pod *corev1.Pod) *denial {
+	maxAge := policy.MaxScanAge.Duration
+	if maxAge <= 0 {
+		return nil
+	}
+	for _, container := range podContainers(pod) {
+		if s.Scanner == nil || s.Inspector == nil {
+			if d := scanFailed(policy, container.Image, fmt.Errorf("no vulnerability scanner configured")); d != nil {
+				return d
+			}
+			continue
+		}
+		digest, err := s.Inspector.digest(ctx, container.Image)
+		if err != nil {
+			if d := inspectFailed(policy, container.Image, err); d != nil {
+				return d
+			}
+			continue
+		}
+		notBefore := s.now().Add(-maxAge)
+		scanned, err := s.Scanner.lastScanned(ctx, container.Image, digest, notBefore)
+		if err != nil {
+			if d := scanFailed(policy, container.Image, err); d != nil {
+				return d
+			}
+			continue
+		}
+		if scanned.IsZero() {
+			return &denial{
+				code:    http.StatusForbidden,
+				message: fmt.Sprintf("%s image has never been scanned for vulnerabilities! Please scan the image before deploying it.", container.Image),
+			}
+		}
+		if scanned.Before(notBefore) {
+			return &denial{
+				code: http.StatusForbidden,
+				message: fmt.Sprintf("%s image was last scanned at %s, scans older than %s are not allowed! Please rescan the image.",
+					container.Image, scanned.Format(time.RFC3339), maxAge),
+			}
+		}
+	}
+	return nil
+}
+
+// scanFailed 处理查询扫描结果失败的情况, 默认放行, 配置了 ScanFailClosed 时拒绝
+func scanFailed(policy *Policy, image string, err error) *denial {
+	klog.Errorf("Can't scan image %s: %v", image, err)
+	if !policy.ScanFailClosed {
+		return nil
+	}
+	return &denial{
+		code:    http.StatusInternalServerError,
+		message: fmt.Sprintf("can't scan image %s: %v", image, err),
+	}
+}
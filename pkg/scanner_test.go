@@ -0,0 +1,198 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type fakeScanner struct {
+	summaries map[string]VulnerabilitySummary
+	scanned   map[string]time.Time
+	err       error
+	calls     int
+}
+
+func (f *fakeScanner) LastScanned(_ context.Context, _, digest string) (time.Time, error) {
+	f.calls++
+	if f.err != nil {
+		return time.Time{}, f.err
+	}
+	return f.scanned[digest], nil
+}
+
+func (f *fakeScanner) Scan(_ context.Context, _, digest string) (VulnerabilitySummary, error) {
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.summaries[digest], nil
+}
+
+func TestCheckVulnerabilities(t *testing.T) {
+	var (
+		clean      = "registry.example.com/app@" + testDigest("clean")
+		vulnerable = "registry.example.com/app@" + testDigest("vulnerable")
+	)
+	summaries := map[string]VulnerabilitySummary{
+		testDigest("clean"):      {"LOW": 3},
+		testDigest("vulnerable"): {"LOW": 1, "CRITICAL": 1},
+	}
+	tests := []struct {
+		name     string
+		policy   Policy
+		image    string
+		scanErr  error
+		wantCode int // 0 表示放行
+	}{
+		{name: "clean image", policy: Policy{VulnerabilityThreshold: "high"}, image: clean},
+		{name: "vulnerable image", policy: Policy{VulnerabilityThreshold: "HIGH"}, image: vulnerable, wantCode: http.StatusForbidden},
+		{name: "below threshold", policy: Policy{VulnerabilityThreshold: "CRITICAL"}, image: clean},
+		{name: "scanner down fails open", policy: Policy{VulnerabilityThreshold: "HIGH"}, image: vulnerable, scanErr: errors.New("connection refused")},
+		{name: "scanner down fails closed", policy: Policy{VulnerabilityThreshold: "HIGH", ScanFailClosed: true}, image: vulnerable,
+			scanErr: errors.New("connection refused"), wantCode: http.StatusInternalServerError},
+		{name: "unknown threshold fails closed", policy: Policy{VulnerabilityThreshold: "HIHG"}, image: clean, wantCode: http.StatusInternalServerError},
+		{name: "no threshold", image: vulnerable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &WebhookServer{
+				Scanner:   NewCachedScanner(&fakeScanner{summaries: summaries, err: tt.scanErr}),
+				Inspector: NewCachedInspector(nil),
+			}
+			d := s.checkVulnerabilities(context.Background(), &tt.policy, imagePod(tt.image))
+			if code := denialCode(d); code != tt.wantCode {
+				t.Errorf("got code %d (%v), want %d", code, d, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestCachedScannerEvicts(t *testing.T) {
+	scanner := &fakeScanner{}
+	c := NewCachedScanner(scanner)
+	c.cache = newLRUCache(2)
+	for _, digest := range []string{"sha256:a", "sha256:b", "sha256:a", "sha256:c", "sha256:a"} {
+		if _, err := c.scan(context.Background(), "app", digest); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if c.cache.Len() != 2 {
+		t.Errorf("cache has %d entries, want 2", c.cache.Len())
+	}
+	// a 一直在被使用, 不会被淘汰; b 在 c 加入时被淘汰
+	if scanner.calls != 3 {
+		t.Errorf("scanner called %d times, want 3", scanner.calls)
+	}
+}
+
+func TestLoadConfigRejectsUnknownSeverity(t *testing.T) {
+	if _, err := loadTestConfig(t, "base:\n  vulnerabilityThreshold: CRITCAL\n"); err == nil {
+		t.Error("LoadConfig accepted an unknown vulnerabilityThreshold")
+	}
+}
+
+func TestCheckScanFreshness(t *testing.T) {
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	var (
+		fresh     = "registry.example.com/app@" + testDigest("fresh")
+		stale     = "registry.example.com/app@" + testDigest("stale")
+		unscanned = "registry.example.com/app@" + testDigest("unscanned")
+	)
+	scanned := map[string]time.Time{
+		testDigest("fresh"): now.Add(-time.Hour),
+		testDigest("stale"): now.Add(-8 * 24 * time.Hour),
+	}
+	week := metav1.Duration{Duration: 7 * 24 * time.Hour}
+	tests := []struct {
+		name     string
+		policy   Policy
+		image    string
+		scanErr  error
+		wantCode int
+	}{
+		{name: "fresh scan", policy: Policy{MaxScanAge: week}, image: fresh},
+		{name: "stale scan", policy: Policy{MaxScanAge: week}, image: stale, wantCode: http.StatusForbidden},
+		{name: "never scanned", policy: Policy{MaxScanAge: week}, image: unscanned, wantCode: http.StatusForbidden},
+		{name: "scanner down fails open", policy: Policy{MaxScanAge: week}, image: stale, scanErr: errors.New("connection refused")},
+		{name: "scanner down fails closed", policy: Policy{MaxScanAge: week, ScanFailClosed: true}, image: fresh,
+			scanErr: errors.New("connection refused"), wantCode: http.StatusInternalServerError},
+		{name: "not configured", image: stale},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &WebhookServer{
+				Scanner:   NewCachedScanner(&fakeScanner{scanned: scanned, err: tt.scanErr}),
+				Inspector: NewCachedInspector(nil),
+				Clock:     func() time.Time { return now },
+			}
+			d := s.checkScanFreshness(context.Background(), &tt.policy, imagePod(tt.image))
+			if code := denialCode(d); code != tt.wantCode {
+				t.Errorf("got code %d (%v), want %d", code, d, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestCachedScannerRefreshesStaleScanTimes(t *testing.T) {
+	now := time.Date(2021, 3, 1, 12, 0, 0, 0, time.UTC)
+	digest := testDigest("app")
+	scanner := &fakeScanner{scanned: map[string]time.Time{digest: now.Add(-2 * time.Hour)}}
+	c := NewCachedScanner(scanner)
+	lookup := func(notBefore time.Time) time.Time {
+		scanned, err := c.lastScanned(context.Background(), "app", digest, notBefore)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return scanned
+	}
+	lookup(now.Add(-24 * time.Hour))
+	lookup(now.Add(-24 * time.Hour))
+	if scanner.calls != 1 {
+		t.Errorf("scanner called %d times for a fresh cached scan, want 1", scanner.calls)
+	}
+	// 缓存的扫描时间不够新, 镜像可能已经重新扫描过了
+	scanner.scanned[digest] = now
+	if scanned := lookup(now.Add(-time.Hour)); !scanned.Equal(now) {
+		t.Errorf("got scan time %s, want the rescan at %s", scanned, now)
+	}
+	if scanner.calls != 2 {
+		t.Errorf("scanner called %d times, want 2", scanner.calls)
+	}
+}
+
+func TestHTTPScannerLastScanned(t *testing.T) {
+	scannedAt := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name    string
+		body    string
+		want    time.Time
+		wantErr bool
+	}{
+		{name: "scanned", body: fmt.Sprintf(`{"vulnerabilities":{"HIGH":1},"scannedAt":%q}`, scannedAt.Format(time.RFC3339)), want: scannedAt},
+		{name: "never scanned", body: `{"vulnerabilities":{}}`},
+		{name: "bad json", body: `{"scannedAt":`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fmt.Fprint(w, tt.body)
+			}))
+			defer server.Close()
+			h := &HTTPScanner{URL: server.URL, Client: server.Client()}
+			got, err := h.LastScanned(context.Background(), "app", testDigest("app"))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("LastScanned() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !got.Equal(tt.want) {
+				t.Errorf("LastScanned() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
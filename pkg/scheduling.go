@@ -0,0 +1,120 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// 控制平面节点上的 taint/label
+var controlPlaneKeys = []string{
+	"node-role.kubernetes.io/control-plane",
+	"node-role.kubernetes.io/master",
+}
+
+func isControlPlaneKey(key string) bool {
+	for _, k := range controlPlaneKeys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// checkControlPlaneScheduling 非系统命名空间的 pod 不能调度到控制平面节点上
+func checkControlPlaneScheduling(_ context.Context, policy *Policy, pod *corev1.Pod) *denial {
+	if !policy.DenyControlPlaneScheduling || policy.isSystemNamespace(pod.Namespace) {
+		return nil
+	}
+	reason := ""
+	for _, t := range pod.Spec.Tolerations {
+		if isControlPlaneKey(t.Key) || (t.Key == "" && t.Operator == corev1.TolerationOpExists) {
+			reason = fmt.Sprintf("tolerates the control-plane taint %q", t.Key)
+			break
+		}
+	}
+	for key := range pod.Spec.NodeSelector {
+		if isControlPlaneKey(key) {
+			reason = fmt.Sprintf("selects control-plane nodes with nodeSelector %q", key)
+		}
+	}
+	if affinity := pod.Spec.Affinity; affinity != nil && affinity.NodeAffinity != nil &&
+		affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution != nil {
+		for _, term := range affinity.NodeAffinity.RequiredDuringSchedulingIgnoredDuringExecution.NodeSelectorTerms {
+			for _, expr := range term.MatchExpressions {
+				if isControlPlaneKey(expr.Key) && (expr.Operator == corev1.NodeSelectorOpIn || expr.Operator == corev1.NodeSelectorOpExists) {
+					reason = fmt.Sprintf("requires control-plane nodes with node affinity %q", expr.Key)
+				}
+			}
+		}
+	}
+	if reason == "" {
+		return nil
+	}
+	return &denial{
+		code: http.StatusForbidden,
+		message: fmt.Sprintf("pod %s %s, only pods in system namespaces %v can run on control-plane nodes.",
+			pod.Name, reason, policy.systemNamespaces()),
+	}
+}
+
+const (
+	zoneLabel           = "topology.kubernetes.io/zone"
+	complianceLabel     = "compliance"
+	complianceRegulated = "regulated"
+)
+
+// checkRegulatedZone 带有 compliance: regulated 标签的 pod 必须通过 nodeSelector 固定在允许的可用区
+func checkRegulatedZone(_ context.Context, policy *Policy, pod *corev1.Pod) *denial {
+	if len(policy.RegulatedZones) == 0 || pod.Labels[complianceLabel] != complianceRegulated {
+		return nil
+	}
+	zone, ok := pod.Spec.NodeSelector[zoneLabel]
+	if ok && containsString(policy.RegulatedZones, zone) {
+		return nil
+	}
+	reason := fmt.Sprintf("has no %s nodeSelector", zoneLabel)
+	if ok {
+		reason = fmt.Sprintf("selects zone %s", zone)
+	}
+	return &denial{
+		code: http.StatusForbidden,
+		message: fmt.Sprintf("regulated pod %s %s! Regulated pods must select one of the zones %v with nodeSelector %s.",
+			pod.Name, reason, policy.RegulatedZones, zoneLabel),
+	}
+}
+
+// checkForbiddenNodes pod 不能通过 spec.nodeName 直接指定 ForbiddenNodes 中的节点, 比如正在维护的节点
+// 直接指定 nodeName 会绕过调度器和 taint, 所以需要在准入时拦截
+func checkForbiddenNodes(_ context.Context, policy *Policy, pod *corev1.Pod) *denial {
+	if pod.Spec.NodeName == "" || !containsString(policy.ForbiddenNodes, pod.Spec.NodeName) {
+		return nil
+	}
+	return &denial{
+		code:    http.StatusForbidden,
+		message: fmt.Sprintf("pod %s is assigned to node %s, which doesn't accept new pods.", pod.Name, pod.Spec.NodeName),
+	}
+}
+
+// checkSchedulerName spec.schedulerName 必须在 AllowedSchedulers 中, 使用自定义调度器的集群避免 pod 指定不存在的调度器一直 Pending
+// 没有指定 schedulerName 时 api-server 会设置为 default-scheduler, 这里按照 default-scheduler 检查
+func checkSchedulerName(_ context.Context, policy *Policy, pod *corev1.Pod) *denial {
+	if len(policy.AllowedSchedulers) == 0 {
+		return nil
+	}
+	scheduler := pod.Spec.SchedulerName
+	if scheduler == "" {
+		scheduler = corev1.DefaultSchedulerName
+	}
+	if containsString(policy.AllowedSchedulers, scheduler) {
+		return nil
+	}
+	return &denial{
+		code: http.StatusForbidden,
+		message: fmt.Sprintf("pod %s uses scheduler %s! Only schedulers %v are allowed.",
+			pod.Name, scheduler, policy.AllowedSchedulers),
+		field: "schedulerName",
+	}
+}
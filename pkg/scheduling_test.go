@@ -0,0 +1,144 @@
+package pkg
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCheckControlPlaneScheduling(t *testing.T) {
+	affinity := func(key string, op corev1.NodeSelectorOperator) *corev1.Affinity {
+		return &corev1.Affinity{NodeAffinity: &corev1.NodeAffinity{
+			RequiredDuringSchedulingIgnoredDuringExecution: &corev1.NodeSelector{NodeSelectorTerms: []corev1.NodeSelectorTerm{{
+				MatchExpressions: []corev1.NodeSelectorRequirement{{Key: key, Operator: op}},
+			}}},
+		}}
+	}
+	tests := []struct {
+		name      string
+		namespace string
+		spec      corev1.PodSpec
+		policy    Policy
+		wantCode  int
+	}{
+		{name: "plain pod"},
+		{
+			name:     "control-plane toleration",
+			spec:     corev1.PodSpec{Tolerations: []corev1.Toleration{{Key: "node-role.kubernetes.io/master", Effect: corev1.TaintEffectNoSchedule}}},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "tolerates everything",
+			spec:     corev1.PodSpec{Tolerations: []corev1.Toleration{{Operator: corev1.TolerationOpExists}}},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name: "other toleration",
+			spec: corev1.PodSpec{Tolerations: []corev1.Toleration{{Key: "dedicated", Operator: corev1.TolerationOpExists}}},
+		},
+		{
+			name:     "nodeSelector",
+			spec:     corev1.PodSpec{NodeSelector: map[string]string{"node-role.kubernetes.io/control-plane": ""}},
+			wantCode: http.StatusForbidden,
+		},
+		{
+			name:     "node affinity",
+			spec:     corev1.PodSpec{Affinity: affinity("node-role.kubernetes.io/control-plane", corev1.NodeSelectorOpExists)},
+			wantCode: http.StatusForbidden,
+		},
+		{name: "anti node affinity", spec: corev1.PodSpec{Affinity: affinity("node-role.kubernetes.io/control-plane", corev1.NodeSelectorOpDoesNotExist)}},
+		{
+			name:      "system namespace",
+			namespace: "kube-system",
+			spec:      corev1.PodSpec{NodeSelector: map[string]string{"node-role.kubernetes.io/master": ""}},
+		},
+		{
+			name:      "custom system namespaces",
+			namespace: "kube-system",
+			spec:      corev1.PodSpec{NodeSelector: map[string]string{"node-role.kubernetes.io/master": ""}},
+			policy:    Policy{SystemNamespaces: []string{"monitoring"}},
+			wantCode:  http.StatusForbidden,
+		},
+	}
+	for _, tt := range tests {
+		namespace := tt.namespace
+		if namespace == "" {
+			namespace = "team"
+		}
+		policy := tt.policy
+		policy.DenyControlPlaneScheduling = true
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: namespace}, Spec: tt.spec}
+		if code := denialCode(checkControlPlaneScheduling(context.Background(), &policy, pod)); code != tt.wantCode {
+			t.Errorf("%s: got code %d, want %d", tt.name, code, tt.wantCode)
+		}
+	}
+}
+
+func TestCheckRegulatedZone(t *testing.T) {
+	policy := &Policy{RegulatedZones: []string{"cn-north-1a", "cn-north-1b"}}
+	regulated := map[string]string{complianceLabel: complianceRegulated}
+	tests := []struct {
+		name         string
+		labels       map[string]string
+		nodeSelector map[string]string
+		wantCode     int
+	}{
+		{name: "allowed zone", labels: regulated, nodeSelector: map[string]string{zoneLabel: "cn-north-1a"}},
+		{name: "other zone", labels: regulated, nodeSelector: map[string]string{zoneLabel: "cn-north-1c"}, wantCode: http.StatusForbidden},
+		{name: "no zone selector", labels: regulated, nodeSelector: map[string]string{"disktype": "ssd"}, wantCode: http.StatusForbidden},
+		{name: "not regulated", nodeSelector: map[string]string{zoneLabel: "cn-north-1c"}},
+		{name: "other compliance", labels: map[string]string{complianceLabel: "internal"}},
+	}
+	for _, tt := range tests {
+		pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web", Labels: tt.labels}, Spec: corev1.PodSpec{NodeSelector: tt.nodeSelector}}
+		if code := denialCode(checkRegulatedZone(context.Background(), policy, pod)); code != tt.wantCode {
+			t.Errorf("%s: got code %d, want %d", tt.name, code, tt.wantCode)
+		}
+	}
+}
+
+func TestCheckForbiddenNodes(t *testing.T) {
+	tests := []struct {
+		name      string
+		nodeName  string
+		forbidden []string
+		wantCode  int
+	}{
+		{name: "forbidden node", nodeName: "node-maintenance", forbidden: []string{"node-maintenance"}, wantCode: http.StatusForbidden},
+		{name: "other node", nodeName: "node-1", forbidden: []string{"node-maintenance"}},
+		{name: "scheduled by the scheduler", forbidden: []string{"node-maintenance"}},
+		{name: "not configured", nodeName: "node-maintenance"},
+	}
+	for _, tt := range tests {
+		pod := imagePod("nginx")
+		pod.Spec.NodeName = tt.nodeName
+		if code := denialCode(checkForbiddenNodes(context.Background(), &Policy{ForbiddenNodes: tt.forbidden}, pod)); code != tt.wantCode {
+			t.Errorf("%s: got code %d, want %d", tt.name, code, tt.wantCode)
+		}
+	}
+}
+
+func TestCheckSchedulerName(t *testing.T) {
+	tests := []struct {
+		name      string
+		scheduler string
+		allowed   []string
+		wantCode  int
+	}{
+		{name: "allowed scheduler", scheduler: "volcano", allowed: []string{"volcano"}},
+		{name: "unknown scheduler", scheduler: "volcnao", allowed: []string{"volcano"}, wantCode: http.StatusForbidden},
+		{name: "default scheduler not listed", allowed: []string{"volcano"}, wantCode: http.StatusForbidden},
+		{name: "default scheduler listed", allowed: []string{"volcano", corev1.DefaultSchedulerName}},
+		{name: "not configured", scheduler: "volcnao"},
+	}
+	for _, tt := range tests {
+		pod := imagePod("nginx")
+		pod.Spec.SchedulerName = tt.scheduler
+		if code := denialCode(checkSchedulerName(context.Background(), &Policy{AllowedSchedulers: tt.allowed}, pod)); code != tt.wantCode {
+			t.Errorf("%s: got code %d, want %d", tt.name, code, tt.wantCode)
+		}
+	}
+}
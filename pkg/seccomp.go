@@ -0,0 +1,55 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// 默认允许的 seccomp profile 类型
+var defaultSeccompProfiles = []string{
+	string(corev1.SeccompProfileTypeRuntimeDefault),
+	string(corev1.SeccompProfileTypeLocalhost),
+}
+
+func (p *Policy) allowedSeccompProfiles() []string {
+	if len(p.AllowedSeccompProfiles) == 0 {
+		return defaultSeccompProfiles
+	}
+	return p.AllowedSeccompProfiles
+}
+
+// checkSeccompProfile 每个容器都必须使用允许的 seccomp profile, 容器没有设置时继承 pod 级别的配置, 都没有设置时拒绝
+func checkSeccompProfile(_ context.Context, policy *Policy, pod *corev1.Pod) *denial {
+	if !policy.RequireSeccompProfile {
+		return nil
+	}
+	var podProfile *corev1.SeccompProfile
+	if pod.Spec.SecurityContext != nil {
+		podProfile = pod.Spec.SecurityContext.SeccompProfile
+	}
+	allowed := policy.allowedSeccompProfiles()
+	for _, container := range podContainers(pod) {
+		profile := podProfile
+		if container.SecurityContext != nil && container.SecurityContext.SeccompProfile != nil {
+			profile = container.SecurityContext.SeccompProfile
+		}
+		if profile == nil {
+			return &denial{
+				code: http.StatusForbidden,
+				message: fmt.Sprintf("container %s has no seccomp profile! Please set securityContext.seccompProfile.type to one of %v.",
+					container.Name, allowed),
+			}
+		}
+		if !containsString(allowed, string(profile.Type)) {
+			return &denial{
+				code: http.StatusForbidden,
+				message: fmt.Sprintf("container %s uses seccomp profile %s, only %v are allowed.",
+					container.Name, profile.Type, allowed),
+			}
+		}
+	}
+	return nil
+}
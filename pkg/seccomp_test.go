@@ -0,0 +1,50 @@
+package pkg
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestCheckSeccompProfile(t *testing.T) {
+	profile := func(t corev1.SeccompProfileType) *corev1.SeccompProfile { return &corev1.SeccompProfile{Type: t} }
+	tests := []struct {
+		name                   string
+		podProfile, appProfile *corev1.SeccompProfile
+		policy                 Policy
+		wantCode               int
+	}{
+		{name: "pod runtime default", podProfile: profile(corev1.SeccompProfileTypeRuntimeDefault)},
+		{name: "container localhost", appProfile: profile(corev1.SeccompProfileTypeLocalhost)},
+		{name: "no profile", wantCode: http.StatusForbidden},
+		{name: "unconfined", podProfile: profile(corev1.SeccompProfileTypeUnconfined), wantCode: http.StatusForbidden},
+		{
+			name:       "container overrides the pod",
+			podProfile: profile(corev1.SeccompProfileTypeRuntimeDefault),
+			appProfile: profile(corev1.SeccompProfileTypeUnconfined),
+			wantCode:   http.StatusForbidden,
+		},
+		{
+			name:       "custom allowed profiles",
+			podProfile: profile(corev1.SeccompProfileTypeLocalhost),
+			policy:     Policy{AllowedSeccompProfiles: []string{string(corev1.SeccompProfileTypeRuntimeDefault)}},
+			wantCode:   http.StatusForbidden,
+		},
+	}
+	for _, tt := range tests {
+		pod := imagePod("nginx")
+		if tt.podProfile != nil {
+			pod.Spec.SecurityContext = &corev1.PodSecurityContext{SeccompProfile: tt.podProfile}
+		}
+		if tt.appProfile != nil {
+			pod.Spec.Containers[0].SecurityContext = &corev1.SecurityContext{SeccompProfile: tt.appProfile}
+		}
+		policy := tt.policy
+		policy.RequireSeccompProfile = true
+		if code := denialCode(checkSeccompProfile(context.Background(), &policy, pod)); code != tt.wantCode {
+			t.Errorf("%s: got code %d, want %d", tt.name, code, tt.wantCode)
+		}
+	}
+}
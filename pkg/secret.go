@@ -0,0 +1,32 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	admissionV1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// checkSecretType 不允许创建 DeniedSecretTypes 中的类型的 Secret, 比如要求使用外部的密钥管理而不是 kubernetes.io/basic-auth
+// 拒绝原因中只包含 Secret 的名字和类型, 不包含内容
+func checkSecretType(_ context.Context, policy *Policy, _ *admissionV1.AdmissionRequest, obj runtime.Object) *denial {
+	secret, ok := obj.(*corev1.Secret)
+	if !ok || len(policy.DeniedSecretTypes) == 0 {
+		return nil
+	}
+	secretType := secret.Type
+	if secretType == "" {
+		secretType = corev1.SecretTypeOpaque
+	}
+	if !containsString(policy.DeniedSecretTypes, string(secretType)) {
+		return nil
+	}
+	return &denial{
+		code: http.StatusForbidden,
+		message: fmt.Sprintf("secret %s has type %s, which is not allowed in namespace %s! Please use the external secret manager instead.",
+			secret.Name, secretType, secret.Namespace),
+	}
+}
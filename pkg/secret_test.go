@@ -0,0 +1,41 @@
+package pkg
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestCheckSecretType(t *testing.T) {
+	denied := []string{string(corev1.SecretTypeBasicAuth), string(corev1.SecretTypeOpaque)}
+	tests := []struct {
+		name     string
+		raw      string
+		denied   []string
+		wantCode int
+	}{
+		{name: "basic auth", raw: `{"metadata":{"name":"db"},"type":"kubernetes.io/basic-auth","stringData":{"password":"hunter2"}}`, denied: denied, wantCode: http.StatusForbidden},
+		{name: "no type is opaque", raw: `{"metadata":{"name":"db"},"data":{"password":"aHVudGVyMg=="}}`, denied: denied, wantCode: http.StatusForbidden},
+		{name: "allowed type", raw: `{"metadata":{"name":"tls"},"type":"kubernetes.io/tls"}`, denied: denied},
+		{name: "not configured", raw: `{"metadata":{"name":"db"},"type":"kubernetes.io/basic-auth"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			obj, _, err := decodeRaw("Secret", "team", []byte(tt.raw), 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if secret := obj.(*corev1.Secret); secret.Data != nil || secret.StringData != nil {
+				t.Errorf("decoded secret keeps its data: %+v", secret)
+			}
+			d := checkSecretType(context.Background(), &Policy{DeniedSecretTypes: tt.denied}, nil, obj)
+			assertDenial(t, d, tt.wantCode, false)
+			if d != nil && (strings.Contains(d.message, "hunter2") || strings.Contains(d.message, "aHVudGVyMg==")) {
+				t.Errorf("denial leaks the secret: %s", d.message)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// checkSecurityContextDowngrade 容器级别的 securityContext 会覆盖 pod 级别的同名字段,
+// 容器不能把 pod 上设置的更严格的配置改回去, 比如 pod 设置了 runAsNonRoot: true, 容器又设置 runAsNonRoot: false
+// 容器没有设置的字段 (包括 securityContext: {} 和显式的 null) 会继承 pod 的配置, 不算降级
+func checkSecurityContextDowngrade(_ context.Context, policy *Policy, pod *corev1.Pod) *denial {
+	podSC := pod.Spec.SecurityContext
+	if !policy.DenySecurityContextDowngrade || podSC == nil {
+		return nil
+	}
+	for i, container := range podContainers(pod) {
+		sc := container.SecurityContext
+		if sc == nil {
+			continue
+		}
+		field, reason := "", ""
+		switch {
+		case podSC.RunAsNonRoot != nil && *podSC.RunAsNonRoot && sc.RunAsNonRoot != nil && !*sc.RunAsNonRoot:
+			field, reason = "runAsNonRoot", "sets runAsNonRoot: false while the pod requires runAsNonRoot: true"
+		case podSC.RunAsUser != nil && *podSC.RunAsUser != 0 && sc.RunAsUser != nil && *sc.RunAsUser == 0:
+			field, reason = "runAsUser", fmt.Sprintf("sets runAsUser: 0 while the pod runs as UID %d", *podSC.RunAsUser)
+		case podSC.RunAsGroup != nil && *podSC.RunAsGroup != 0 && sc.RunAsGroup != nil && *sc.RunAsGroup == 0:
+			field, reason = "runAsGroup", fmt.Sprintf("sets runAsGroup: 0 while the pod runs as GID %d", *podSC.RunAsGroup)
+		case podSC.SeccompProfile != nil && podSC.SeccompProfile.Type != corev1.SeccompProfileTypeUnconfined &&
+			sc.SeccompProfile != nil && sc.SeccompProfile.Type == corev1.SeccompProfileTypeUnconfined:
+			field, reason = "seccompProfile", fmt.Sprintf("sets an Unconfined seccompProfile while the pod uses %s", podSC.SeccompProfile.Type)
+		}
+		if field == "" {
+			continue
+		}
+		return &denial{
+			code: http.StatusForbidden,
+			message: fmt.Sprintf("container %s in pod %s %s! Container securityContext fields override the pod securityContext, please remove securityContext.%s from the container to inherit the pod setting.",
+				container.Name, pod.Name, reason, field),
+			field: containerField(pod, i) + ".securityContext." + field,
+		}
+	}
+	return nil
+}
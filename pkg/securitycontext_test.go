@@ -0,0 +1,50 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestCheckSecurityContextDowngrade(t *testing.T) {
+	strict := `"securityContext":{"runAsNonRoot":true,"runAsUser":1000,"runAsGroup":1000,"seccompProfile":{"type":"RuntimeDefault"}}`
+	tests := []struct {
+		name      string
+		spec      string
+		disabled  bool
+		wantCode  int
+		wantField string
+	}{
+		{name: "inherits the pod", spec: `{` + strict + `,"containers":[{"name":"app"}]}`},
+		{name: "empty security context", spec: `{` + strict + `,"containers":[{"name":"app","securityContext":{}}]}`},
+		{name: "null security context", spec: `{` + strict + `,"containers":[{"name":"app","securityContext":null}]}`},
+		{name: "stricter container", spec: `{` + strict + `,"containers":[{"name":"app","securityContext":{"runAsUser":2000}}]}`},
+		{name: "runAsNonRoot false", spec: `{` + strict + `,"containers":[{"name":"app","securityContext":{"runAsNonRoot":false}}]}`,
+			wantCode: http.StatusForbidden, wantField: "containers[0].securityContext.runAsNonRoot"},
+		{name: "root user", spec: `{` + strict + `,"containers":[{"name":"app"},{"name":"sidecar","securityContext":{"runAsUser":0}}]}`,
+			wantCode: http.StatusForbidden, wantField: "containers[1].securityContext.runAsUser"},
+		{name: "root group in init container", spec: `{` + strict + `,"initContainers":[{"name":"setup","securityContext":{"runAsGroup":0}}],"containers":[{"name":"app"}]}`,
+			wantCode: http.StatusForbidden, wantField: "initContainers[0].securityContext.runAsGroup"},
+		{name: "unconfined seccomp", spec: `{` + strict + `,"containers":[{"name":"app","securityContext":{"seccompProfile":{"type":"Unconfined"}}}]}`,
+			wantCode: http.StatusForbidden, wantField: "containers[0].securityContext.seccompProfile"},
+		{name: "pod runs as root", spec: `{"securityContext":{"runAsUser":0},"containers":[{"name":"app","securityContext":{"runAsUser":0}}]}`},
+		{name: "no pod security context", spec: `{"containers":[{"name":"app","securityContext":{"runAsNonRoot":false}}]}`},
+		{name: "not configured", spec: `{` + strict + `,"containers":[{"name":"app","securityContext":{"runAsNonRoot":false}}]}`, disabled: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pod := &corev1.Pod{}
+			if err := json.Unmarshal([]byte(tt.spec), &pod.Spec); err != nil {
+				t.Fatal(err)
+			}
+			d := checkSecurityContextDowngrade(context.Background(), &Policy{DenySecurityContextDowngrade: !tt.disabled}, pod)
+			assertDenial(t, d, tt.wantCode, false)
+			if d != nil && d.field != tt.wantField {
+				t.Errorf("denied field %q, want %q", d.field, tt.wantField)
+			}
+		})
+	}
+}
@@ -0,0 +1,36 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	admissionV1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// checkServiceType 受限的命名空间中不允许 NodePort (以及按配置不允许 LoadBalancer) 类型的 Service, 只能使用 ClusterIP
+// LoadBalancer 类型同样会分配 NodePort, 所以只关闭 NodePort 时也会拒绝 LoadBalancer, 除非显式关闭了节点端口分配
+func checkServiceType(_ context.Context, policy *Policy, _ *admissionV1.AdmissionRequest, obj runtime.Object) *denial {
+	svc, ok := obj.(*corev1.Service)
+	if !ok {
+		return nil
+	}
+	denied := false
+	switch svc.Spec.Type {
+	case corev1.ServiceTypeNodePort:
+		denied = policy.DenyNodePortServices
+	case corev1.ServiceTypeLoadBalancer:
+		allocatesNodePorts := svc.Spec.AllocateLoadBalancerNodePorts == nil || *svc.Spec.AllocateLoadBalancerNodePorts
+		denied = policy.DenyLoadBalancerServices || (policy.DenyNodePortServices && allocatesNodePorts)
+	}
+	if !denied {
+		return nil
+	}
+	return &denial{
+		code: http.StatusForbidden,
+		message: fmt.Sprintf("service %s has type %s, only %s services are allowed in namespace %s.",
+			svc.Name, svc.Spec.Type, corev1.ServiceTypeClusterIP, svc.Namespace),
+	}
+}
@@ -0,0 +1,38 @@
+package pkg
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestCheckServiceType(t *testing.T) {
+	noNodePorts := false
+	tests := []struct {
+		name              string
+		serviceType       corev1.ServiceType
+		allocateNodePorts *bool
+		policy            Policy
+		wantCode          int
+	}{
+		{name: "cluster IP", serviceType: corev1.ServiceTypeClusterIP, policy: Policy{DenyNodePortServices: true, DenyLoadBalancerServices: true}},
+		{name: "node port", serviceType: corev1.ServiceTypeNodePort, policy: Policy{DenyNodePortServices: true}, wantCode: http.StatusForbidden},
+		{name: "load balancer allocates node ports", serviceType: corev1.ServiceTypeLoadBalancer, policy: Policy{DenyNodePortServices: true}, wantCode: http.StatusForbidden},
+		{name: "load balancer without node ports", serviceType: corev1.ServiceTypeLoadBalancer, allocateNodePorts: &noNodePorts, policy: Policy{DenyNodePortServices: true}},
+		{name: "load balancer denied", serviceType: corev1.ServiceTypeLoadBalancer, allocateNodePorts: &noNodePorts, policy: Policy{DenyLoadBalancerServices: true}, wantCode: http.StatusForbidden},
+		{name: "node port with only load balancers denied", serviceType: corev1.ServiceTypeNodePort, policy: Policy{DenyLoadBalancerServices: true}},
+		{name: "not configured", serviceType: corev1.ServiceTypeNodePort},
+	}
+	for _, tt := range tests {
+		svc := &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "team"},
+			Spec:       corev1.ServiceSpec{Type: tt.serviceType, AllocateLoadBalancerNodePorts: tt.allocateNodePorts},
+		}
+		if code := denialCode(checkServiceType(context.Background(), &tt.policy, nil, svc)); code != tt.wantCode {
+			t.Errorf("%s: got code %d, want %d", tt.name, code, tt.wantCode)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	admissionV1 "k8s.io/api/admission/v1"
+	"k8s.io/klog"
+)
+
+// Decision 一次准入决定, 发送给 SIEM 之类的外部系统
+type Decision struct {
+	Time          time.Time `json:"time"`
+	UID           string    `json:"uid"`
+	Kind          string    `json:"kind"`
+	Namespace     string    `json:"namespace"`
+	Name          string    `json:"name"`
+	Operation     string    `json:"operation"`
+	User          string    `json:"user"`
+	Allowed       bool      `json:"allowed"`
+	Message       string    `json:"message,omitempty"`
+	PolicyVersion string    `json:"policyVersion,omitempty"`
+
+	BreakGlassApprover string `json:"breakGlassApprover,omitempty"` // 通过 break-glass 绕过检查时的批准人
+}
+
+func newDecision(req *admissionV1.AdmissionRequest, resp *admissionV1.AdmissionResponse, policyVersion string) Decision {
+	d := Decision{
+		Time:          time.Now(),
+		UID:           string(req.UID),
+		Kind:          req.Kind.Kind,
+		Namespace:     req.Namespace,
+		Name:          req.Name,
+		Operation:     string(req.Operation),
+		User:          req.UserInfo.Username,
+		Allowed:       resp.Allowed,
+		PolicyVersion: policyVersion,
+	}
+	if resp.Result != nil {
+		d.Message = resp.Result.Message
+	}
+	if approver, ok := resp.AuditAnnotations["break-glass-approver"]; ok {
+		d.BreakGlassApprover = approver
+		d.Message = resp.AuditAnnotations["break-glass-bypassed"]
+	}
+	return d
+}
+
+// DecisionSink 接收准入决定, Send 不能阻塞准入请求
+type DecisionSink interface {
+	Send(d Decision)
+}
+
+var sinkDroppedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+	Name: "admission_decision_sink_dropped_total",
+	Help: "Number of admission decisions dropped because the sink queue was full or delivery failed.",
+})
+
+func init() {
+	prometheus.MustRegister(sinkDroppedTotal)
+}
+
+// HTTPSink 把准入决定以 json POST 到指定的地址
+// 决定先放进有界的队列, 由 Run 异步发送, 队列满了或者重试之后仍然失败的决定会被丢弃并计数
+type HTTPSink struct {
+	URL     string
+	Client  *http.Client
+	Retries int // 失败后的重试次数
+
+	queue chan Decision
+}
+
+// NewHTTPSink 创建 HTTPSink, queueSize 为队列长度
+func NewHTTPSink(url string, queueSize int) *HTTPSink {
+	return &HTTPSink{
+		URL:     url,
+		Client:  &http.Client{Timeout: 5 * time.Second},
+		Retries: 3,
+		queue:   make(chan Decision, queueSize),
+	}
+}
+
+// Send 把决定放进队列, 队列满时直接丢弃
+func (h *HTTPSink) Send(d Decision) {
+	select {
+	case h.queue <- d:
+	default:
+		sinkDroppedTotal.Inc()
+	}
+}
+
+// Run 从队列中取出决定并发送, 直到 stopCh 关闭
+func (h *HTTPSink) Run(stopCh <-chan struct{}) {
+	for {
+		select {
+		case d := <-h.queue:
+			h.deliver(d)
+		case <-stopCh:
+			return
+		}
+	}
+}
+
+func (h *HTTPSink) deliver(d Decision) {
+	body, err := json.Marshal(d)
+	if err != nil {
+		klog.Errorf("Can't encode decision: %v", err)
+		sinkDroppedTotal.Inc()
+		return
+	}
+	backoff := 100 * time.Millisecond
+	for attempt := 0; ; attempt++ {
+		err = h.post(body)
+		if err == nil {
+			return
+		}
+		if attempt >= h.Retries {
+			break
+		}
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+	klog.Errorf("Failed to deliver decision %s to %s: %v", d.UID, h.URL, err)
+	sinkDroppedTotal.Inc()
+}
+
+func (h *HTTPSink) post(body []byte) error {
+	req, err := http.NewRequest(http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := h.Client.Do(req.WithContext(context.Background()))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("sink returned %s", resp.Status)
+	}
+	return nil
+}
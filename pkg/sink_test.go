@@ -0,0 +1,124 @@
+package pkg
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/haozi4263/admission-registry/pkg/testutil"
+	promtestutil "github.com/prometheus/client_golang/prometheus/testutil"
+	admissionV1 "k8s.io/api/admission/v1"
+)
+
+// sinkServer 假的 SIEM 服务, 前 failures 个请求返回 503, 之后保存收到的决定
+type sinkServer struct {
+	mu        sync.Mutex
+	failures  int
+	decisions []Decision
+	received  chan struct{}
+}
+
+func (s *sinkServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.failures > 0 {
+		s.failures--
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	var d Decision
+	if err := json.NewDecoder(r.Body).Decode(&d); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	s.decisions = append(s.decisions, d)
+	s.received <- struct{}{}
+}
+
+func TestHTTPSinkDelivers(t *testing.T) {
+	tests := []struct {
+		name     string
+		failures int
+	}{
+		{name: "first attempt"},
+		{name: "after retries", failures: 2},
+	}
+	for _, tt := range tests {
+		receiver := &sinkServer{failures: tt.failures, received: make(chan struct{}, 10)}
+		server := httptest.NewServer(receiver)
+		sink := NewHTTPSink(server.URL, 10)
+		stopCh := make(chan struct{})
+		go sink.Run(stopCh)
+
+		s := &WebhookServer{Sink: sink}
+		s.SetConfig(Config{PolicyVersion: "v7", Base: Policy{WhiteListRegistries: []string{"docker.io"}}})
+		review := testutil.NewPodAdmissionReview(testPod("web", "quay.io/app:1.0"), admissionV1.Create)
+		s.Review(review)
+		select {
+		case <-receiver.received:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("%s: decision was not delivered", tt.name)
+		}
+		close(stopCh)
+		server.Close()
+
+		d := receiver.decisions[0]
+		if d.UID != string(review.Request.UID) || d.Allowed || d.Namespace != "team" || d.Name != "web" || d.PolicyVersion != "v7" {
+			t.Errorf("%s: got decision %+v", tt.name, d)
+		}
+	}
+}
+
+func TestHTTPSinkDoesNotBlock(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+
+	sink := NewHTTPSink(server.URL, 1)
+	sink.Retries = 0
+	stopCh, done := make(chan struct{}), make(chan struct{})
+	go func() {
+		sink.Run(stopCh)
+		close(done)
+	}()
+	// 等 Run 退出之后再结束, 避免队列中剩下的决定在后面的测试中被丢弃
+	defer func() {
+		close(stopCh)
+		close(release)
+		<-done
+	}()
+
+	s := &WebhookServer{Sink: sink}
+	s.SetConfig(Config{Base: Policy{WhiteListRegistries: []string{"docker.io"}}})
+	dropped := promtestutil.ToFloat64(sinkDroppedTotal)
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		s.Review(testutil.NewPodAdmissionReview(testPod("web", "nginx:1.21"), admissionV1.Create))
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("10 reviews took %s with a stuck sink", elapsed)
+	}
+	// 一个正在发送, 一个在队列中, 其余的被丢弃
+	if got := promtestutil.ToFloat64(sinkDroppedTotal) - dropped; got < 8 {
+		t.Errorf("dropped %v decisions, want at least 8", got)
+	}
+}
+
+func TestHTTPSinkDropsUndeliverable(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	sink := NewHTTPSink(server.URL, 1)
+	sink.Retries = 1
+	dropped := promtestutil.ToFloat64(sinkDroppedTotal)
+	sink.deliver(Decision{UID: "undeliverable"})
+	if got := promtestutil.ToFloat64(sinkDroppedTotal) - dropped; got != 1 {
+		t.Errorf("dropped %v decisions, want 1", got)
+	}
+}
@@ -0,0 +1,43 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	admissionV1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// checkHeadlessService StatefulSet 的 serviceName 必须是命名空间中已经存在的 headless Service, 否则 pod 的 DNS 记录无法解析
+func (s *WebhookServer) checkHeadlessService(ctx context.Context, policy *Policy, _ *admissionV1.AdmissionRequest, obj runtime.Object) *denial {
+	sts, ok := obj.(*appsv1.StatefulSet)
+	if !ok || policy.RequireHeadlessService == "" || s.Client == nil {
+		return nil
+	}
+	if sts.Spec.ServiceName == "" {
+		return policy.RequireHeadlessService.violation(http.StatusForbidden,
+			fmt.Sprintf("statefulset %s has no serviceName! It must reference a headless Service.", sts.Name))
+	}
+	ctx, cancel := s.lookupContext(ctx)
+	defer cancel()
+	svc, err := s.Client.CoreV1().Services(sts.Namespace).Get(ctx, sts.Spec.ServiceName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return policy.RequireHeadlessService.violation(http.StatusForbidden,
+			fmt.Sprintf("statefulset %s references Service %s, which does not exist in namespace %s!",
+				sts.Name, sts.Spec.ServiceName, sts.Namespace))
+	}
+	if err != nil {
+		return lookupFailed(policy, "Service "+sts.Spec.ServiceName, err)
+	}
+	if svc.Spec.ClusterIP != corev1.ClusterIPNone {
+		return policy.RequireHeadlessService.violation(http.StatusForbidden,
+			fmt.Sprintf("statefulset %s references Service %s, which is not headless! The governing Service must set clusterIP: None.",
+				sts.Name, svc.Name))
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package pkg
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func statefulSet(serviceName string) *appsv1.StatefulSet {
+	return &appsv1.StatefulSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "store", Namespace: "team"},
+		Spec:       appsv1.StatefulSetSpec{ServiceName: serviceName},
+	}
+}
+
+func TestCheckHeadlessService(t *testing.T) {
+	headless := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "team"}, Spec: corev1.ServiceSpec{ClusterIP: corev1.ClusterIPNone}}
+	clusterIP := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "team"}, Spec: corev1.ServiceSpec{ClusterIP: "10.0.0.10"}}
+	tests := []struct {
+		name        string
+		obj         runtime.Object
+		policy      Policy
+		failGets    bool
+		wantCode    int
+		wantWarning bool
+	}{
+		{name: "headless service", obj: statefulSet("db"), policy: Policy{RequireHeadlessService: ActionDeny}},
+		{name: "service is not headless", obj: statefulSet("web"), policy: Policy{RequireHeadlessService: ActionDeny}, wantCode: http.StatusForbidden},
+		{name: "missing service", obj: statefulSet("missing"), policy: Policy{RequireHeadlessService: ActionDeny}, wantCode: http.StatusForbidden},
+		{name: "no serviceName", obj: statefulSet(""), policy: Policy{RequireHeadlessService: ActionDeny}, wantCode: http.StatusForbidden},
+		{name: "warn only", obj: statefulSet("missing"), policy: Policy{RequireHeadlessService: ActionWarn}, wantCode: http.StatusForbidden, wantWarning: true},
+		{name: "not configured", obj: statefulSet("missing")},
+		{name: "not a statefulset", obj: &appsv1.Deployment{}, policy: Policy{RequireHeadlessService: ActionDeny}},
+		{name: "lookup error fails open", obj: statefulSet("db"), policy: Policy{RequireHeadlessService: ActionDeny}, failGets: true},
+		{
+			name:     "lookup error fails closed",
+			obj:      statefulSet("db"),
+			policy:   Policy{RequireHeadlessService: ActionDeny, LookupFailClosed: true},
+			failGets: true,
+			wantCode: http.StatusInternalServerError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset(headless, clusterIP)
+			if tt.failGets {
+				failRequests(client, "get", "services")
+			}
+			s := &WebhookServer{Client: client}
+			assertDenial(t, s.checkHeadlessService(context.Background(), &tt.policy, nil, tt.obj), tt.wantCode, tt.wantWarning)
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+
+	admissionV1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/klog"
+)
+
+// checkStorageClasses pod 引用的 PVC 只能使用允许的 StorageClass
+// 没有设置 storageClassName 的 PVC 使用集群默认的 StorageClass, 不做检查
+func (s *WebhookServer) checkStorageClasses(ctx context.Context, policy *Policy, pod *corev1.Pod) *denial {
+	if len(policy.AllowedStorageClasses) == 0 || s.Client == nil {
+		return nil
+	}
+	for _, volume := range pod.Spec.Volumes {
+		if volume.PersistentVolumeClaim == nil {
+			continue
+		}
+		lookupCtx, cancel := s.lookupContext(ctx)
+		pvc, err := s.Client.CoreV1().PersistentVolumeClaims(pod.Namespace).Get(lookupCtx, volume.PersistentVolumeClaim.ClaimName, metav1.GetOptions{})
+		cancel()
+		if err != nil {
+			if d := lookupFailed(policy, "PersistentVolumeClaim "+volume.PersistentVolumeClaim.ClaimName, err); d != nil {
+				return d
+			}
+			continue
+		}
+		if pvc.Spec.StorageClassName == nil || *pvc.Spec.StorageClassName == "" {
+			continue
+		}
+		class := *pvc.Spec.StorageClassName
+		if !containsString(policy.AllowedStorageClasses, class) {
+			return &denial{
+				code: http.StatusForbidden,
+				message: fmt.Sprintf("volume %s uses PersistentVolumeClaim %s with storage class %s! Only storage classes %v are allowed.",
+					volume.Name, pvc.Name, class, policy.AllowedStorageClasses),
+			}
+		}
+	}
+	return nil
+}
+
+// 默认通过 StorageClass 的 encrypted 参数或注解判断是否加密
+const defaultEncryptedStorageKey = "encrypted"
+
+// checkEncryptedStorage pod 引用的 PVC 必须使用加密的 StorageClass
+// 这是合规要求, 查询 PVC 或 StorageClass 失败时总是拒绝, 不受 LookupFailClosed 影响
+func (s *WebhookServer) checkEncryptedStorage(ctx context.Context, policy *Policy, pod *corev1.Pod) *denial {
+	if !policy.RequireEncryptedStorage {
+		return nil
+	}
+	key := policy.EncryptedStorageKey
+	if key == "" {
+		key = defaultEncryptedStorageKey
+	}
+	for _, volume := range pod.Spec.Volumes {
+		if volume.PersistentVolumeClaim == nil {
+			continue
+		}
+		if s.Client == nil {
+			return encryptedStorageLookupFailed("PersistentVolumeClaim "+volume.PersistentVolumeClaim.ClaimName, fmt.Errorf("no kubernetes client"))
+		}
+		lookupCtx, cancel := s.lookupContext(ctx)
+		pvc, err := s.Client.CoreV1().PersistentVolumeClaims(pod.Namespace).Get(lookupCtx, volume.PersistentVolumeClaim.ClaimName, metav1.GetOptions{})
+		cancel()
+		if err != nil {
+			return encryptedStorageLookupFailed("PersistentVolumeClaim "+volume.PersistentVolumeClaim.ClaimName, err)
+		}
+		// 集群默认的 StorageClass 可能随时改变, 要求显式指定
+		if pvc.Spec.StorageClassName == nil || *pvc.Spec.StorageClassName == "" {
+			return &denial{
+				code: http.StatusForbidden,
+				message: fmt.Sprintf("volume %s uses PersistentVolumeClaim %s without a storage class! Storage must use an encrypted storage class.",
+					volume.Name, pvc.Name),
+			}
+		}
+		class := *pvc.Spec.StorageClassName
+		lookupCtx, cancel = s.lookupContext(ctx)
+		sc, err := s.Client.StorageV1().StorageClasses().Get(lookupCtx, class, metav1.GetOptions{})
+		cancel()
+		if err != nil {
+			return encryptedStorageLookupFailed("StorageClass "+class, err)
+		}
+		if sc.Parameters[key] != "true" && sc.Annotations[key] != "true" {
+			return &denial{
+				code: http.StatusForbidden,
+				message: fmt.Sprintf("volume %s uses PersistentVolumeClaim %s with storage class %s, which is not encrypted! Storage classes must set %s: \"true\".",
+					volume.Name, pvc.Name, class, key),
+			}
+		}
+	}
+	return nil
+}
+
+func encryptedStorageLookupFailed(what string, err error) *denial {
+	klog.Errorf("Failed to look up %s: %v", what, err)
+	return &denial{
+		code:    http.StatusInternalServerError,
+		message: fmt.Sprintf("failed to look up %s to verify storage encryption: %v", what, err),
+	}
+}
+
+// checkPersistentVolumeClaim 限制 PVC 申请的容量和访问模式
+func checkPersistentVolumeClaim(_ context.Context, policy *Policy, _ *admissionV1.AdmissionRequest, obj runtime.Object) *denial {
+	pvc, ok := obj.(*corev1.PersistentVolumeClaim)
+	if !ok {
+		return nil
+	}
+	if policy.MaxPVCSize != "" {
+		limit, err := resource.ParseQuantity(policy.MaxPVCSize)
+		if err != nil {
+			klog.Errorf("Invalid maxPVCSize %q: %v", policy.MaxPVCSize, err)
+		} else if size, ok := pvc.Spec.Resources.Requests[corev1.ResourceStorage]; ok && size.Cmp(limit) > 0 {
+			return &denial{
+				code: http.StatusForbidden,
+				message: fmt.Sprintf("PersistentVolumeClaim %s requests %s of storage, at most %s is allowed.",
+					pvc.Name, size.String(), limit.String()),
+			}
+		}
+	}
+	if len(policy.AllowedAccessModes) > 0 {
+		for _, mode := range pvc.Spec.AccessModes {
+			if !containsString(policy.AllowedAccessModes, string(mode)) {
+				return &denial{
+					code: http.StatusForbidden,
+					message: fmt.Sprintf("PersistentVolumeClaim %s uses access mode %s! Only access modes %v are allowed.",
+						pvc.Name, mode, policy.AllowedAccessModes),
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// checkVolumeTypes pod 的卷只能使用 AllowedVolumeTypes 中的类型, 类型名和 pod spec 中的字段名一致, 比如 configMap、emptyDir
+func checkVolumeTypes(_ context.Context, policy *Policy, pod *corev1.Pod) *denial {
+	if len(policy.AllowedVolumeTypes) == 0 {
+		return nil
+	}
+	for _, volume := range pod.Spec.Volumes {
+		volumeType := volumeSourceType(&volume.VolumeSource)
+		if !containsString(policy.AllowedVolumeTypes, volumeType) {
+			return &denial{
+				code: http.StatusForbidden,
+				message: fmt.Sprintf("volume %s uses type %s! Only volume types %v are allowed.",
+					volume.Name, volumeType, policy.AllowedVolumeTypes),
+			}
+		}
+	}
+	return nil
+}
+
+// checkEmptyDirSize 限制 emptyDir 的 sizeLimit, 避免写满节点磁盘或者内存
+// medium 为 Memory 的 emptyDir 占用的是节点内存, 使用单独的上限
+func checkEmptyDirSize(_ context.Context, policy *Policy, pod *corev1.Pod) *denial {
+	if !policy.RequireEmptyDirSizeLimit && policy.MaxEmptyDirSize == "" && policy.MaxMemoryEmptyDirSize == "" {
+		return nil
+	}
+	for _, volume := range pod.Spec.Volumes {
+		emptyDir := volume.EmptyDir
+		if emptyDir == nil {
+			continue
+		}
+		if emptyDir.SizeLimit == nil {
+			if policy.RequireEmptyDirSizeLimit {
+				return &denial{
+					code:    http.StatusForbidden,
+					message: fmt.Sprintf("emptyDir volume %s has no sizeLimit! emptyDir volumes must set a sizeLimit.", volume.Name),
+				}
+			}
+			continue
+		}
+		maxSize, field := policy.MaxEmptyDirSize, "maxEmptyDirSize"
+		if emptyDir.Medium == corev1.StorageMediumMemory {
+			maxSize, field = policy.MaxMemoryEmptyDirSize, "maxMemoryEmptyDirSize"
+		}
+		if maxSize == "" {
+			continue
+		}
+		// LoadConfig 已经检查过上限的格式, 这里解析失败说明配置是直接通过 SetConfig 设置的, 拒绝而不是跳过检查
+		limit, err := resource.ParseQuantity(maxSize)
+		if err != nil {
+			return &denial{
+				code:    http.StatusInternalServerError,
+				message: fmt.Sprintf("invalid %s %q: %v", field, maxSize, err),
+			}
+		}
+		if emptyDir.SizeLimit.Cmp(limit) > 0 {
+			return &denial{
+				code: http.StatusForbidden,
+				message: fmt.Sprintf("emptyDir volume %s has sizeLimit %s, at most %s is allowed.",
+					volume.Name, emptyDir.SizeLimit.String(), limit.String()),
+			}
+		}
+	}
+	return nil
+}
+
+// volumeSourceType 返回卷的类型, 即 VolumeSource 中被设置的字段的 json 名字
+// 新版本 Kubernetes 增加的卷类型也能自动识别, 不需要修改这里
+func volumeSourceType(source *corev1.VolumeSource) string {
+	v := reflect.ValueOf(source).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if v.Field(i).Kind() == reflect.Ptr && !v.Field(i).IsNil() {
+			return strings.Split(v.Type().Field(i).Tag.Get("json"), ",")[0]
+		}
+	}
+	return "unknown"
+}
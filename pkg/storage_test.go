@@ -0,0 +1,232 @@
+package pkg
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	storagev1 "k8s.io/api/storage/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func emptyDirPod(medium corev1.StorageMedium, sizeLimit string) *corev1.Pod {
+	emptyDir := &corev1.EmptyDirVolumeSource{Medium: medium}
+	if sizeLimit != "" {
+		size := resource.MustParse(sizeLimit)
+		emptyDir.SizeLimit = &size
+	}
+	return &corev1.Pod{Spec: corev1.PodSpec{Volumes: []corev1.Volume{
+		{Name: "scratch", VolumeSource: corev1.VolumeSource{EmptyDir: emptyDir}},
+	}}}
+}
+
+func TestCheckEmptyDirSize(t *testing.T) {
+	policy := &Policy{RequireEmptyDirSizeLimit: true, MaxEmptyDirSize: "10Gi", MaxMemoryEmptyDirSize: "1Gi"}
+	tests := []struct {
+		name      string
+		medium    corev1.StorageMedium
+		sizeLimit string
+		wantCode  int
+	}{
+		{name: "disk within limit", sizeLimit: "10Gi"},
+		{name: "disk over limit", sizeLimit: "11Gi", wantCode: http.StatusForbidden},
+		{name: "memory within limit", medium: corev1.StorageMediumMemory, sizeLimit: "512Mi"},
+		{name: "memory over limit", medium: corev1.StorageMediumMemory, sizeLimit: "2Gi", wantCode: http.StatusForbidden},
+		{name: "no sizeLimit", wantCode: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		d := checkEmptyDirSize(context.Background(), policy, emptyDirPod(tt.medium, tt.sizeLimit))
+		if code := denialCode(d); code != tt.wantCode {
+			t.Errorf("%s: got code %d (%v), want %d", tt.name, code, d, tt.wantCode)
+		}
+	}
+
+	// 没有经过 LoadConfig 的非法上限不能让检查失效
+	policy = &Policy{MaxEmptyDirSize: "10 GB"}
+	if code := denialCode(checkEmptyDirSize(context.Background(), policy, emptyDirPod("", "100Gi"))); code != http.StatusInternalServerError {
+		t.Errorf("invalid maxEmptyDirSize: got code %d, want %d", code, http.StatusInternalServerError)
+	}
+}
+
+func TestLoadConfigRejectsInvalidEmptyDirSize(t *testing.T) {
+	tests := []struct {
+		config, wantErr string
+	}{
+		{config: "base:\n  maxEmptyDirSize: 10GB\n", wantErr: "base.maxEmptyDirSize"},
+		{config: "namespaces:\n  team:\n    maxMemoryEmptyDirSize: lots\n", wantErr: "team.maxMemoryEmptyDirSize"},
+	}
+	for _, tt := range tests {
+		_, err := loadTestConfig(t, tt.config)
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%q: got error %v, want it to mention %s", tt.config, err, tt.wantErr)
+		}
+	}
+	if _, err := loadTestConfig(t, "base:\n  maxEmptyDirSize: 10Gi\n  maxMemoryEmptyDirSize: 512Mi\n"); err != nil {
+		t.Errorf("valid sizes rejected: %v", err)
+	}
+}
+
+func classPVC(name, class string) *corev1.PersistentVolumeClaim {
+	pvc := &corev1.PersistentVolumeClaim{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "team"}}
+	if class != "" {
+		pvc.Spec.StorageClassName = &class
+	}
+	return pvc
+}
+
+func claimPod(claims ...string) *corev1.Pod {
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Namespace: "team"}}
+	for _, claim := range claims {
+		pod.Spec.Volumes = append(pod.Spec.Volumes, corev1.Volume{
+			Name:         claim,
+			VolumeSource: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: claim}},
+		})
+	}
+	return pod
+}
+
+func TestCheckStorageClasses(t *testing.T) {
+	tests := []struct {
+		name     string
+		pod      *corev1.Pod
+		policy   Policy
+		failGets bool
+		wantCode int
+	}{
+		{name: "approved class", pod: claimPod("fast")},
+		{name: "unapproved class", pod: claimPod("fast", "cheap"), wantCode: http.StatusForbidden},
+		{name: "default class", pod: claimPod("default")},
+		{name: "missing PVC fails open", pod: claimPod("missing")},
+		{name: "missing PVC fails closed", pod: claimPod("missing"), policy: Policy{LookupFailClosed: true}, wantCode: http.StatusInternalServerError},
+		{name: "lookup error fails open", pod: claimPod("cheap"), failGets: true},
+		{name: "no PVC volumes", pod: emptyDirPod("", "")},
+	}
+	for _, tt := range tests {
+		client := fake.NewSimpleClientset(classPVC("fast", "ssd"), classPVC("cheap", "hdd"), classPVC("default", ""))
+		if tt.failGets {
+			failRequests(client, "get", "persistentvolumeclaims")
+		}
+		s := &WebhookServer{Client: client}
+		policy := tt.policy
+		policy.AllowedStorageClasses = []string{"ssd"}
+		if code := denialCode(s.checkStorageClasses(context.Background(), &policy, tt.pod)); code != tt.wantCode {
+			t.Errorf("%s: got code %d, want %d", tt.name, code, tt.wantCode)
+		}
+	}
+}
+
+func TestCheckEncryptedStorage(t *testing.T) {
+	encrypted := &storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "ssd"}, Parameters: map[string]string{"encrypted": "true"}}
+	plain := &storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "hdd"}, Parameters: map[string]string{"encrypted": "false"}}
+	annotated := &storagev1.StorageClass{ObjectMeta: metav1.ObjectMeta{Name: "vault", Annotations: map[string]string{"example.com/encrypted": "true"}}}
+	tests := []struct {
+		name     string
+		pod      *corev1.Pod
+		policy   Policy
+		failGets bool
+		wantCode int
+	}{
+		{name: "encrypted class", pod: claimPod("fast")},
+		{name: "unencrypted class", pod: claimPod("fast", "cheap"), wantCode: http.StatusForbidden},
+		{name: "default class", pod: claimPod("default"), wantCode: http.StatusForbidden},
+		{name: "custom key", pod: claimPod("secure"), policy: Policy{EncryptedStorageKey: "example.com/encrypted"}},
+		{name: "custom key ignores the default", pod: claimPod("fast"), policy: Policy{EncryptedStorageKey: "example.com/encrypted"}, wantCode: http.StatusForbidden},
+		{name: "missing PVC fails closed", pod: claimPod("missing"), wantCode: http.StatusInternalServerError},
+		{name: "missing storage class fails closed", pod: claimPod("orphan"), wantCode: http.StatusInternalServerError},
+		{name: "lookup error fails closed", pod: claimPod("fast"), failGets: true, wantCode: http.StatusInternalServerError},
+		{name: "no PVC volumes", pod: emptyDirPod("", "")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := fake.NewSimpleClientset(
+				encrypted, plain, annotated,
+				classPVC("fast", "ssd"), classPVC("cheap", "hdd"), classPVC("secure", "vault"),
+				classPVC("default", ""), classPVC("orphan", "deleted"),
+			)
+			if tt.failGets {
+				failRequests(client, "get", "storageclasses")
+			}
+			s := &WebhookServer{Client: client}
+			policy := tt.policy
+			policy.RequireEncryptedStorage = true
+			assertDenial(t, s.checkEncryptedStorage(context.Background(), &policy, tt.pod), tt.wantCode, false)
+		})
+	}
+
+	// 没有开启时不查询集群
+	s := &WebhookServer{}
+	if d := s.checkEncryptedStorage(context.Background(), &Policy{}, claimPod("fast")); d != nil {
+		t.Errorf("disabled check denied: %v", d)
+	}
+}
+
+func TestCheckPersistentVolumeClaim(t *testing.T) {
+	policy := &Policy{MaxPVCSize: "100Gi", AllowedAccessModes: []string{"ReadWriteOnce", "ReadOnlyMany"}}
+	tests := []struct {
+		name, spec string
+		wantCode   int
+	}{
+		{name: "within limits", spec: `{"accessModes": ["ReadWriteOnce"], "resources": {"requests": {"storage": "10Gi"}}}`},
+		{name: "exactly the limit", spec: `{"accessModes": ["ReadWriteOnce"], "resources": {"requests": {"storage": "100Gi"}}}`},
+		{name: "too large", spec: `{"accessModes": ["ReadWriteOnce"], "resources": {"requests": {"storage": "1Ti"}}}`, wantCode: http.StatusForbidden},
+		{name: "disallowed access mode", spec: `{"accessModes": ["ReadOnlyMany", "ReadWriteMany"], "resources": {"requests": {"storage": "1Gi"}}}`, wantCode: http.StatusForbidden},
+		{name: "no size", spec: `{"accessModes": ["ReadWriteOnce"]}`},
+	}
+	for _, tt := range tests {
+		obj, _, err := decodeRaw("PersistentVolumeClaim", "team", []byte(`{"metadata": {"name": "data"}, "spec": `+tt.spec+`}`), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code := denialCode(checkPersistentVolumeClaim(context.Background(), policy, nil, obj)); code != tt.wantCode {
+			t.Errorf("%s: got code %d, want %d", tt.name, code, tt.wantCode)
+		}
+	}
+	// 其它对象不受影响
+	if d := checkPersistentVolumeClaim(context.Background(), policy, nil, emptyDirPod("", "")); d != nil {
+		t.Errorf("pod was checked as a PVC: %s", d.message)
+	}
+}
+
+func TestCheckVolumeTypes(t *testing.T) {
+	allowed := []string{"configMap", "emptyDir", "persistentVolumeClaim"}
+	tests := []struct {
+		name     string
+		source   corev1.VolumeSource
+		allowed  []string
+		wantCode int
+	}{
+		{name: "configMap", source: corev1.VolumeSource{ConfigMap: &corev1.ConfigMapVolumeSource{}}, allowed: allowed},
+		{name: "emptyDir", source: corev1.VolumeSource{EmptyDir: &corev1.EmptyDirVolumeSource{}}, allowed: allowed},
+		{name: "hostPath", source: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: "/var/run"}}, allowed: allowed, wantCode: http.StatusForbidden},
+		{name: "unset source", allowed: allowed, wantCode: http.StatusForbidden},
+		{name: "not configured", source: corev1.VolumeSource{HostPath: &corev1.HostPathVolumeSource{Path: "/var/run"}}},
+	}
+	for _, tt := range tests {
+		pod := imagePod("nginx")
+		pod.Spec.Volumes = []corev1.Volume{{Name: "data", VolumeSource: tt.source}}
+		if code := denialCode(checkVolumeTypes(context.Background(), &Policy{AllowedVolumeTypes: tt.allowed}, pod)); code != tt.wantCode {
+			t.Errorf("%s: got code %d, want %d", tt.name, code, tt.wantCode)
+		}
+	}
+}
+
+func TestVolumeSourceType(t *testing.T) {
+	tests := []struct {
+		source corev1.VolumeSource
+		want   string
+	}{
+		{source: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{}}, want: "secret"},
+		{source: corev1.VolumeSource{PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{}}, want: "persistentVolumeClaim"},
+		{source: corev1.VolumeSource{Projected: &corev1.ProjectedVolumeSource{}}, want: "projected"},
+		{want: "unknown"},
+	}
+	for _, tt := range tests {
+		if got := volumeSourceType(&tt.source); got != tt.want {
+			t.Errorf("got volume type %q, want %q", got, tt.want)
+		}
+	}
+}
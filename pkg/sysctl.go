@@ -0,0 +1,53 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"path"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// kubelet 默认允许的 safe sysctl, 这些 sysctl 只影响 pod 自己的命名空间
+var safeSysctls = []string{
+	"kernel.shm_rmid_forced",
+	"net.ipv4.ip_local_port_range",
+	"net.ipv4.tcp_syncookies",
+	"net.ipv4.ping_group_range",
+}
+
+// checkSysctls pod 不能设置 DeniedSysctls 中的 sysctl, 不在 safe 集合中的 sysctl 需要在 AllowedUnsafeSysctls 中显式允许
+// DeniedSysctls 优先, 即使是 safe 的 sysctl 也可以通过它禁止
+func checkSysctls(_ context.Context, policy *Policy, pod *corev1.Pod) *denial {
+	if !policy.RestrictSysctls || pod.Spec.SecurityContext == nil {
+		return nil
+	}
+	for _, sysctl := range pod.Spec.SecurityContext.Sysctls {
+		if matchSysctl(sysctl.Name, policy.DeniedSysctls) {
+			return &denial{
+				code:    http.StatusForbidden,
+				message: fmt.Sprintf("pod %s sets sysctl %s, which is denied by policy!", pod.Name, sysctl.Name),
+			}
+		}
+		if containsString(safeSysctls, sysctl.Name) || matchSysctl(sysctl.Name, policy.AllowedUnsafeSysctls) {
+			continue
+		}
+		return &denial{
+			code: http.StatusForbidden,
+			message: fmt.Sprintf("pod %s sets unsafe sysctl %s! Only the safe sysctls %v and %v are allowed.",
+				pod.Name, sysctl.Name, safeSysctls, policy.AllowedUnsafeSysctls),
+		}
+	}
+	return nil
+}
+
+// matchSysctl 判断 sysctl 是否匹配列表中的名字, 支持 path.Match 风格的通配符
+func matchSysctl(name string, patterns []string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,36 @@
+package pkg
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+func TestCheckSysctls(t *testing.T) {
+	tests := []struct {
+		name     string
+		sysctl   string
+		policy   Policy
+		wantCode int
+	}{
+		{name: "safe sysctl", sysctl: "net.ipv4.ip_local_port_range", policy: Policy{RestrictSysctls: true}},
+		{name: "unsafe sysctl", sysctl: "net.core.somaxconn", policy: Policy{RestrictSysctls: true}, wantCode: http.StatusForbidden},
+		{name: "allowed unsafe sysctl", sysctl: "net.core.somaxconn", policy: Policy{RestrictSysctls: true, AllowedUnsafeSysctls: []string{"net.core.somaxconn"}}},
+		{name: "allowed by wildcard", sysctl: "net.ipv4.tcp_keepalive_time", policy: Policy{RestrictSysctls: true, AllowedUnsafeSysctls: []string{"net.ipv4.tcp_*"}}},
+		{name: "denied safe sysctl", sysctl: "kernel.shm_rmid_forced", policy: Policy{RestrictSysctls: true, DeniedSysctls: []string{"kernel.*"}}, wantCode: http.StatusForbidden},
+		{name: "denied wins over allowed", sysctl: "kernel.msgmax", policy: Policy{RestrictSysctls: true, AllowedUnsafeSysctls: []string{"kernel.*"}, DeniedSysctls: []string{"kernel.msgmax"}}, wantCode: http.StatusForbidden},
+		{name: "not configured", sysctl: "net.core.somaxconn"},
+	}
+	for _, tt := range tests {
+		pod := imagePod("nginx")
+		pod.Spec.SecurityContext = &corev1.PodSecurityContext{Sysctls: []corev1.Sysctl{{Name: tt.sysctl, Value: "1"}}}
+		if code := denialCode(checkSysctls(context.Background(), &tt.policy, pod)); code != tt.wantCode {
+			t.Errorf("%s: got code %d, want %d", tt.name, code, tt.wantCode)
+		}
+	}
+	if d := checkSysctls(context.Background(), &Policy{RestrictSysctls: true}, imagePod("nginx")); d != nil {
+		t.Errorf("no security context: got denial %q", d.message)
+	}
+}
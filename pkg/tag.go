@@ -0,0 +1,116 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// checkExplicitTag 镜像地址必须显式指定 tag 或 digest, nginx 这样的写法会被拒绝, nginx:latest 可以通过
+func checkExplicitTag(_ context.Context, policy *Policy, pod *corev1.Pod) *denial {
+	if !policy.RequireExplicitTagOrDigest {
+		return nil
+	}
+	for i, container := range podContainers(pod) {
+		ref := parseImage(container.Image)
+		if ref.Tag == "" && ref.Digest == "" {
+			return &denial{
+				code: http.StatusForbidden,
+				message: fmt.Sprintf("%s image of container %s has neither a tag nor a digest! Please reference the image as name:tag or name@digest.",
+					container.Image, container.Name),
+				field: containerField(pod, i) + ".image",
+			}
+		}
+	}
+	return nil
+}
+
+// checkFloatingTags 镜像不能使用会移动的 tag, 比如仓库中同时存在 1.2 和 1.2.3 时, 1.2 会随着新版本发布而变化
+// 判断的依据是仓库的 tag 列表, 见 floatingTag
+func (s *WebhookServer) checkFloatingTags(ctx context.Context, policy *Policy, pod *corev1.Pod) *denial {
+	if policy.FloatingTags == "" || s.Inspector == nil {
+		return nil
+	}
+	for _, container := range podContainers(pod) {
+		ref := parseImage(container.Image)
+		if ref.Tag == "" || ref.Digest != "" {
+			continue
+		}
+		tags, err := s.Inspector.tags(ctx, container.Image)
+		if err != nil {
+			if d := inspectFailed(policy, container.Image, err); d != nil {
+				return d
+			}
+			continue
+		}
+		if floatingTag(ref.Tag, tags) {
+			return policy.FloatingTags.violation(http.StatusForbidden,
+				fmt.Sprintf("%s image uses floating tag %s! Please pin the image to a specific version or digest.",
+					container.Image, ref.Tag))
+		}
+	}
+	return nil
+}
+
+// floatingTag 判断 tag 是否是浮动的: 仓库中有其它 tag 在它的版本号后面加上了数字的版本号
+// 比如存在 1.21.3 时 1.21 是浮动的, 存在 1.21.3-alpine 时 1.21-alpine 是浮动的
+// 1.21.0-alpine 这样的变体不会让 1.21.0 变成浮动的, 1.21.0-rc1 和 1.21.x 这样的 tag 也不算
+func floatingTag(tag string, tags []string) bool {
+	version, variant := splitTagVariant(tag)
+	for _, t := range tags {
+		v, suffix := splitTagVariant(t)
+		if suffix == variant && strings.HasPrefix(v, version+".") && numericVersion(v[len(version)+1:]) {
+			return true
+		}
+	}
+	return false
+}
+
+// splitTagVariant 把 tag 拆分成版本号和变体, 比如 1.21-alpine 拆分成 1.21 和 -alpine
+func splitTagVariant(tag string) (version, variant string) {
+	if i := strings.Index(tag, "-"); i != -1 {
+		return tag[:i], tag[i:]
+	}
+	return tag, ""
+}
+
+// numericVersion 判断 s 是否由 . 分隔的数字组成, 比如 3 或者 3.1
+func numericVersion(s string) bool {
+	for _, part := range strings.Split(s, ".") {
+		if part == "" {
+			return false
+		}
+		for _, c := range part {
+			if c < '0' || c > '9' {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// checkTagPattern 镜像的 tag 必须完整匹配 AllowedTagPattern, 比如生产命名空间只允许 release-.* 的 tag
+// 使用 digest 的镜像内容不可变, 默认不检查 tag, 配置了 TagPatternForDigests 时同样检查
+func checkTagPattern(_ context.Context, policy *Policy, pod *corev1.Pod) *denial {
+	if policy.AllowedTagPattern == "" {
+		return nil
+	}
+	for i, container := range podContainers(pod) {
+		ref := parseImage(container.Image)
+		if ref.Digest != "" && !policy.TagPatternForDigests {
+			continue
+		}
+		if !matchPattern(policy.AllowedTagPattern, ref.Tag) {
+			return &denial{
+				code: http.StatusForbidden,
+				message: fmt.Sprintf("%s image of container %s has tag %q, only tags matching %q are allowed in namespace %s.",
+					container.Image, container.Name, ref.Tag, policy.AllowedTagPattern, pod.Namespace),
+				field: containerField(pod, i) + ".image",
+			}
+		}
+	}
+	return nil
+}
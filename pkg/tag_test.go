@@ -0,0 +1,118 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+)
+
+func TestFloatingTag(t *testing.T) {
+	tags := []string{"1", "1.21", "1.21.0", "1.21.0-alpine", "1.21.3", "1.21-alpine", "1.21.3-alpine", "1.22.0-rc1", "1.22", "stable", "stable-perl"}
+	tests := []struct {
+		tag  string
+		want bool
+	}{
+		{"1", true},
+		{"1.21", true},
+		{"1.21-alpine", true},
+		{"1.21.0", false},
+		{"1.21.3", false},
+		{"1.21.3-alpine", false},
+		{"1.22", false},
+		{"stable", false},
+		{"latest", false},
+	}
+	for _, tt := range tests {
+		if got := floatingTag(tt.tag, tags); got != tt.want {
+			t.Errorf("floatingTag(%q) = %v, want %v", tt.tag, got, tt.want)
+		}
+	}
+}
+
+func TestCheckFloatingTags(t *testing.T) {
+	inspector := &fakeInspector{tags: map[string][]string{"library/nginx": {"1.21", "1.21.0", "1.21.0-alpine", "1.21.3"}}}
+	tests := []struct {
+		name     string
+		policy   Policy
+		image    string
+		err      error
+		wantCode int
+		warning  bool
+	}{
+		{name: "pinned tag", policy: Policy{FloatingTags: ActionDeny}, image: "nginx:1.21.0"},
+		{name: "floating tag denied", policy: Policy{FloatingTags: ActionDeny}, image: "nginx:1.21", wantCode: http.StatusForbidden},
+		{name: "floating tag warned", policy: Policy{FloatingTags: ActionWarn}, image: "nginx:1.21", wantCode: http.StatusForbidden, warning: true},
+		{name: "digest is not checked", policy: Policy{FloatingTags: ActionDeny}, image: "nginx:1.21@" + testDigest("nginx")},
+		{name: "check disabled", image: "nginx:1.21"},
+		{name: "registry down fails open", policy: Policy{FloatingTags: ActionDeny}, image: "nginx:1.21", err: errors.New("unavailable")},
+		{name: "registry down fails closed", policy: Policy{FloatingTags: ActionDeny, InspectFailClosed: true}, image: "nginx:1.21",
+			err: errors.New("unavailable"), wantCode: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inspector.err = tt.err
+			s := &WebhookServer{Inspector: NewCachedInspector(inspector)}
+			d := s.checkFloatingTags(context.Background(), &tt.policy, imagePod(tt.image))
+			if code := denialCode(d); code != tt.wantCode {
+				t.Fatalf("got code %d (%v), want %d", code, d, tt.wantCode)
+			}
+			if d != nil && d.warning != tt.warning {
+				t.Errorf("warning = %v, want %v", d.warning, tt.warning)
+			}
+		})
+	}
+}
+
+func TestCheckExplicitTag(t *testing.T) {
+	policy := &Policy{RequireExplicitTagOrDigest: true}
+	tests := []struct {
+		image     string
+		wantCode  int
+		wantField string
+	}{
+		{image: "nginx:latest"},
+		{image: "nginx:1.21"},
+		{image: "nginx@" + testDigest("nginx")},
+		{image: "localhost:5000/app:1"},
+		{image: "nginx", wantCode: http.StatusForbidden, wantField: "containers[0].image"},
+		// 仓库地址中的端口不是 tag
+		{image: "localhost:5000/app", wantCode: http.StatusForbidden, wantField: "containers[0].image"},
+	}
+	for _, tt := range tests {
+		d := checkExplicitTag(context.Background(), policy, imagePod(tt.image))
+		if code := denialCode(d); code != tt.wantCode {
+			t.Errorf("%s: got code %d, want %d", tt.image, code, tt.wantCode)
+			continue
+		}
+		if d != nil && d.field != tt.wantField {
+			t.Errorf("%s: field = %q, want %q", tt.image, d.field, tt.wantField)
+		}
+	}
+	if d := checkExplicitTag(context.Background(), &Policy{}, imagePod("nginx")); d != nil {
+		t.Errorf("check is off by default, got %v", d.message)
+	}
+}
+
+func TestCheckTagPattern(t *testing.T) {
+	digest := "@" + testDigest("registry.corp.com/app")
+	tests := []struct {
+		name     string
+		image    string
+		policy   Policy
+		wantCode int
+	}{
+		{name: "matching tag", image: "registry.corp.com/app:release-1.2", policy: Policy{AllowedTagPattern: "release-.*"}},
+		{name: "other tag", image: "registry.corp.com/app:dev-1.2", policy: Policy{AllowedTagPattern: "release-.*"}, wantCode: http.StatusForbidden},
+		{name: "pattern must match the whole tag", image: "registry.corp.com/app:pre-release-1.2", policy: Policy{AllowedTagPattern: "release-.*"}, wantCode: http.StatusForbidden},
+		{name: "no tag", image: "registry.corp.com/app", policy: Policy{AllowedTagPattern: "release-.*"}, wantCode: http.StatusForbidden},
+		{name: "digest skips the tag", image: "registry.corp.com/app:dev-1.2" + digest, policy: Policy{AllowedTagPattern: "release-.*"}},
+		{name: "digest checked when configured", image: "registry.corp.com/app:dev-1.2" + digest, policy: Policy{AllowedTagPattern: "release-.*", TagPatternForDigests: true}, wantCode: http.StatusForbidden},
+		{name: "not configured", image: "registry.corp.com/app:dev-1.2"},
+	}
+	for _, tt := range tests {
+		if code := denialCode(checkTagPattern(context.Background(), &tt.policy, imagePod(tt.image))); code != tt.wantCode {
+			t.Errorf("%s: got code %d, want %d", tt.name, code, tt.wantCode)
+		}
+	}
+}
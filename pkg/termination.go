@@ -0,0 +1,38 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// checkTerminationGracePeriod pod 的 terminationGracePeriodSeconds 必须在配置的范围内, 过长的优雅退出时间会拖慢节点排空和滚动更新
+// 没有设置时按照 Kubernetes 的默认值 30 秒检查
+func checkTerminationGracePeriod(_ context.Context, policy *Policy, pod *corev1.Pod) *denial {
+	if policy.MaxTerminationGracePeriodSeconds == 0 && policy.MinTerminationGracePeriodSeconds == 0 {
+		return nil
+	}
+	seconds := int64(corev1.DefaultTerminationGracePeriodSeconds)
+	source := "defaults to"
+	if pod.Spec.TerminationGracePeriodSeconds != nil {
+		seconds = *pod.Spec.TerminationGracePeriodSeconds
+		source = "sets"
+	}
+	if policy.MaxTerminationGracePeriodSeconds > 0 && seconds > policy.MaxTerminationGracePeriodSeconds {
+		return &denial{
+			code: http.StatusForbidden,
+			message: fmt.Sprintf("pod %s %s terminationGracePeriodSeconds %d, at most %d is allowed.",
+				pod.Name, source, seconds, policy.MaxTerminationGracePeriodSeconds),
+		}
+	}
+	if seconds < policy.MinTerminationGracePeriodSeconds {
+		return &denial{
+			code: http.StatusForbidden,
+			message: fmt.Sprintf("pod %s %s terminationGracePeriodSeconds %d, at least %d is required.",
+				pod.Name, source, seconds, policy.MinTerminationGracePeriodSeconds),
+		}
+	}
+	return nil
+}
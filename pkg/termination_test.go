@@ -0,0 +1,33 @@
+package pkg
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestCheckTerminationGracePeriod(t *testing.T) {
+	seconds := func(s int64) *int64 { return &s }
+	tests := []struct {
+		name     string
+		period   *int64
+		policy   Policy
+		wantCode int
+	}{
+		{name: "within range", period: seconds(60), policy: Policy{MinTerminationGracePeriodSeconds: 10, MaxTerminationGracePeriodSeconds: 120}},
+		{name: "at the maximum", period: seconds(120), policy: Policy{MaxTerminationGracePeriodSeconds: 120}},
+		{name: "too long", period: seconds(600), policy: Policy{MaxTerminationGracePeriodSeconds: 120}, wantCode: http.StatusForbidden},
+		{name: "too short", period: seconds(0), policy: Policy{MinTerminationGracePeriodSeconds: 10}, wantCode: http.StatusForbidden},
+		{name: "default within range", policy: Policy{MinTerminationGracePeriodSeconds: 10, MaxTerminationGracePeriodSeconds: 120}},
+		{name: "default over the maximum", policy: Policy{MaxTerminationGracePeriodSeconds: 20}, wantCode: http.StatusForbidden},
+		{name: "default under the minimum", policy: Policy{MinTerminationGracePeriodSeconds: 60}, wantCode: http.StatusForbidden},
+		{name: "not configured", period: seconds(3600)},
+	}
+	for _, tt := range tests {
+		pod := imagePod("nginx")
+		pod.Spec.TerminationGracePeriodSeconds = tt.period
+		if code := denialCode(checkTerminationGracePeriod(context.Background(), &tt.policy, pod)); code != tt.wantCode {
+			t.Errorf("%s: got code %d, want %d", tt.name, code, tt.wantCode)
+		}
+	}
+}
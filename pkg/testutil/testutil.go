@@ -0,0 +1,124 @@
+// Package testutil 构造 AdmissionReview 并发送给 webhook 的辅助函数, 用于测试内置的和自定义的策略
+package testutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+
+	admissionV1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// 生成请求 uid 的计数器, 同一个进程中的请求 uid 不会重复
+var uidCounter int64
+
+// NewAdmissionReview 构造 admission/v1 的 AdmissionReview, obj 为请求中的对象
+// DELETE 请求的对象放在 oldObject 中, 其它操作放在 object 中
+func NewAdmissionReview(kind metav1.GroupVersionKind, resource metav1.GroupVersionResource, namespace, name string,
+	obj interface{}, op admissionV1.Operation) (*admissionV1.AdmissionReview, error) {
+	raw, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	request := &admissionV1.AdmissionRequest{
+		UID:       types.UID(fmt.Sprintf("testutil-%d", atomic.AddInt64(&uidCounter, 1))),
+		Kind:      kind,
+		Resource:  resource,
+		Namespace: namespace,
+		Name:      name,
+		Operation: op,
+	}
+	if op == admissionV1.Delete {
+		request.OldObject.Raw = raw
+	} else {
+		request.Object.Raw = raw
+	}
+	return &admissionV1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: admissionV1.SchemeGroupVersion.String(),
+			Kind:       "AdmissionReview",
+		},
+		Request: request,
+	}, nil
+}
+
+// NewPodAdmissionReview 构造 pod 的 AdmissionReview, 命名空间和名字取自 pod
+func NewPodAdmissionReview(pod corev1.Pod, op admissionV1.Operation) *admissionV1.AdmissionReview {
+	review, err := NewAdmissionReview(
+		metav1.GroupVersionKind{Version: "v1", Kind: "Pod"},
+		metav1.GroupVersionResource{Version: "v1", Resource: "pods"},
+		pod.Namespace, pod.Name, pod, op)
+	if err != nil {
+		// corev1.Pod 总是可以编码成 json
+		panic(err)
+	}
+	return review
+}
+
+// Client 把 AdmissionReview 发送给测试的 webhook 服务, 并解析返回的 AdmissionResponse
+type Client struct {
+	BaseURL    string       // 服务的地址, 比如 https://127.0.0.1:8443
+	HTTPClient *http.Client // 为 nil 时使用 http.DefaultClient
+}
+
+// NewClient 创建访问 httptest.Server 的 Client, TLS 的服务会使用信任其证书的 http.Client
+func NewClient(server *httptest.Server) *Client {
+	return &Client{BaseURL: server.URL, HTTPClient: server.Client()}
+}
+
+// Review 把 review POST 到 path (比如 /validate), 返回响应中的 AdmissionResponse
+func (c *Client) Review(path string, review *admissionV1.AdmissionReview) (*admissionV1.AdmissionResponse, error) {
+	body, err := json.Marshal(review)
+	if err != nil {
+		return nil, err
+	}
+	client := c.HTTPClient
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Post(c.BaseURL+path, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return decodeResponse(resp.StatusCode, data)
+}
+
+// ServeReview 不经过网络, 直接用 handler 处理 review, 适合只测试 WebhookServer.Handler 的场景
+func ServeReview(handler http.Handler, path string, review *admissionV1.AdmissionReview) (*admissionV1.AdmissionResponse, error) {
+	body, err := json.Marshal(review)
+	if err != nil {
+		return nil, err
+	}
+	request := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	return decodeResponse(recorder.Code, recorder.Body.Bytes())
+}
+
+// decodeResponse 解析 webhook 返回的 AdmissionReview, 非 200 的状态码表示请求本身有问题, 作为错误返回
+func decodeResponse(status int, data []byte) (*admissionV1.AdmissionResponse, error) {
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("webhook returned status %d: %s", status, bytes.TrimSpace(data))
+	}
+	var review admissionV1.AdmissionReview
+	if err := json.Unmarshal(data, &review); err != nil {
+		return nil, fmt.Errorf("can't decode response: %v", err)
+	}
+	if review.Response == nil {
+		return nil, fmt.Errorf("webhook returned no response")
+	}
+	return review.Response, nil
+}
@@ -0,0 +1,62 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	corev1 "k8s.io/api/core/v1"
+)
+
+// checkRegistryTier 镜像所在仓库的信任等级不能低于 RequiredRegistryTier, 等级的数字越小越可信, 比如 1 为内部仓库
+// 仓库按 RegistryTiers 中最长的前缀匹配, 前缀只在 / 处断开, 所以 gcr.io 不会匹配 gcr.io.evil.com
+// 没有配置等级的仓库按照配置中最低的等级(数字最大)处理
+func checkRegistryTier(_ context.Context, policy *Policy, pod *corev1.Pod) *denial {
+	if policy.RequiredRegistryTier <= 0 {
+		return nil
+	}
+	if len(policy.RegistryTiers) == 0 {
+		return &denial{
+			code:    http.StatusForbidden,
+			message: fmt.Sprintf("namespace %s requires registry trust tier %d, but no registry tiers are configured!", pod.Namespace, policy.RequiredRegistryTier),
+		}
+	}
+	for _, container := range podContainers(pod) {
+		tier, registry := policy.registryTier(policy.canonicalImage(container.Image))
+		if tier <= policy.RequiredRegistryTier {
+			continue
+		}
+		if registry == "" {
+			return &denial{
+				code: http.StatusForbidden,
+				message: fmt.Sprintf("%s image of container %s comes from a registry without a trust tier, which counts as tier %d, namespace %s requires tier %d or better.",
+					container.Image, container.Name, tier, pod.Namespace, policy.RequiredRegistryTier),
+			}
+		}
+		return &denial{
+			code: http.StatusForbidden,
+			message: fmt.Sprintf("%s image of container %s comes from registry %s with trust tier %d, namespace %s requires tier %d or better.",
+				container.Image, container.Name, registry, tier, pod.Namespace, policy.RequiredRegistryTier),
+		}
+	}
+	return nil
+}
+
+// registryTier 返回镜像的信任等级和匹配到的仓库前缀, 没有匹配时前缀为空, 等级为配置中最低的等级
+// RegistryTiers 的 key 在 LoadConfig 时已经通过 normalizeRegistryPrefix 规范化
+func (p *Policy) registryTier(image string) (int, string) {
+	image = normalizeImage(image)
+	matched, tier, lowest := "", 0, 0
+	for registry, t := range p.RegistryTiers {
+		if t > lowest {
+			lowest = t
+		}
+		if hasRegistryPrefix(image, registry) && len(registry) > len(matched) {
+			matched, tier = registry, t
+		}
+	}
+	if matched == "" {
+		return lowest, ""
+	}
+	return tier, matched
+}
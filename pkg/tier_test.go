@@ -0,0 +1,85 @@
+package pkg
+
+import (
+	"context"
+	"net/http"
+	"testing"
+)
+
+func TestCheckRegistryTier(t *testing.T) {
+	config, err := loadTestConfig(t, `base:
+  requiredRegistryTier: 2
+  registryTiers:
+    registry.corp.com: 1
+    gcr.io/: 2
+    index.docker.io: 3
+    docker.io/library/nginx: 2
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := config.Base
+	tests := []struct {
+		image    string
+		wantCode int
+	}{
+		{image: "registry.corp.com/team/app:1"},
+		{image: "gcr.io/distroless/static"},
+		{image: "gcr.io.evil.com/distroless/static", wantCode: http.StatusForbidden},
+		{image: "quay.io/app", wantCode: http.StatusForbidden},
+		{image: "busybox", wantCode: http.StatusForbidden},
+		{image: "nginx:1.21"},
+		{image: "nginx-unprivileged:1.21", wantCode: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		d := checkRegistryTier(context.Background(), &policy, imagePod(tt.image))
+		if code := denialCode(d); code != tt.wantCode {
+			t.Errorf("%s: got code %d (%v), want %d", tt.image, code, d, tt.wantCode)
+		}
+	}
+}
+
+func TestUnclassifiedRegistryLowestTier(t *testing.T) {
+	tiers := map[string]int{"registry.corp.com": 1, "partner.example.com": 2, "docker.io": 3}
+	tests := []struct {
+		name     string
+		required int
+		image    string
+		wantCode int
+	}{
+		{name: "production tier-1", required: 1, image: "registry.corp.com/team/app:1"},
+		{name: "production tier-3", required: 1, image: "nginx", wantCode: http.StatusForbidden},
+		{name: "staging tier-2", required: 2, image: "partner.example.com/app:1"},
+		// 没有配置等级的仓库按照最低的等级 3 处理
+		{name: "unclassified in production", required: 1, image: "quay.io/app", wantCode: http.StatusForbidden},
+		{name: "unclassified in staging", required: 2, image: "quay.io/app", wantCode: http.StatusForbidden},
+		{name: "unclassified at lowest tier", required: 3, image: "quay.io/app"},
+	}
+	for _, tt := range tests {
+		policy := &Policy{RequiredRegistryTier: tt.required, RegistryTiers: tiers}
+		d := checkRegistryTier(context.Background(), policy, imagePod(tt.image))
+		if code := denialCode(d); code != tt.wantCode {
+			t.Errorf("%s: got code %d (%v), want %d", tt.name, code, d, tt.wantCode)
+		}
+	}
+}
+
+func TestHasRegistryPrefix(t *testing.T) {
+	tests := []struct {
+		image, prefix string
+		want          bool
+	}{
+		{"gcr.io/app", "gcr.io", true},
+		{"gcr.io.evil.com/app", "gcr.io", false},
+		{"gcr.io:5000/app", "gcr.io", false},
+		{"docker.io/library/nginx:1", "docker.io/library/nginx", true},
+		{"docker.io/library/nginx@sha256:abc", "docker.io/library/nginx", true},
+		{"docker.io/library/nginx-extra:1", "docker.io/library/nginx", false},
+		{"docker.io/library/nginx", "docker.io/library", true},
+	}
+	for _, tt := range tests {
+		if got := hasRegistryPrefix(tt.image, tt.prefix); got != tt.want {
+			t.Errorf("hasRegistryPrefix(%q, %q) = %v, want %v", tt.image, tt.prefix, got, tt.want)
+		}
+	}
+}
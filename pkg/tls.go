@@ -0,0 +1,79 @@
+package pkg
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"k8s.io/klog"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// TLSVersion 解析 1.2 这样的 TLS 版本号
+func TLSVersion(version string) (uint16, error) {
+	v, ok := tlsVersions[version]
+	if !ok {
+		return 0, fmt.Errorf("unknown TLS version %q", version)
+	}
+	return v, nil
+}
+
+// CipherSuites 按名字解析加密套件, 比如 TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256
+// 只允许 Go 认为安全的套件; TLS 1.3 的套件不可配置, 不受这里的影响
+func CipherSuites(names []string) ([]uint16, error) {
+	byName := make(map[string]uint16)
+	for _, suite := range tls.CipherSuites() {
+		byName[suite.Name] = suite.ID
+	}
+	var ids []uint16
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		id, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %q", name)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// LoadKeyPair 加载证书和私钥, 失败时每隔 delay 重试, 最多重试 retries 次
+// cert-manager 挂载的证书可能比容器启动晚一点出现, 证书加载成功之后才开始监听, 在这之前 /readyz 不会就绪
+func LoadKeyPair(certFile, keyFile string, retries int, delay time.Duration) (tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	for i := 0; err != nil && i < retries; i++ {
+		klog.Warningf("Key pair %s is not loadable yet, retrying in %s (%d/%d): %v", certFile, delay, i+1, retries, err)
+		time.Sleep(delay)
+		cert, err = tls.LoadX509KeyPair(certFile, keyFile)
+	}
+	return cert, err
+}
+
+// NewMutateServer 创建只处理 /mutate 的 server, 使用单独的证书并监听 param.MutatePort
+// TLS 版本和加密套件与 s.Server 一致, 这样 mutate 和 validate 各自只能看到自己的证书
+func (s *WebhookServer) NewMutateServer(cert tls.Certificate, param WhSvrParam) *http.Server {
+	tlsConfig := s.Server.TLSConfig.Clone()
+	tlsConfig.Certificates = []tls.Certificate{cert}
+	mux := http.NewServeMux()
+	mux.HandleFunc(MutatePath, s.Handler)
+	server := &http.Server{
+		Addr:              fmt.Sprintf(":%d", param.MutatePort),
+		Handler:           mux,
+		IdleTimeout:       param.IdleTimeout,
+		ReadHeaderTimeout: param.ReadHeaderTimeout,
+		TLSConfig:         tlsConfig,
+	}
+	server.SetKeepAlivesEnabled(param.KeepAlive)
+	return server
+}
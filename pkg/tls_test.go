@@ -0,0 +1,254 @@
+package pkg
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/haozi4263/admission-registry/pkg/testutil"
+	admissionV1 "k8s.io/api/admission/v1"
+)
+
+func TestTLSVersion(t *testing.T) {
+	tests := []struct {
+		version string
+		want    uint16
+		wantErr bool
+	}{
+		{version: "1.2", want: tls.VersionTLS12},
+		{version: "1.3", want: tls.VersionTLS13},
+		{version: "TLS1.2", wantErr: true},
+		{version: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := TLSVersion(tt.version)
+		if got != tt.want || (err != nil) != tt.wantErr {
+			t.Errorf("TLSVersion(%q) = %d, %v, want %d (error %v)", tt.version, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestCipherSuites(t *testing.T) {
+	tests := []struct {
+		names   []string
+		want    []uint16
+		wantErr bool
+	}{
+		{names: []string{""}},
+		{
+			names: []string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256", " TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384"},
+			want:  []uint16{tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384},
+		},
+		{names: []string{"TLS_RSA_WITH_RC4_128_SHA"}, wantErr: true},
+		{names: []string{"TLS_NOT_A_SUITE"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := CipherSuites(tt.names)
+		if !reflect.DeepEqual(got, tt.want) || (err != nil) != tt.wantErr {
+			t.Errorf("CipherSuites(%q) = %v, %v, want %v (error %v)", tt.names, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestMinTLSVersion(t *testing.T) {
+	minVersion, err := TLSVersion("1.2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cipherSuites, err := CipherSuites([]string{"TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	server.TLS = &tls.Config{MinVersion: minVersion, CipherSuites: cipherSuites}
+	server.Config.ErrorLog = log.New(ioutil.Discard, "", 0) // 握手失败是预期的
+	server.StartTLS()
+	defer server.Close()
+
+	tests := []struct {
+		name       string
+		minVersion uint16
+		maxVersion uint16
+		suites     []uint16
+		wantErr    bool
+	}{
+		{name: "TLS 1.1", minVersion: tls.VersionTLS10, maxVersion: tls.VersionTLS11, wantErr: true},
+		{name: "TLS 1.2 with a configured suite", minVersion: tls.VersionTLS12, maxVersion: tls.VersionTLS12, suites: cipherSuites},
+		{
+			name:       "TLS 1.2 with other suites",
+			minVersion: tls.VersionTLS12,
+			maxVersion: tls.VersionTLS12,
+			suites:     []uint16{tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384},
+			wantErr:    true,
+		},
+		{name: "TLS 1.3", minVersion: tls.VersionTLS13, maxVersion: tls.VersionTLS13},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := server.Client()
+			transport := client.Transport.(*http.Transport).Clone()
+			transport.TLSClientConfig.MinVersion = tt.minVersion
+			transport.TLSClientConfig.MaxVersion = tt.maxVersion
+			transport.TLSClientConfig.CipherSuites = tt.suites
+			client.Transport = transport
+			resp, err := client.Get(server.URL)
+			if err == nil {
+				resp.Body.Close()
+				if resp.TLS.Version != tt.maxVersion {
+					t.Errorf("negotiated version %x, want %x", resp.TLS.Version, tt.maxVersion)
+				}
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("got error %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// testKeyPair 生成 commonName 的自签名证书和私钥, PEM 格式
+func testKeyPair(t *testing.T, commonName string) (certPEM, keyPEM []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: commonName},
+		DNSNames:     []string{commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+}
+
+// testCertificate 生成 commonName 的自签名证书
+func testCertificate(t *testing.T, commonName string) tls.Certificate {
+	t.Helper()
+	cert, err := tls.X509KeyPair(testKeyPair(t, commonName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestNewMutateServerCertificate(t *testing.T) {
+	s := &WebhookServer{Server: &http.Server{TLSConfig: &tls.Config{
+		Certificates: []tls.Certificate{testCertificate(t, "validate.webhook.svc")},
+		MinVersion:   tls.VersionTLS12,
+	}}}
+	s.SetConfig(Config{Base: Policy{WhiteListRegistries: []string{"docker.io"}}})
+	validateMux := http.NewServeMux()
+	validateMux.HandleFunc(ValidatePath, s.Handler)
+	validate := httptest.NewUnstartedServer(validateMux)
+	validate.TLS = s.Server.TLSConfig
+	validate.StartTLS()
+	defer validate.Close()
+
+	mutateServer := s.NewMutateServer(testCertificate(t, "mutate.webhook.svc"), WhSvrParam{MutatePort: 8443, KeepAlive: true})
+	if mutateServer.Addr != ":8443" || mutateServer.TLSConfig.MinVersion != tls.VersionTLS12 {
+		t.Errorf("got address %q and minimum TLS version %x", mutateServer.Addr, mutateServer.TLSConfig.MinVersion)
+	}
+	if len(s.Server.TLSConfig.Certificates) != 1 {
+		t.Errorf("mutate server changed the validate certificates")
+	}
+	mutate := httptest.NewUnstartedServer(mutateServer.Handler)
+	mutate.TLS = mutateServer.TLSConfig
+	mutate.StartTLS()
+	defer mutate.Close()
+
+	review, err := json.Marshal(testutil.NewPodAdmissionReview(testPod("web", "nginx:1.21"), admissionV1.Create))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}}
+	tests := []struct {
+		name       string
+		url        string
+		wantCN     string
+		wantStatus int
+	}{
+		{name: "validate", url: validate.URL + ValidatePath, wantCN: "validate.webhook.svc", wantStatus: http.StatusOK},
+		{name: "mutate", url: mutate.URL + MutatePath, wantCN: "mutate.webhook.svc", wantStatus: http.StatusOK},
+		{name: "validate on the mutate port", url: mutate.URL + ValidatePath, wantCN: "mutate.webhook.svc", wantStatus: http.StatusNotFound},
+	}
+	discardLogs(t)
+	for _, tt := range tests {
+		resp, err := client.Post(tt.url, "application/json", bytes.NewReader(review))
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		resp.Body.Close()
+		if cn := resp.TLS.PeerCertificates[0].Subject.CommonName; cn != tt.wantCN {
+			t.Errorf("%s: served certificate %q, want %q", tt.name, cn, tt.wantCN)
+		}
+		if resp.StatusCode != tt.wantStatus {
+			t.Errorf("%s: got status %d, want %d", tt.name, resp.StatusCode, tt.wantStatus)
+		}
+	}
+}
+
+func TestLoadKeyPair(t *testing.T) {
+	tests := []struct {
+		name    string
+		retries int
+		wantErr bool
+	}{
+		{name: "retries until mounted", retries: 20},
+		{name: "no retries", retries: 0, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "certs")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+			certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+			certPEM, keyPEM := testKeyPair(t, "admission-registry")
+			// 模拟 cert-manager 在 webhook 启动之后才挂载证书
+			mounted := make(chan error, 1)
+			go func() {
+				time.Sleep(20 * time.Millisecond)
+				if err := ioutil.WriteFile(keyFile, keyPEM, 0600); err != nil {
+					mounted <- err
+					return
+				}
+				mounted <- ioutil.WriteFile(certFile, certPEM, 0600)
+			}()
+			_, err = LoadKeyPair(certFile, keyFile, tt.retries, 10*time.Millisecond)
+			if werr := <-mounted; werr != nil {
+				t.Fatal(werr)
+			}
+			if (err != nil) != tt.wantErr {
+				t.Errorf("LoadKeyPair() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
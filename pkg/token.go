@@ -0,0 +1,92 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+
+	admissionV1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// ServiceAccount token 默认挂载的目录
+const defaultTokenMountPath = "/var/run/secrets/"
+
+// tokenVolumes 返回挂载了 ServiceAccount token 的 volume: 带有 serviceAccountToken 的 projected volume,
+// 以及引用了 ServiceAccount token Secret (名字中带 -token-) 的 secret volume
+func tokenVolumes(pod *corev1.Pod) map[string]bool {
+	volumes := make(map[string]bool)
+	for _, volume := range pod.Spec.Volumes {
+		if volume.Projected != nil {
+			for _, source := range volume.Projected.Sources {
+				if source.ServiceAccountToken != nil {
+					volumes[volume.Name] = true
+				}
+			}
+		}
+		if volume.Secret != nil && strings.Contains(volume.Secret.SecretName, "-token-") {
+			volumes[volume.Name] = true
+		}
+	}
+	return volumes
+}
+
+// checkTokenMounts 敏感命名空间中, ServiceAccount token 只能挂载到允许的目录下,
+// 并且 pod 不能显式打开 automountServiceAccountToken
+func checkTokenMounts(_ context.Context, policy *Policy, pod *corev1.Pod) *denial {
+	if !policy.RestrictTokenMounts {
+		return nil
+	}
+	if pod.Spec.AutomountServiceAccountToken != nil && *pod.Spec.AutomountServiceAccountToken {
+		return &denial{
+			code:    http.StatusForbidden,
+			message: fmt.Sprintf("pod %s sets automountServiceAccountToken: true, which is not allowed in namespace %s.", pod.Name, pod.Namespace),
+		}
+	}
+	allowed := policy.TokenMountPaths
+	if len(allowed) == 0 {
+		allowed = []string{defaultTokenMountPath}
+	}
+	volumes := tokenVolumes(pod)
+	for _, container := range podContainers(pod) {
+		for _, mount := range container.VolumeMounts {
+			if !volumes[mount.Name] || hasAnyPrefix(mount.MountPath, allowed) {
+				continue
+			}
+			return &denial{
+				code: http.StatusForbidden,
+				message: fmt.Sprintf("container %s mounts service account token volume %s at %s! Tokens may only be mounted under %v.",
+					container.Name, mount.Name, mount.MountPath, allowed),
+			}
+		}
+	}
+	return nil
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkServiceAccountAutomount 不允许创建设置了 automountServiceAccountToken: true 的 ServiceAccount
+// token 应该由确实需要的 pod 自己显式挂载, 而不是让使用这个 ServiceAccount 的所有 pod 默认挂载
+func checkServiceAccountAutomount(_ context.Context, policy *Policy, _ *admissionV1.AdmissionRequest, obj runtime.Object) *denial {
+	sa, ok := obj.(*corev1.ServiceAccount)
+	if !ok || !policy.DenyServiceAccountAutomount {
+		return nil
+	}
+	if sa.AutomountServiceAccountToken != nil && *sa.AutomountServiceAccountToken {
+		return &denial{
+			code: http.StatusForbidden,
+			message: fmt.Sprintf("ServiceAccount %s sets automountServiceAccountToken: true, which is not allowed in namespace %s! Pods that need the token should set automountServiceAccountToken: true themselves.",
+				sa.Name, sa.Namespace),
+		}
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package pkg
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// tokenPod 构造把 volume 挂载到 mountPath 的 pod
+func tokenPod(volume corev1.Volume, mountPath string) *corev1.Pod {
+	return &corev1.Pod{Spec: corev1.PodSpec{
+		Volumes: []corev1.Volume{volume},
+		Containers: []corev1.Container{{
+			Name:         "app",
+			VolumeMounts: []corev1.VolumeMount{{Name: volume.Name, MountPath: mountPath}},
+		}},
+	}}
+}
+
+func TestCheckTokenMounts(t *testing.T) {
+	projected := corev1.Volume{Name: "token", VolumeSource: corev1.VolumeSource{Projected: &corev1.ProjectedVolumeSource{
+		Sources: []corev1.VolumeProjection{{ServiceAccountToken: &corev1.ServiceAccountTokenProjection{Path: "token"}}},
+	}}}
+	legacy := corev1.Volume{Name: "default-token-abcde", VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: "default-token-abcde"}}}
+	config := corev1.Volume{Name: "config", VolumeSource: corev1.VolumeSource{Secret: &corev1.SecretVolumeSource{SecretName: "app-config"}}}
+	mount := true
+	automount := tokenPod(config, "/etc/app")
+	automount.Spec.AutomountServiceAccountToken = &mount
+	tests := []struct {
+		name     string
+		pod      *corev1.Pod
+		policy   Policy
+		wantCode int
+	}{
+		{name: "projected token at the default path", pod: tokenPod(projected, "/var/run/secrets/tokens")},
+		{name: "projected token elsewhere", pod: tokenPod(projected, "/host/etc"), wantCode: http.StatusForbidden},
+		{name: "legacy token secret elsewhere", pod: tokenPod(legacy, "/app/token"), wantCode: http.StatusForbidden},
+		{name: "other secret elsewhere", pod: tokenPod(config, "/app/config")},
+		{name: "custom token path", pod: tokenPod(projected, "/app/token"), policy: Policy{TokenMountPaths: []string{"/app/"}}},
+		{name: "custom path replaces the default", pod: tokenPod(projected, "/var/run/secrets/tokens"), policy: Policy{TokenMountPaths: []string{"/app/"}}, wantCode: http.StatusForbidden},
+		{name: "explicit automount", pod: automount, wantCode: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		policy := tt.policy
+		policy.RestrictTokenMounts = true
+		if code := denialCode(checkTokenMounts(context.Background(), &policy, tt.pod)); code != tt.wantCode {
+			t.Errorf("%s: got code %d, want %d", tt.name, code, tt.wantCode)
+		}
+	}
+	if d := checkTokenMounts(context.Background(), &Policy{}, tokenPod(projected, "/host/etc")); d != nil {
+		t.Errorf("check is off by default, got %v", d.message)
+	}
+}
+
+func TestCheckServiceAccountAutomount(t *testing.T) {
+	automount, noAutomount := true, false
+	tests := []struct {
+		name      string
+		automount *bool
+		deny      bool
+		wantCode  int
+	}{
+		{name: "automount enabled", automount: &automount, deny: true, wantCode: http.StatusForbidden},
+		{name: "automount disabled", automount: &noAutomount, deny: true},
+		{name: "unset", deny: true},
+		{name: "not configured", automount: &automount},
+	}
+	for _, tt := range tests {
+		sa := &corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "deployer", Namespace: "team"}, AutomountServiceAccountToken: tt.automount}
+		if code := denialCode(checkServiceAccountAutomount(context.Background(), &Policy{DenyServiceAccountAutomount: tt.deny}, nil, sa)); code != tt.wantCode {
+			t.Errorf("%s: got code %d, want %d", tt.name, code, tt.wantCode)
+		}
+	}
+	// 其它对象不做检查
+	if d := checkServiceAccountAutomount(context.Background(), &Policy{DenyServiceAccountAutomount: true}, nil, imagePod("nginx")); d != nil {
+		t.Errorf("pod: got denial %q", d.message)
+	}
+}
@@ -0,0 +1,41 @@
+package pkg
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp"
+	"go.opentelemetry.io/otel/exporters/otlp/otlphttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/semconv"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/haozi4263/admission-registry"
+
+// InitTracing 初始化 OpenTelemetry, 通过 OTLP/HTTP 把 span 发送到 endpoint (host:port)
+// 返回的函数用于退出时把剩余的 span 发送完
+// 没有调用 InitTracing 时全局的 TracerProvider 是空操作的, 所有的 span 都不会被记录
+func InitTracing(ctx context.Context, endpoint string) (func(context.Context) error, error) {
+	exporter, err := otlp.NewExporter(ctx, otlphttp.NewDriver(
+		otlphttp.WithEndpoint(endpoint),
+		otlphttp.WithInsecure(),
+	))
+	if err != nil {
+		return nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.ServiceNameKey.String("admission-registry"))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+	return provider.Shutdown, nil
+}
+
+// startSpan 开启一个子 span
+func startSpan(ctx context.Context, name string) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name)
+}
@@ -0,0 +1,103 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/haozi4263/admission-registry/pkg/testutil"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	admissionV1 "k8s.io/api/admission/v1"
+)
+
+// recordSpans 在测试期间用内存中的 exporter 记录所有 span
+func recordSpans(t *testing.T) *tracetest.InMemoryExporter {
+	exporter := tracetest.NewInMemoryExporter()
+	provider, propagator := otel.GetTracerProvider(), otel.GetTextMapPropagator()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() {
+		otel.SetTracerProvider(provider)
+		otel.SetTextMapPropagator(propagator)
+	})
+	return exporter
+}
+
+func TestHandlerSpans(t *testing.T) {
+	exporter := recordSpans(t)
+	s := &WebhookServer{Inspector: NewCachedInspector(&fakeInspector{})}
+	s.SetConfig(Config{Base: Policy{WhiteListRegistries: []string{"docker.io"}, MaxImageSize: 1 << 30}})
+	body, err := json.Marshal(testutil.NewPodAdmissionReview(testPod("web", "nginx:1.21"), admissionV1.Create))
+	if err != nil {
+		t.Fatal(err)
+	}
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+	request := httptest.NewRequest(http.MethodPost, ValidatePath, bytes.NewReader(body))
+	request.Header.Set("Content-Type", "application/json")
+	request.Header.Set("traceparent", "00-"+traceID+"-00f067aa0ba902b7-01")
+	s.Handler(httptest.NewRecorder(), request)
+
+	spans := make(map[string]*sdktrace.SpanSnapshot)
+	for _, span := range exporter.GetSpans() {
+		spans[span.Name] = span
+		// 所有的 span 都挂在调用方传过来的 trace 下面
+		if got := span.SpanContext.TraceID().String(); got != traceID {
+			t.Errorf("span %s has trace ID %s, want %s", span.Name, got, traceID)
+		}
+	}
+	root := spans["admission "+ValidatePath]
+	if root == nil {
+		t.Fatalf("no handler span, got %v", spanNames(exporter))
+	}
+	if got := root.Parent.SpanID().String(); got != "00f067aa0ba902b7" {
+		t.Errorf("handler span parent = %s, want the incoming span", got)
+	}
+	for name, parent := range map[string]string{"decode": root.Name, "validate": root.Name, "inspect image": "validate"} {
+		span := spans[name]
+		if span == nil {
+			t.Errorf("no %s span, got %v", name, spanNames(exporter))
+			continue
+		}
+		if span.Parent.SpanID() != spans[parent].SpanContext.SpanID() {
+			t.Errorf("span %s is not a child of %s", name, parent)
+		}
+	}
+	wantAttributes := map[string][]attribute.KeyValue{
+		root.Name:  {attribute.Bool("admission.allowed", true)},
+		"validate": {attribute.Bool("admission.allowed", true), attribute.Int("admission.image_count", 1)},
+	}
+	for name, attributes := range wantAttributes {
+		span := spans[name]
+		if span == nil {
+			continue
+		}
+		for _, want := range attributes {
+			if !hasAttribute(span.Attributes, want) {
+				t.Errorf("span %s has attributes %v, want %v", name, span.Attributes, want)
+			}
+		}
+	}
+}
+
+func spanNames(exporter *tracetest.InMemoryExporter) []string {
+	var names []string
+	for _, span := range exporter.GetSpans() {
+		names = append(names, span.Name)
+	}
+	return names
+}
+
+func hasAttribute(attributes []attribute.KeyValue, want attribute.KeyValue) bool {
+	for _, kv := range attributes {
+		if kv.Key == want.Key && kv.Value == want.Value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,36 @@
+package pkg
+
+import (
+	"encoding/json"
+	"net/http"
+	"runtime"
+)
+
+// 构建时通过 -ldflags 注入, 比如
+// go build -ldflags "-X github.com/haozi4263/admission-registry/pkg.Version=v0.4 -X github.com/haozi4263/admission-registry/pkg.GitCommit=$(git rev-parse HEAD)"
+var (
+	Version   = "dev"
+	GitCommit = "unknown"
+)
+
+// BuildInfo 当前运行的版本信息
+type BuildInfo struct {
+	Version   string `json:"version"`
+	GitCommit string `json:"gitCommit"`
+	GoVersion string `json:"goVersion"`
+}
+
+// GetBuildInfo 返回当前运行的版本信息
+func GetBuildInfo() BuildInfo {
+	return BuildInfo{
+		Version:   Version,
+		GitCommit: GitCommit,
+		GoVersion: runtime.Version(),
+	}
+}
+
+// VersionHandler 以 json 返回版本信息
+func VersionHandler(writer http.ResponseWriter, request *http.Request) {
+	writer.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(writer).Encode(GetBuildInfo())
+}
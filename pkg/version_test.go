@@ -0,0 +1,29 @@
+package pkg
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"testing"
+)
+
+func TestVersionHandler(t *testing.T) {
+	version, commit := Version, GitCommit
+	defer func() { Version, GitCommit = version, commit }()
+	Version, GitCommit = "v0.4", "0123abc"
+
+	recorder := httptest.NewRecorder()
+	VersionHandler(recorder, httptest.NewRequest(http.MethodGet, "/version", nil))
+	if ct := recorder.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+	var info BuildInfo
+	if err := json.NewDecoder(recorder.Body).Decode(&info); err != nil {
+		t.Fatal(err)
+	}
+	want := BuildInfo{Version: "v0.4", GitCommit: "0123abc", GoVersion: runtime.Version()}
+	if info != want {
+		t.Errorf("got %+v, want %+v", info, want)
+	}
+}
@@ -1,17 +1,27 @@
 package pkg
 
 import (
+	"compress/gzip"
+	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"net/http"
-	"strings"
+	"runtime/debug"
+	"sync"
+	"time"
 
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 	admissionV1 "k8s.io/api/admission/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/client-go/kubernetes"
 	"k8s.io/klog"
 )
 
@@ -21,56 +31,209 @@ var (
 	deserializer  = codeFactory.UniversalDeserializer()
 )
 
+// 请求 body 默认的最大字节数, 和 api-server 对请求大小的限制保持一致
+const defaultMaxBodySize = 3 * 1024 * 1024
+
 type WhSvrParam struct {
-	Port     int
-	CertFile string
-	KeyFile  string
+	Port       int
+	CertFile   string
+	KeyFile    string
+	ConfigFile string
+
+	MutateCertFile string // mutate 使用的证书, 为空时 mutate 和 validate 共用 CertFile
+	MutateKeyFile  string
+	MutatePort     int // mutate 使用单独的证书时监听的端口
+
+	CertRetries     int           // 启动时证书加载失败的重试次数, 用于等待 cert-manager 挂载证书
+	CertWarmupDelay time.Duration // 每次重试加载证书之前等待的时间
+
+	MinTLSVersion   string // 最低的 TLS 版本, 比如 1.2
+	TLSCipherSuites string // 逗号分隔的加密套件名, 为空时使用 Go 的默认值
+
+	KeepAlive         bool          // 是否复用和 api-server 之间的连接, 关闭时每个响应都带 Connection: close
+	IdleTimeout       time.Duration // 空闲连接保持的时间, 应该长于 api-server 客户端的空闲超时, 避免连接被服务端先关闭
+	ReadHeaderTimeout time.Duration // 读取请求 header 的超时时间
+	MaxRequestTimeout time.Duration // 单个准入请求最多处理的时间
+
+	AllowlistURL      string
+	AllowlistInterval time.Duration
+
+	DebugSampleRate  float64
+	DebugSampleByUID bool
+
+	TracingEnabled  bool
+	TracingEndpoint string
+
+	DecisionSinkURL string
+	ScannerURL      string
+
+	BreakerThreshold int           // 外部依赖连续失败多少次之后熔断, 为 0 时不熔断
+	BreakerCooldown  time.Duration // 熔断之后多久开始探测
+
+	SelfRegister      bool // 启动时自己创建 webhook 配置
+	RegistrationName  string
+	ServiceNamespace  string
+	ServiceName       string
+	CABundleFile      string
+	FailurePolicy     string
+	NamespaceSelector string // label selector, 比如 admission-registry=enabled
+	CleanupOnShutdown bool
+
+	LogFormat string // 日志格式, text 或 json
+
+	BootstrapWindow time.Duration // 启动之后允许所有镜像的时间, 为 0 时不开启
+
+	ConfigTokenFile string // 访问 /config 和 /recent 的 token 所在的文件
+
+	BreakGlassKeyFile string        // 校验 break-glass token 的 HMAC key 所在的文件, 为空时关闭 break-glass
+	BreakGlassMaxTTL  time.Duration // break-glass token 最长的有效期
+
+	RecentDecisions int // 在内存中保存的最近准入决定的数量, 为 0 时关闭 /recent
+
+	ReadyWhenDegraded bool // 外部依赖不可达时 /readyz 仍然返回 200, 默认开启, 避免依赖故障时所有副本同时被摘掉
+
+	ReplayDir string // 回放目录中抓取的请求并退出, 不启动服务
+
+	ImpactReportOnReload bool // 重新加载配置之后在后台评估新策略对正在运行的 pod 的影响
+
+	MaxBodySize     int64 // 请求 body 的最大字节数, UPDATE 请求同时带有新旧两个对象, 可能超过 api-server 对单个对象的限制
+	LargeObjectSize int   // 超过这个字节数的工作负载只解析检查需要的部分, 为 0 时总是完整解析
 }
 
 type WebhookServer struct {
-	Server              *http.Server
-	WhiteListRegistries []string // 白名单的镜像仓库列表
+	Server *http.Server
+	Config Config // 策略配置, 运行时修改需要通过 SetConfig
+
+	MutateServer *http.Server // mutate 使用单独证书时的 server, 为 nil 时 mutate 由 Server 处理
+
+	Inspector    *CachedInspector     // 查询镜像元数据
+	Scanner      *CachedScanner       // 查询镜像的漏洞扫描结果, 为 nil 时不检查漏洞
+	Attestations *CachedVerifier      // 查询镜像的 SBOM attestation
+	Client       kubernetes.Interface // 查询集群中的其它资源, 为 nil 时跳过依赖集群状态的检查
+	Allowlist    *RemoteAllowlist     // 从外部服务获取的白名单, 和配置中的白名单一起生效
+	Sink         DecisionSink         // 接收所有的准入决定, 比如发送给 SIEM
+	Recent       *RecentDecisions     // 最近的准入决定, 通过 /recent 查看, 为 nil 时不保存
+
+	DebugSampleRate  float64 // 打印详细调试日志的请求比例, 0 到 1 之间
+	DebugSampleByUID bool    // 按请求 UID 采样, 同一个请求的采样结果是确定的
+
+	LookupTimeout time.Duration    // 查询集群状态的超时时间, 为 0 时使用默认值
+	Checks        *CheckRegistry   // 执行的检查, 为 nil 时使用所有内置的检查
+	Clock         func() time.Time // 返回当前时间, 为 nil 时使用 time.Now
+
+	BootstrapUntil time.Time // 在这之前处于 bootstrap 模式, 允许所有请求并返回警告, 为零值时不开启
+
+	ConfigToken string // 访问 /config 和 /recent 需要的 Bearer token, 为空时关闭这两个接口
+
+	BreakGlassKey    []byte        // 校验 break-glass token 的 HMAC key, 为空时关闭 break-glass
+	BreakGlassMaxTTL time.Duration // break-glass token 最长的有效期, 为 0 时使用默认值
+
+	MaxRequestTimeout time.Duration // 单个请求最多处理的时间, api-server 传过来的 timeout 更短时以 timeout 为准, 为 0 时使用默认值
+
+	MaxBodySize     int64 // 请求 body 的最大字节数, 为 0 时使用默认值
+	LargeObjectSize int   // 超过这个字节数的工作负载跳过 status/managedFields 等检查不需要的部分, 降低内存占用, 为 0 时总是完整解析
+
+	Dependencies      []Dependency  // 就绪检查时探测的外部依赖
+	ReadyWhenDegraded bool          // 外部依赖不可达时就绪检查仍然通过, 只把状态标记为 degraded
+	ProbeTimeout      time.Duration // 探测外部依赖的超时时间, 为 0 时使用默认值
+
+	policyMu sync.RWMutex
+	policies *policyCache // 按命名空间缓存的合并后的策略
+
+	usageMu sync.Mutex
+	usage   map[string]namespaceUsage // 按命名空间缓存的资源用量
+
+	runtimeClassMu sync.Mutex
+	runtimeClasses map[string]runtimeClassOverhead // 按名字缓存的 RuntimeClass overhead
+
+	optInMu sync.Mutex
+	optIns  map[string]namespaceOptIns // 按命名空间缓存的 opt-in 注解
+
+	limitRangeMu sync.Mutex
+	limitRanges  map[string]limitRangeDefaults // 按命名空间缓存的是否有默认资源的 LimitRange
+
+	impactRunning int32 // 是否有正在执行的策略影响评估
 }
 
 func (s *WebhookServer) Handler(writer http.ResponseWriter, request *http.Request) {
+	// 从请求的 header 中取出调用方的 trace 信息, 准入处理的 span 会挂在它下面
+	ctx := otel.GetTextMapPropagator().Extract(request.Context(), propagation.HeaderCarrier(request.Header))
+	ctx, span := startSpan(ctx, "admission "+request.URL.Path)
+	defer span.End()
+	ctx, cancel := s.requestContext(ctx, request)
+	defer cancel()
+
+	// 只有无法解析或者过大的请求才返回非 200 的状态码,
+	// 解析出 AdmissionReview 之后, 所有的结果都通过 200 返回, 由 AdmissionResponse 表示是否允许, 这是 api-server 期望的行为
+	maxBodySize := s.MaxBodySize
+	if maxBodySize == 0 {
+		maxBodySize = defaultMaxBodySize
+	}
 	var body []byte
 	if request.Body != nil {
-		if data, err := ioutil.ReadAll(request.Body); err == nil {
-			body = data
+		var reader io.Reader = request.Body
+		// gzip 压缩的 body 先解压, 大小限制作用在解压之后的数据上
+		if request.Header.Get("Content-Encoding") == "gzip" {
+			gz, err := gzip.NewReader(request.Body)
+			if err != nil {
+				klog.Errorf("Can't read gzip body: %v", err)
+				http.Error(writer, fmt.Sprintf("Can't read gzip body: %v", err), http.StatusBadRequest)
+				return
+			}
+			defer gz.Close()
+			reader = gz
+		}
+		// 读取中途出错时 ReadAll 会返回已经读到的部分数据, 这样不完整的 body 不能继续处理
+		data, err := ioutil.ReadAll(io.LimitReader(reader, maxBodySize+1))
+		if err != nil {
+			klog.Errorf("Can't read request body after %d bytes: %v", len(data), err)
+			http.Error(writer, fmt.Sprintf("can't read request body after %d bytes: %v", len(data), err), http.StatusBadRequest)
+			return
 		}
+		body = data
 	}
 	if len(body) == 0 {
 		klog.Error("empty data body")
 		http.Error(writer, "empty data body", http.StatusBadRequest)
 		return
 	}
-
-	// 校验content-type
-	contentType := request.Header.Get("Content-Type")
-	if contentType != "application/json" {
-		klog.Error("Content-Type is %s, but expect application/json", contentType)
-		klog.Error(writer, "Content-Type invalid, expect application/json", http.StatusBadRequest)
+	if int64(len(body)) > maxBodySize {
+		klog.Errorf("Request body is larger than %d bytes", maxBodySize)
+		http.Error(writer, fmt.Sprintf("request body is larger than %d bytes", maxBodySize), http.StatusRequestEntityTooLarge)
 		return
 	}
 
 	// 数据序列化(validate、mutate)请求的数据都是AdmissionReview
-	var admissionResponse *admissionV1.AdmissionResponse
 	requestedAdmissionReview := admissionV1.AdmissionReview{}
-	if _, _, err := deserializer.Decode(body, nil, &requestedAdmissionReview); err != nil {
-		klog.Error("Can't decode body: %v", err)
+	_, decodeSpan := startSpan(ctx, "decode")
+	_, _, decodeErr := deserializer.Decode(body, nil, &requestedAdmissionReview)
+	decodeSpan.End()
+	if decodeErr != nil {
+		klog.Errorf("Can't decode body: %v", decodeErr)
+		http.Error(writer, fmt.Sprintf("Can't decode body: %v", decodeErr), http.StatusBadRequest)
+		return
+	}
+
+	var admissionResponse *admissionV1.AdmissionResponse
+	// 校验content-type
+	contentType := request.Header.Get("Content-Type")
+	if contentType != "application/json" {
+		klog.Errorf("Content-Type is %s, but expect application/json", contentType)
 		admissionResponse = &admissionV1.AdmissionResponse{
 			Result: &metav1.Status{
-				Message: err.Error(),
-				Code:    http.StatusInternalServerError,
+				Message: "Content-Type invalid, expect application/json",
+				Code:    http.StatusBadRequest,
 			},
 		}
 	} else {
 		//序列化成功，也就是说获取到了请求的AdmissionReview的数据
-		if request.URL.Path == "/mutate" {
-			// TODO
-		} else if request.URL.Path == "/validate" {
-			admissionResponse = s.validate(&requestedAdmissionReview)
-		}
+		admissionResponse = s.review(ctx, request.URL.Path, &requestedAdmissionReview)
+	}
+	span.SetAttributes(attribute.Bool("admission.allowed", admissionResponse.Allowed))
+	// 不管走的是哪个分支, 只要解析出了请求, 返回的 uid 都需要和请求的 uid 保持一致, 否则 api-server 会认为响应不匹配
+	// 完全无法解析的请求拿不到 uid, 前面已经直接返回了 400
+	if requestedAdmissionReview.Request != nil {
+		admissionResponse.UID = requestedAdmissionReview.Request.UID
 	}
 
 	// 构造返回的 AdmissionReview这个结构体
@@ -78,75 +241,229 @@ func (s *WebhookServer) Handler(writer http.ResponseWriter, request *http.Reques
 	// admission/v1
 	responseAdmissionReview.APIVersion = requestedAdmissionReview.APIVersion // v1版本需要指定版本
 	responseAdmissionReview.Kind = requestedAdmissionReview.Kind
-	if admissionResponse != nil {
-		responseAdmissionReview.Response = admissionResponse
-		if requestedAdmissionReview.Request != nil {
-			//返回的uuid需要和请求的uid保持一致
-			responseAdmissionReview.Response.UID = requestedAdmissionReview.Request.UID
-		}
+	if responseAdmissionReview.APIVersion == "" {
+		responseAdmissionReview.APIVersion = admissionV1.SchemeGroupVersion.String()
+		responseAdmissionReview.Kind = "AdmissionReview"
 	}
+	responseAdmissionReview.Response = admissionResponse
 
 	klog.Info(fmt.Sprintf("sending response: %v", responseAdmissionReview.Response))
 	// send response
-	respBytes, err := json.Marshal(responseAdmissionReview)
-	if err != nil {
-		klog.Error("Can't encode response: %v", err)
-		http.Error(writer, fmt.Sprintf("Can't encode response: %v", err), http.StatusBadRequest)
-		return
+	// 直接把响应编码写到 ResponseWriter, 不再先 Marshal 成完整的 []byte
+	// 写入 body 之后状态码和 header 已经发送, 编码失败时只能记录日志
+	writer.Header().Set("Content-Type", "application/json")
+	// 准入的结果只对这一个请求有效, 不允许任何中间层缓存
+	writer.Header().Set("Cache-Control", "no-store")
+	writer.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(writer).Encode(responseAdmissionReview); err != nil {
+		klog.Errorf("Can't encode response: %v", err)
 	}
-	klog.Info("Ready to write response...")
+}
 
-	if _, err := writer.Write(respBytes); err != nil {
-		klog.Error("Can't write response: %v", err)
-		http.Error(writer, fmt.Sprintf("Can't write response: %v", err), http.StatusBadRequest)
+// 没有收到 api-server 的 timeout 参数时, 单个请求最多处理的时间, 和 api-server 允许的最大超时时间一致
+const defaultMaxRequestTimeout = 30 * time.Second
+
+// requestContext 按照 api-server 传过来的 timeout 参数设置请求的 deadline, 并且不超过 MaxRequestTimeout
+// deadline 比 api-server 的超时提前一点, 让查询失败时的 fail-open/fail-closed 逻辑先于 api-server 的超时生效
+func (s *WebhookServer) requestContext(ctx context.Context, request *http.Request) (context.Context, context.CancelFunc) {
+	timeout := s.MaxRequestTimeout
+	if timeout == 0 {
+		timeout = defaultMaxRequestTimeout
+	}
+	if value := request.URL.Query().Get("timeout"); value != "" {
+		if d, err := time.ParseDuration(value); err != nil {
+			klog.Warningf("Invalid timeout parameter %q: %v", value, err)
+		} else if d > 0 && d < timeout {
+			timeout = d
+		}
+	}
+	margin := timeout / 10
+	if margin > time.Second {
+		margin = time.Second
 	}
+	return context.WithTimeout(ctx, timeout-margin)
+}
 
+// 准入请求的路径
+const (
+	ValidatePath = "/validate"
+	MutatePath   = "/mutate"
+)
+
+// Review 对 AdmissionReview 做校验并返回结果, 不依赖 http 层, 方便嵌入到其它的准入服务中
+func (s *WebhookServer) Review(ar *admissionV1.AdmissionReview) *admissionV1.AdmissionResponse {
+	return s.ReviewPath(ValidatePath, ar)
+}
+
+// ReviewPath 按照请求路径分发到 validate 或 mutate
+func (s *WebhookServer) ReviewPath(path string, ar *admissionV1.AdmissionReview) *admissionV1.AdmissionResponse {
+	return s.review(context.Background(), path, ar)
 }
 
-func (s *WebhookServer) validate(ar *admissionV1.AdmissionReview) *admissionV1.AdmissionResponse {
-	req := ar.Request
-	var (
-		allowed = true
-		code = 200
-		message = ""
-	)
-	klog.Info("AdmissionReview for Kind=%s, Namespace=%s, Name=%s, UID=%s",
+func (s *WebhookServer) review(ctx context.Context, path string, ar *admissionV1.AdmissionReview) (resp *admissionV1.AdmissionResponse) {
+	// 检查中的 panic 不能让连接直接断开, api-server 会拿不到任何响应, 这里转成内部错误返回
+	defer func() {
+		if r := recover(); r != nil {
+			klog.Errorf("Panic while reviewing %s: %v\n%s", path, r, debug.Stack())
+			resp = &admissionV1.AdmissionResponse{
+				Allowed: false,
+				Result: &metav1.Status{
+					Code:    http.StatusInternalServerError,
+					Reason:  metav1.StatusReasonInternalError,
+					Message: fmt.Sprintf("internal error while reviewing the request: %v", r),
+				},
+			}
+			if ar.Request != nil {
+				resp.UID = ar.Request.UID
+			}
+		}
+	}()
+	if ar.Request == nil {
+		return &admissionV1.AdmissionResponse{
+			Result: &metav1.Status{
+				Message: "admission review has no request",
+				Code:    http.StatusBadRequest,
+			},
+		}
+	}
+	switch path {
+	case MutatePath:
+		resp = s.mutate(ctx, ar)
+	case ValidatePath:
+		resp = s.validate(ctx, ar)
+	default:
+		resp = &admissionV1.AdmissionResponse{
+			Result: &metav1.Status{
+				Message: fmt.Sprintf("unknown admission path %s", path),
+				Code:    http.StatusNotFound,
+			},
+		}
+	}
+	//返回的uuid需要和请求的uid保持一致
+	resp.UID = ar.Request.UID
+	return resp
+}
+
+func (s *WebhookServer) validate(ctx context.Context, ar *admissionV1.AdmissionReview) *admissionV1.AdmissionResponse {
+	ctx, span := startSpan(ctx, "validate")
+	defer span.End()
+	resp := s.evaluate(ctx, ar.Request)
+	span.SetAttributes(attribute.Bool("admission.allowed", resp.Allowed))
+	// 在审计信息中记录做出决定的策略版本, 方便排查问题时和配置变更对应起来
+	version := s.policyVersion()
+	if version != "" {
+		if resp.AuditAnnotations == nil {
+			resp.AuditAnnotations = make(map[string]string)
+		}
+		resp.AuditAnnotations["policy-version"] = version
+	}
+	logDecision(ar.Request, resp, version)
+	if s.Sink != nil || s.Recent != nil {
+		decision := newDecision(ar.Request, resp, version)
+		if s.Sink != nil {
+			s.Sink.Send(decision)
+		}
+		if s.Recent != nil {
+			s.Recent.Send(decision)
+		}
+	}
+	return resp
+}
+
+func (s *WebhookServer) evaluate(ctx context.Context, req *admissionV1.AdmissionRequest) *admissionV1.AdmissionResponse {
+	klog.Infof("AdmissionReview for Kind=%s, Namespace=%s, Name=%s, UID=%s",
 		req.Kind.Kind, req.Namespace, req.Name, req.UID)
-	var pod corev1.Pod
-	if err := json.Unmarshal(req.Object.Raw, &pod); err != nil {
-		klog.Error("Can't unmarshal object raw: %v", err)
-		allowed = false
-		code = http.StatusBadRequest
+	policy := s.namespacePolicy(ctx, req.Namespace)
+	if skipRequest(&policy, req) {
+		klog.Infof("Skipping %s of %s/%s for UID=%s: no object to validate", req.Operation, req.Resource.Resource, req.SubResource, req.UID)
+		return &admissionV1.AdmissionResponse{
+			Allowed: true,
+			Result: &metav1.Status{
+				Code: http.StatusOK,
+			},
+		}
+	}
+	obj, pod, err := decodeObject(req, s.LargeObjectSize)
+	if err != nil {
+		klog.Errorf("Can't unmarshal object raw: %v", err)
 		return &admissionV1.AdmissionResponse{
-			Allowed: allowed,
+			Allowed: false,
 			Result: &metav1.Status{
-				Code: int32(code),
+				Code:    http.StatusBadRequest,
+				Reason:  metav1.StatusReasonBadRequest,
 				Message: err.Error(),
 			},
 		}
 	}
 
-	// 处理真正的业务逻辑
-	for _, container := range pod.Spec.Containers{
-		var whitelisted = false
-		for _, reg := range s.WhiteListRegistries {
-			if strings.HasPrefix(container.Image, reg){
-				whitelisted = true
-			}
-		}
-		if !whitelisted {
-			allowed = false
-			code = http.StatusForbidden
-			message = fmt.Sprintf("%s image comes from untrusted registry! Only images form %v are allowed.",
-				container.Image, s.WhiteListRegistries)
-			break
+	if pod != nil {
+		trace.SpanFromContext(ctx).SetAttributes(attribute.Int("admission.image_count", len(podContainers(pod))))
+	}
+
+	debug := s.sampled(req.UID)
+	if debug && pod != nil {
+		redactedPod := redactPod(&policy, pod)
+		debugLog(req.UID, "pod", "namespace", req.Namespace, "name", req.Name,
+			"annotations", redactedPod.Annotations, "spec", redactedPod.Spec)
+	}
+
+	// 处理真正的业务逻辑, 依次执行各个检查
+	if debug {
+		debugLog(req.UID, "policy", "policy", policy)
+	}
+	resp := s.checks().Run(ctx, pod, &Request{AdmissionRequest: req, Object: obj, Policy: &policy, Debug: debug, Time: s.now()})
+	resp = s.breakGlass(req, pod, resp)
+	if s.bootstrapping() {
+		return s.bootstrapResponse(string(req.UID), resp)
+	}
+	return resp
+}
+
+// addAllowlist 把外部白名单追加到策略的白名单中
+func (s *WebhookServer) addAllowlist(policy *Policy) {
+	if s.Allowlist == nil {
+		return
+	}
+	// 缓存的策略是共享的, 追加之前先复制一份
+	whitelist := make([]string, 0, len(policy.WhiteListRegistries)+len(s.Allowlist.Registries()))
+	whitelist = append(whitelist, policy.WhiteListRegistries...)
+	policy.WhiteListRegistries = append(whitelist, s.Allowlist.Registries()...)
+}
+
+// checkRegistries 镜像必须来自白名单中的仓库
+func (s *WebhookServer) checkRegistries(ctx context.Context, policy *Policy, pod *corev1.Pod) *denial {
+	// 单独的一项 * 表示允许所有镜像, 比如 kube-system 这样的系统命名空间
+	for _, reg := range policy.WhiteListRegistries {
+		if reg == allowAllRegistries {
+			return nil
 		}
 	}
-	return &admissionV1.AdmissionResponse{
-		Allowed: allowed,
-		Result: &metav1.Status{
-			Code: int32(code),
-			Message: message,
-		},
+	for i, container := range podContainers(pod) {
+		// 例外列表中的镜像即使来自不可信的仓库也允许, 每次使用都打印警告, 方便之后清理
+		if exceptedImage(container.Image, policy.AllowedImages) {
+			klog.Warningf("%s image in namespace %s is allowed by the image exception list", container.Image, pod.Namespace)
+			continue
+		}
+		// 迁移期间临时允许的仓库, 过期之后拒绝
+		image := policy.canonicalImage(container.Image)
+		if s.temporarilyAllowed(image, pod.Namespace, policy.TemporaryRegistries) {
+			continue
+		}
+		if !whitelisted(image, policy.WhiteListRegistries) {
+			if !advisory(ctx) {
+				recordDenied(container.Image)
+			}
+			return &denial{
+				code: http.StatusForbidden,
+				message: fmt.Sprintf("%s image comes from untrusted registry! Only images form %v are allowed.",
+					container.Image, policy.WhiteListRegistries),
+				field: containerField(pod, i) + ".image",
+				auditAnnotations: map[string]string{
+					"rejected-image":     container.Image,
+					"suggested-registry": nearestRegistry(container.Image, policy.WhiteListRegistries),
+				},
+			}
+		}
 	}
+	return nil
 }
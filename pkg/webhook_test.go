@@ -0,0 +1,615 @@
+package pkg
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/haozi4263/admission-registry/pkg/testutil"
+	admissionV1 "k8s.io/api/admission/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func testPod(name, image string) corev1.Pod {
+	return corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "team"},
+		Spec:       corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: image}}},
+	}
+}
+
+func TestValidateHandler(t *testing.T) {
+	s := &WebhookServer{}
+	s.SetConfig(Config{Base: Policy{WhiteListRegistries: []string{"docker.io"}}})
+	tests := []struct {
+		name, image string
+		wantAllowed bool
+		wantCode    int32
+	}{
+		{name: "whitelisted", image: "nginx:1.21", wantAllowed: true, wantCode: http.StatusOK},
+		{name: "other registry", image: "quay.io/app:1.0", wantCode: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		review := testutil.NewPodAdmissionReview(testPod(tt.name, tt.image), admissionV1.Create)
+		resp, err := testutil.ServeReview(http.HandlerFunc(s.Handler), ValidatePath, review)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if resp.UID != review.Request.UID {
+			t.Errorf("%s: response UID %q does not match request UID %q", tt.name, resp.UID, review.Request.UID)
+		}
+		if resp.Allowed != tt.wantAllowed || resp.Result == nil || resp.Result.Code != tt.wantCode {
+			t.Errorf("%s: got allowed %v, result %+v, want %v and code %d", tt.name, resp.Allowed, resp.Result, tt.wantAllowed, tt.wantCode)
+		}
+	}
+}
+
+func TestValidateHandlerOverTLS(t *testing.T) {
+	s := &WebhookServer{}
+	s.SetConfig(Config{Base: Policy{WhiteListRegistries: []string{"docker.io"}}})
+	mux := http.NewServeMux()
+	mux.HandleFunc(ValidatePath, s.Handler)
+	server := httptest.NewTLSServer(mux)
+	defer server.Close()
+
+	client := testutil.NewClient(server)
+	resp, err := client.Review(ValidatePath, testutil.NewPodAdmissionReview(testPod("web", "quay.io/app:1.0"), admissionV1.Create))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Allowed {
+		t.Error("pod from a registry outside the whitelist was allowed")
+	}
+	if _, err := client.Review("/missing", testutil.NewPodAdmissionReview(testPod("web", "nginx"), admissionV1.Create)); err == nil {
+		t.Error("expected an error for a path without a handler")
+	}
+}
+
+// BenchmarkEncodeResponse 比较先 Marshal 再写入和直接编码到 writer 的内存分配
+func BenchmarkEncodeResponse(b *testing.B) {
+	review := admissionV1.AdmissionReview{
+		TypeMeta: metav1.TypeMeta{APIVersion: admissionV1.SchemeGroupVersion.String(), Kind: "AdmissionReview"},
+		Response: &admissionV1.AdmissionResponse{
+			UID:              "bench",
+			Warnings:         []string{"image nginx:1.21 uses a floating tag", "container app has no memory limit"},
+			AuditAnnotations: map[string]string{"policy-id": "registries,floating-tags"},
+			Result: &metav1.Status{
+				Code:    http.StatusForbidden,
+				Reason:  metav1.StatusReasonForbidden,
+				Message: strings.Repeat("quay.io/app:1.0 image is not in the whitelist! ", 20),
+			},
+		},
+	}
+	b.Run("marshal", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			data, err := json.Marshal(review)
+			if err != nil {
+				b.Fatal(err)
+			}
+			ioutil.Discard.Write(data)
+		}
+	})
+	b.Run("stream", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if err := json.NewEncoder(ioutil.Discard).Encode(review); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkValidateHandler(b *testing.B) {
+	s := &WebhookServer{}
+	s.SetConfig(Config{Base: Policy{WhiteListRegistries: []string{"docker.io"}}})
+	body, err := json.Marshal(testutil.NewPodAdmissionReview(testPod("web", "nginx:1.21"), admissionV1.Create))
+	if err != nil {
+		b.Fatal(err)
+	}
+	discardLogs(b)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		request := httptest.NewRequest(http.MethodPost, ValidatePath, bytes.NewReader(body))
+		request.Header.Set("Content-Type", "application/json")
+		s.Handler(httptest.NewRecorder(), request)
+	}
+}
+
+// keepAliveServer 启动 validate 服务, 通过 ConnState 统计新建的连接数
+func keepAliveServer(keepAlive bool, conns *int32) *httptest.Server {
+	s := &WebhookServer{}
+	s.SetConfig(Config{Base: Policy{WhiteListRegistries: []string{"docker.io"}}})
+	mux := http.NewServeMux()
+	mux.HandleFunc(ValidatePath, s.Handler)
+	server := httptest.NewUnstartedServer(mux)
+	server.Config.ConnState = func(_ net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(conns, 1)
+		}
+	}
+	server.Config.SetKeepAlivesEnabled(keepAlive)
+	server.StartTLS()
+	return server
+}
+
+func TestKeepAlive(t *testing.T) {
+	discardLogs(t)
+	review := testutil.NewPodAdmissionReview(testPod("web", "nginx:1.21"), admissionV1.Create)
+	body, err := json.Marshal(review)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, keepAlive := range []bool{true, false} {
+		var conns int32
+		server := keepAliveServer(keepAlive, &conns)
+		for i := 0; i < 5; i++ {
+			resp, err := server.Client().Post(server.URL+ValidatePath, "application/json", bytes.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			if resp.Close == keepAlive {
+				t.Errorf("keepAlive %v: response has Connection: close = %v", keepAlive, resp.Close)
+			}
+		}
+		server.Close()
+		want := int32(5)
+		if keepAlive {
+			want = 1
+		}
+		if conns := atomic.LoadInt32(&conns); conns != want {
+			t.Errorf("keepAlive %v: opened %d connections for 5 requests, want %d", keepAlive, conns, want)
+		}
+	}
+}
+
+// BenchmarkKeepAlive 比较复用连接和每个请求新建 TLS 连接时的吞吐
+func BenchmarkKeepAlive(b *testing.B) {
+	discardLogs(b)
+	body, err := json.Marshal(testutil.NewPodAdmissionReview(testPod("web", "nginx:1.21"), admissionV1.Create))
+	if err != nil {
+		b.Fatal(err)
+	}
+	for _, keepAlive := range []bool{true, false} {
+		b.Run(fmt.Sprintf("keepAlive=%v", keepAlive), func(b *testing.B) {
+			var conns int32
+			server := keepAliveServer(keepAlive, &conns)
+			defer server.Close()
+			client := server.Client()
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					resp, err := client.Post(server.URL+ValidatePath, "application/json", bytes.NewReader(body))
+					if err != nil {
+						b.Error(err)
+						return
+					}
+					ioutil.ReadAll(resp.Body)
+					resp.Body.Close()
+				}
+			})
+			b.ReportMetric(float64(atomic.LoadInt32(&conns))/float64(b.N), "conns/op")
+		})
+	}
+}
+
+func TestHandlerStatusCodes(t *testing.T) {
+	valid, err := json.Marshal(testutil.NewPodAdmissionReview(testPod("web", "nginx:1.21"), admissionV1.Create))
+	if err != nil {
+		t.Fatal(err)
+	}
+	badReview, err := testutil.NewAdmissionReview(
+		metav1.GroupVersionKind{Version: "v1", Kind: "Pod"}, metav1.GroupVersionResource{Version: "v1", Resource: "pods"},
+		"team", "web", map[string]interface{}{"spec": map[string]interface{}{"containers": "nginx"}}, admissionV1.Create)
+	if err != nil {
+		t.Fatal(err)
+	}
+	badObject, err := json.Marshal(badReview)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gzipped bytes.Buffer
+	gz := gzip.NewWriter(&gzipped)
+	gz.Write(valid)
+	gz.Close()
+	panicking := NewCheckRegistry(funcCheck{"panics", func(context.Context) Result { panic("boom") }})
+
+	tests := []struct {
+		name        string
+		path        string
+		body        []byte
+		contentType string
+		encoding    string
+		checks      *CheckRegistry
+		maxBodySize int64
+		wantStatus  int
+		wantCode    int32 // 200 时 AdmissionResponse.Result 中的状态码
+	}{
+		// 无法解析出 AdmissionReview 的请求才返回非 200
+		{name: "empty body", body: nil, wantStatus: http.StatusBadRequest},
+		{name: "not json", body: []byte("{"), wantStatus: http.StatusBadRequest},
+		{name: "too large", body: valid, maxBodySize: 100, wantStatus: http.StatusRequestEntityTooLarge},
+		{name: "bad gzip", body: []byte("not gzip"), encoding: "gzip", wantStatus: http.StatusBadRequest},
+		// 解析出 AdmissionReview 之后所有的结果都通过 200 返回
+		{name: "allowed", body: valid, wantStatus: http.StatusOK, wantCode: http.StatusOK},
+		{name: "gzip", body: gzipped.Bytes(), encoding: "gzip", wantStatus: http.StatusOK, wantCode: http.StatusOK},
+		{name: "wrong content type", body: valid, contentType: "text/plain", wantStatus: http.StatusOK, wantCode: http.StatusBadRequest},
+		{name: "no request", body: []byte(`{"apiVersion":"admission.k8s.io/v1","kind":"AdmissionReview"}`), wantStatus: http.StatusOK, wantCode: http.StatusBadRequest},
+		{name: "undecodable object", body: badObject, wantStatus: http.StatusOK, wantCode: http.StatusBadRequest},
+		{name: "unknown path", path: "/other", body: valid, wantStatus: http.StatusOK, wantCode: http.StatusNotFound},
+		{name: "panicking check", body: valid, checks: panicking, wantStatus: http.StatusOK, wantCode: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		s := &WebhookServer{Checks: tt.checks, MaxBodySize: tt.maxBodySize}
+		s.SetConfig(Config{Base: Policy{WhiteListRegistries: []string{"docker.io"}}})
+		path, contentType := tt.path, tt.contentType
+		if path == "" {
+			path = ValidatePath
+		}
+		if contentType == "" {
+			contentType = "application/json"
+		}
+		request := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(tt.body))
+		request.Header.Set("Content-Type", contentType)
+		if tt.encoding != "" {
+			request.Header.Set("Content-Encoding", tt.encoding)
+		}
+		recorder := httptest.NewRecorder()
+		s.Handler(recorder, request)
+		if recorder.Code != tt.wantStatus {
+			t.Errorf("%s: got status %d, want %d", tt.name, recorder.Code, tt.wantStatus)
+			continue
+		}
+		if tt.wantStatus != http.StatusOK {
+			continue
+		}
+		var review admissionV1.AdmissionReview
+		if err := json.Unmarshal(recorder.Body.Bytes(), &review); err != nil || review.Response == nil {
+			t.Errorf("%s: 200 without an AdmissionResponse: %s", tt.name, recorder.Body.String())
+			continue
+		}
+		if result := review.Response.Result; result == nil || result.Code != tt.wantCode {
+			t.Errorf("%s: got result %+v, want code %d", tt.name, result, tt.wantCode)
+		}
+	}
+}
+
+func TestCheckRegistriesInitContainers(t *testing.T) {
+	s := &WebhookServer{}
+	policy := &Policy{WhiteListRegistries: normalizeRegistryPrefixes([]string{"docker.io"})}
+	pod := testPod("init", "nginx:1.21")
+	pod.Spec.InitContainers = []corev1.Container{{Name: "setup", Image: "quay.io/tools/setup:1"}}
+	d := s.checkRegistries(context.Background(), policy, &pod)
+	if d == nil {
+		t.Fatal("init container from an untrusted registry is allowed")
+	}
+	if d.field != "initContainers[0].image" {
+		t.Errorf("field = %q, want initContainers[0].image", d.field)
+	}
+	pod.Spec.InitContainers[0].Image = "busybox:1.33"
+	if d := s.checkRegistries(context.Background(), policy, &pod); d != nil {
+		t.Errorf("whitelisted init container is denied: %s", d.message)
+	}
+}
+
+func TestValidateHandlerRemediationHints(t *testing.T) {
+	s := &WebhookServer{}
+	s.SetConfig(Config{Base: Policy{WhiteListRegistries: []string{"docker.io", "registry.corp.com"}}})
+	tests := []struct {
+		name, image     string
+		wantAnnotations map[string]string
+	}{
+		{name: "allowed", image: "registry.corp.com/team/app:1"},
+		{
+			name:            "typo in registry",
+			image:           "registry.crop.com/team/app:1",
+			wantAnnotations: map[string]string{"rejected-image": "registry.crop.com/team/app:1", "suggested-registry": "registry.corp.com"},
+		},
+	}
+	for _, tt := range tests {
+		review := testutil.NewPodAdmissionReview(testPod(tt.name, tt.image), admissionV1.Create)
+		resp, err := testutil.ServeReview(http.HandlerFunc(s.Handler), ValidatePath, review)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		for key, want := range tt.wantAnnotations {
+			if got := resp.AuditAnnotations[key]; got != want {
+				t.Errorf("%s: audit annotation %s = %q, want %q", tt.name, key, got, want)
+			}
+		}
+		if tt.wantAnnotations == nil && len(resp.AuditAnnotations) != 0 {
+			t.Errorf("%s: allowed response has audit annotations %v", tt.name, resp.AuditAnnotations)
+		}
+	}
+}
+
+func TestValidateHandlerPolicyVersion(t *testing.T) {
+	tests := []struct {
+		name, version, image string
+	}{
+		{name: "allowed", version: "2021-05-01", image: "nginx:1.21"},
+		{name: "denied", version: "2021-05-01", image: "quay.io/app:1.0"},
+		{name: "no version", image: "nginx:1.21"},
+	}
+	for _, tt := range tests {
+		s := &WebhookServer{}
+		s.SetConfig(Config{PolicyVersion: tt.version, Base: Policy{WhiteListRegistries: []string{"docker.io"}}})
+		review := testutil.NewPodAdmissionReview(testPod(tt.name, tt.image), admissionV1.Create)
+		resp, err := testutil.ServeReview(http.HandlerFunc(s.Handler), ValidatePath, review)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		got, ok := resp.AuditAnnotations["policy-version"]
+		if got != tt.version || ok != (tt.version != "") {
+			t.Errorf("%s: policy-version annotation = %q (present %v), want %q", tt.name, got, ok, tt.version)
+		}
+	}
+}
+
+func TestCheckRegistriesWildcard(t *testing.T) {
+	s := &WebhookServer{}
+	s.SetConfig(Config{
+		Base: Policy{WhiteListRegistries: []string{"registry.corp.com"}},
+		Namespaces: map[string]Policy{
+			"kube-system": {WhiteListRegistries: []string{"*"}},
+			"team":        {WhiteListRegistries: []string{"quay*"}},
+		},
+	})
+	tests := []struct {
+		namespace, image string
+		wantCode         int
+	}{
+		{namespace: "kube-system", image: "k8s.gcr.io/kube-proxy:v1.20.2"},
+		{namespace: "kube-system", image: "quay.io/app:1"},
+		{namespace: "default", image: "quay.io/app:1", wantCode: http.StatusForbidden},
+		// 只有单独的 * 才表示允许所有镜像
+		{namespace: "team", image: "quay.io/app:1", wantCode: http.StatusForbidden},
+		{namespace: "team", image: "registry.corp.com/app:1"},
+	}
+	for _, tt := range tests {
+		policy := s.resolvePolicy(tt.namespace)
+		pod := testPod("web", tt.image)
+		pod.Namespace = tt.namespace
+		if code := denialCode(s.checkRegistries(context.Background(), &policy, &pod)); code != tt.wantCode {
+			t.Errorf("%s in %s: got code %d, want %d", tt.image, tt.namespace, code, tt.wantCode)
+		}
+	}
+}
+
+func TestReview(t *testing.T) {
+	s := &WebhookServer{}
+	s.SetConfig(Config{Base: Policy{WhiteListRegistries: []string{"docker.io"}}})
+	tests := []struct {
+		name        string
+		path        string
+		review      *admissionV1.AdmissionReview
+		wantAllowed bool
+		wantCode    int32
+	}{
+		{name: "allowed", review: testutil.NewPodAdmissionReview(testPod("web", "nginx:1.21"), admissionV1.Create), wantAllowed: true, wantCode: http.StatusOK},
+		{name: "denied", review: testutil.NewPodAdmissionReview(testPod("web", "quay.io/app:1"), admissionV1.Create), wantCode: http.StatusForbidden},
+		{name: "no request", review: &admissionV1.AdmissionReview{}, wantCode: http.StatusBadRequest},
+		{name: "unknown path", path: "/audit", review: testutil.NewPodAdmissionReview(testPod("web", "nginx:1.21"), admissionV1.Create), wantCode: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		var resp *admissionV1.AdmissionResponse
+		if tt.path == "" {
+			resp = s.Review(tt.review)
+		} else {
+			resp = s.ReviewPath(tt.path, tt.review)
+		}
+		if resp.Allowed != tt.wantAllowed || resp.Result == nil || resp.Result.Code != tt.wantCode {
+			t.Errorf("%s: got allowed %v, result %+v, want %v and code %d", tt.name, resp.Allowed, resp.Result, tt.wantAllowed, tt.wantCode)
+		}
+		if tt.review.Request != nil && resp.UID != tt.review.Request.UID {
+			t.Errorf("%s: response UID %q does not match request UID %q", tt.name, resp.UID, tt.review.Request.UID)
+		}
+	}
+}
+
+func TestHandlerEchoesUIDOnErrors(t *testing.T) {
+	pod := testPod("web", "nginx:1.21")
+	badObject, err := testutil.NewAdmissionReview(
+		metav1.GroupVersionKind{Version: "v1", Kind: "Pod"}, metav1.GroupVersionResource{Version: "v1", Resource: "pods"},
+		"team", "web", map[string]interface{}{"spec": map[string]interface{}{"containers": "nginx"}}, admissionV1.Create)
+	if err != nil {
+		t.Fatal(err)
+	}
+	panicking := NewCheckRegistry(funcCheck{"panic", func(context.Context) Result { panic("check bug") }})
+	tests := []struct {
+		name        string
+		path        string
+		contentType string
+		review      *admissionV1.AdmissionReview
+		checks      *CheckRegistry
+		wantCode    int32
+	}{
+		{name: "wrong content type", path: ValidatePath, contentType: "text/plain", review: testutil.NewPodAdmissionReview(pod, admissionV1.Create), wantCode: http.StatusBadRequest},
+		{name: "undecodable object", path: ValidatePath, review: badObject, wantCode: http.StatusBadRequest},
+		{name: "undecodable object on mutate", path: MutatePath, review: badObject, wantCode: http.StatusBadRequest},
+		{name: "unknown path", path: "/audit", review: testutil.NewPodAdmissionReview(pod, admissionV1.Create), wantCode: http.StatusNotFound},
+		{name: "panic in a check", path: ValidatePath, review: testutil.NewPodAdmissionReview(pod, admissionV1.Create), checks: panicking, wantCode: http.StatusInternalServerError},
+	}
+	discardLogs(t)
+	for _, tt := range tests {
+		s := &WebhookServer{Checks: tt.checks}
+		body, err := json.Marshal(tt.review)
+		if err != nil {
+			t.Fatal(err)
+		}
+		contentType := tt.contentType
+		if contentType == "" {
+			contentType = "application/json"
+		}
+		request := httptest.NewRequest(http.MethodPost, tt.path, bytes.NewReader(body))
+		request.Header.Set("Content-Type", contentType)
+		recorder := httptest.NewRecorder()
+		s.Handler(recorder, request)
+		var review admissionV1.AdmissionReview
+		if err := json.Unmarshal(recorder.Body.Bytes(), &review); err != nil || review.Response == nil {
+			t.Fatalf("%s: status %d, can't decode response %q: %v", tt.name, recorder.Code, recorder.Body.String(), err)
+		}
+		resp := review.Response
+		if resp.UID != tt.review.Request.UID {
+			t.Errorf("%s: response UID %q does not match request UID %q", tt.name, resp.UID, tt.review.Request.UID)
+		}
+		if resp.Allowed || resp.Result == nil || resp.Result.Code != tt.wantCode {
+			t.Errorf("%s: got allowed %v, result %+v, want code %d", tt.name, resp.Allowed, resp.Result, tt.wantCode)
+		}
+	}
+}
+
+func TestCheckRegistriesExceptions(t *testing.T) {
+	s := &WebhookServer{}
+	policy := &Policy{
+		WhiteListRegistries: normalizeRegistryPrefixes([]string{"registry.corp.com"}),
+		AllowedImages:       []string{"quay.io/vendor/agent:1.4"},
+	}
+	if d := s.checkRegistries(context.Background(), policy, imagePod("registry.corp.com/app:1", "quay.io/vendor/agent:1.4")); d != nil {
+		t.Errorf("excepted image is denied: %s", d.message)
+	}
+	if d := s.checkRegistries(context.Background(), policy, imagePod("quay.io/vendor/agent:1.5")); d == nil {
+		t.Error("image outside the exception list is allowed")
+	}
+}
+
+func TestHandlerGzipBody(t *testing.T) {
+	compress := func(data []byte) []byte {
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		gz.Write(data)
+		gz.Close()
+		return buf.Bytes()
+	}
+	denied, err := json.Marshal(testutil.NewPodAdmissionReview(testPod("web", "quay.io/app:1.0"), admissionV1.Create))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 压缩之后很小, 解压之后超过大小限制
+	padded := append(append([]byte{}, denied[:len(denied)-1]...), []byte(`,"padding":"`+strings.Repeat("a", 8000)+`"}`)...)
+	truncated := compress(denied)
+	truncated = truncated[:len(truncated)/2]
+	tests := []struct {
+		name       string
+		body       []byte
+		wantStatus int
+		wantCode   int32
+	}{
+		{name: "denied pod", body: compress(denied), wantStatus: http.StatusOK, wantCode: http.StatusForbidden},
+		{name: "larger than the limit after decompression", body: compress(padded), wantStatus: http.StatusRequestEntityTooLarge},
+		{name: "truncated stream", body: truncated, wantStatus: http.StatusBadRequest},
+	}
+	discardLogs(t)
+	for _, tt := range tests {
+		s := &WebhookServer{MaxBodySize: 4096}
+		s.SetConfig(Config{Base: Policy{WhiteListRegistries: []string{"docker.io"}}})
+		request := httptest.NewRequest(http.MethodPost, ValidatePath, bytes.NewReader(tt.body))
+		request.Header.Set("Content-Type", "application/json")
+		request.Header.Set("Content-Encoding", "gzip")
+		recorder := httptest.NewRecorder()
+		s.Handler(recorder, request)
+		if recorder.Code != tt.wantStatus {
+			t.Errorf("%s: got status %d (%s), want %d", tt.name, recorder.Code, recorder.Body.String(), tt.wantStatus)
+			continue
+		}
+		if tt.wantStatus != http.StatusOK {
+			continue
+		}
+		var review admissionV1.AdmissionReview
+		if err := json.Unmarshal(recorder.Body.Bytes(), &review); err != nil || review.Response == nil || review.Response.Result == nil {
+			t.Fatalf("%s: can't decode response %s: %v", tt.name, recorder.Body.String(), err)
+		}
+		if code := review.Response.Result.Code; review.Response.Allowed || code != tt.wantCode {
+			t.Errorf("%s: got allowed %v, code %d, want code %d", tt.name, review.Response.Allowed, code, tt.wantCode)
+		}
+	}
+}
+
+// partialReader 先返回 data, 之后的读取返回 err, 模拟读取中途断开的连接
+type partialReader struct {
+	data []byte
+	err  error
+}
+
+func (r *partialReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, r.err
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+func TestHandlerPartialRead(t *testing.T) {
+	allowed, err := json.Marshal(testutil.NewPodAdmissionReview(testPod("web", "nginx:1.21"), admissionV1.Create))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		body       io.Reader
+		wantStatus int
+	}{
+		{name: "complete body", body: bytes.NewReader(allowed), wantStatus: http.StatusOK},
+		{name: "connection reset after part of the body", body: &partialReader{data: allowed[:len(allowed)/2], err: errors.New("connection reset by peer")}, wantStatus: http.StatusBadRequest},
+		{name: "connection reset after the whole body", body: &partialReader{data: allowed, err: io.ErrUnexpectedEOF}, wantStatus: http.StatusBadRequest},
+	}
+	discardLogs(t)
+	for _, tt := range tests {
+		s := &WebhookServer{}
+		s.SetConfig(Config{Base: Policy{WhiteListRegistries: []string{"docker.io"}}})
+		request := httptest.NewRequest(http.MethodPost, ValidatePath, tt.body)
+		request.Header.Set("Content-Type", "application/json")
+		recorder := httptest.NewRecorder()
+		s.Handler(recorder, request)
+		if recorder.Code != tt.wantStatus {
+			t.Errorf("%s: got status %d (%s), want %d", tt.name, recorder.Code, recorder.Body.String(), tt.wantStatus)
+		}
+		if tt.wantStatus == http.StatusBadRequest && !strings.Contains(recorder.Body.String(), "can't read request body after") {
+			t.Errorf("%s: got body %q, want the read error", tt.name, recorder.Body.String())
+		}
+	}
+}
+
+func TestRequestContextTimeout(t *testing.T) {
+	tests := []struct {
+		name       string
+		query      string
+		maxTimeout time.Duration
+		want       time.Duration
+	}{
+		{name: "api-server timeout", query: "?timeout=10s", want: 9 * time.Second},
+		{name: "short timeout keeps a tenth as margin", query: "?timeout=5s", want: 4500 * time.Millisecond},
+		{name: "longer than the maximum", query: "?timeout=30s", maxTimeout: 10 * time.Second, want: 9 * time.Second},
+		{name: "no timeout", want: 29 * time.Second},
+		{name: "invalid timeout", query: "?timeout=soon", maxTimeout: 5 * time.Second, want: 4500 * time.Millisecond},
+		{name: "zero timeout", query: "?timeout=0s", maxTimeout: 5 * time.Second, want: 4500 * time.Millisecond},
+	}
+	discardLogs(t)
+	for _, tt := range tests {
+		s := &WebhookServer{MaxRequestTimeout: tt.maxTimeout}
+		request := httptest.NewRequest(http.MethodPost, ValidatePath+tt.query, nil)
+		ctx, cancel := s.requestContext(context.Background(), request)
+		deadline, ok := ctx.Deadline()
+		cancel()
+		if remaining := time.Until(deadline); !ok || remaining > tt.want || remaining < tt.want-time.Second {
+			t.Errorf("%s: got deadline in %s, want %s", tt.name, remaining, tt.want)
+		}
+	}
+}
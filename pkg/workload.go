@@ -0,0 +1,337 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	admissionV1 "k8s.io/api/admission/v1"
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2beta2 "k8s.io/api/autoscaling/v2beta2"
+	batchv1 "k8s.io/api/batch/v1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// skipRequest 判断请求是否没有可以检查的对象, 比如 CONNECT 请求和 pods/exec 这样的子资源
+// webhook 注册的范围过大时, 这些请求会直接放行, 不去解析请求中的对象
+func skipRequest(policy *Policy, req *admissionV1.AdmissionRequest) bool {
+	if req.Operation == admissionV1.Connect {
+		return true
+	}
+	return req.SubResource != "" && containsString(policy.skippedSubResources(), req.SubResource)
+}
+
+// decodeObject 按照请求的 Kind 解析对象
+// 对于工作负载, 同时返回由 pod 模板构造出来的 pod, 让 pod 的检查同样作用在工作负载上
+// 超过 largeObjectSize 字节的工作负载只解析检查需要的部分, 为 0 时总是完整解析
+func decodeObject(req *admissionV1.AdmissionRequest, largeObjectSize int) (runtime.Object, *corev1.Pod, error) {
+	return decodeRaw(req.Kind.Kind, req.Namespace, req.Object.Raw, largeObjectSize)
+}
+
+// decodeOldObject 解析 UPDATE/DELETE 请求中的旧对象
+// 旧对象只用来和新对象的 pod 模板比较, 所以工作负载总是只解析需要的部分
+func decodeOldObject(req *admissionV1.AdmissionRequest) (runtime.Object, *corev1.Pod, error) {
+	return decodeRaw(req.Kind.Kind, req.Namespace, req.OldObject.Raw, 1)
+}
+
+func decodeRaw(kind, namespace string, raw []byte, largeObjectSize int) (runtime.Object, *corev1.Pod, error) {
+	if largeObjectSize > 0 && len(raw) >= largeObjectSize {
+		if obj, pod, ok, err := decodeLargeWorkload(kind, namespace, raw); ok {
+			return obj, pod, err
+		}
+	}
+	switch kind {
+	case "Deployment":
+		var deploy appsv1.Deployment
+		if err := json.Unmarshal(raw, &deploy); err != nil {
+			return nil, nil, decodeError(kind, raw, err)
+		}
+		deploy.Namespace = namespace
+		return &deploy, objectPod(&deploy), nil
+	case "StatefulSet":
+		var sts appsv1.StatefulSet
+		if err := json.Unmarshal(raw, &sts); err != nil {
+			return nil, nil, decodeError(kind, raw, err)
+		}
+		sts.Namespace = namespace
+		return &sts, objectPod(&sts), nil
+	case "CronJob":
+		var cronJob batchv1beta1.CronJob
+		if err := json.Unmarshal(raw, &cronJob); err != nil {
+			return nil, nil, decodeError(kind, raw, err)
+		}
+		cronJob.Namespace = namespace
+		return &cronJob, objectPod(&cronJob), nil
+	case "Job":
+		var job batchv1.Job
+		if err := json.Unmarshal(raw, &job); err != nil {
+			return nil, nil, decodeError(kind, raw, err)
+		}
+		job.Namespace = namespace
+		return &job, objectPod(&job), nil
+	case "Service":
+		var svc corev1.Service
+		if err := json.Unmarshal(raw, &svc); err != nil {
+			return nil, nil, decodeError(kind, raw, err)
+		}
+		svc.Namespace = namespace
+		return &svc, nil, nil
+	case "Ingress":
+		var ingress networkingv1.Ingress
+		if err := json.Unmarshal(raw, &ingress); err != nil {
+			return nil, nil, decodeError(kind, raw, err)
+		}
+		ingress.Namespace = namespace
+		return &ingress, nil, nil
+	case "HorizontalPodAutoscaler":
+		var hpa autoscalingv2beta2.HorizontalPodAutoscaler
+		if err := json.Unmarshal(raw, &hpa); err != nil {
+			return nil, nil, decodeError(kind, raw, err)
+		}
+		hpa.Namespace = namespace
+		return &hpa, nil, nil
+	case "Secret":
+		var secret corev1.Secret
+		if err := json.Unmarshal(raw, &secret); err != nil {
+			return nil, nil, decodeError(kind, raw, err)
+		}
+		// 检查只需要 type, 解析之后丢掉内容, 避免被日志或者拒绝原因带出去
+		secret.Data, secret.StringData = nil, nil
+		secret.Namespace = namespace
+		return &secret, nil, nil
+	case "ServiceAccount":
+		var sa corev1.ServiceAccount
+		if err := json.Unmarshal(raw, &sa); err != nil {
+			return nil, nil, decodeError(kind, raw, err)
+		}
+		sa.Namespace = namespace
+		return &sa, nil, nil
+	case "PersistentVolumeClaim":
+		var pvc corev1.PersistentVolumeClaim
+		if err := json.Unmarshal(raw, &pvc); err != nil {
+			return nil, nil, decodeError(kind, raw, err)
+		}
+		pvc.Namespace = namespace
+		return &pvc, nil, nil
+	case "Pod", "":
+		var pod corev1.Pod
+		if err := json.Unmarshal(raw, &pod); err != nil {
+			return nil, nil, decodeError(kind, raw, err)
+		}
+		pod.Namespace = namespace
+		return &pod, &pod, nil
+	default:
+		// 没有内置支持的类型(比如 CRD)按 unstructured 解析, 只有 FieldRules 这样按字段路径的检查会生效
+		var obj unstructured.Unstructured
+		if err := obj.UnmarshalJSON(raw); err != nil {
+			return nil, nil, decodeError(kind, raw, err)
+		}
+		return &obj, nil, nil
+	}
+}
+
+// decodeError 把解析失败的错误转换成带有出错位置的错误, 方便用户修改
+// 出错的位置在某个容器中时, 指出是哪个容器, 比如 spec.template.spec.containers[1] (container "web")
+func decodeError(kind string, raw []byte, err error) error {
+	if kind == "" {
+		kind = "Pod"
+	}
+	specPath := strings.Split(strings.TrimPrefix(podSpecPath(kind), "/"), "/")
+	for _, field := range []string{"initContainers", "containers"} {
+		var containers []json.RawMessage
+		if json.Unmarshal(rawField(raw, append(specPath, field)...), &containers) != nil {
+			continue
+		}
+		for i, c := range containers {
+			var container corev1.Container
+			if cerr := json.Unmarshal(c, &container); cerr != nil {
+				var name string
+				json.Unmarshal(rawField(c, "name"), &name)
+				return fmt.Errorf("can't decode %s: %s.%s[%d] (container %q): %s",
+					kind, strings.Join(specPath, "."), field, i, name, describeDecodeError(cerr))
+			}
+		}
+	}
+	return fmt.Errorf("can't decode %s: %s", kind, describeDecodeError(err))
+}
+
+// describeDecodeError 类型不匹配时给出字段路径和期望的类型
+func describeDecodeError(err error) string {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		return fmt.Sprintf("field %s must be %s, got JSON %s", typeErr.Field, typeErr.Type, typeErr.Value)
+	}
+	return err.Error()
+}
+
+// rawField 返回 raw 中按 keys 逐层取出的字段, 字段不存在时返回 nil
+func rawField(raw []byte, keys ...string) json.RawMessage {
+	for _, key := range keys {
+		var fields map[string]json.RawMessage
+		if json.Unmarshal(raw, &fields) != nil {
+			return nil
+		}
+		raw = fields[key]
+	}
+	return raw
+}
+
+// objectPod 返回对象对应的 pod, 工作负载返回由 pod 模板构造的 pod, 其它对象返回 nil
+func objectPod(obj runtime.Object) *corev1.Pod {
+	switch o := obj.(type) {
+	case *corev1.Pod:
+		return o
+	case *appsv1.Deployment:
+		return podFromTemplate(o.Namespace, &o.Spec.Template)
+	case *appsv1.StatefulSet:
+		return podFromTemplate(o.Namespace, &o.Spec.Template)
+	case *batchv1beta1.CronJob:
+		return podFromTemplate(o.Namespace, &o.Spec.JobTemplate.Spec.Template)
+	case *batchv1.Job:
+		return podFromTemplate(o.Namespace, &o.Spec.Template)
+	}
+	return nil
+}
+
+// podContainers 返回 pod 中的所有容器, 包括 init 容器
+func podContainers(pod *corev1.Pod) []corev1.Container {
+	containers := make([]corev1.Container, 0, len(pod.Spec.InitContainers)+len(pod.Spec.Containers))
+	containers = append(containers, pod.Spec.InitContainers...)
+	return append(containers, pod.Spec.Containers...)
+}
+
+func podFromTemplate(namespace string, template *corev1.PodTemplateSpec) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: template.ObjectMeta,
+		Spec:       template.Spec,
+	}
+	pod.Namespace = namespace
+	return pod
+}
+
+// checkDeployment 要求高可用的命名空间中 Deployment 的副本数不能低于下限, 并且使用滚动更新
+func checkDeployment(_ context.Context, policy *Policy, _ *admissionV1.AdmissionRequest, obj runtime.Object) *denial {
+	deploy, ok := obj.(*appsv1.Deployment)
+	if !ok {
+		return nil
+	}
+	replicas := int32(1)
+	if deploy.Spec.Replicas != nil {
+		replicas = *deploy.Spec.Replicas
+	}
+	if policy.MinReplicas > 0 && replicas < policy.MinReplicas {
+		return &denial{
+			code: http.StatusForbidden,
+			message: fmt.Sprintf("deployment %s has %d replicas, at least %d replicas are required in namespace %s.",
+				deploy.Name, replicas, policy.MinReplicas, deploy.Namespace),
+		}
+	}
+	if policy.RequireRollingUpdate && deploy.Spec.Strategy.Type == appsv1.RecreateDeploymentStrategyType {
+		return &denial{
+			code: http.StatusForbidden,
+			message: fmt.Sprintf("deployment %s uses the %s strategy, only %s is allowed in namespace %s.",
+				deploy.Name, deploy.Spec.Strategy.Type, appsv1.RollingUpdateDeploymentStrategyType, deploy.Namespace),
+		}
+	}
+	return nil
+}
+
+// checkRevisionHistoryLimit 要求 Deployment/StatefulSet 设置不超过 MaxRevisionHistoryLimit 的 revisionHistoryLimit
+// 保留过多的历史版本会在 etcd 中堆积大量 ReplicaSet/ControllerRevision
+func checkRevisionHistoryLimit(_ context.Context, policy *Policy, _ *admissionV1.AdmissionRequest, obj runtime.Object) *denial {
+	if policy.MaxRevisionHistoryLimit <= 0 {
+		return nil
+	}
+	var kind, name string
+	var limit *int32
+	switch o := obj.(type) {
+	case *appsv1.Deployment:
+		kind, name, limit = "deployment", o.Name, o.Spec.RevisionHistoryLimit
+	case *appsv1.StatefulSet:
+		kind, name, limit = "statefulset", o.Name, o.Spec.RevisionHistoryLimit
+	default:
+		return nil
+	}
+	if limit == nil {
+		return &denial{
+			code: http.StatusForbidden,
+			message: fmt.Sprintf("%s %s must set revisionHistoryLimit, at most %d is allowed.",
+				kind, name, policy.MaxRevisionHistoryLimit),
+		}
+	}
+	if *limit > policy.MaxRevisionHistoryLimit {
+		return &denial{
+			code: http.StatusForbidden,
+			message: fmt.Sprintf("%s %s has revisionHistoryLimit %d, at most %d is allowed.",
+				kind, name, *limit, policy.MaxRevisionHistoryLimit),
+		}
+	}
+	return nil
+}
+
+// checkCronJob 要求 CronJob 禁止并发执行, 并且设置启动和运行的截止时间, 避免任务重叠或者一直运行
+func checkCronJob(_ context.Context, policy *Policy, _ *admissionV1.AdmissionRequest, obj runtime.Object) *denial {
+	cronJob, ok := obj.(*batchv1beta1.CronJob)
+	if !ok || !policy.RequireCronJobGuards {
+		return nil
+	}
+	var missing []string
+	if cronJob.Spec.ConcurrencyPolicy == "" || cronJob.Spec.ConcurrencyPolicy == batchv1beta1.AllowConcurrent {
+		missing = append(missing, "concurrencyPolicy: Forbid or Replace")
+	}
+	if cronJob.Spec.StartingDeadlineSeconds == nil {
+		missing = append(missing, "startingDeadlineSeconds")
+	}
+	if cronJob.Spec.JobTemplate.Spec.ActiveDeadlineSeconds == nil {
+		missing = append(missing, "jobTemplate.spec.activeDeadlineSeconds")
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	return &denial{
+		code: http.StatusForbidden,
+		message: fmt.Sprintf("cronjob %s must set %s in namespace %s.",
+			cronJob.Name, strings.Join(missing, ", "), cronJob.Namespace),
+	}
+}
+
+// checkJob 要求 Job 设置 activeDeadlineSeconds, 并且 backoffLimit 不超过 MaxJobBackoffLimit, 避免失败的任务一直重试
+func checkJob(_ context.Context, policy *Policy, _ *admissionV1.AdmissionRequest, obj runtime.Object) *denial {
+	job, ok := obj.(*batchv1.Job)
+	if !ok || !policy.RequireJobGuards {
+		return nil
+	}
+	var missing []string
+	if job.Spec.ActiveDeadlineSeconds == nil {
+		missing = append(missing, "activeDeadlineSeconds")
+	}
+	maxBackoff := policy.maxJobBackoffLimit()
+	if job.Spec.BackoffLimit == nil || *job.Spec.BackoffLimit > maxBackoff {
+		missing = append(missing, fmt.Sprintf("backoffLimit of at most %d", maxBackoff))
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	return &denial{
+		code: http.StatusForbidden,
+		message: fmt.Sprintf("job %s must set %s in namespace %s.",
+			job.Name, strings.Join(missing, ", "), job.Namespace),
+	}
+}
+
+// Job 默认的 backoffLimit
+const defaultJobBackoffLimit = 6
+
+func (p *Policy) maxJobBackoffLimit() int32 {
+	if p.MaxJobBackoffLimit <= 0 {
+		return defaultJobBackoffLimit
+	}
+	return p.MaxJobBackoffLimit
+}
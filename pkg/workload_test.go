@@ -0,0 +1,243 @@
+package pkg
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	admissionV1 "k8s.io/api/admission/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+func TestDecodeErrorNamesContainer(t *testing.T) {
+	badContainers := `{"containers": [{"name": "ok", "image": "nginx"}, {"name": "web", "image": "nginx", "ports": [{"containerPort": "80"}]}]}`
+	tests := []struct {
+		kind, raw, want string
+	}{
+		{
+			kind: "Pod",
+			raw:  `{"spec": ` + badContainers + `}`,
+			want: `can't decode Pod: spec.containers[1] (container "web")`,
+		},
+		{
+			kind: "",
+			raw:  `{"spec": ` + badContainers + `}`,
+			want: `can't decode Pod: spec.containers[1] (container "web")`,
+		},
+		{
+			kind: "Deployment",
+			raw:  `{"spec": {"template": {"spec": ` + badContainers + `}}}`,
+			want: `can't decode Deployment: spec.template.spec.containers[1] (container "web")`,
+		},
+		{
+			kind: "StatefulSet",
+			raw:  `{"spec": {"template": {"spec": {"initContainers": [{"name": "init", "command": "sh"}]}}}}`,
+			want: `can't decode StatefulSet: spec.template.spec.initContainers[0] (container "init")`,
+		},
+		{
+			kind: "CronJob",
+			raw:  `{"spec": {"jobTemplate": {"spec": {"template": {"spec": ` + badContainers + `}}}}}`,
+			want: `can't decode CronJob: spec.jobTemplate.spec.template.spec.containers[1] (container "web")`,
+		},
+		{
+			kind: "Deployment",
+			raw:  `{"spec": {"replicas": "3"}}`,
+			want: "can't decode Deployment: field spec.replicas must be int32",
+		},
+	}
+	for _, tt := range tests {
+		// 完整解析和大对象的部分解析给出同样的位置
+		for _, largeObjectSize := range []int{0, 1} {
+			_, _, err := decodeRaw(tt.kind, "team", []byte(tt.raw), largeObjectSize)
+			if err == nil || !strings.HasPrefix(err.Error(), tt.want) {
+				t.Errorf("%q (largeObjectSize %d): got error %v, want prefix %s", tt.kind, largeObjectSize, err, tt.want)
+			}
+		}
+	}
+}
+
+func TestCheckCronJob(t *testing.T) {
+	policy := &Policy{RequireCronJobGuards: true}
+	tests := []struct {
+		name, spec  string
+		wantCode    int
+		wantMissing []string
+	}{
+		{
+			name: "compliant",
+			spec: `{"concurrencyPolicy": "Forbid", "startingDeadlineSeconds": 60, "jobTemplate": {"spec": {"activeDeadlineSeconds": 600}}}`,
+		},
+		{
+			name: "replace is allowed",
+			spec: `{"concurrencyPolicy": "Replace", "startingDeadlineSeconds": 60, "jobTemplate": {"spec": {"activeDeadlineSeconds": 600}}}`,
+		},
+		{
+			name:        "defaults",
+			spec:        `{"jobTemplate": {"spec": {}}}`,
+			wantCode:    http.StatusForbidden,
+			wantMissing: []string{"concurrencyPolicy", "startingDeadlineSeconds", "activeDeadlineSeconds"},
+		},
+		{
+			name:        "explicit Allow without activeDeadlineSeconds",
+			spec:        `{"concurrencyPolicy": "Allow", "startingDeadlineSeconds": 60, "jobTemplate": {"spec": {}}}`,
+			wantCode:    http.StatusForbidden,
+			wantMissing: []string{"concurrencyPolicy", "activeDeadlineSeconds"},
+		},
+	}
+	for _, tt := range tests {
+		obj, _, err := decodeRaw("CronJob", "team", []byte(`{"metadata": {"name": "report"}, "spec": `+tt.spec+`}`), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d := checkCronJob(context.Background(), policy, nil, obj)
+		if code := denialCode(d); code != tt.wantCode {
+			t.Errorf("%s: got code %d (%v), want %d", tt.name, code, d, tt.wantCode)
+			continue
+		}
+		for _, field := range tt.wantMissing {
+			if !strings.Contains(d.message, field) {
+				t.Errorf("%s: message %q does not mention %s", tt.name, d.message, field)
+			}
+		}
+	}
+
+	obj, _, _ := decodeRaw("CronJob", "team", []byte(`{"spec": {}}`), 0)
+	if d := checkCronJob(context.Background(), &Policy{}, nil, obj); d != nil {
+		t.Errorf("guards are off by default, got %v", d.message)
+	}
+}
+
+func TestCheckDeployment(t *testing.T) {
+	policy := &Policy{MinReplicas: 2, RequireRollingUpdate: true}
+	tests := []struct {
+		name, spec string
+		policy     *Policy
+		wantCode   int
+	}{
+		{name: "compliant", spec: `{"replicas": 3, "strategy": {"type": "RollingUpdate"}}`},
+		{name: "default strategy", spec: `{"replicas": 2}`},
+		{name: "zero replicas", spec: `{"replicas": 0}`, wantCode: http.StatusForbidden},
+		{name: "default replicas", spec: `{}`, wantCode: http.StatusForbidden},
+		{name: "recreate", spec: `{"replicas": 3, "strategy": {"type": "Recreate"}}`, wantCode: http.StatusForbidden},
+		{name: "checks off by default", spec: `{"replicas": 0, "strategy": {"type": "Recreate"}}`, policy: &Policy{}},
+	}
+	for _, tt := range tests {
+		obj, _, err := decodeRaw("Deployment", "team", []byte(`{"metadata": {"name": "web"}, "spec": `+tt.spec+`}`), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		p := policy
+		if tt.policy != nil {
+			p = tt.policy
+		}
+		if code := denialCode(checkDeployment(context.Background(), p, nil, obj)); code != tt.wantCode {
+			t.Errorf("%s: got code %d, want %d", tt.name, code, tt.wantCode)
+		}
+	}
+}
+
+func TestCheckRevisionHistoryLimit(t *testing.T) {
+	tests := []struct {
+		name, kind, spec string
+		max              int32
+		wantCode         int
+	}{
+		{name: "deployment within the limit", kind: "Deployment", spec: `{"revisionHistoryLimit": 3}`, max: 5},
+		{name: "deployment at the limit", kind: "Deployment", spec: `{"revisionHistoryLimit": 5}`, max: 5},
+		{name: "deployment over the limit", kind: "Deployment", spec: `{"revisionHistoryLimit": 10}`, max: 5, wantCode: http.StatusForbidden},
+		{name: "deployment unset", kind: "Deployment", spec: `{}`, max: 5, wantCode: http.StatusForbidden},
+		{name: "statefulset over the limit", kind: "StatefulSet", spec: `{"revisionHistoryLimit": 10}`, max: 5, wantCode: http.StatusForbidden},
+		{name: "statefulset unset", kind: "StatefulSet", spec: `{}`, max: 5, wantCode: http.StatusForbidden},
+		{name: "other kinds", kind: "CronJob", spec: `{}`, max: 5},
+		{name: "not configured", kind: "Deployment", spec: `{}`},
+	}
+	for _, tt := range tests {
+		obj, _, err := decodeRaw(tt.kind, "team", []byte(`{"metadata": {"name": "web"}, "spec": `+tt.spec+`}`), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code := denialCode(checkRevisionHistoryLimit(context.Background(), &Policy{MaxRevisionHistoryLimit: tt.max}, nil, obj)); code != tt.wantCode {
+			t.Errorf("%s: got code %d, want %d", tt.name, code, tt.wantCode)
+		}
+	}
+}
+
+func TestCheckJob(t *testing.T) {
+	tests := []struct {
+		name, spec  string
+		policy      Policy
+		wantCode    int
+		wantMessage string
+	}{
+		{name: "guarded", spec: `{"activeDeadlineSeconds": 600, "backoffLimit": 3}`, policy: Policy{RequireJobGuards: true}},
+		{name: "default maximum backoff", spec: `{"activeDeadlineSeconds": 600, "backoffLimit": 6}`, policy: Policy{RequireJobGuards: true}},
+		{name: "backoff over the default", spec: `{"activeDeadlineSeconds": 600, "backoffLimit": 7}`, policy: Policy{RequireJobGuards: true},
+			wantCode: http.StatusForbidden, wantMessage: "job migrate must set backoffLimit of at most 6 in namespace team."},
+		{name: "backoff over the configured maximum", spec: `{"activeDeadlineSeconds": 600, "backoffLimit": 3}`, policy: Policy{RequireJobGuards: true, MaxJobBackoffLimit: 2},
+			wantCode: http.StatusForbidden, wantMessage: "job migrate must set backoffLimit of at most 2 in namespace team."},
+		{name: "nothing set", spec: `{}`, policy: Policy{RequireJobGuards: true},
+			wantCode: http.StatusForbidden, wantMessage: "job migrate must set activeDeadlineSeconds, backoffLimit of at most 6 in namespace team."},
+		{name: "not configured", spec: `{}`},
+	}
+	for _, tt := range tests {
+		obj, _, err := decodeRaw("Job", "team", []byte(`{"metadata": {"name": "migrate"}, "spec": `+tt.spec+`}`), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		d := checkJob(context.Background(), &tt.policy, nil, obj)
+		if code := denialCode(d); code != tt.wantCode {
+			t.Errorf("%s: got code %d, want %d", tt.name, code, tt.wantCode)
+			continue
+		}
+		if d != nil && d.message != tt.wantMessage {
+			t.Errorf("%s: got message %q, want %q", tt.name, d.message, tt.wantMessage)
+		}
+	}
+}
+
+func TestSkipRequest(t *testing.T) {
+	tests := []struct {
+		name        string
+		operation   admissionV1.Operation
+		subResource string
+		policy      Policy
+		want        bool
+	}{
+		{name: "connect", operation: admissionV1.Connect, want: true},
+		{name: "exec", operation: admissionV1.Create, subResource: "exec", want: true},
+		{name: "attach", operation: admissionV1.Create, subResource: "attach", want: true},
+		{name: "pod", operation: admissionV1.Create},
+		{name: "status", operation: admissionV1.Update, subResource: "status"},
+		{name: "custom subresources", operation: admissionV1.Update, subResource: "status", policy: Policy{SkippedSubResources: []string{"status"}}, want: true},
+		{name: "custom subresources replace the default", operation: admissionV1.Create, subResource: "exec", policy: Policy{SkippedSubResources: []string{"status"}}},
+	}
+	for _, tt := range tests {
+		req := &admissionV1.AdmissionRequest{Operation: tt.operation, SubResource: tt.subResource}
+		if got := skipRequest(&tt.policy, req); got != tt.want {
+			t.Errorf("%s: skipRequest = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestReviewSkipsRequestsWithoutObjects(t *testing.T) {
+	s := &WebhookServer{}
+	s.SetConfig(Config{Base: Policy{WhiteListRegistries: []string{"registry.corp.com"}, DisableAutomountServiceAccountToken: true}})
+	// pods/exec 的 CONNECT 请求中是 PodExecOptions, 不是 pod, 不能按照 pod 解析
+	review := &admissionV1.AdmissionReview{Request: &admissionV1.AdmissionRequest{
+		UID:         "exec",
+		Kind:        metav1.GroupVersionKind{Version: "v1", Kind: "PodExecOptions"},
+		Resource:    metav1.GroupVersionResource{Version: "v1", Resource: "pods"},
+		SubResource: "exec",
+		Namespace:   "team",
+		Operation:   admissionV1.Connect,
+		Object:      runtime.RawExtension{Raw: []byte(`{"kind":"PodExecOptions","apiVersion":"v1","command":["sh"]}`)},
+	}}
+	for _, path := range []string{ValidatePath, MutatePath} {
+		resp := s.ReviewPath(path, review)
+		if !resp.Allowed || len(resp.Patch) != 0 {
+			t.Errorf("%s: got allowed %v, patch %s, result %v", path, resp.Allowed, resp.Patch, resp.Result)
+		}
+	}
+}